@@ -0,0 +1,379 @@
+package gsuitemdm
+
+//
+// GSuiteMDM access log sources (Slack, G Suite login audit, CSV)
+//
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	reports "google.golang.org/api/admin/reports/v1"
+	"google.golang.org/api/option"
+)
+
+// Slack API endpoints
+const (
+	slackAccessLogsURL string = "https://slack.com/api/team.accessLogs"
+	slackUserInfoURL   string = "https://slack.com/api/users.info"
+)
+
+// A source of login/access logs
+type AccessLogSource interface {
+	// Name of the source, e.g. "slack"
+	Name() string
+
+	// Get all access log entries
+	Entries() ([]AccessLogEntry, error)
+}
+
+// Access log source backed by the Slack team.accessLogs API. Users are looked up with
+// users.info (once per user) to get their email address.
+type SlackAccessLogSource struct {
+	Client *http.Client // HTTP client, http.DefaultClient if nil
+	Pages  int          // Maximum number of pages of access logs to read
+	Token  string       // Slack API token
+
+	users map[string]*SlackUserInfo
+}
+
+// Create a new Slack access log source
+func NewSlackAccessLogSource(token string, pages int) *SlackAccessLogSource {
+	if pages < 1 {
+		pages = 5
+	}
+
+	return &SlackAccessLogSource{Pages: pages, Token: token}
+}
+
+// Name of the Slack access log source
+func (s *SlackAccessLogSource) Name() string {
+	return "slack"
+}
+
+// Get all Slack access log entries, one page at a time as per the Slack API spec
+func (s *SlackAccessLogSource) Entries() ([]AccessLogEntry, error) {
+	var entries []AccessLogEntry
+
+	for page := 1; page <= s.Pages; page++ {
+		var sal SlackAccessLog
+		err := s.get(slackAccessLogsURL, url.Values{"count": {"1000"}, "page": {fmt.Sprintf("%d", page)}}, &sal)
+		if err != nil {
+			return nil, err
+		}
+		if !sal.Status {
+			return nil, errors.New(fmt.Sprintf("Error getting Slack access logs: %s", sal.Error))
+		}
+
+		for _, le := range sal.Logins {
+			e := AccessLogEntry{
+				DateLast:  time.Unix(le.DateLast, 0),
+				IP:        le.IP,
+				Source:    s.Name(),
+				UserAgent: le.UserAgent,
+				UserID:    le.UserID,
+				Username:  le.Username,
+			}
+
+			// Get the user's email address & name
+			su, err := s.user(le.UserID)
+			if err != nil {
+				return nil, err
+			}
+			e.Email = su.User.Profile.Email
+			e.Name = su.User.RealName
+
+			entries = append(entries, e)
+		}
+
+		// Last page?
+		if page >= sal.PagingData.Pages {
+			break
+		}
+	}
+
+	return entries, nil
+}
+
+// Get a Slack user, from the cache if we've already seen them
+func (s *SlackAccessLogSource) user(userid string) (*SlackUserInfo, error) {
+	if s.users == nil {
+		s.users = make(map[string]*SlackUserInfo)
+	}
+	if su, ok := s.users[userid]; ok {
+		return su, nil
+	}
+
+	var su SlackUserInfo
+	err := s.get(slackUserInfoURL, url.Values{"user": {userid}}, &su)
+	if err != nil {
+		return nil, err
+	}
+	if !su.Ok {
+		return nil, errors.New(fmt.Sprintf("Error getting Slack user %s: %s", userid, su.Error))
+	}
+	s.users[userid] = &su
+
+	return &su, nil
+}
+
+// Call a Slack API method and decode the JSON response
+func (s *SlackAccessLogSource) get(u string, params url.Values, v interface{}) error {
+	client := s.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	req, err := http.NewRequest("GET", u+"?"+params.Encode(), nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+s.Token)
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return errors.New(fmt.Sprintf("Error calling Slack API: %s", err))
+	}
+	defer resp.Body.Close()
+
+	err = json.NewDecoder(resp.Body).Decode(v)
+	if err != nil {
+		return errors.New(fmt.Sprintf("Error decoding Slack API response: %s", err))
+	}
+
+	return nil
+}
+
+// Access log source backed by the G Suite Reports API audit activities of an application
+// ("token" by default) in a single domain
+// Refer to https://developers.google.com/admin-sdk/reports/v1/reference/activities/list
+type GSuiteAccessLogSource struct {
+	Application string // Reports API application name, e.g. "token"
+	Days        int    // Number of days of activities to read
+	Domain      string // G Suite domain
+
+	mdms *GSuiteMDMService
+}
+
+// Default Reports API application of G Suite access log sources. "token" events carry the OAuth
+// client's details (client_type, app_name), which is what user agent rules are matched against
+const DefaultGSuiteApplication string = "token"
+
+// Create a new G Suite access log source for a domain
+func (mdms *GSuiteMDMService) NewGSuiteAccessLogSource(domain, application string, days int) *GSuiteAccessLogSource {
+	if application == "" {
+		application = DefaultGSuiteApplication
+	}
+	if days < 1 {
+		days = 7
+	}
+
+	return &GSuiteAccessLogSource{Application: application, Days: days, Domain: domain, mdms: mdms}
+}
+
+// Name of the G Suite access log source
+func (s *GSuiteAccessLogSource) Name() string {
+	return "gsuite-" + s.Application
+}
+
+// Get all G Suite audit activities for the application as access log entries. The Reports API
+// has no user agent as such, so one is built from the client details of each event, e.g.
+// "client_type=NATIVE_IOS app_name=Slack" for "token" activities.
+func (s *GSuiteAccessLogSource) Entries() ([]AccessLogEntry, error) {
+	var entries []AccessLogEntry
+
+	// Authenticate with this domain
+	client, err := s.mdms.DomainHTTPClient(s.Domain, s.mdms.C.ReportsScope)
+	if err != nil {
+		return nil, err
+	}
+	rs, err := reports.NewService(s.mdms.Ctx, option.WithHTTPClient(client))
+	if err != nil {
+		return nil, err
+	}
+
+	// Pull down all pages of activities
+	start := time.Now().AddDate(0, 0, -s.Days).Format(time.RFC3339)
+	err = rs.Activities.List("all", s.Application).StartTime(start).Pages(s.mdms.Ctx, func(a *reports.Activities) error {
+		for _, act := range a.Items {
+			if act.Actor == nil || act.Actor.Email == "" {
+				continue
+			}
+
+			var ts time.Time
+			if act.Id != nil {
+				ts, _ = time.Parse(time.RFC3339, act.Id.Time)
+			}
+
+			for _, ev := range act.Events {
+				var ua []string
+				for _, p := range ev.Parameters {
+					switch p.Name {
+					case "app_name", "client_type", "device_type", "os_version", "user_agent":
+						if p.Value != "" {
+							ua = append(ua, p.Name+"="+p.Value)
+						}
+					}
+				}
+				entries = append(entries, AccessLogEntry{
+					DateLast:  ts,
+					Email:     act.Actor.Email,
+					IP:        act.IpAddress,
+					Source:    s.Name(),
+					UserAgent: strings.Join(ua, " "),
+					UserID:    act.Actor.ProfileId,
+					Username:  act.Actor.Email,
+				})
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("Error getting %s audit activities for %s: %s", s.Application, s.Domain, err))
+	}
+
+	// Events without any client details can never be classified, so a source that only has
+	// those (e.g. "login" activities) would silently never flag anyone
+	if len(entries) > 0 && !anyUserAgent(entries) {
+		return nil, errors.New(fmt.Sprintf("None of the %d %s audit activities for %s have client details to classify, use the %q application instead", len(entries), s.Application, s.Domain, DefaultGSuiteApplication))
+	}
+
+	return entries, nil
+}
+
+// Do any access log entries have a user agent?
+func anyUserAgent(entries []AccessLogEntry) bool {
+	for _, e := range entries {
+		if e.UserAgent != "" {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Access log source backed by a CSV file with a header row. Recognised columns are email,
+// useragent, ip, time (RFC3339), name, userid and username; email and useragent are required.
+type CSVAccessLogSource struct {
+	Path string // Path of the CSV file
+}
+
+// Name of the CSV access log source
+func (s *CSVAccessLogSource) Name() string {
+	return "csv-" + filepath.Base(s.Path)
+}
+
+// Get all access log entries from the CSV file
+func (s *CSVAccessLogSource) Entries() ([]AccessLogEntry, error) {
+	f, err := os.Open(s.Path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return ReadCSVAccessLog(f, s.Name())
+}
+
+// Read access log entries from CSV
+func ReadCSVAccessLog(r io.Reader, source string) ([]AccessLogEntry, error) {
+	var entries []AccessLogEntry
+
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	rows, err := cr.ReadAll()
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("Error reading CSV access log: %s", err))
+	}
+	if len(rows) < 1 {
+		return entries, nil
+	}
+
+	// Map the header row to column indexes
+	cols := make(map[string]int)
+	for k, h := range rows[0] {
+		cols[strings.ToLower(strings.TrimSpace(h))] = k
+	}
+	for _, c := range []string{"email", "useragent"} {
+		if _, ok := cols[c]; !ok {
+			return nil, errors.New(fmt.Sprintf("CSV access log has no %s column", c))
+		}
+	}
+	get := func(r []string, c string) string {
+		if k, ok := cols[c]; ok && k < len(r) {
+			return strings.TrimSpace(r[k])
+		}
+		return ""
+	}
+
+	// Range through the data rows
+	for _, r := range rows[1:] {
+		e := AccessLogEntry{
+			Email:     get(r, "email"),
+			IP:        get(r, "ip"),
+			Name:      get(r, "name"),
+			Source:    source,
+			UserAgent: get(r, "useragent"),
+			UserID:    get(r, "userid"),
+			Username:  get(r, "username"),
+		}
+		if e.Email == "" {
+			continue
+		}
+		if e.Username == "" {
+			e.Username = e.Email
+		}
+		e.DateLast, _ = time.Parse(time.RFC3339, get(r, "time"))
+		entries = append(entries, e)
+	}
+
+	return entries, nil
+}
+
+// Create the configured access log sources. If no sources are configured, the Slack access
+// log source is used. A G Suite source is created for each configured domain.
+func (mdms *GSuiteMDMService) NewAccessLogSources() ([]AccessLogSource, error) {
+	var sources []AccessLogSource
+
+	conf := mdms.C.Compliance.Sources
+	if len(conf) < 1 {
+		conf = []AccessLogSourceConf{{Type: "slack"}}
+	}
+
+	for _, sc := range conf {
+		switch sc.Type {
+		case "slack":
+			token, err := mdms.GetSecret(mdms.C.Compliance.SlackTokenID)
+			if err != nil {
+				return nil, err
+			}
+			sources = append(sources, NewSlackAccessLogSource(strings.TrimSpace(token), mdms.C.Compliance.SlackPages))
+
+		case "gsuite":
+			for _, d := range mdms.C.Domains {
+				sources = append(sources, mdms.NewGSuiteAccessLogSource(d.DomainName, sc.Application, sc.Days))
+			}
+
+		case "csv":
+			if sc.Path == "" {
+				return nil, errors.New("csv access log source requires path")
+			}
+			sources = append(sources, &CSVAccessLogSource{Path: sc.Path})
+
+		default:
+			return nil, errors.New(fmt.Sprintf("Unknown access log source type %q", sc.Type))
+		}
+	}
+
+	return sources, nil
+}
+
+// EOF
@@ -0,0 +1,185 @@
+package gsuitemdm
+
+//
+// GSuiteMDM action engine http handler, shared by the action cloud functions
+//
+
+import (
+	"cloud.google.com/go/logging"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// Serve a request for a single registered action
+type ActionHandler struct {
+	Action   string // Name of the registered action this handler performs
+	APIKeyID string // Secret Manager ID of the API key
+	AppName  string // Name of the cloud function, used for logging
+	ConfigID string // Secret Manager ID of the shared master configuration
+
+	mu      sync.Mutex     // Guards watcher
+	watcher *ConfigWatcher // Watches the configuration, once the first request has loaded it
+}
+
+// Get a service using the current configuration. The configuration is loaded by the first request
+// and reloaded by later ones when it changes, so a bad new version doesn't stop the function
+func (h *ActionHandler) service(ctx context.Context) (*GSuiteMDMService, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.watcher == nil {
+		cw, err := NewConfigWatcher(context.Background(), ConfigSecretPrefix+h.ConfigID)
+		if err != nil {
+			return nil, err
+		}
+		h.watcher = cw
+	} else {
+		_, err := h.watcher.Reload()
+		if err != nil {
+			log.Printf("Error reloading configuration, keeping the current configuration: %s", err)
+		}
+	}
+
+	return h.watcher.Service(ctx)
+}
+
+// Serve an action request
+func (h *ActionHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var err error
+	var l *logging.Client
+	var request ActionRequest
+	var role string
+
+	// Null message body?
+	if r.Body == nil {
+		http.Error(w, "Error: Null message body", 400)
+		return
+	}
+
+	// Not null, lets decode the message body
+	err = json.NewDecoder(r.Body).Decode(&request)
+	if err != nil {
+		log.Printf("Error decoding JSON message body: %s", err)
+		http.Error(w, "Error decoding JSON message body", 400)
+		return
+	}
+
+	// Get a context
+	ctx := context.Background()
+
+	// Get the API key from Secret Manager
+	apikey, err := GetSecret(ctx, h.APIKeyID)
+	if err != nil {
+		log.Printf("Error retrieving API key from Secret Manager: %s", err)
+		http.Error(w, "Error retrieving API key from Secret Manager", 400)
+		return
+	}
+
+	// The API key may perform any action. Other keys are checked once we have the configuration
+	if request.Key != "" && request.Key == strings.TrimSpace(apikey) {
+		role = RoleAdmin
+	}
+
+	// Correct action specified?
+	if request.Action != h.Action {
+		log.Printf("Error: Invalid action specified")
+		http.Error(w, "Invalid request (invalid action specified)", 400)
+		return
+	}
+
+	// Check if the request is valid
+	if (request.IMEI == "" && request.SN == "") || (request.IMEI != "" && request.SN != "") {
+		log.Printf("Error: Invalid request (IMEI or SN not specified)")
+		http.Error(w, "Invalid request (IMEI or SN not specified)", 400)
+		return
+	}
+
+	// Get a G Suite MDM Service
+	gs, err := h.service(ctx)
+	if err != nil {
+		// Log to stderr, will be captured as a basic Stackdriver log
+		log.Printf("Error: gsuitemdm cloudfunction %s could not start: %s", h.AppName, err)
+		http.Error(w, fmt.Sprintf("Error: %s could not start", h.AppName), 500)
+		return
+	}
+
+	// Was the operator key sent?
+	if role == "" {
+		role = gs.OperatorRole(request.Key)
+	}
+	if role == "" {
+		log.Printf("Error: Incorrect key sent with request")
+		http.Error(w, "Not authorized", 401)
+		return
+	}
+
+	// Debug mode?
+	if request.Debug == true {
+		gs.C.Debug = true
+	}
+
+	// Initialise Stackdriver logging for this GCP project
+	l, err = logging.NewClient(ctx, gs.C.ProjectID)
+	if err != nil {
+		log.Printf("Error creating Stackdriver logging client: %s", err)
+		http.Error(w, "Error creating Stackdriver logging client", 500)
+		return
+	}
+	defer l.Close()
+
+	// Register a Stackdriver logger instance for this app
+	sl := l.Logger(h.AppName)
+
+	// Run the action
+	result, err := gs.RunAction(&request, role)
+	if err != nil {
+		code := 500
+		if ae, ok := err.(*ActionError); ok {
+			code = ae.Code
+		}
+		log.Printf("%s", err)
+		http.Error(w, err.Error(), code)
+		if code >= 500 {
+			sl.Log(logging.Entry{Severity: logging.Warning, Payload: err.Error()})
+		}
+		return
+	}
+
+	// Finished, write a log entry
+	sl.Log(logging.Entry{Severity: logging.Notice, Payload: h.AppName + " Success: SN=" + result.Device.SN + " Owner=" + result.Device.Email + " Action=" + result.SDKAction + " Status=" + result.Status + " Role=" + role + " RemoteIP=" + GetIP(r)})
+
+	// Report the confirmed status, if we have it
+	if result.Confirmed {
+		fmt.Fprintf(w, "%s Success: status=%s (confirmed)\n", h.AppName, result.Status)
+	} else {
+		fmt.Fprintf(w, "%s Success: status=%s (not yet confirmed by the Admin SDK)\n", h.AppName, result.Status)
+	}
+
+	return
+}
+
+// Get the role of a caller that sent a key other than the API key: RoleOperator if it is the
+// configured operator key, otherwise no role
+func (mdms *GSuiteMDMService) OperatorRole(key string) string {
+	if key == "" || mdms.C.OperatorKeyID == "" {
+		return ""
+	}
+
+	opkey, err := GetSecret(mdms.Ctx, mdms.C.OperatorKeyID)
+	if err != nil {
+		log.Printf("Error retrieving operator key from Secret Manager: %s", err)
+		return ""
+	}
+	if key != strings.TrimSpace(opkey) {
+		return ""
+	}
+
+	return RoleOperator
+}
+
+// EOF
@@ -0,0 +1,384 @@
+package gsuitemdm
+
+//
+// GSuiteMDM mobile device actions, valid status transitions & the action engine
+//
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	admin "google.golang.org/api/admin/directory/v1"
+	"google.golang.org/api/googleapi"
+)
+
+// Admin SDK mobile device actions
+// Refer to https://developers.google.com/admin-sdk/directory/v1/reference/mobiledevices/action
+const (
+	ActionAccountWipe            string = "admin_account_wipe"
+	ActionApprove                string = "approve"
+	ActionBlock                  string = "block"
+	ActionCancelWipeThenActivate string = "cancel_remote_wipe_then_activate"
+	ActionCancelWipeThenBlock    string = "cancel_remote_wipe_then_block"
+	ActionRemoteWipe             string = "admin_remote_wipe"
+)
+
+// Default post-action verification timings
+const (
+	DefaultActionVerifyIntervalSeconds int = 2
+	DefaultActionVerifyTimeoutSeconds  int = 30
+)
+
+// Roles that may be granted to callers of actions. Admins may perform any action, operators
+// only those registered with RoleOperator
+const (
+	RoleAdmin    string = "admin"
+	RoleOperator string = "operator"
+)
+
+// Device statuses each action may be performed from. The Admin SDK reports wipes in progress
+// as WIPING, or ACCOUNT_WIPING/DEVICE_WIPING depending on the API version
+var ActionValidStatuses = map[string][]string{
+	ActionAccountWipe:            {"APPROVED", "BLOCKED", "PENDING", "UNPROVISIONED"},
+	ActionApprove:                {"BLOCKED", "PENDING"},
+	ActionBlock:                  {"APPROVED", "PENDING", "UNPROVISIONED"},
+	ActionCancelWipeThenActivate: {"ACCOUNT_WIPING", "DEVICE_WIPING", "WIPING"},
+	ActionCancelWipeThenBlock:    {"ACCOUNT_WIPING", "DEVICE_WIPING", "WIPING"},
+	// An account wipe can be escalated to a device wipe
+	ActionRemoteWipe: {"ACCOUNT_WIPED", "ACCOUNT_WIPING", "APPROVED", "BLOCKED", "PENDING", "UNPROVISIONED", "WIPING"},
+}
+
+// Device statuses that confirm each action has been performed
+var ActionResultStatuses = map[string][]string{
+	ActionAccountWipe:            {"ACCOUNT_WIPED", "ACCOUNT_WIPING"},
+	ActionApprove:                {"APPROVED"},
+	ActionBlock:                  {"BLOCKED"},
+	ActionCancelWipeThenActivate: {"APPROVED"},
+	ActionCancelWipeThenBlock:    {"BLOCKED"},
+	ActionRemoteWipe:             {"DEVICE_WIPED", "DEVICE_WIPING", "WIPED", "WIPING"},
+}
+
+// Check that an action makes sense for a device in its current status
+func ValidateAction(status, action string) error {
+	valid, ok := ActionValidStatuses[action]
+	if !ok {
+		return errors.New(fmt.Sprintf("Unknown action %q", action))
+	}
+
+	if !containsString(valid, strings.ToUpper(status)) {
+		return errors.New(fmt.Sprintf("Action %s is not valid for a device in status %s (must be one of %s)", action, status, strings.Join(valid, ", ")))
+	}
+
+	return nil
+}
+
+// Find a device in a domain in Datastore by IMEI or serial number
+func (mdms *GSuiteMDMService) FindDatastoreDevice(domain, imei, sn string) (*DatastoreMobileDevice, error) {
+	if (imei == "" && sn == "") || (imei != "" && sn != "") {
+		return nil, errors.New("Invalid request (IMEI or SN not specified)")
+	}
+
+	// Devices are keyed by serial number
+	if sn != "" {
+		d, err := mdms.GetDatastoreDevice(sn)
+		if err != nil || d.Domain != domain {
+			return nil, errors.New("Device not found")
+		}
+		return d, nil
+	}
+
+	err := mdms.GetDatastoreData()
+	if err != nil {
+		return nil, err
+	}
+	for i, d := range mdms.DatastoreData {
+		if d.Domain == domain && strings.Replace(d.IMEI, " ", "", -1) == strings.Replace(imei, " ", "", -1) {
+			return &mdms.DatastoreData[i], nil
+		}
+	}
+
+	return nil, errors.New("Device not found")
+}
+
+// A mobile device action that can be requested of the action engine
+type ActionDefinition struct {
+	// Does the request need to be sent with `confirm: true`?
+	Confirm bool
+
+	// Deletes the device, rather than changing its status
+	Delete bool
+
+	// Perform the Admin SDK call for the resolved Admin SDK action
+	Do func(mdms *GSuiteMDMService, d *DatastoreMobileDevice, sdkaction string) error
+
+	// Name of the action as sent in requests, e.g. "approve"
+	Name string
+
+	// Work out the Admin SDK action to perform on a device, e.g. "admin_remote_wipe". If nil,
+	// the Admin SDK action is the action name
+	Resolve func(mdms *GSuiteMDMService, d *DatastoreMobileDevice, req *ActionRequest) (string, error)
+
+	// Role required of the caller
+	Role string
+
+	// Device statuses the action may be performed from. If nil, the valid statuses of the
+	// resolved Admin SDK action are used, and actions with neither are allowed from any status
+	Statuses []string
+
+	// Verb used in messages, e.g. "approving"
+	Verb string
+}
+
+// Result of an action run by the action engine
+type ActionResult struct {
+	Confirmed bool                   // Did the Admin SDK confirm the expected status?
+	Device    *DatastoreMobileDevice // Device the action was performed on
+	SDKAction string                 // Admin SDK action performed
+	Status    string                 // Last known status of the device, "DELETED" once deleted
+}
+
+// An action engine error, with the HTTP status code to respond with
+type ActionError struct {
+	Code    int
+	Message string
+}
+
+// Registered actions, by name
+var registeredActions = map[string]*ActionDefinition{}
+
+// Register the built in actions
+func init() {
+	// Approve & block are performed as-is
+	sdkAction := func(mdms *GSuiteMDMService, d *DatastoreMobileDevice, sdkaction string) error {
+		return mdms.ActionDevice(d, sdkaction)
+	}
+
+	RegisterAction(&ActionDefinition{Confirm: true, Do: sdkAction, Name: ActionApprove, Role: RoleOperator, Verb: "approving"})
+	RegisterAction(&ActionDefinition{Confirm: true, Do: sdkAction, Name: ActionBlock, Role: RoleOperator, Verb: "blocking"})
+
+	// Cancel a wipe in progress, then activate or block the device
+	RegisterAction(&ActionDefinition{
+		Confirm: true,
+		Do:      sdkAction,
+		Name:    "cancelwipe",
+		Resolve: func(mdms *GSuiteMDMService, d *DatastoreMobileDevice, req *ActionRequest) (string, error) {
+			switch req.Then {
+			case "", "activate":
+				return ActionCancelWipeThenActivate, nil
+			case "block":
+				return ActionCancelWipeThenBlock, nil
+			}
+			return "", errors.New("Invalid request (then must be activate or block)")
+		},
+		Role: RoleOperator,
+		Verb: "cancelling the wipe of",
+	})
+
+	// Delete has no Admin SDK action, so no status restrictions
+	RegisterAction(&ActionDefinition{
+		Confirm: true,
+		Do: func(mdms *GSuiteMDMService, d *DatastoreMobileDevice, sdkaction string) error {
+			return mdms.DeleteAdminSDKDevice(d)
+		},
+		Delete: true,
+		Name:   "delete",
+		Role:   RoleAdmin,
+		Verb:   "deleting",
+	})
+
+	// Unblocking is an approve of a BLOCKED device
+	RegisterAction(&ActionDefinition{
+		Confirm: true,
+		Do:      sdkAction,
+		Name:    "unblock",
+		Resolve: func(mdms *GSuiteMDMService, d *DatastoreMobileDevice, req *ActionRequest) (string, error) {
+			return ActionApprove, nil
+		},
+		Role:     RoleOperator,
+		Statuses: []string{"BLOCKED"},
+		Verb:     "unblocking",
+	})
+
+	// The wipe type comes from the request or the device's ownership
+	RegisterAction(&ActionDefinition{
+		Confirm: true,
+		Do:      sdkAction,
+		Name:    "wipe",
+		Resolve: func(mdms *GSuiteMDMService, d *DatastoreMobileDevice, req *ActionRequest) (string, error) {
+			return mdms.WipeAction(d, req.WipeType)
+		},
+		Role: RoleAdmin,
+		Verb: "wiping",
+	})
+}
+
+// Error message of an action engine error
+func (e *ActionError) Error() string {
+	return e.Message
+}
+
+// Get a registered action
+func GetAction(name string) (*ActionDefinition, bool) {
+	def, ok := registeredActions[name]
+	return def, ok
+}
+
+// Register an action with the action engine, replacing any action with the same name
+func RegisterAction(def *ActionDefinition) {
+	registeredActions[def.Name] = def
+}
+
+// Names of all registered actions
+func RegisteredActions() []string {
+	var names []string
+	for name := range registeredActions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// Can a caller with this role perform an action that requires the required role?
+func RoleAllows(role, required string) bool {
+	switch role {
+	case RoleAdmin:
+		return true
+	case RoleOperator:
+		return required == RoleOperator
+	}
+
+	return false
+}
+
+// Run an action on a device on behalf of a caller with the given role, then wait for the Admin
+// SDK to confirm it
+func (mdms *GSuiteMDMService) RunAction(req *ActionRequest, role string) (*ActionResult, error) {
+	// Do we know this action?
+	def, ok := GetAction(req.Action)
+	if !ok {
+		return nil, &ActionError{Code: 400, Message: "Invalid request (invalid action specified)"}
+	}
+
+	// Is the caller allowed to perform it?
+	if !RoleAllows(role, def.Role) {
+		return nil, &ActionError{Code: 403, Message: fmt.Sprintf("Not authorized (action %s requires role %s)", def.Name, def.Role)}
+	}
+
+	// Was the (required) domain specified?
+	if req.Domain == "" || mdms.IsDomainConfigured(req.Domain) == false {
+		return nil, &ActionError{Code: 400, Message: "Invalid domain specified"}
+	}
+
+	// Find the device
+	d, err := mdms.FindDatastoreDevice(req.Domain, req.IMEI, req.SN)
+	if err != nil {
+		return nil, &ActionError{Code: 400, Message: "Error: " + err.Error()}
+	}
+
+	// Which Admin SDK action?
+	sdkaction := def.Name
+	if def.Resolve != nil {
+		sdkaction, err = def.Resolve(mdms, d, req)
+		if err != nil {
+			return nil, &ActionError{Code: 400, Message: "Error: " + err.Error()}
+		}
+	}
+
+	// Does it make sense for this device?
+	valid := def.Statuses
+	if valid == nil {
+		valid = ActionValidStatuses[sdkaction]
+	}
+	if valid != nil && !containsString(valid, strings.ToUpper(d.Status)) {
+		return nil, &ActionError{Code: 400, Message: fmt.Sprintf("Error: Device found but action %s is not valid for a device in status %s (must be one of %s)", def.Name, d.Status, strings.Join(valid, ", "))}
+	}
+
+	// Was `confirm: true` sent along with the request?
+	if def.Confirm && req.Confirm != true {
+		return nil, &ActionError{Code: 400, Message: "Error: Device found but no CONFIRM sent"}
+	}
+
+	// Do it
+	err = def.Do(mdms, d, sdkaction)
+	if err != nil {
+		return nil, &ActionError{Code: 500, Message: fmt.Sprintf("Error %s device %s in domain %s: %s", def.Verb, d.ResourceId, req.Domain, err)}
+	}
+
+	return mdms.VerifyAction(d, sdkaction, def.Delete), nil
+}
+
+// Poll the Admin SDK until a device reaches the expected status of an action (or is gone, for
+// deletes) or verification times out, then update the stored device with what was found. If
+// verification is disabled, the device is checked once without waiting
+func (mdms *GSuiteMDMService) VerifyAction(d *DatastoreMobileDevice, sdkaction string, deleted bool) *ActionResult {
+	var last *admin.MobileDevice
+	var err error
+
+	r := &ActionResult{Device: d, SDKAction: sdkaction, Status: d.Status}
+
+	// Work out the timings
+	interval := mdms.C.ActionVerify.IntervalSeconds
+	if interval < 1 {
+		interval = DefaultActionVerifyIntervalSeconds
+	}
+	timeout := mdms.C.ActionVerify.TimeoutSeconds
+	if timeout == 0 {
+		timeout = DefaultActionVerifyTimeoutSeconds
+	}
+	deadline := time.Now().Add(time.Duration(timeout) * time.Second)
+
+	// Poll
+	for {
+		var md *admin.MobileDevice
+		md, err = mdms.GetAdminSDKDevice(d)
+		switch {
+		case deleted && isNotFound(err):
+			r.Confirmed = true
+			r.Status = "DELETED"
+		case err != nil:
+			log.Printf("Error verifying action %s on device SN=%s: %s", sdkaction, d.SN, err)
+		case md != nil:
+			last = md
+			r.Status = md.Status
+			r.Confirmed = !deleted && containsString(ActionResultStatuses[sdkaction], strings.ToUpper(md.Status))
+		}
+		if r.Confirmed || timeout < 0 || !time.Now().Add(time.Duration(interval)*time.Second).Before(deadline) {
+			break
+		}
+		time.Sleep(time.Duration(interval) * time.Second)
+	}
+
+	// Update the stored device straight away rather than waiting for the next sync, using the
+	// last state successfully read, as the final poll may have failed
+	switch {
+	case deleted && r.Confirmed:
+		err = mdms.DeleteDatastoreDevice(d.SN)
+	case last != nil:
+		err = mdms.UpdateDatastoreDevice(last)
+		d.Status = last.Status
+	default:
+		return r
+	}
+	if err != nil {
+		log.Printf("Error updating stored device SN=%s after action %s: %s", d.SN, sdkaction, err)
+	}
+
+	return r
+}
+
+// Is an Admin SDK error a 404?
+func isNotFound(err error) bool {
+	if e, ok := err.(*googleapi.Error); ok {
+		return e.Code == http.StatusNotFound
+	}
+
+	return false
+}
+
+// EOF
@@ -0,0 +1,46 @@
+package gsuitemdm
+
+//
+// GSuiteMDM action engine tests
+//
+
+import (
+	"testing"
+)
+
+// Actions are only valid from the statuses they make sense in
+func TestValidateAction(t *testing.T) {
+	tests := []struct {
+		name    string
+		status  string
+		action  string
+		wanterr bool
+	}{
+		{name: "approve pending", status: "PENDING", action: ActionApprove},
+		{name: "approve blocked", status: "BLOCKED", action: ActionApprove},
+		{name: "approve approved", status: "APPROVED", action: ActionApprove, wanterr: true},
+		{name: "block approved", status: "APPROVED", action: ActionBlock},
+		{name: "block blocked", status: "BLOCKED", action: ActionBlock, wanterr: true},
+		{name: "status case ignored", status: "approved", action: ActionBlock},
+		{name: "account wipe approved", status: "APPROVED", action: ActionAccountWipe},
+		{name: "account wipe wiping", status: "ACCOUNT_WIPING", action: ActionAccountWipe, wanterr: true},
+		{name: "remote wipe escalates account wipe", status: "ACCOUNT_WIPED", action: ActionRemoteWipe},
+		{name: "remote wipe wiped", status: "WIPED", action: ActionRemoteWipe, wanterr: true},
+		{name: "cancel wipe then activate wiping", status: "WIPING", action: ActionCancelWipeThenActivate},
+		{name: "cancel wipe then block device wiping", status: "DEVICE_WIPING", action: ActionCancelWipeThenBlock},
+		{name: "cancel wipe approved", status: "APPROVED", action: ActionCancelWipeThenActivate, wanterr: true},
+		{name: "unknown action", status: "APPROVED", action: "delete_everything", wanterr: true},
+		{name: "empty status", status: "", action: ActionApprove, wanterr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateAction(tt.status, tt.action)
+			if (err != nil) != tt.wanterr {
+				t.Errorf("ValidateAction(%q, %q) error = %v, wanterr %v", tt.status, tt.action, err, tt.wanterr)
+			}
+		})
+	}
+}
+
+// EOF
@@ -5,47 +5,50 @@ package gsuitemdm
 //
 
 import (
-	"context"
 	"errors"
 	"fmt"
-	"golang.org/x/oauth2/google"
 	admin "google.golang.org/api/admin/directory/v1"
+	"net/http"
 	"strings"
+	"time"
 )
 
+// Most devices the Admin SDK returns in a page of results
+const MaxAdminSDKPageSize int64 = 100
+
 // Authenticate with a domain, get an admin.Service
 func (mdms *GSuiteMDMService) AuthenticateWithDomain(customerid, domain, scope string) (*admin.Service, error) {
+	// Get an authenticated http client for this domain
+	client, err := mdms.DomainHTTPClient(domain, scope)
+	if err != nil {
+		return nil, err
+	}
+
+	// Get the API client for the authenticated http client
+	as, err := adminService(client)
+	if err != nil {
+		return nil, err
+	}
+
+	// We've made it all the way through (w00t!), so return the admin.Service
+	return as, nil
+}
+
+// Get an http client authenticated with a domain's credentials, running as the domain's admin user
+func (mdms *GSuiteMDMService) DomainHTTPClient(domain, scope string) (*http.Client, error) {
 	// Range through slice of configured domains until we find the domain we're looking for
 	for _, d := range mdms.C.Domains {
 		switch {
 		// Domain found!
 		case d.DomainName == domain:
-			// We need to get the credentials for this domain from Secret Manager
-			ctx := context.Background()
-
-			// Retrieve this domain's configuration from Secret Manager
-			creds, err := GetSecret(ctx, d.SecretID)
-			if err != nil {
-				return nil, err
-			}
-
-			// Create JWT config using the credentials
-			jwt, err := google.JWTConfigFromJSON([]byte(creds), scope)
+			// Retrieve this domain's credentials from the secret provider
+			creds, err := mdms.GetSecret(d.SecretID)
 			if err != nil {
 				return nil, err
 			}
 
-			// Specify which admin user the API calls should "run as"
-			jwt.Subject = d.AdminUser
-
-			// Make the API client using our JWT config
-			as, err := admin.New(jwt.Client(context.Background()))
-			if err != nil {
-				return nil, err
-			}
-
-			// We've made it all the way through (w00t!), so return the admin.Service
-			return as, nil
+			// Get a client using the credentials, with API calls "running as" the admin user
+			return jwtClient(creds, scope, d.AdminUser)
 		}
 	}
 
@@ -53,6 +56,80 @@ func (mdms *GSuiteMDMService) AuthenticateWithDomain(customerid, domain, scope s
 	return nil, errors.New(fmt.Sprintf("Could not authenticate with domain %s", domain))
 }
 
+// Perform an Admin SDK action (approve, block, admin_account_wipe etc) on a mobile device
+// Refer to https://developers.google.com/admin-sdk/directory/v1/reference/mobiledevices/action
+func (mdms *GSuiteMDMService) ActionDevice(device *DatastoreMobileDevice, action string) error {
+	// Does the action make sense for this device?
+	err := ValidateAction(device.Status, action)
+	if err != nil {
+		return errors.New(fmt.Sprintf("Error performing action on device SN=%s: %s", device.SN, err))
+	}
+
+	// Get this domain's CustomerID
+	cid, err := mdms.GetDomainCustomerID(device.Domain)
+	if err != nil {
+		return err
+	}
+
+	// Authenticate with the Admin SDK for this domain
+	as, err := mdms.AuthenticateWithDomain(cid, device.Domain, mdms.C.ActionScope)
+	if err != nil {
+		return errors.New(fmt.Sprintf("Error authenticating with the Admin SDK for domain %s: %s", device.Domain, err))
+	}
+
+	// Perform the action
+	err = as.Mobiledevices.Action(cid, device.ResourceId, &admin.MobileDeviceAction{Action: action}).Do()
+	if err != nil {
+		return errors.New(fmt.Sprintf("Error performing action %s on device SN=%s: %s", action, device.SN, err))
+	}
+
+	mdms.PublishAction(device, action)
+
+	return nil
+}
+
+// Delete a mobile device from G Suite MDM using the Admin SDK
+func (mdms *GSuiteMDMService) DeleteAdminSDKDevice(device *DatastoreMobileDevice) error {
+	// Get this domain's CustomerID
+	cid, err := mdms.GetDomainCustomerID(device.Domain)
+	if err != nil {
+		return err
+	}
+
+	// Authenticate with the Admin SDK for this domain
+	as, err := mdms.AuthenticateWithDomain(cid, device.Domain, mdms.C.ActionScope)
+	if err != nil {
+		return errors.New(fmt.Sprintf("Error authenticating with the Admin SDK for domain %s: %s", device.Domain, err))
+	}
+
+	// Delete the device. Admin SDK errors are returned as is, so callers can check for a 404
+	return as.Mobiledevices.Delete(cid, device.ResourceId).Do()
+}
+
+// Get the current state of a mobile device from the Admin SDK
+func (mdms *GSuiteMDMService) GetAdminSDKDevice(device *DatastoreMobileDevice) (*admin.MobileDevice, error) {
+	// Get this domain's CustomerID
+	cid, err := mdms.GetDomainCustomerID(device.Domain)
+	if err != nil {
+		return nil, err
+	}
+
+	// Authenticate with the Admin SDK for this domain
+	as, err := mdms.AuthenticateWithDomain(cid, device.Domain, mdms.C.SearchScope)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("Error authenticating with the Admin SDK for domain %s: %s", device.Domain, err))
+	}
+
+	// Get the device
+	// Refer to https://developers.google.com/admin-sdk/directory/v1/reference/mobiledevices/get
+	md, err := as.Mobiledevices.Get(cid, device.ResourceId).Do()
+	if err != nil {
+		return nil, err
+	}
+
+	return md, nil
+}
+
 // Convert an Admin SDK mobile device object to a Datastore mobile device object
 func (mdms *GSuiteMDMService) ConvertSDKDeviceToDatastore(device *admin.MobileDevice) (*DatastoreMobileDevice, error) {
 	var d DatastoreMobileDevice
@@ -84,38 +161,157 @@ func (mdms *GSuiteMDMService) ConvertSDKDeviceToDatastore(device *admin.MobileDe
 
 // Get the list of devices for a G Suite domain from the Admin SDK
 func (mdms *GSuiteMDMService) GetAdminSDKDevices(domain string) error {
-	var as *admin.Service
-	var cid string
-	var err error
+	devices, err := mdms.ListAdminSDKDevices(domain)
+	if err != nil {
+		return err
+	}
 
-	// Iterate through main config struct until we find the specific domain
-	for _, d := range mdms.C.Domains {
-		switch {
-		case d.DomainName == domain:
-			// Domain found!
-			cid, err = mdms.GetDomainCustomerID(domain)
-			if err != nil {
-				return err
-			}
+	mdms.SDKData = &admin.MobileDevices{Mobiledevices: devices}
 
-			// Authenticate with this domain
-			as, err = mdms.AuthenticateWithDomain(cid, domain, mdms.C.SearchScope)
-			if err != nil {
-				return err
-			}
+	return nil
+}
+
+// List all of a G Suite domain's devices from the Admin SDK, following every page of results
+func (mdms *GSuiteMDMService) ListAdminSDKDevices(domain string) ([]*admin.MobileDevice, error) {
+	var devices []*admin.MobileDevice
 
-			// Pull down the list of devices for this G Suite domain
-			// Refer to https://godoc.org/google.golang.org/api/admin/directory/v1#MobileDevices
-			mdms.SDKData, err = as.Mobiledevices.List(d.CustomerID).OrderBy(mdms.C.APIQueryOrderBy).Do()
+	_, _, err := mdms.ListAdminSDKDevicePages(domain, func(page []*admin.MobileDevice) error {
+		devices = append(devices, page...)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return devices, nil
+}
+
+// List a G Suite domain's devices from the Admin SDK a page at a time, calling f with each page.
+// Requests are limited by the domain's rate limiter and transient errors are retried. Returns the
+// number of requests made and how long they waited for the rate limiter
+func (mdms *GSuiteMDMService) ListAdminSDKDevicePages(domain string, f func([]*admin.MobileDevice) error) (int, time.Duration, error) {
+	var requests int
+	var token string
+	var waited time.Duration
+
+	// Get this domain's CustomerID
+	cid, err := mdms.GetDomainCustomerID(domain)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	// Authenticate with this domain
+	as, err := mdms.AuthenticateWithDomain(cid, domain, mdms.C.SearchScope)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	// Pull down the list of devices for this G Suite domain, a page at a time
+	// Refer to https://godoc.org/google.golang.org/api/admin/directory/v1#MobileDevices
+	limiter := mdms.DomainLimiter(domain)
+	call := as.Mobiledevices.List(cid).OrderBy(mdms.C.APIQueryOrderBy).MaxResults(MaxAdminSDKPageSize)
+	for {
+		var page *admin.MobileDevices
+
+		n, err := mdms.withRetry(func() error {
+			start := time.Now()
+			err := limiter.Wait(mdms.Ctx)
+			waited += time.Since(start)
 			if err != nil {
 				return err
 			}
+			page, err = call.PageToken(token).Context(mdms.Ctx).Do()
+			return err
+		})
+		requests += n
+		if err != nil {
+			return requests, waited, err
+		}
 
+		err = f(page.Mobiledevices)
+		if err != nil {
+			return requests, waited, err
+		}
+
+		// Last page?
+		if page.NextPageToken == "" {
+			return requests, waited, nil
+		}
+		token = page.NextPageToken
+	}
+}
+
+// Find the mobile devices owned by an email address in every configured domain using the Admin
+// SDK. Domains that can't be searched are returned as errors, and don't stop the others
+func (mdms *GSuiteMDMService) FindAdminSDKDevicesByEmail(email string) ([]*DatastoreMobileDevice, []error) {
+	var devices []*DatastoreMobileDevice
+	var errs []error
+
+	for _, d := range mdms.C.Domains {
+		// Authenticate with this domain
+		as, err := mdms.AuthenticateWithDomain(d.CustomerID, d.DomainName, mdms.C.SearchScope)
+		if err != nil {
+			errs = append(errs, errors.New(fmt.Sprintf("Error authenticating with the Admin SDK for domain %s: %s", d.DomainName, err)))
+			continue
+		}
+
+		// Search this domain's devices
+		// Refer to https://support.google.com/a/answer/7549103 for the query syntax
+		err = as.Mobiledevices.List(d.CustomerID).Query("email:"+email).Pages(mdms.Ctx, func(md *admin.MobileDevices) error {
+			for _, device := range md.Mobiledevices {
+				// The query matches prefixes, so check for an exact match
+				if len(device.Email) < 1 || strings.ToLower(device.Email[0]) != strings.ToLower(email) || len(device.Name) < 1 {
+					continue
+				}
+				dd, err := mdms.ConvertSDKDeviceToDatastore(device)
+				if err != nil {
+					return err
+				}
+				devices = append(devices, dd)
+			}
 			return nil
+		})
+		if err != nil {
+			errs = append(errs, errors.New(fmt.Sprintf("Error searching for devices of %s in domain %s: %s", email, d.DomainName, err)))
 		}
 	}
 
-	return nil
+	return devices, errs
+}
+
+// Get the list of active users for a G Suite domain from the Admin SDK
+func (mdms *GSuiteMDMService) GetAdminSDKUsers(domain string) ([]*admin.User, error) {
+	var users []*admin.User
+
+	// Get the CustomerID for this domain
+	cid, err := mdms.GetDomainCustomerID(domain)
+	if err != nil {
+		return nil, err
+	}
+
+	// Authenticate with this domain
+	as, err := mdms.AuthenticateWithDomain(cid, domain, mdms.C.DirectoryScope)
+	if err != nil {
+		return nil, err
+	}
+
+	// Pull down all pages of users for this G Suite domain
+	// Refer to https://godoc.org/google.golang.org/api/admin/directory/v1#UsersListCall
+	err = as.Users.List().Domain(domain).OrderBy("email").Pages(mdms.Ctx, func(u *admin.Users) error {
+		for _, user := range u.Users {
+			// Skip suspended & archived users
+			if user.Suspended || user.Archived {
+				continue
+			}
+			users = append(users, user)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return users, nil
 }
 
 // EOF
@@ -0,0 +1,196 @@
+package gsuitemdm
+
+//
+// GSuiteMDM asset lifecycle funcs
+//
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Layout of asset dates (purchase date, warranty expiry)
+const AssetDateLayout string = "2006-01-02"
+
+// Preserve existing asset data for a device and record an owner assignment if the
+// device's owner has changed
+func preserveAssetData(nd, ed *DatastoreMobileDevice, now time.Time) {
+	// Asset data is never reported by the Admin SDK, so always keep what we have
+	nd.AssetTag = ed.AssetTag
+	nd.Carrier = ed.Carrier
+	nd.Cost = ed.Cost
+	nd.Plan = ed.Plan
+	nd.PurchaseDate = ed.PurchaseDate
+	nd.Vendor = ed.Vendor
+	nd.WarrantyExpiry = ed.WarrantyExpiry
+	nd.OwnerHistory = ed.OwnerHistory
+	nd.Ownership = ed.Ownership
+
+	// No owner, nothing to record
+	if nd.Email == "" {
+		return
+	}
+
+	// Brand new history? Start it from the device's first sync
+	if len(nd.OwnerHistory) < 1 {
+		from := nd.SyncFirst
+		if from == "" {
+			from = now.Format(time.RFC3339)
+		}
+		nd.OwnerHistory = []OwnerAssignment{{Email: nd.Email, Name: nd.Name, From: from}}
+		return
+	}
+
+	// Has the owner changed?
+	cur := &nd.OwnerHistory[len(nd.OwnerHistory)-1]
+	if strings.ToLower(cur.Email) == strings.ToLower(nd.Email) && cur.To == "" {
+		return
+	}
+
+	// Close off the current assignment and start a new one
+	ts := now.Format(time.RFC3339)
+	if cur.To == "" {
+		cur.To = ts
+	}
+	nd.OwnerHistory = append(nd.OwnerHistory, OwnerAssignment{Email: nd.Email, Name: nd.Name, From: ts})
+}
+
+// Update the asset data of a device in Datastore. Only fields that are set in the request are changed
+func (mdms *GSuiteMDMService) UpdateDeviceAsset(req AssetRequest) (*DatastoreMobileDevice, error) {
+	// Validate the request before touching anything
+	err := req.validate()
+	if err != nil {
+		return nil, err
+	}
+
+	// Get the device
+	d, err := mdms.GetDatastoreDevice(req.SN)
+	if err != nil {
+		return nil, err
+	}
+
+	// Update whatever was specified
+	if req.AssetTag != nil {
+		d.AssetTag = strings.TrimSpace(*req.AssetTag)
+	}
+	if req.Carrier != nil {
+		d.Carrier = strings.TrimSpace(*req.Carrier)
+	}
+	if req.Cost != nil {
+		d.Cost = 0
+		if *req.Cost != "" {
+			d.Cost, _ = strconv.ParseFloat(*req.Cost, 64)
+		}
+	}
+	if req.Ownership != nil {
+		d.Ownership = strings.ToLower(strings.TrimSpace(*req.Ownership))
+	}
+	if req.Plan != nil {
+		d.Plan = strings.TrimSpace(*req.Plan)
+	}
+	if req.PurchaseDate != nil {
+		d.PurchaseDate = *req.PurchaseDate
+	}
+	if req.Vendor != nil {
+		d.Vendor = strings.TrimSpace(*req.Vendor)
+	}
+	if req.WarrantyExpiry != nil {
+		d.WarrantyExpiry = *req.WarrantyExpiry
+	}
+
+	// Save the device
+	err = mdms.PutDatastoreDevice(d)
+	if err != nil {
+		return nil, err
+	}
+
+	return d, nil
+}
+
+// Validate an asset update request
+func (req AssetRequest) validate() error {
+	if req.SN == "" {
+		return errors.New("Invalid request (SN not specified)")
+	}
+
+	// Dates must be valid, or empty to clear them
+	for n, v := range map[string]*string{"purchasedate": req.PurchaseDate, "warrantyexpiry": req.WarrantyExpiry} {
+		if v == nil || *v == "" {
+			continue
+		}
+		if _, err := time.Parse(AssetDateLayout, *v); err != nil {
+			return errors.New(fmt.Sprintf("Invalid request (%s must be YYYY-MM-DD)", n))
+		}
+	}
+
+	// Ownership must be known, or empty to clear it
+	if req.Ownership != nil && !ValidOwnership(strings.ToLower(strings.TrimSpace(*req.Ownership))) {
+		return errors.New(fmt.Sprintf("Invalid request (ownership must be %q or %q)", OwnershipCorporate, OwnershipBYOD))
+	}
+
+	// Cost must be a non-negative number, or empty to clear it
+	if req.Cost != nil && *req.Cost != "" {
+		c, err := strconv.ParseFloat(*req.Cost, 64)
+		if err != nil || c < 0 {
+			return errors.New("Invalid request (cost must be a non-negative number)")
+		}
+	}
+
+	return nil
+}
+
+// Get devices whose warranty expires within the next N days. Results are sorted by warranty
+// expiry, soonest first.
+func ExpiringWarranties(devices []DatastoreMobileDevice, days int, now time.Time) []DatastoreMobileDevice {
+	var expiring []DatastoreMobileDevice
+
+	// Compare dates, not times
+	today, _ := time.Parse(AssetDateLayout, now.Format(AssetDateLayout))
+	cutoff := today.AddDate(0, 0, days)
+
+	for _, d := range devices {
+		if d.WarrantyExpiry == "" {
+			continue
+		}
+		we, err := time.Parse(AssetDateLayout, d.WarrantyExpiry)
+		if err != nil {
+			continue
+		}
+		if we.Before(today) || we.After(cutoff) {
+			continue
+		}
+		expiring = append(expiring, d)
+	}
+
+	sort.Slice(expiring, func(i, j int) bool {
+		return expiring[i].WarrantyExpiry < expiring[j].WarrantyExpiry
+	})
+
+	return expiring
+}
+
+// Format a cost for display, empty if not set
+func formatCost(c float64) string {
+	if c == 0 {
+		return ""
+	}
+
+	return strconv.FormatFloat(c, 'f', 2, 64)
+}
+
+// Format an owner history for display, e.g. "jane@foo.com (2019-01-02 - 2020-03-04); john@foo.com (2020-03-04 -)"
+func formatOwnerHistory(oh []OwnerAssignment) string {
+	var s []string
+
+	for _, o := range oh {
+		s = append(s, fmt.Sprintf("%s (%.10s - %.10s)", o.Email, o.From, o.To))
+	}
+
+	return strings.Join(s, "; ")
+}
+
+// EOF
@@ -0,0 +1,185 @@
+package gsuitemdm
+
+//
+// GSuiteMDM process-wide caches of secrets & authenticated clients, reused across warm Cloud
+// Function invocations and by long-running processes
+//
+
+import (
+	"cloud.google.com/go/datastore"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"golang.org/x/oauth2/google"
+	admin "google.golang.org/api/admin/directory/v1"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Default seconds the latest version of a secret is cached for
+const DefaultSecretCacheSeconds int = 300
+
+// Key of a cached secret: the provider it came from & its ID
+type secretKey struct {
+	p   SecretProvider
+	sid string
+}
+
+// A cached secret
+type cachedSecret struct {
+	expires time.Time // When the secret must be fetched again. Zero for pinned versions, which never change
+	value   string    // The secret
+}
+
+// Secrets & clients cached by this process
+var cache = struct {
+	sync.Mutex
+	admins    map[*http.Client]*admin.Service // Admin SDK services, by authenticated http client
+	clients   map[string]*http.Client         // Authenticated http clients, by credentials, scope & subject
+	datastore map[string]*datastore.Client    // Datastore clients, by project ID
+	providers map[SecretsConf]SecretProvider  // Secret providers of configurations, by their configuration
+	secrets   map[secretKey]cachedSecret      // Secrets, by secret provider & secret ID
+	ttl       time.Duration                   // How long the latest version of a bootstrap secret is cached for
+}{
+	admins:    make(map[*http.Client]*admin.Service),
+	clients:   make(map[string]*http.Client),
+	datastore: make(map[string]*datastore.Client),
+	providers: make(map[SecretsConf]SecretProvider),
+	secrets:   make(map[secretKey]cachedSecret),
+	ttl:       time.Duration(DefaultSecretCacheSeconds) * time.Second,
+}
+
+// Set how long the latest version of a secret read with GetSecret (from the bootstrap secret
+// provider) is cached for. Zero or less disables caching of latest versions; pinned versions are
+// always cached. Secrets named in a configuration are cached for its cache.secretttlseconds
+func SetSecretCacheTTL(ttl time.Duration) {
+	cache.Lock()
+	defer cache.Unlock()
+
+	cache.ttl = ttl
+}
+
+// Get how long the latest version of a bootstrap secret is cached for
+func secretCacheTTL() time.Duration {
+	cache.Lock()
+	defer cache.Unlock()
+
+	return cache.ttl
+}
+
+// Forget a cached secret, from every provider, e.g. after it has been rotated. Clients
+// authenticated with the old secret are no longer used once the new secret has been fetched
+func InvalidateSecret(sid string) {
+	cache.Lock()
+	defer cache.Unlock()
+
+	for k := range cache.secrets {
+		if k.sid == sid {
+			delete(cache.secrets, k)
+		}
+	}
+}
+
+// Forget all cached secrets & authenticated clients
+func FlushCache() {
+	cache.Lock()
+	defer cache.Unlock()
+
+	cache.admins = make(map[*http.Client]*admin.Service)
+	cache.clients = make(map[string]*http.Client)
+	cache.secrets = make(map[secretKey]cachedSecret)
+}
+
+// Get a cached secret, if it hasn't expired
+func getCachedSecret(key secretKey) (string, bool) {
+	cache.Lock()
+	defer cache.Unlock()
+
+	s, ok := cache.secrets[key]
+	if !ok || (!s.expires.IsZero() && time.Now().After(s.expires)) {
+		return "", false
+	}
+
+	return s.value, true
+}
+
+// Cache a secret for ttl. Pinned versions never expire
+func putCachedSecret(key secretKey, pinned bool, value string, ttl time.Duration) {
+	cache.Lock()
+	defer cache.Unlock()
+
+	s := cachedSecret{value: value}
+	if !pinned {
+		if ttl <= 0 {
+			return
+		}
+		s.expires = time.Now().Add(ttl)
+	}
+	cache.secrets[key] = s
+}
+
+// Get an http client authenticated with a service account's JSON credentials, running as subject.
+// Clients are shared by everything using the same credentials, scope & subject, and refresh their
+// own tokens as they expire
+func jwtClient(creds, scope, subject string) (*http.Client, error) {
+	sum := sha256.Sum256([]byte(creds))
+	key := hex.EncodeToString(sum[:]) + "|" + scope + "|" + subject
+
+	cache.Lock()
+	defer cache.Unlock()
+
+	if client, ok := cache.clients[key]; ok {
+		return client, nil
+	}
+
+	// Create JWT config using the credentials
+	jwt, err := google.JWTConfigFromJSON([]byte(creds), scope)
+	if err != nil {
+		return nil, err
+	}
+	jwt.Subject = subject
+
+	client := jwt.Client(context.Background())
+	cache.clients[key] = client
+
+	return client, nil
+}
+
+// Get the Admin SDK service for an authenticated http client
+func adminService(client *http.Client) (*admin.Service, error) {
+	cache.Lock()
+	defer cache.Unlock()
+
+	if as, ok := cache.admins[client]; ok {
+		return as, nil
+	}
+
+	as, err := admin.New(client)
+	if err != nil {
+		return nil, err
+	}
+	cache.admins[client] = as
+
+	return as, nil
+}
+
+// Get the shared Datastore client for a GCP project, creating it on first use
+func datastoreClient(projectid string) (*datastore.Client, error) {
+	cache.Lock()
+	defer cache.Unlock()
+
+	if dc, ok := cache.datastore[projectid]; ok {
+		return dc, nil
+	}
+
+	dc, err := datastore.NewClient(context.Background(), projectid)
+	if err != nil {
+		return nil, err
+	}
+	cache.datastore[projectid] = dc
+
+	return dc, nil
+}
+
+// EOF
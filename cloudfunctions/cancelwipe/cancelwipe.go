@@ -0,0 +1,30 @@
+package cancelwipe
+
+//
+// GSuiteMDM cancelwipe Cloud Function
+//
+
+import (
+	"github.com/rickt/gsuitemdm"
+	"net/http"
+	"os"
+)
+
+var (
+	appname      string = os.Getenv("APPNAME")
+	sm_apikey_id string = os.Getenv("SM_APIKEY_ID")
+	sm_config_id string = os.Getenv("SM_CONFIG_ID")
+)
+
+// Cancel a pending wipe of a mobile device using the G Suite Admin SDK, then activate or block it
+func CancelWipe(w http.ResponseWriter, r *http.Request) {
+	h := &gsuitemdm.ActionHandler{
+		Action:   "cancelwipe",
+		APIKeyID: sm_apikey_id,
+		AppName:  appname,
+		ConfigID: sm_config_id,
+	}
+	h.ServeHTTP(w, r)
+}
+
+// EOF
@@ -3,7 +3,7 @@
 # change this to point to your own GCP project
 PROJECT="mdm-updater"
 
-CLOUDFUNCTIONS="approvedevice blockdevice deletedevice directory searchdatastore slackdirectory updatedatastore updatesheet wipedevice"
+CLOUDFUNCTIONS="approvedevice blockdevice cancelwipe deletedevice directory importdevices jobs lostdevice notifyowners offboard ownerresponse phonelines searchdatastore slackdirectory slackmdm unblockdevice updateasset updatedatastore updatesheet wipedevice"
 
 for FUNCTION in $CLOUDFUNCTIONS
 do
@@ -13,6 +13,17 @@ do
 	go clean && go build
 	gcloud config set project $PROJECT
 	gcloud functions deploy $FUNCTION --runtime go111 --trigger-http --env-vars-file env_${FUNCTION}.yaml
+	cd ..
 done
 
+# background cloud functions, triggered by Pub/Sub
+echo "*** RunJobs ***"
+cd jobs
+gcloud functions deploy RunJobs --runtime go111 --trigger-topic gsuitemdm-jobs --timeout 540s --env-vars-file env_jobs.yaml
+cd ..
+echo "*** SlackMDMWorker ***"
+cd slackmdm
+gcloud functions deploy SlackMDMWorker --runtime go111 --trigger-topic gsuitemdm-slackmdm --env-vars-file env_slackmdm.yaml
+cd ..
+
 # EOF
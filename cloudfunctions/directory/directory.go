@@ -5,7 +5,6 @@ package directory
 //
 
 import (
-	"cloud.google.com/go/datastore"
 	"cloud.google.com/go/logging"
 	"context"
 	"encoding/json"
@@ -24,10 +23,9 @@ var (
 	sm_config_id string = os.Getenv("SM_CONFIG_ID")
 )
 
-// Search Google Datastore for a mobile device owner and return the associated phone number
+// Search the G Suite user directory and return matching users and their phone numbers
 func Directory(w http.ResponseWriter, r *http.Request) {
 	var err error
-	var devices []*gsuitemdm.DatastoreMobileDevice
 	var l *logging.Client
 	var request gsuitemdm.SearchRequest
 
@@ -98,8 +96,15 @@ func Directory(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Do we support the specified query type? Directory supports only "email" and "name"
-	if request.QType != "email" && request.QType != "name" {
+	// Do we support the specified query type?
+	var valid bool
+	for _, qt := range gsuitemdm.DirectoryQueryTypes {
+		if request.QType == qt {
+			valid = true
+			break
+		}
+	}
+	if !valid {
 		log.Printf("Error: Invalid query type specified")
 		http.Error(w, "Error: Invalid query type specified", 400)
 		sl.Log(logging.Entry{Severity: logging.Warning, Payload: "Invalid query type specified"})
@@ -114,67 +119,27 @@ func Directory(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Query type is valid and query string (q=) is not zero length, lets get the Datastore data
-	dc, err := datastore.NewClient(ctx, gs.C.ProjectID)
+	// Query type is valid and query string (q=) is not zero length, lets get the cached directory users
+	users, err := gs.GetDirectoryUsers()
 	if err != nil {
-		log.Printf("Error creating Datastore client: %s", err)
-		http.Error(w, fmt.Sprintf("Error creating Datastore client: %s", err), 500)
-		sl.Log(logging.Entry{Severity: logging.Warning, Payload: "Error creating Datastore client: " + err.Error()})
+		log.Printf("%s", err)
+		http.Error(w, err.Error(), 500)
+		sl.Log(logging.Entry{Severity: logging.Warning, Payload: err.Error()})
 		return
 	}
 
-	// Perform a full Datastore search with no filter
-	_, err = dc.GetAll(ctx, datastore.NewQuery(gs.C.DSNamekey).
-		Order(gs.C.DatastoreQueryOrderBy),
-		&devices)
+	// Search for directory entries using the search type specified
+	users, err = gsuitemdm.SearchDirectory(users, request.QType, request.Q)
 	if err != nil {
-		log.Printf("Error querying Datastore for all devices: %s", err)
-		http.Error(w, fmt.Sprintf("Error querying Datastore for all devices: %s", err), 500)
-		sl.Log(logging.Entry{Severity: logging.Warning, Payload: "Error querying Datastore for all devices: " + err.Error()})
+		log.Printf("Error: %s", err)
+		http.Error(w, fmt.Sprintf("Error: %s", err), 400)
+		sl.Log(logging.Entry{Severity: logging.Warning, Payload: err.Error()})
 		return
 	}
 
-	// Search for directory entries using the search type specified
 	var dirdata []gsuitemdm.DirectoryData
-
-	// Range through the list of devices and perform the correct type of search
-	for k := range devices {
-
-		switch request.QType {
-		// Email search
-		case "email":
-			if devices[k].Email == request.Q {
-				// Only return data if PhoneNumber exists
-				if devices[k].PhoneNumber != "" {
-					var p gsuitemdm.DirectoryData
-					p.Name = devices[k].Name
-					p.Email = devices[k].Email
-					p.PhoneNumber = "(" + devices[k].PhoneNumber[0:3] + ") " + devices[k].PhoneNumber[3:6] + "-" + devices[k].PhoneNumber[6:10]
-					dirdata = append(dirdata, p)
-					break
-				}
-			}
-
-		// Name search
-		case "name":
-			if strings.Contains(strings.ToUpper(devices[k].Name), strings.ToUpper(request.Q)) {
-				// Only return data if PhoneNumber exists
-				if devices[k].PhoneNumber != "" {
-					var p gsuitemdm.DirectoryData
-					p.Name = devices[k].Name
-					p.Email = devices[k].Email
-					p.PhoneNumber = "(" + devices[k].PhoneNumber[0:3] + ") " + devices[k].PhoneNumber[3:6] + "-" + devices[k].PhoneNumber[6:10]
-					dirdata = append(dirdata, p)
-					break
-				}
-			}
-
-		default:
-			log.Printf("Error: Invalid query type specified")
-			http.Error(w, "Error: Invalid query type specified", 400)
-			sl.Log(logging.Entry{Severity: logging.Warning, Payload: "Invalid query type specified"})
-			return
-		}
+	for k := range users {
+		dirdata = append(dirdata, users[k].ToDirectoryData(gs.C.DefaultRegion))
 	}
 
 	// Do we have any data to return? If so, marshal into JSON and return it
@@ -0,0 +1,124 @@
+package importdevices
+
+//
+// GSuiteMDM importdevices Cloud Function
+//
+
+import (
+	"cloud.google.com/go/logging"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/rickt/gsuitemdm"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+)
+
+var (
+	appname      string = os.Getenv("APPNAME")
+	sm_apikey_id string = os.Getenv("SM_APIKEY_ID")
+	sm_config_id string = os.Getenv("SM_CONFIG_ID")
+)
+
+// Import sheet-owned device fields (phone number, color, RAM, notes) into Google Datastore
+func ImportDevices(w http.ResponseWriter, r *http.Request) {
+	var err error
+	var l *logging.Client
+	var request gsuitemdm.ImportRequest
+
+	// Null message body?
+	if r.Body == nil {
+		http.Error(w, "Error: Null message body", 400)
+		return
+	}
+
+	// Not null, lets decode the message body
+	err = json.NewDecoder(r.Body).Decode(&request)
+	if err != nil {
+		log.Printf("Error decoding JSON message body: %s", err)
+		http.Error(w, "Error decoding JSON message body", 400)
+		return
+	}
+
+	// Get a context
+	ctx := context.Background()
+
+	// Get the API key from Secret Manager
+	apikey, err := gsuitemdm.GetSecret(ctx, sm_apikey_id)
+	if err != nil {
+		log.Printf("Error retrieving API key from Secret Manager: %s", err)
+		http.Error(w, "Error retrieving API key from Secret Manager", 400)
+		return
+	}
+
+	// Check that the API key sent with the request matches
+	if request.Key != strings.TrimSpace(apikey) {
+		log.Printf("Error: Incorrect key sent with request")
+		http.Error(w, "Not authorized", 401)
+		return
+	}
+
+	// Check if the request is valid
+	if len(request.Devices) < 1 {
+		log.Printf("Error: Invalid request (no devices specified)")
+		http.Error(w, "Invalid request (no devices specified)", 400)
+		return
+	}
+
+	// Get our app configuration from Secret Manager
+	config, err := gsuitemdm.GetSecret(ctx, sm_config_id)
+	if err != nil {
+		log.Printf("Error retrieving app configuration from Secret Manager: %s", err)
+		http.Error(w, "Error retrieving app configuration from Secret Manager", 400)
+		return
+	}
+
+	// Get a G Suite MDM Service
+	gs, err := gsuitemdm.New(ctx, config)
+	if err != nil {
+		// Log to stderr, will be captured as a basic Stackdriver log
+		log.Printf("Error: gsuitemdm cloudfunction %s could not start: %s", appname, err)
+		return
+	}
+
+	// Debug mode?
+	if request.Debug == true {
+		gs.C.Debug = true
+	}
+
+	// Initialise Stackdriver logging for this GCP project
+	l, err = logging.NewClient(ctx, gs.C.ProjectID)
+
+	// Register a Stackdriver logger instance for this app
+	sl := l.Logger(appname)
+
+	// Import the devices. Unless `confirm: true` was sent, this is a dry run
+	changes, err := gs.ImportDevices(request.Devices, request.Confirm != true)
+	if err != nil {
+		log.Printf("Error importing devices: %s", err)
+		http.Error(w, fmt.Sprintf("Error importing devices: %s", err), 400)
+		sl.Log(logging.Entry{Severity: logging.Warning, Payload: "Error importing devices: " + err.Error()})
+		return
+	}
+
+	// Return the list of changes
+	js, err := json.MarshalIndent(gsuitemdm.ImportResponse{Applied: request.Confirm, Changes: changes}, "", "   ")
+	if err != nil {
+		log.Printf("Error marshaling JSON: %s", err)
+		http.Error(w, fmt.Sprintf("Error marshaling JSON: %s", err), 500)
+		sl.Log(logging.Entry{Severity: logging.Warning, Payload: "Error marshaling JSON: " + err.Error()})
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(js)
+
+	// Finished, write a log entry
+	sl.Log(logging.Entry{Severity: logging.Notice, Payload: appname + " Success: " + strconv.Itoa(len(changes)) + " changes, applied=" + strconv.FormatBool(request.Confirm) + " RemoteIP=" + gsuitemdm.GetIP(r)})
+
+	return
+}
+
+// EOF
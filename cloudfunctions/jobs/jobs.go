@@ -0,0 +1,272 @@
+package jobs
+
+//
+// GSuiteMDM jobs Cloud Function
+//
+
+import (
+	"cloud.google.com/go/logging"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/rickt/gsuitemdm"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+)
+
+var (
+	appname      string = os.Getenv("APPNAME")
+	sm_apikey_id string = os.Getenv("SM_APIKEY_ID")
+	sm_config_id string = os.Getenv("SM_CONFIG_ID")
+)
+
+// Header the API key may be sent in, for requests without a body
+const keyHeader string = "X-GSuiteMDM-Key"
+
+// Submit, follow & cancel long-running jobs:
+//
+//	GET    /v1/jobs              list jobs (?state=STATE&limit=N)
+//	POST   /v1/jobs              submit a job
+//	GET    /v1/jobs/{id}         get a job's progress & results
+//	POST   /v1/jobs/{id}/cancel  cancel a job (as does DELETE /v1/jobs/{id})
+//	POST   /v1/jobs/run          run all queued jobs, failing any that have stopped
+//
+// Submitted jobs stay queued, and are run by RunJobs (if jobs.topic is configured) or the next
+// call to /v1/jobs/run
+func Jobs(w http.ResponseWriter, r *http.Request) {
+	var err error
+	var l *logging.Client
+	var request gsuitemdm.JobRequest
+	var role string
+
+	// Work out what we're being asked to do from the path
+	i := strings.Index(r.URL.Path, "/v1/jobs")
+	if i < 0 {
+		http.Error(w, "Not found", 404)
+		return
+	}
+	parts := strings.Split(strings.Trim(r.URL.Path[i+len("/v1/jobs"):], "/"), "/")
+	id := parts[0]
+
+	var op string
+	switch {
+	case id == "" && r.Method == http.MethodGet:
+		op = "list"
+	case id == "" && r.Method == http.MethodPost:
+		op = "submit"
+	case id == "run" && len(parts) == 1 && r.Method == http.MethodPost:
+		op = "run"
+	case len(parts) == 1 && r.Method == http.MethodGet:
+		op = "get"
+	case len(parts) == 1 && r.Method == http.MethodDelete,
+		len(parts) == 2 && parts[1] == "cancel" && r.Method == http.MethodPost:
+		op = "cancel"
+	default:
+		http.Error(w, "Not found", 404)
+		return
+	}
+
+	// Decode the message body of submissions
+	if op == "submit" {
+		if r.Body == nil {
+			http.Error(w, "Error: Null message body", 400)
+			return
+		}
+		err = json.NewDecoder(r.Body).Decode(&request)
+		if err != nil {
+			log.Printf("Error decoding JSON message body: %s", err)
+			http.Error(w, "Error decoding JSON message body", 400)
+			return
+		}
+	}
+	if request.Key == "" {
+		request.Key = r.Header.Get(keyHeader)
+	}
+	if request.By == "" {
+		request.By = "api:" + gsuitemdm.GetIP(r)
+	}
+
+	// Get a context
+	ctx := context.Background()
+
+	// Get the API key from Secret Manager
+	apikey, err := gsuitemdm.GetSecret(ctx, sm_apikey_id)
+	if err != nil {
+		log.Printf("Error retrieving API key from Secret Manager: %s", err)
+		http.Error(w, "Error retrieving API key from Secret Manager", 400)
+		return
+	}
+
+	// The API key may do anything. Other keys are checked once we have the configuration
+	if request.Key != "" && request.Key == strings.TrimSpace(apikey) {
+		role = gsuitemdm.RoleAdmin
+	}
+
+	// Get our app configuration from Secret Manager
+	config, err := gsuitemdm.GetSecret(ctx, sm_config_id)
+	if err != nil {
+		log.Printf("Error retrieving app configuration from Secret Manager: %s", err)
+		http.Error(w, "Error retrieving app configuration from Secret Manager", 400)
+		return
+	}
+
+	// Get a G Suite MDM Service
+	gs, err := gsuitemdm.New(ctx, config)
+	if err != nil {
+		// Log to stderr, will be captured as a basic Stackdriver log
+		log.Printf("Error: gsuitemdm cloudfunction %s could not start: %s", appname, err)
+		return
+	}
+
+	// Was the operator key sent?
+	if role == "" {
+		role = gs.OperatorRole(request.Key)
+	}
+	if role == "" {
+		log.Printf("Error: Incorrect key sent with request")
+		http.Error(w, "Not authorized", 401)
+		return
+	}
+
+	// Debug mode?
+	if request.Debug == true {
+		gs.C.Debug = true
+	}
+
+	// Initialise Stackdriver logging for this GCP project
+	l, err = logging.NewClient(ctx, gs.C.ProjectID)
+
+	// Register a Stackdriver logger instance for this app
+	sl := l.Logger(appname)
+
+	// Do it
+	var out interface{}
+	code := http.StatusOK
+
+	switch op {
+	// List jobs
+	case "list":
+		limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+		if limit < 1 {
+			limit = 20
+		}
+		out, err = gs.ListJobs(r.URL.Query().Get("state"), limit)
+
+	// Submit a job. It is left queued for RunJobs or /v1/jobs/run, so we can respond now
+	case "submit":
+		out, err = gs.SubmitJob(request.Type, request.Params, request.By, role)
+		code = http.StatusAccepted
+
+	// Get a job
+	case "get":
+		out, err = gs.GetJob(id)
+
+	// Cancel a job
+	case "cancel":
+		out, err = gs.CancelJob(id)
+
+	// Run queued jobs, e.g. from Cloud Scheduler
+	case "run":
+		if role != gsuitemdm.RoleAdmin {
+			http.Error(w, "Not authorized", 403)
+			return
+		}
+		stale, errs := runJobs(gs, sl, "")
+		out = map[string]int{"errors": len(errs), "stale": stale}
+	}
+	if err != nil {
+		code = 500
+		if ae, ok := err.(*gsuitemdm.ActionError); ok {
+			code = ae.Code
+		}
+		log.Printf("Error: %s", err)
+		http.Error(w, fmt.Sprintf("Error: %s", err), code)
+		return
+	}
+
+	// Send the response
+	js, err := json.Marshal(out)
+	if err != nil {
+		log.Printf("Error marshaling JSON: %s", err)
+		http.Error(w, "Error marshaling JSON", 500)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	w.Write(js)
+
+	// Finished, write a log entry
+	sl.Log(logging.Entry{Severity: logging.Notice, Payload: appname + " Success: Op=" + op + " ID=" + id + " Role=" + role + " RemoteIP=" + gsuitemdm.GetIP(r)})
+
+	return
+}
+
+// Run a job published to the jobs.topic Pub/Sub topic. Triggered by Pub/Sub, so the job runs for
+// as long as this function's timeout allows, independently of the request that submitted it
+func RunJobs(ctx context.Context, m gsuitemdm.PubSubMessage) error {
+	// Get our app configuration from Secret Manager
+	config, err := gsuitemdm.GetSecret(ctx, sm_config_id)
+	if err != nil {
+		log.Printf("Error retrieving app configuration from Secret Manager: %s", err)
+		return err
+	}
+
+	// Get a G Suite MDM Service
+	gs, err := gsuitemdm.New(ctx, config)
+	if err != nil {
+		log.Printf("Error: gsuitemdm cloudfunction %s could not start: %s", appname, err)
+		return err
+	}
+
+	// Initialise Stackdriver logging for this GCP project
+	l, err := logging.NewClient(ctx, gs.C.ProjectID)
+	if err != nil {
+		log.Printf("Error creating Stackdriver logging client: %s", err)
+		return err
+	}
+	defer l.Close()
+
+	// Register a Stackdriver logger instance for this app
+	sl := l.Logger(appname)
+
+	// Errors are logged, not returned: a retried job would only find it is no longer queued
+	runJobs(gs, sl, strings.TrimSpace(string(m.Data)))
+
+	return nil
+}
+
+// Fail running jobs that have stopped sending heartbeats, then run a queued job, or all queued
+// jobs if id is empty. Returns the number of stale jobs and any errors
+func runJobs(gs *gsuitemdm.GSuiteMDMService, sl *logging.Logger, id string) (int, []error) {
+	var errs []error
+
+	stale, err := gs.FailStaleJobs()
+	if err != nil {
+		errs = append(errs, err)
+	}
+	for _, job := range stale {
+		sl.Log(logging.Entry{Severity: logging.Warning, Payload: appname + " job " + job.ID + " failed: " + job.Error})
+	}
+
+	if id == "" {
+		errs = append(errs, gs.RunQueuedJobs()...)
+	} else {
+		err = gs.RunJobID(id)
+		if err != nil && err != gsuitemdm.ErrJobNotQueued {
+			errs = append(errs, errors.New(fmt.Sprintf("Error running job %s: %s", id, err)))
+		}
+	}
+
+	for _, e := range errs {
+		log.Printf("%s", e)
+		sl.Log(logging.Entry{Severity: logging.Warning, Payload: e.Error()})
+	}
+
+	return len(stale), errs
+}
+
+// EOF
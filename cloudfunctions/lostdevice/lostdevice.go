@@ -0,0 +1,170 @@
+package lostdevice
+
+//
+// GSuiteMDM lostdevice Cloud Function
+//
+
+import (
+	"cloud.google.com/go/logging"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/rickt/gsuitemdm"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+var (
+	appname      string = os.Getenv("APPNAME")
+	sm_apikey_id string = os.Getenv("SM_APIKEY_ID")
+	sm_config_id string = os.Getenv("SM_CONFIG_ID")
+)
+
+// Report mobile devices lost or found, and issue the wipes of lost devices when they are due
+func LostDevice(w http.ResponseWriter, r *http.Request) {
+	var err error
+	var l *logging.Client
+	var request gsuitemdm.LostRequest
+
+	// Null message body?
+	if r.Body == nil {
+		http.Error(w, "Error: Null message body", 400)
+		return
+	}
+
+	// Not null, lets decode the message body
+	err = json.NewDecoder(r.Body).Decode(&request)
+	if err != nil {
+		log.Printf("Error decoding JSON message body: %s", err)
+		http.Error(w, "Error decoding JSON message body", 400)
+		return
+	}
+
+	// Get a context
+	ctx := context.Background()
+
+	// Get the API key from Secret Manager
+	apikey, err := gsuitemdm.GetSecret(ctx, sm_apikey_id)
+	if err != nil {
+		log.Printf("Error retrieving API key from Secret Manager: %s", err)
+		http.Error(w, "Error retrieving API key from Secret Manager", 400)
+		return
+	}
+
+	// Check that the API key sent with the request matches
+	if request.Key != strings.TrimSpace(apikey) {
+		log.Printf("Error: Incorrect key sent with request")
+		http.Error(w, "Not authorized", 401)
+		return
+	}
+
+	// Check if the request is valid
+	switch request.Action {
+	case "list", "process":
+	case "found", "lost":
+		if request.SN == "" {
+			log.Printf("Error: Invalid request (SN not specified)")
+			http.Error(w, "Invalid request (SN not specified)", 400)
+			return
+		}
+	default:
+		log.Printf("Error: Invalid action specified")
+		http.Error(w, "Invalid request (invalid action specified)", 400)
+		return
+	}
+	if request.By == "" {
+		request.By = "api:" + gsuitemdm.GetIP(r)
+	}
+
+	// Get our app configuration from Secret Manager
+	config, err := gsuitemdm.GetSecret(ctx, sm_config_id)
+	if err != nil {
+		log.Printf("Error retrieving app configuration from Secret Manager: %s", err)
+		http.Error(w, "Error retrieving app configuration from Secret Manager", 400)
+		return
+	}
+
+	// Get a G Suite MDM Service
+	gs, err := gsuitemdm.New(ctx, config)
+	if err != nil {
+		// Log to stderr, will be captured as a basic Stackdriver log
+		log.Printf("Error: gsuitemdm cloudfunction %s could not start: %s", appname, err)
+		return
+	}
+
+	// Debug mode?
+	if request.Debug == true {
+		gs.C.Debug = true
+	}
+
+	// Initialise Stackdriver logging for this GCP project
+	l, err = logging.NewClient(ctx, gs.C.ProjectID)
+
+	// Register a Stackdriver logger instance for this app
+	sl := l.Logger(appname)
+
+	// Perform the requested action
+	var devices []gsuitemdm.DatastoreMobileDevice
+	var d *gsuitemdm.DatastoreMobileDevice
+	now := time.Now()
+
+	// Requests are sent with the API key, so may perform any action
+	switch request.Action {
+	// Report a device lost
+	case "lost":
+		d, err = gs.ReportLost(request.SN, request.By, request.Notes, gsuitemdm.RoleAdmin, now)
+
+	// Report a lost device found
+	case "found":
+		d, err = gs.ReportFound(request.SN, request.By, request.Notes, gsuitemdm.RoleAdmin, now)
+
+	// List lost devices
+	case "list":
+		devices, err = gs.GetLostDevices()
+
+	// Issue any wipes that are due
+	case "process":
+		var errs []error
+		devices, errs = gs.ProcessLostDevices(gsuitemdm.RoleAdmin, now)
+		for _, e := range errs {
+			log.Printf("Error processing lost devices: %s", e)
+			sl.Log(logging.Entry{Severity: logging.Warning, Payload: "Error processing lost devices: " + e.Error()})
+		}
+	}
+	if err != nil {
+		log.Printf("Error performing lost device action %s: %s", request.Action, err)
+		sl.Log(logging.Entry{Severity: logging.Warning, Payload: "Error performing lost device action " + request.Action + ": " + err.Error()})
+		// A device is still returned if the report was recorded but the block or approve failed
+		if d == nil {
+			http.Error(w, fmt.Sprintf("Error performing lost device action %s: %s", request.Action, err), 400)
+			return
+		}
+	}
+
+	// Return the affected device(s)
+	if d != nil {
+		devices = append(devices, *d)
+	}
+	if devices == nil {
+		devices = []gsuitemdm.DatastoreMobileDevice{}
+	}
+	js, err := json.MarshalIndent(devices, "", "   ")
+	if err != nil {
+		log.Printf("Error marshaling JSON: %s", err)
+		http.Error(w, fmt.Sprintf("Error marshaling JSON: %s", err), 500)
+		sl.Log(logging.Entry{Severity: logging.Warning, Payload: "Error marshaling JSON: " + err.Error()})
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(js)
+
+	// Finished, write a log entry
+	sl.Log(logging.Entry{Severity: logging.Notice, Payload: appname + " Success: action=" + request.Action + " SN=" + request.SN + " By=" + request.By + " RemoteIP=" + gsuitemdm.GetIP(r)})
+
+	return
+}
+
+// EOF
@@ -0,0 +1,168 @@
+package notifyowners
+
+//
+// GSuiteMDM notifyowners Cloud Function
+//
+
+import (
+	"cloud.google.com/go/logging"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/rickt/gsuitemdm"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+var (
+	appname      string = os.Getenv("APPNAME")
+	sm_apikey_id string = os.Getenv("SM_APIKEY_ID")
+	sm_config_id string = os.Getenv("SM_CONFIG_ID")
+)
+
+// Send notices with signed one-time links to the owners of pending & non-compliant mobile devices
+func NotifyOwners(w http.ResponseWriter, r *http.Request) {
+	var err error
+	var l *logging.Client
+	var request gsuitemdm.OwnerNoticeRequest
+	var response gsuitemdm.OwnerNoticeResponse
+
+	// Null message body?
+	if r.Body == nil {
+		http.Error(w, "Error: Null message body", 400)
+		return
+	}
+
+	// Not null, lets decode the message body
+	err = json.NewDecoder(r.Body).Decode(&request)
+	if err != nil {
+		log.Printf("Error decoding JSON message body: %s", err)
+		http.Error(w, "Error decoding JSON message body", 400)
+		return
+	}
+
+	// Get a context
+	ctx := context.Background()
+
+	// Get the API key from Secret Manager
+	apikey, err := gsuitemdm.GetSecret(ctx, sm_apikey_id)
+	if err != nil {
+		log.Printf("Error retrieving API key from Secret Manager: %s", err)
+		http.Error(w, "Error retrieving API key from Secret Manager", 400)
+		return
+	}
+
+	// Check that the API key sent with the request matches
+	if request.Key != strings.TrimSpace(apikey) {
+		log.Printf("Error: Incorrect key sent with request")
+		http.Error(w, "Not authorized", 401)
+		return
+	}
+
+	// Get our app configuration from Secret Manager
+	config, err := gsuitemdm.GetSecret(ctx, sm_config_id)
+	if err != nil {
+		log.Printf("Error retrieving app configuration from Secret Manager: %s", err)
+		http.Error(w, "Error retrieving app configuration from Secret Manager", 400)
+		return
+	}
+
+	// Get a G Suite MDM Service
+	gs, err := gsuitemdm.New(ctx, config)
+	if err != nil {
+		// Log to stderr, will be captured as a basic Stackdriver log
+		log.Printf("Error: gsuitemdm cloudfunction %s could not start: %s", appname, err)
+		return
+	}
+
+	// Debug mode?
+	if request.Debug == true {
+		gs.C.Debug = true
+	}
+
+	// Initialise Stackdriver logging for this GCP project
+	l, err = logging.NewClient(ctx, gs.C.ProjectID)
+
+	// Register a Stackdriver logger instance for this app
+	sl := l.Logger(appname)
+
+	// Was a valid domain specified?
+	if request.Domain != "" && gs.IsDomainConfigured(request.Domain) == false {
+		log.Printf("Error: Invalid domain specified")
+		http.Error(w, "Invalid domain specified", 400)
+		return
+	}
+
+	now := time.Now()
+
+	switch request.SN {
+	// Contact the owners of all devices that need it
+	case "":
+		err = gs.GetDatastoreData()
+		if err != nil {
+			log.Printf("Error getting Datastore data: %s", err)
+			http.Error(w, fmt.Sprintf("Error getting Datastore data: %s", err), 500)
+			sl.Log(logging.Entry{Severity: logging.Warning, Payload: "Error getting Datastore data: " + err.Error()})
+			return
+		}
+		var devices []gsuitemdm.DatastoreMobileDevice
+		for _, d := range gs.DatastoreData {
+			if request.Domain == "" || d.Domain == request.Domain {
+				devices = append(devices, d)
+			}
+		}
+		var errs []error
+		response.Notified, errs = gs.NotifyOwners(devices, now)
+		for _, e := range errs {
+			log.Printf("%s", e)
+			sl.Log(logging.Entry{Severity: logging.Warning, Payload: e.Error()})
+			response.Errors = append(response.Errors, e.Error())
+		}
+
+	// Contact the owner of a specific device, whatever state it is in
+	default:
+		d, err := gs.GetDatastoreDevice(request.SN)
+		if err != nil {
+			log.Printf("Error: Device not found: %s", err)
+			http.Error(w, "Error: Device not found", 400)
+			return
+		}
+		reason := gsuitemdm.OwnerNoticeReason(d)
+		if reason == "" {
+			log.Printf("Error: Device SN=%s is not pending or non-compliant", d.SN)
+			http.Error(w, fmt.Sprintf("Error: Device SN=%s is not pending or non-compliant (status=%s)", d.SN, d.Status), 400)
+			return
+		}
+		err = gs.NotifyOwner(d, reason, now)
+		if err != nil {
+			log.Printf("Error notifying owner of device SN=%s: %s", d.SN, err)
+			http.Error(w, fmt.Sprintf("Error notifying owner of device SN=%s: %s", d.SN, err), 500)
+			sl.Log(logging.Entry{Severity: logging.Warning, Payload: "Error notifying owner of device SN=" + d.SN + ": " + err.Error()})
+			return
+		}
+		response.Notified = append(response.Notified, d.SN)
+	}
+
+	// Return who was contacted
+	if response.Notified == nil {
+		response.Notified = []string{}
+	}
+	js, err := json.MarshalIndent(response, "", "   ")
+	if err != nil {
+		log.Printf("Error marshaling JSON: %s", err)
+		http.Error(w, fmt.Sprintf("Error marshaling JSON: %s", err), 500)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(js)
+
+	// Finished, write a log entry
+	sl.Log(logging.Entry{Severity: logging.Notice, Payload: fmt.Sprintf("%s Success: notified=%d errors=%d RemoteIP=%s", appname, len(response.Notified), len(response.Errors), gsuitemdm.GetIP(r))})
+
+	return
+}
+
+// EOF
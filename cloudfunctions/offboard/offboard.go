@@ -0,0 +1,169 @@
+package offboard
+
+//
+// GSuiteMDM offboard Cloud Function
+//
+
+import (
+	"cloud.google.com/go/logging"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/rickt/gsuitemdm"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+var (
+	appname      string = os.Getenv("APPNAME")
+	sm_apikey_id string = os.Getenv("SM_APIKEY_ID")
+	sm_config_id string = os.Getenv("SM_CONFIG_ID")
+)
+
+// Find every mobile device of a departing user across all configured domains and apply the
+// offboarding plan to them
+func Offboard(w http.ResponseWriter, r *http.Request) {
+	var err error
+	var l *logging.Client
+	var request gsuitemdm.OffboardRequest
+
+	// Null message body?
+	if r.Body == nil {
+		http.Error(w, "Error: Null message body", 400)
+		return
+	}
+
+	// Not null, lets decode the message body
+	err = json.NewDecoder(r.Body).Decode(&request)
+	if err != nil {
+		log.Printf("Error decoding JSON message body: %s", err)
+		http.Error(w, "Error decoding JSON message body", 400)
+		return
+	}
+
+	// Get a context
+	ctx := context.Background()
+
+	// Get the API key from Secret Manager
+	apikey, err := gsuitemdm.GetSecret(ctx, sm_apikey_id)
+	if err != nil {
+		log.Printf("Error retrieving API key from Secret Manager: %s", err)
+		http.Error(w, "Error retrieving API key from Secret Manager", 400)
+		return
+	}
+
+	// Check that the API key sent with the request matches
+	if request.Key != strings.TrimSpace(apikey) {
+		log.Printf("Error: Incorrect key sent with request")
+		http.Error(w, "Not authorized", 401)
+		return
+	}
+
+	// Check if the request is valid
+	switch request.Action {
+	case "process":
+	case "plan", "offboard":
+		if request.Email == "" {
+			log.Printf("Error: Invalid request (email not specified)")
+			http.Error(w, "Invalid request (email not specified)", 400)
+			return
+		}
+		if request.Action == "offboard" && request.Confirm != true {
+			log.Printf("Error: Offboard requested but no CONFIRM sent")
+			http.Error(w, "Error: Offboard requested but no CONFIRM sent", 400)
+			return
+		}
+	default:
+		log.Printf("Error: Invalid action specified")
+		http.Error(w, "Invalid request (invalid action specified)", 400)
+		return
+	}
+	if request.By == "" {
+		request.By = "api:" + gsuitemdm.GetIP(r)
+	}
+
+	// Get our app configuration from Secret Manager
+	config, err := gsuitemdm.GetSecret(ctx, sm_config_id)
+	if err != nil {
+		log.Printf("Error retrieving app configuration from Secret Manager: %s", err)
+		http.Error(w, "Error retrieving app configuration from Secret Manager", 400)
+		return
+	}
+
+	// Get a G Suite MDM Service
+	gs, err := gsuitemdm.New(ctx, config)
+	if err != nil {
+		// Log to stderr, will be captured as a basic Stackdriver log
+		log.Printf("Error: gsuitemdm cloudfunction %s could not start: %s", appname, err)
+		return
+	}
+
+	// Debug mode?
+	if request.Debug == true {
+		gs.C.Debug = true
+	}
+
+	// Initialise Stackdriver logging for this GCP project
+	l, err = logging.NewClient(ctx, gs.C.ProjectID)
+
+	// Register a Stackdriver logger instance for this app
+	sl := l.Logger(appname)
+
+	// Perform the requested action
+	var result interface{}
+	now := time.Now()
+
+	switch request.Action {
+	// Show what offboarding would do
+	case "plan":
+		result, err = gs.PlanOffboarding(request.Email, request.By, now)
+
+	// Offboard the user
+	case "offboard":
+		var report *gsuitemdm.OffboardReport
+		report, err = gs.Offboard(request.Email, request.By, now)
+		if err == nil {
+			sl.Log(logging.Entry{Severity: logging.Notice, Payload: report.Text()})
+		}
+		result = report
+
+	// Delete offboarded device records that are due
+	case "process":
+		deleted, errs := gs.ProcessOffboardedDevices(now)
+		for _, e := range errs {
+			log.Printf("Error processing offboarded devices: %s", e)
+			sl.Log(logging.Entry{Severity: logging.Warning, Payload: "Error processing offboarded devices: " + e.Error()})
+		}
+		if deleted == nil {
+			deleted = []string{}
+		}
+		result = deleted
+	}
+	if err != nil {
+		log.Printf("Error performing offboard action %s: %s", request.Action, err)
+		http.Error(w, fmt.Sprintf("Error performing offboard action %s: %s", request.Action, err), 400)
+		sl.Log(logging.Entry{Severity: logging.Warning, Payload: "Error performing offboard action " + request.Action + ": " + err.Error()})
+		return
+	}
+
+	// Return the report
+	js, err := json.MarshalIndent(result, "", "   ")
+	if err != nil {
+		log.Printf("Error marshaling JSON: %s", err)
+		http.Error(w, fmt.Sprintf("Error marshaling JSON: %s", err), 500)
+		sl.Log(logging.Entry{Severity: logging.Warning, Payload: "Error marshaling JSON: " + err.Error()})
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(js)
+
+	// Finished, write a log entry
+	sl.Log(logging.Entry{Severity: logging.Notice, Payload: appname + " Success: action=" + request.Action + " email=" + request.Email + " By=" + request.By + " RemoteIP=" + gsuitemdm.GetIP(r)})
+
+	return
+}
+
+// EOF
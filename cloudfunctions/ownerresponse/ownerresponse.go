@@ -0,0 +1,150 @@
+package ownerresponse
+
+//
+// GSuiteMDM ownerresponse Cloud Function
+//
+
+import (
+	"cloud.google.com/go/logging"
+	"context"
+	"github.com/rickt/gsuitemdm"
+	"html/template"
+	"log"
+	"net/http"
+	"os"
+	"time"
+)
+
+var (
+	appname      string = os.Getenv("APPNAME")
+	sm_config_id string = os.Getenv("SM_CONFIG_ID")
+)
+
+// Data for the owner response page
+type page struct {
+	Device  *gsuitemdm.DatastoreMobileDevice
+	Done    string
+	Error   string
+	Problem []string
+	Token   string
+}
+
+var tmpl = template.Must(template.New("page").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>Mobile Device</title>
+<style>
+body { font-family: sans-serif; max-width: 36em; margin: 2em auto; padding: 0 1em; }
+textarea { width: 100%; height: 6em; }
+button { margin: 0.5em 0.5em 0 0; padding: 0.5em 1em; }
+.error { color: #a00; }
+</style>
+</head>
+<body>
+<h1>Mobile Device</h1>
+{{if .Error}}
+<p class="error">{{.Error}}</p>
+<p>Please contact IT if you need help.</p>
+{{else if .Done}}
+<p>Thank you, your response ({{.Done}}) has been recorded. IT will be in touch if anything else is needed.</p>
+{{else}}
+<p><b>{{.Device.Model}}</b>, serial number <b>{{.Device.SN}}</b>, owned by {{.Device.Name}} ({{.Device.Email}}).</p>
+<p>Status: {{.Device.Status}}</p>
+{{if .Problem}}<p>This device does not meet company mobile device policy:</p>
+<ul>{{range .Problem}}<li>{{.}}</li>{{end}}</ul>{{end}}
+<form method="POST">
+<input type="hidden" name="t" value="{{.Token}}">
+<p><label for="notes">Notes for IT (e.g. "phone lost", "this is not my device"):</label></p>
+<textarea id="notes" name="notes"></textarea>
+<p>
+<button type="submit" name="action" value="acknowledge">Acknowledge</button>
+<button type="submit" name="action" value="notes">Send notes</button>
+<button type="submit" name="action" value="wipe" onclick="return confirm('Ask IT to wipe this device?')">Request a wipe</button>
+</p>
+</form>
+<p><small>This link can be used once.</small></p>
+{{end}}
+</body>
+</html>
+`))
+
+// Let the owner of a mobile device acknowledge a notice, add notes or request a wipe, using
+// the signed one-time link they were sent
+func OwnerResponse(w http.ResponseWriter, r *http.Request) {
+	var err error
+	var l *logging.Client
+	var p page
+
+	// Links are one-time credentials, don't let them leak
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Referrer-Policy", "no-referrer")
+
+	if r.Method != "GET" && r.Method != "POST" {
+		http.Error(w, "Method not allowed", 405)
+		return
+	}
+
+	// Get a context
+	ctx := context.Background()
+
+	// Get our app configuration from Secret Manager
+	config, err := gsuitemdm.GetSecret(ctx, sm_config_id)
+	if err != nil {
+		log.Printf("Error retrieving app configuration from Secret Manager: %s", err)
+		http.Error(w, "Error retrieving app configuration from Secret Manager", 500)
+		return
+	}
+
+	// Get a G Suite MDM Service
+	gs, err := gsuitemdm.New(ctx, config)
+	if err != nil {
+		// Log to stderr, will be captured as a basic Stackdriver log
+		log.Printf("Error: gsuitemdm cloudfunction %s could not start: %s", appname, err)
+		http.Error(w, "Internal error", 500)
+		return
+	}
+
+	// Initialise Stackdriver logging for this GCP project
+	l, err = logging.NewClient(ctx, gs.C.ProjectID)
+
+	// Register a Stackdriver logger instance for this app
+	sl := l.Logger(appname)
+
+	p.Token = r.FormValue("t")
+
+	switch r.Method {
+	// Show the device & what the owner can do
+	case "GET":
+		p.Device, err = gs.GetOwnerDevice(p.Token, time.Now())
+		if err == nil {
+			p.Problem = gsuitemdm.DeviceProblems(p.Device)
+		}
+
+	// Record what the owner did
+	case "POST":
+		action := r.FormValue("action")
+		p.Device, err = gs.RecordOwnerResponse(p.Token, action, r.FormValue("notes"), gsuitemdm.GetIP(r), time.Now())
+		if err == nil {
+			p.Done = action
+			sl.Log(logging.Entry{Severity: logging.Notice, Payload: appname + " Success: action=" + action + " SN=" + p.Device.SN + " Owner=" + p.Device.Email + " RemoteIP=" + gsuitemdm.GetIP(r)})
+		}
+	}
+	if err != nil {
+		log.Printf("Error: owner response: %s", err)
+		sl.Log(logging.Entry{Severity: logging.Warning, Payload: "Owner response error: " + err.Error() + " RemoteIP=" + gsuitemdm.GetIP(r)})
+		p.Error = err.Error()
+		w.WriteHeader(400)
+	}
+
+	err = tmpl.Execute(w, p)
+	if err != nil {
+		log.Printf("Error rendering page: %s", err)
+	}
+
+	return
+}
+
+// EOF
@@ -0,0 +1,191 @@
+package phonelines
+
+//
+// GSuiteMDM phonelines Cloud Function
+//
+
+import (
+	"cloud.google.com/go/logging"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/rickt/gsuitemdm"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+)
+
+var (
+	appname      string = os.Getenv("APPNAME")
+	sm_apikey_id string = os.Getenv("SM_APIKEY_ID")
+	sm_config_id string = os.Getenv("SM_CONFIG_ID")
+)
+
+// Manage carrier phone lines & their links to mobile devices
+func PhoneLines(w http.ResponseWriter, r *http.Request) {
+	var err error
+	var l *logging.Client
+	var request gsuitemdm.PhoneLineRequest
+
+	// Null message body?
+	if r.Body == nil {
+		http.Error(w, "Error: Null message body", 400)
+		return
+	}
+
+	// Not null, lets decode the message body
+	err = json.NewDecoder(r.Body).Decode(&request)
+	if err != nil {
+		log.Printf("Error decoding JSON message body: %s", err)
+		http.Error(w, "Error decoding JSON message body", 400)
+		return
+	}
+
+	// Get a context
+	ctx := context.Background()
+
+	// Get the API key from Secret Manager
+	apikey, err := gsuitemdm.GetSecret(ctx, sm_apikey_id)
+	if err != nil {
+		log.Printf("Error retrieving API key from Secret Manager: %s", err)
+		http.Error(w, "Error retrieving API key from Secret Manager", 400)
+		return
+	}
+
+	// Check that the API key sent with the request matches
+	if request.Key != strings.TrimSpace(apikey) {
+		log.Printf("Error: Incorrect key sent with request")
+		http.Error(w, "Not authorized", 401)
+		return
+	}
+
+	// Check if the request is valid
+	switch request.Action {
+	case "list", "unassigned":
+	case "add", "delete", "unlink", "update":
+		if request.Number == "" {
+			log.Printf("Error: Invalid request (number not specified)")
+			http.Error(w, "Invalid request (number not specified)", 400)
+			return
+		}
+	case "link":
+		if request.Number == "" || request.SN == "" {
+			log.Printf("Error: Invalid request (number or SN not specified)")
+			http.Error(w, "Invalid request (number or SN not specified)", 400)
+			return
+		}
+	default:
+		log.Printf("Error: Invalid action specified")
+		http.Error(w, "Invalid request (invalid action specified)", 400)
+		return
+	}
+
+	// Get our app configuration from Secret Manager
+	config, err := gsuitemdm.GetSecret(ctx, sm_config_id)
+	if err != nil {
+		log.Printf("Error retrieving app configuration from Secret Manager: %s", err)
+		http.Error(w, "Error retrieving app configuration from Secret Manager", 400)
+		return
+	}
+
+	// Get a G Suite MDM Service
+	gs, err := gsuitemdm.New(ctx, config)
+	if err != nil {
+		// Log to stderr, will be captured as a basic Stackdriver log
+		log.Printf("Error: gsuitemdm cloudfunction %s could not start: %s", appname, err)
+		return
+	}
+
+	// Debug mode?
+	if request.Debug == true {
+		gs.C.Debug = true
+	}
+
+	// Initialise Stackdriver logging for this GCP project
+	l, err = logging.NewClient(ctx, gs.C.ProjectID)
+
+	// Register a Stackdriver logger instance for this app
+	sl := l.Logger(appname)
+
+	// Perform the requested action
+	var lines []gsuitemdm.PhoneLine
+	var pl *gsuitemdm.PhoneLine
+
+	switch request.Action {
+	// List all lines, or just the unassigned ones
+	case "list", "unassigned":
+		lines, err = gs.GetPhoneLines()
+		if err == nil && request.Action == "unassigned" {
+			lines = gsuitemdm.UnassignedPhoneLines(lines)
+		}
+
+	// Add a new line
+	case "add":
+		if _, err = gs.GetPhoneLine(request.Number); err == nil {
+			err = errors.New(fmt.Sprintf("Phone line %s already exists", request.Number))
+			break
+		}
+		pl = &gsuitemdm.PhoneLine{Number: request.Number}
+		if request.Carrier != nil {
+			pl.Carrier = *request.Carrier
+		}
+		if request.MonthlyCost != nil {
+			pl.MonthlyCost = *request.MonthlyCost
+		}
+		if request.Notes != nil {
+			pl.Notes = *request.Notes
+		}
+		if request.Plan != nil {
+			pl.Plan = *request.Plan
+		}
+		err = gs.PutPhoneLine(pl)
+
+	// Update an existing line's carrier details, only changing the fields that were sent
+	case "update":
+		pl, err = gs.UpdatePhoneLine(request)
+
+	// Delete a line
+	case "delete":
+		err = gs.DeletePhoneLine(request.Number)
+
+	// Link a line to a device
+	case "link":
+		pl, err = gs.LinkPhoneLine(request.Number, request.SN)
+
+	// Unlink a line from its device
+	case "unlink":
+		pl, err = gs.UnlinkPhoneLine(request.Number)
+	}
+	if err != nil {
+		log.Printf("Error performing phone line action %s: %s", request.Action, err)
+		http.Error(w, fmt.Sprintf("Error performing phone line action %s: %s", request.Action, err), 400)
+		sl.Log(logging.Entry{Severity: logging.Warning, Payload: "Error performing phone line action " + request.Action + ": " + err.Error()})
+		return
+	}
+
+	// Return the affected line(s)
+	if pl != nil {
+		lines = append(lines, *pl)
+	}
+	if lines == nil {
+		lines = []gsuitemdm.PhoneLine{}
+	}
+	js, err := json.MarshalIndent(lines, "", "   ")
+	if err != nil {
+		log.Printf("Error marshaling JSON: %s", err)
+		http.Error(w, fmt.Sprintf("Error marshaling JSON: %s", err), 500)
+		sl.Log(logging.Entry{Severity: logging.Warning, Payload: "Error marshaling JSON: " + err.Error()})
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(js)
+
+	// Finished, write a log entry
+	sl.Log(logging.Entry{Severity: logging.Notice, Payload: appname + " Success: action=" + request.Action + " number=" + request.Number + " RemoteIP=" + gsuitemdm.GetIP(r)})
+
+	return
+}
+
+// EOF
@@ -204,7 +204,7 @@ func SearchDatastore(w http.ResponseWriter, r *http.Request) {
 			}
 
 		case "phone":
-			if devices[k].PhoneNumber == request.Q {
+			if gs.PhoneNumbersMatch(devices[k].PhoneNumber, request.Q) {
 				searchdata = append(searchdata, devices[k])
 				break
 			}
@@ -5,7 +5,6 @@ package slackdirectory
 //
 
 import (
-	"cloud.google.com/go/datastore"
 	"cloud.google.com/go/logging"
 	"context"
 	"fmt"
@@ -19,55 +18,38 @@ import (
 )
 
 var (
-	appname          string = os.Getenv("APPNAME")
-	sm_config_id     string = os.Getenv("SM_CONFIG_ID")
-	sm_slacktoken_id string = os.Getenv("SM_SLACKTOKEN_ID")
+	appname           string = os.Getenv("APPNAME")
+	sm_config_id      string = os.Getenv("SM_CONFIG_ID")
+	sm_slacksecret_id string = os.Getenv("SM_SLACKSECRET_ID")
 )
 
-// Search Google Datastore for a mobile device owner and return the associated phone number to Slack
+// Search the G Suite user directory and return matching users and their phone numbers to Slack.
+// Only requests with a valid Slack signature are handled
 func SlackDirectory(w http.ResponseWriter, r *http.Request) {
+	gsuitemdm.VerifySlackRequests(sm_slacksecret_id, slackDirectory)(w, r)
+}
+
+// Handle a verified request from Slack
+func slackDirectory(w http.ResponseWriter, r *http.Request) {
 	var err error
-	var devices []*gsuitemdm.DatastoreMobileDevice
 	var l *logging.Client
-	var text, token, user string
-
-	// Null message body?
-	if r.Body == nil {
-		http.Error(w, "Error: Null message body", 400)
-		return
-	}
+	var text, user string
 
-	// Not null, lets decode the x-www-form-urlencoded message body
-	err = r.ParseForm()
+	// Parse the Slack request
+	sr, err := gsuitemdm.ParseSlackRequest(r)
 	if err != nil {
-		log.Printf("Error decoding Slack x-www-form-urlencoded message body: %s", err)
-		http.Error(w, "Error decoding Slack x-www-form-urlencoded message body", 400)
+		log.Printf("%s", err)
+		http.Error(w, err.Error(), 400)
 		return
 	}
 
 	// Extract the pieces of the request we care about
-	text = r.Form.Get("text")
-	token = r.Form.Get("token")
-	user = r.Form.Get("user_name")
+	text = sr.Text
+	user = sr.UserName
 
 	// Get a context
 	ctx := context.Background()
 
-	// Get the Slack token from Secret Manager
-	slacktoken, err := gsuitemdm.GetSecret(ctx, sm_slacktoken_id)
-	if err != nil {
-		log.Printf("Error retrieving Slack token from Secret Manager", err)
-		http.Error(w, "Error retrieving Slack token from Secret Manager", 400)
-		return
-	}
-
-	// Check the key
-	if token != slacktoken {
-		log.Printf("Error: incorrect token sent with request")
-		http.Error(w, "Not authorized, incorrect token", 401)
-		return
-	}
-
 	// Get our app configuration from Secret Manager
 	config, err := gsuitemdm.GetSecret(ctx, sm_config_id)
 	if err != nil {
@@ -97,39 +79,25 @@ func SlackDirectory(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// OK, lets get the Datastore data
-	dc, err := datastore.NewClient(ctx, gs.C.ProjectID)
+	// OK, lets get the cached directory users
+	users, err := gs.GetDirectoryUsers()
 	if err != nil {
-		http.Error(w, fmt.Sprintf("Error creating Datastore client: %s", err), 500)
-		sl.Log(logging.Entry{Severity: logging.Warning, Payload: "Error creating Datastore client: " + err.Error()})
+		http.Error(w, err.Error(), 500)
+		sl.Log(logging.Entry{Severity: logging.Warning, Payload: err.Error()})
 		return
 	}
 
-	// Perform a full Datastore search with no filter
-	_, err = dc.GetAll(ctx, datastore.NewQuery(gs.C.DSNamekey).
-		Order(gs.C.DatastoreQueryOrderBy),
-		&devices)
+	// Search name, email, department & title using the text sent in the request from Slack
+	users, err = gsuitemdm.SearchDirectory(users, "any", text)
 	if err != nil {
-		http.Error(w, fmt.Sprintf("Error querying Datastore for all devices: %s", err), 500)
-		sl.Log(logging.Entry{Severity: logging.Warning, Payload: "Error querying Datastore for all devices: " + err.Error()})
+		http.Error(w, err.Error(), 500)
+		sl.Log(logging.Entry{Severity: logging.Warning, Payload: err.Error()})
 		return
 	}
 
-	// Search for directory entries using the search type specified
 	var dirdata []gsuitemdm.DirectoryData
-
-	// Range through the list of devices and search for a name using the text sent in the request from Slack
-	for k := range devices {
-		if strings.Contains(strings.ToUpper(devices[k].Name), strings.ToUpper(text)) {
-			// Only return data if PhoneNumber exists
-			if devices[k].PhoneNumber != "" {
-				var p gsuitemdm.DirectoryData
-				p.Name = devices[k].Name
-				p.Email = devices[k].Email
-				p.PhoneNumber = "(" + devices[k].PhoneNumber[0:3] + ") " + devices[k].PhoneNumber[3:6] + "-" + devices[k].PhoneNumber[6:10]
-				dirdata = append(dirdata, p)
-			}
-		}
+	for k := range users {
+		dirdata = append(dirdata, users[k].ToDirectoryData(gs.C.DefaultRegion))
 	}
 
 	// Do we have any data to return? If so, return it
@@ -141,7 +109,14 @@ func SlackDirectory(w http.ResponseWriter, r *http.Request) {
 		var s string
 		s = fmt.Sprintf("Users matching \"%s\": (%d)\n", text, len(dirdata))
 		for n := range dirdata {
-			s = s + fmt.Sprintf("%s: :dir_phone: %s :dir_email: `%s`\n", dirdata[n].Name, dirdata[n].PhoneNumber, dirdata[n].Email)
+			s = s + fmt.Sprintf("%s", dirdata[n].Name)
+			if dirdata[n].Title != "" || dirdata[n].Department != "" {
+				s = s + fmt.Sprintf(" (%s)", strings.Trim(dirdata[n].Title+", "+dirdata[n].Department, ", "))
+			}
+			if dirdata[n].PhoneNumber != "" {
+				s = s + fmt.Sprintf(": :dir_phone: %s", dirdata[n].PhoneNumber)
+			}
+			s = s + fmt.Sprintf(" :dir_email: `%s`\n", dirdata[n].Email)
 		}
 		// Write the data
 		w.Write([]byte(s))
@@ -0,0 +1,407 @@
+package slackmdm
+
+//
+// GSuiteMDM slackmdm Cloud Function
+//
+
+import (
+	"cloud.google.com/go/logging"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/rickt/gsuitemdm"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+)
+
+var (
+	appname           string = os.Getenv("APPNAME")
+	pubsub_topic      string = os.Getenv("PUBSUB_TOPIC")
+	sm_config_id      string = os.Getenv("SM_CONFIG_ID")
+	sm_slacksecret_id string = os.Getenv("SM_SLACKSECRET_ID")
+)
+
+// Maximum number of devices returned by "/mdm search"
+const maxSearchResults int = 20
+
+// Slack interactive action IDs
+const (
+	actionBlockCancel  string = "block_cancel"
+	actionBlockConfirm string = "block_confirm"
+)
+
+// Slow work handed to SlackMDMWorker through the PUBSUB_TOPIC Pub/Sub topic
+type slackWork struct {
+	Arg         string `json:"arg"`         // Search term or device SN
+	Op          string `json:"op"`          // "search" or "block"
+	ResponseURL string `json:"responseurl"` // Where the result is posted
+	UserID      string `json:"userid"`      // Slack user that asked for the work
+	UserName    string `json:"username"`    // Slack user name, for logging
+}
+
+// Usage text for the /mdm slash command
+const usage string = "Usage:\n" +
+	"`/mdm search <name, email, SN, IMEI or phone number>` search for mobile devices\n" +
+	"`/mdm device <SN>` show a mobile device\n" +
+	"`/mdm block <SN>` block a mobile device"
+
+// Handle /mdm slash commands and interactive messages from Slack. Only requests with a
+// valid Slack signature are handled
+func SlackMDM(w http.ResponseWriter, r *http.Request) {
+	gsuitemdm.VerifySlackRequests(sm_slacksecret_id, slackMDM)(w, r)
+}
+
+// Handle a verified request from Slack
+func slackMDM(w http.ResponseWriter, r *http.Request) {
+	var err error
+	var l *logging.Client
+
+	// Get a context
+	ctx := context.Background()
+
+	// Get our app configuration from Secret Manager
+	config, err := gsuitemdm.GetSecret(ctx, sm_config_id)
+	if err != nil {
+		log.Printf("Error retrieving app configuration from Secret Manager: %s", err)
+		http.Error(w, "Error retrieving app configuration from Secret Manager", 400)
+		return
+	}
+
+	// Get a G Suite MDM Service
+	gs, err := gsuitemdm.New(ctx, config)
+	if err != nil {
+		// Log to stderr, will be captured as a basic Stackdriver log
+		log.Printf("Error: gsuitemdm cloudfunction %s could not start: %s", appname, err)
+		return
+	}
+
+	// Initialise Stackdriver logging for this GCP project
+	l, err = logging.NewClient(ctx, gs.C.ProjectID)
+
+	// Register a Stackdriver logger instance for this app
+	sl := l.Logger(appname)
+
+	// Interactive messages are sent with a JSON payload, slash commands without
+	if r.FormValue("payload") != "" {
+		ia, err := gsuitemdm.ParseSlackInteraction(r)
+		if err != nil {
+			log.Printf("%s", err)
+			http.Error(w, err.Error(), 400)
+			return
+		}
+		handleInteraction(w, gs, sl, ia)
+		return
+	}
+
+	sr, err := gsuitemdm.ParseSlackRequest(r)
+	if err != nil {
+		log.Printf("%s", err)
+		http.Error(w, err.Error(), 400)
+		return
+	}
+	handleCommand(w, gs, sl, sr)
+
+	return
+}
+
+// Handle a /mdm slash command
+func handleCommand(w http.ResponseWriter, gs *gsuitemdm.GSuiteMDMService, sl *logging.Logger, sr *gsuitemdm.SlackRequest) {
+	userid := sr.UserID
+	user := sr.UserName
+	text := sr.Text
+
+	// Split the command text into a subcommand and its argument
+	var sub, arg string
+	fields := strings.Fields(text)
+	if len(fields) > 0 {
+		sub = strings.ToLower(fields[0])
+		arg = strings.TrimSpace(strings.TrimPrefix(text, fields[0]))
+	}
+
+	switch sub {
+	// Search
+	case "search":
+		if !checkPermission(w, gs, sl, userid, user, gsuitemdm.SlackPermSearch) {
+			return
+		}
+		if arg == "" {
+			writeMessage(w, gsuitemdm.SlackMessage{Text: usage})
+			return
+		}
+
+		// Searching all devices can be slow, so acknowledge now and let SlackMDMWorker respond
+		// via response_url
+		err := queueWork(gs, slackWork{Arg: arg, Op: "search", ResponseURL: sr.ResponseURL, UserID: userid, UserName: user})
+		if err != nil {
+			log.Printf("%s", err)
+			sl.Log(logging.Entry{Severity: logging.Error, Payload: appname + " search q=" + arg + " user=@" + user + " failed: " + err.Error()})
+			writeMessage(w, gsuitemdm.SlackMessage{Text: fmt.Sprintf(":x: Error searching devices: %s", err)})
+			return
+		}
+		writeMessage(w, gsuitemdm.SlackMessage{Text: fmt.Sprintf("Searching for \"%s\"...", arg)})
+
+	// Device
+	case "device":
+		if !checkPermission(w, gs, sl, userid, user, gsuitemdm.SlackPermDevice) {
+			return
+		}
+		d, err := gs.GetDatastoreDevice(arg)
+		if err != nil {
+			writeMessage(w, gsuitemdm.SlackMessage{Text: fmt.Sprintf("I'm sorry, but I could not find a device with SN `%s`! :confused:", arg)})
+			return
+		}
+		writeMessage(w, gsuitemdm.SlackMessage{Text: "Device " + d.SN, Blocks: []gsuitemdm.SlackBlock{deviceBlock(d, gs.C.DefaultRegion, true)}})
+		sl.Log(logging.Entry{Severity: logging.Notice, Payload: appname + " device sn=" + arg + " user=@" + user})
+
+	// Block
+	case "block":
+		if !checkPermission(w, gs, sl, userid, user, gsuitemdm.SlackPermBlock) {
+			return
+		}
+		d, err := gs.GetDatastoreDevice(arg)
+		if err != nil {
+			writeMessage(w, gsuitemdm.SlackMessage{Text: fmt.Sprintf("I'm sorry, but I could not find a device with SN `%s`! :confused:", arg)})
+			return
+		}
+
+		// Valid states for block are APPROVED, PENDING and UNPROVISIONED
+		if gsuitemdm.ValidateAction(d.Status, gsuitemdm.ActionBlock) != nil {
+			writeMessage(w, gsuitemdm.SlackMessage{Text: fmt.Sprintf("Device `%s` cannot be blocked (status=%s)", d.SN, d.Status)})
+			return
+		}
+
+		// Ask for confirmation
+		confirm := gsuitemdm.SlackButton("Block", actionBlockConfirm, d.SN, "danger")
+		confirm.Confirm = &gsuitemdm.SlackConfirm{
+			Title:   gsuitemdm.SlackText{Type: "plain_text", Text: "Block device?"},
+			Text:    gsuitemdm.SlackText{Type: "mrkdwn", Text: fmt.Sprintf("Device `%s` owned by %s will be blocked.", d.SN, d.Email)},
+			Confirm: gsuitemdm.SlackText{Type: "plain_text", Text: "Block"},
+			Deny:    gsuitemdm.SlackText{Type: "plain_text", Text: "Cancel"},
+		}
+		writeMessage(w, gsuitemdm.SlackMessage{
+			Text: "Block device " + d.SN + "?",
+			Blocks: []gsuitemdm.SlackBlock{
+				gsuitemdm.SlackSection("*Block this device?*"),
+				deviceBlock(d, gs.C.DefaultRegion, false),
+				{Type: "actions", Elements: []gsuitemdm.SlackElement{
+					confirm,
+					gsuitemdm.SlackButton("Cancel", actionBlockCancel, d.SN, ""),
+				}},
+			},
+		})
+
+	// Help, or anything we don't understand
+	default:
+		writeMessage(w, gsuitemdm.SlackMessage{Text: usage})
+	}
+}
+
+// Handle an interactive message (button click)
+func handleInteraction(w http.ResponseWriter, gs *gsuitemdm.GSuiteMDMService, sl *logging.Logger, ia *gsuitemdm.SlackInteraction) {
+	if len(ia.Actions) < 1 {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	action := ia.Actions[0]
+
+	switch action.ActionID {
+	// Block cancelled
+	case actionBlockCancel:
+		w.WriteHeader(http.StatusOK)
+		respond(ia.ResponseURL, gsuitemdm.SlackMessage{ReplaceOriginal: true, Text: fmt.Sprintf("Block of device `%s` cancelled.", action.Value)}, sl)
+
+	// Block confirmed
+	case actionBlockConfirm:
+		// Permissions are checked again, anyone can click a button
+		if !gs.SlackUserPermitted(ia.User.ID, gsuitemdm.SlackPermBlock) {
+			sl.Log(logging.Entry{Severity: logging.Warning, Payload: appname + " block sn=" + action.Value + " denied for user=@" + ia.User.Username})
+			w.WriteHeader(http.StatusOK)
+			respond(ia.ResponseURL, gsuitemdm.SlackMessage{Text: "I'm sorry, but you are not allowed to block devices. :no_entry:"}, sl)
+			return
+		}
+
+		// Blocking waits for the Admin SDK, so acknowledge now and let SlackMDMWorker respond
+		// via response_url
+		err := queueWork(gs, slackWork{Arg: action.Value, Op: "block", ResponseURL: ia.ResponseURL, UserID: ia.User.ID, UserName: ia.User.Username})
+		if err != nil {
+			log.Printf("%s", err)
+			sl.Log(logging.Entry{Severity: logging.Error, Payload: appname + " block sn=" + action.Value + " user=@" + ia.User.Username + " failed: " + err.Error()})
+			respond(ia.ResponseURL, gsuitemdm.SlackMessage{ReplaceOriginal: true, Text: fmt.Sprintf(":x: Error blocking device `%s`: %s", action.Value, err)}, sl)
+		}
+		w.WriteHeader(http.StatusOK)
+
+	default:
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+// Hand slow work to SlackMDMWorker, so the request can be acknowledged within Slack's 3 seconds
+func queueWork(gs *gsuitemdm.GSuiteMDMService, sw slackWork) error {
+	if pubsub_topic == "" {
+		return errors.New("PUBSUB_TOPIC is not set, so slow operations can't be run")
+	}
+
+	js, err := json.Marshal(sw)
+	if err != nil {
+		return err
+	}
+
+	return gsuitemdm.PublishMessage(gs.Ctx, gs.C.ProjectID, pubsub_topic, js)
+}
+
+// Do slow work queued by SlackMDM (searches & blocks), posting the result to Slack via the
+// request's response_url. Triggered by the PUBSUB_TOPIC Pub/Sub topic
+func SlackMDMWorker(ctx context.Context, m gsuitemdm.PubSubMessage) error {
+	var sw slackWork
+
+	err := json.Unmarshal(m.Data, &sw)
+	if err != nil {
+		// Retrying won't help
+		log.Printf("Error decoding queued work: %s", err)
+		return nil
+	}
+
+	// Get our app configuration from Secret Manager
+	config, err := gsuitemdm.GetSecret(ctx, sm_config_id)
+	if err != nil {
+		log.Printf("Error retrieving app configuration from Secret Manager: %s", err)
+		return err
+	}
+
+	// Get a G Suite MDM Service
+	gs, err := gsuitemdm.New(ctx, config)
+	if err != nil {
+		log.Printf("Error: gsuitemdm cloudfunction %s could not start: %s", appname, err)
+		return err
+	}
+
+	// Initialise Stackdriver logging for this GCP project
+	l, err := logging.NewClient(ctx, gs.C.ProjectID)
+	if err != nil {
+		log.Printf("Error creating Stackdriver logging client: %s", err)
+		return err
+	}
+	defer l.Close()
+
+	// Register a Stackdriver logger instance for this app
+	sl := l.Logger(appname)
+
+	switch sw.Op {
+	// Search
+	case "search":
+		respond(sw.ResponseURL, searchDevices(gs, sw.Arg), sl)
+		sl.Log(logging.Entry{Severity: logging.Notice, Payload: appname + " search q=" + sw.Arg + " user=@" + sw.UserName})
+
+	// Block, permission was checked when the button was clicked
+	case "block":
+		d, err := gs.GetDatastoreDevice(sw.Arg)
+		if err == nil {
+			err = gs.ActionDevice(d, "block")
+		}
+		if err != nil {
+			sl.Log(logging.Entry{Severity: logging.Error, Payload: appname + " block sn=" + sw.Arg + " user=@" + sw.UserName + " failed: " + err.Error()})
+			respond(sw.ResponseURL, gsuitemdm.SlackMessage{ReplaceOriginal: true, Text: fmt.Sprintf(":x: Error blocking device `%s`: %s", sw.Arg, err)}, sl)
+			return nil
+		}
+
+		sl.Log(logging.Entry{Severity: logging.Notice, Payload: appname + " block sn=" + sw.Arg + " user=@" + sw.UserName + " Success"})
+		respond(sw.ResponseURL, gsuitemdm.SlackMessage{ReplaceOriginal: true, ResponseType: "in_channel", Text: fmt.Sprintf(":lock: Device `%s` (%s) blocked by <@%s>.", d.SN, d.Email, sw.UserID)}, sl)
+
+	default:
+		log.Printf("Unknown queued work %q", sw.Op)
+	}
+
+	return nil
+}
+
+// Check that a Slack user has a permission, and tell them if they don't
+func checkPermission(w http.ResponseWriter, gs *gsuitemdm.GSuiteMDMService, sl *logging.Logger, userid, user, perm string) bool {
+	if gs.SlackUserPermitted(userid, perm) {
+		return true
+	}
+
+	sl.Log(logging.Entry{Severity: logging.Warning, Payload: appname + " permission " + perm + " denied for user=@" + user})
+	writeMessage(w, gsuitemdm.SlackMessage{Text: fmt.Sprintf("I'm sorry, but you do not have the \"%s\" permission. :no_entry:", perm)})
+
+	return false
+}
+
+// Search all devices by name, email, SN, IMEI or phone number
+func searchDevices(gs *gsuitemdm.GSuiteMDMService, q string) gsuitemdm.SlackMessage {
+	err := gs.GetDatastoreData()
+	if err != nil {
+		return gsuitemdm.SlackMessage{Text: fmt.Sprintf(":x: Error searching devices: %s", err)}
+	}
+
+	uq := strings.ToUpper(q)
+	var blocks []gsuitemdm.SlackBlock
+	var n int
+	for k := range gs.DatastoreData {
+		d := &gs.DatastoreData[k]
+		for _, f := range []string{d.Name, d.Email, d.SN, d.IMEI, d.PhoneNumber} {
+			if strings.Contains(strings.ToUpper(f), uq) {
+				n++
+				if n <= maxSearchResults {
+					blocks = append(blocks, deviceBlock(d, gs.C.DefaultRegion, false))
+				}
+				break
+			}
+		}
+	}
+
+	if n < 1 {
+		return gsuitemdm.SlackMessage{Text: fmt.Sprintf("I'm sorry, but I was not able to find a device using your search term \"%s\"! :confused:", q)}
+	}
+
+	title := fmt.Sprintf("Devices matching \"%s\": (%d)", q, n)
+	if n > maxSearchResults {
+		title = title + fmt.Sprintf(", showing the first %d", maxSearchResults)
+	}
+
+	return gsuitemdm.SlackMessage{Text: title, Blocks: append([]gsuitemdm.SlackBlock{gsuitemdm.SlackSection("*" + title + "*")}, blocks...)}
+}
+
+// Build a Slack block describing a device, with its phone number formatted for the region
+func deviceBlock(d *gsuitemdm.DatastoreMobileDevice, region string, verbose bool) gsuitemdm.SlackBlock {
+	s := fmt.Sprintf("*%s* `%s`\n%s (%s) :dir_phone: %s\nStatus: *%s*, last sync %.10s",
+		d.Model, d.SN, d.Name, d.Email, gsuitemdm.FormatPhoneNumber(d.PhoneNumber, region), d.Status, d.SyncLast)
+
+	if verbose {
+		s = s + fmt.Sprintf("\nDomain: %s\nOS: %s (%s)\nIMEI: %s\nCompromised: %s, Encryption: %s, Password: %s",
+			d.Domain, d.OS, d.OSBuild, d.IMEI, d.CompromisedStatus, d.EncryptionStatus, d.PasswordStatus)
+		if d.AssetTag != "" {
+			s = s + fmt.Sprintf("\nAsset Tag: %s", d.AssetTag)
+		}
+	}
+
+	return gsuitemdm.SlackSection(s)
+}
+
+// Post a delayed response to Slack
+func respond(url string, msg gsuitemdm.SlackMessage, sl *logging.Logger) {
+	err := gsuitemdm.PostSlackResponse(url, msg)
+	if err != nil {
+		log.Printf("%s", err)
+		sl.Log(logging.Entry{Severity: logging.Warning, Payload: err.Error()})
+	}
+}
+
+// Write an ephemeral Slack message as the response to a request
+func writeMessage(w http.ResponseWriter, msg gsuitemdm.SlackMessage) {
+	if msg.ResponseType == "" {
+		msg.ResponseType = "ephemeral"
+	}
+
+	js, err := json.Marshal(msg)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error marshaling JSON: %s", err), 500)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(js)
+}
+
+// EOF
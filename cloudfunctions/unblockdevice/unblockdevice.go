@@ -0,0 +1,30 @@
+package unblockdevice
+
+//
+// GSuiteMDM unblockdevice Cloud Function
+//
+
+import (
+	"github.com/rickt/gsuitemdm"
+	"net/http"
+	"os"
+)
+
+var (
+	appname      string = os.Getenv("APPNAME")
+	sm_apikey_id string = os.Getenv("SM_APIKEY_ID")
+	sm_config_id string = os.Getenv("SM_CONFIG_ID")
+)
+
+// Unblock a blocked mobile device using the G Suite Admin SDK
+func UnblockDevice(w http.ResponseWriter, r *http.Request) {
+	h := &gsuitemdm.ActionHandler{
+		Action:   "unblock",
+		APIKeyID: sm_apikey_id,
+		AppName:  appname,
+		ConfigID: sm_config_id,
+	}
+	h.ServeHTTP(w, r)
+}
+
+// EOF
@@ -24,7 +24,6 @@ var (
 
 // Update Google Datastore with fresh mobile device data from the Admin SDK and the Google Sheet
 func UpdateDatastore(w http.ResponseWriter, r *http.Request) {
-	var domain string
 	var err error
 	var l *logging.Client
 	var request gsuitemdm.UpdateRequest
@@ -73,7 +72,8 @@ func UpdateDatastore(w http.ResponseWriter, r *http.Request) {
 	gs, err := gsuitemdm.New(ctx, config)
 	if err != nil {
 		// Log to stderr, will be captured as a basic Stackdriver log
-		log.Printf("Error: gsuitemdm cloudfunction %s could not start: %s", err)
+		log.Printf("Error: gsuitemdm cloudfunction %s could not start: %s", appname, err)
+		http.Error(w, fmt.Sprintf("Error: %s could not start", appname), 500)
 		return
 	}
 
@@ -84,51 +84,59 @@ func UpdateDatastore(w http.ResponseWriter, r *http.Request) {
 
 	// Initialise Stackdriver logging for this GCP project
 	l, err = logging.NewClient(ctx, gs.C.ProjectID)
+	if err != nil {
+		log.Printf("Error creating Stackdriver logging client: %s", err)
+		http.Error(w, "Error creating Stackdriver logging client", 500)
+		return
+	}
+	defer l.Close()
 
 	// Register a Stackdriver logger instance for this app
 	sl := l.Logger(appname)
 
-	// Get existing Datastore data
-	err = gs.GetDatastoreData()
+	// Run a sync job for all domains. Domains are synced independently, and the job's progress
+	// can be followed with the jobs cloud function
+	job, err := gs.NewJob(gsuitemdm.JobTypeSync, gsuitemdm.JobParams{}, "api:"+gsuitemdm.GetIP(r), gsuitemdm.RoleAdmin)
 	if err != nil {
-	  log.Printf("Error getting existing Datastore data: %s", err)
-		sl.Log(logging.Entry{Severity: logging.Error, Payload: "Error getting existing Datastore data: " + fmt.Sprintf("%s", err)})
+		log.Printf("Error creating sync job: %s", err)
+		sl.Log(logging.Entry{Severity: logging.Error, Payload: "Error creating sync job: " + err.Error()})
+		http.Error(w, fmt.Sprintf("Error creating sync job: %s", err), 500)
 		return
 	}
-
-	// Get Google Sheet data
-	err = gs.GetSheetData()
+	err = gs.RunJob(job)
 	if err != nil {
-	  log.Printf("Error getting existing Google Sheet data: %s", err)
-		sl.Log(logging.Entry{Severity: logging.Error, Payload: "Error getting Google Sheet data: " + fmt.Sprintf("%s", err)})
-		return
+		log.Printf("Error running sync job %s: %s", job.ID, err)
 	}
 
-	// Range through the slice of configured domains
-	for _, dm := range gs.C.Domains {
-		domain = dm.DomainName
-
-		// Get data about this domain's devices from the Admin SDK
-		err = gs.GetAdminSDKDevices(domain)
-		if err != nil {
-		  log.Printf("Error getting Admin SDK data for %s: %s", domain, err)
-			sl.Log(logging.Entry{Severity: logging.Error, Payload: "UpdateDatastore(): Error getting Admin SDK data for " + domain + ": " + fmt.Sprintf("%s", err)})
-			return
-		}
-
-		// Range through this domain's list of devices and update it in Datastore
-		for _, device := range gs.SDKData.Mobiledevices {
-			err = gs.UpdateDatastoreDevice(device)
-			if err != nil {
-			  log.Printf("Error converting device: %s", err)
-				sl.Log(logging.Entry{Severity: logging.Error, Payload: "Error converting device: " + fmt.Sprintf("%s", err)})
-			}
-		}
+	// Log the result of each domain
+	severity := logging.Notice
+	code := http.StatusOK
+	if job.State != gsuitemdm.JobStateSucceeded {
+		severity = logging.Error
+		code = 500
 	}
-
-	// Finished
-	sl.Log(logging.Entry{Severity: logging.Notice, Payload: appname + " Success RemoteIP=" + gsuitemdm.GetIP(r)})
-	fmt.Fprintf(w, "%s Success\n", appname)
+	for _, dr := range job.Sync.Domains {
+		log.Printf("Sync of domain %s: seen=%d written=%d failed=%d pages=%d attempts=%d batches=%d duration=%s devicespersecond=%.1f ratelimited=%s error=%q", dr.Domain, dr.Seen, dr.Written, dr.Failed, dr.Pages, dr.Attempts, dr.Batches, dr.Duration, dr.DevicesPerSecond, dr.RateLimited, dr.Error)
+	}
+	sl.Log(logging.Entry{Severity: severity, Payload: map[string]interface{}{
+		"app":      appname,
+		"job":      job.ID,
+		"remoteip": gsuitemdm.GetIP(r),
+		"state":    job.State,
+		"error":    job.Error,
+		"sync":     job.Sync,
+	}})
+
+	// Send the job, with the result of each domain
+	js, err := json.Marshal(job)
+	if err != nil {
+		log.Printf("Error marshaling JSON: %s", err)
+		http.Error(w, "Error marshaling JSON", 500)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	w.Write(js)
 
 	return
 }
@@ -87,37 +87,25 @@ func UpdateSheet(w http.ResponseWriter, r *http.Request) {
 	// Register a Stackdriver logger instance for this app
 	sl := l.Logger(appname)
 
-	// Get Google Sheet data
-	err = gs.GetSheetData()
+	// Run a sheet export job, whose progress can be followed with the jobs cloud function
+	job, err := gs.NewJob(gsuitemdm.JobTypeSheet, gsuitemdm.JobParams{}, "api:"+gsuitemdm.GetIP(r), gsuitemdm.RoleAdmin)
 	if err != nil {
-	  log.Printf("Error retrieving Google Sheet data: %s", err)
-		sl.Log(logging.Entry{Severity: logging.Error, Payload: "Error retrieving Google Sheet data: " + fmt.Sprintf("%s", err)})
+		log.Printf("Error creating sheet job: %s", err)
+		sl.Log(logging.Entry{Severity: logging.Error, Payload: "Error creating sheet job: " + err.Error()})
+		http.Error(w, fmt.Sprintf("Error creating sheet job: %s", err), 500)
 		return
 	}
-
-	// Get existing Datastore data
-	err = gs.GetDatastoreData()
-	if err != nil {
-	  log.Printf("Error retrieving Google Datastore data: %s", err)
-		sl.Log(logging.Entry{Severity: logging.Error, Payload: "Error retrieving Google Datastore data: " + fmt.Sprintf("%s", err)})
-		return
-	}
-
-	// Merge the data
-	var md []gsuitemdm.DatastoreMobileDevice
-	md = gs.MergeDatastoreAndSheetData()
-
-	// Update the Google Sheet
-	err = gs.UpdateSheet(md)
-	if err != nil {
-	  log.Printf("Error updating Google Sheet: %s", err)
-		sl.Log(logging.Entry{Severity: logging.Error, Payload: "Error updating Google Sheet: " + fmt.Sprintf("%s", err)})
+	err = gs.RunJob(job)
+	if err != nil || job.State != gsuitemdm.JobStateSucceeded {
+		log.Printf("Error: sheet job %s %s: %s %s", job.ID, job.State, job.Error, err)
+		sl.Log(logging.Entry{Severity: logging.Error, Payload: "Error updating Google Sheet: " + job.Text()})
+		http.Error(w, job.Text(), 500)
 		return
 	}
 
 	// Finished
-	sl.Log(logging.Entry{Severity: logging.Notice, Payload: appname + " Success RemoteIP=" + gsuitemdm.GetIP(r)})
-	fmt.Fprintf(w, "%s Success\n", appname)
+	sl.Log(logging.Entry{Severity: logging.Notice, Payload: appname + " Success: Job=" + job.ID + " RemoteIP=" + gsuitemdm.GetIP(r)})
+	fmt.Fprintf(w, "%s Success\n%s", appname, job.Text())
 
 	return
 }
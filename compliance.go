@@ -0,0 +1,162 @@
+package gsuitemdm
+
+//
+// GSuiteMDM mobile access compliance reporting
+//
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Default compliance report title
+const defaultComplianceTitle string = "Mobile Access Compliance Report"
+
+// Build a compliance report by cross-referencing mobile logins with the device store. Users
+// are de-duplicated by email address (or source user ID if they have no email), and are
+// compliant if they have at least one Approved device in the device store. Logins are
+// classified using uac; logins from clients that are not mobile are ignored.
+func BuildComplianceReport(title string, entries []AccessLogEntry, devices []DatastoreMobileDevice, uac *UserAgentClassifier, now time.Time) *ComplianceReport {
+	if title == "" {
+		title = defaultComplianceTitle
+	}
+
+	// Count Approved devices per owner
+	approved := make(map[string]int)
+	for _, d := range devices {
+		if d.Status == "APPROVED" {
+			approved[strings.ToLower(d.Email)]++
+		}
+	}
+
+	// De-duplicate the mobile users
+	users := make(map[string]*ComplianceUser)
+	for _, e := range entries {
+		client, mobile := uac.Classify(e.UserAgent)
+		if !mobile {
+			continue
+		}
+
+		key := strings.ToLower(e.Email)
+		if key == "" {
+			key = e.Source + ":" + e.UserID
+		}
+
+		u, ok := users[key]
+		if !ok {
+			u = &ComplianceUser{
+				Devices:  approved[strings.ToLower(e.Email)],
+				Email:    e.Email,
+				LastSeen: e.DateLast,
+				Name:     e.Name,
+				UserID:   e.UserID,
+				Username: e.Username,
+			}
+			users[key] = u
+		}
+		if e.DateLast.After(u.LastSeen) {
+			u.LastSeen = e.DateLast
+		}
+		if u.Name == "" {
+			u.Name = e.Name
+		}
+		if u.UserID == "" && e.UserID != "" {
+			u.UserID = e.UserID
+			u.Username = e.Username
+		}
+		u.Clients = appendUnique(u.Clients, client)
+		u.Sources = appendUnique(u.Sources, e.Source)
+	}
+
+	// Split into compliant & non-compliant users
+	report := &ComplianceReport{Generated: now, Title: title}
+	for _, u := range users {
+		if u.Devices > 0 {
+			report.Compliant = append(report.Compliant, *u)
+		} else {
+			report.NonCompliant = append(report.NonCompliant, *u)
+		}
+	}
+
+	// Sort the data
+	for _, cu := range [][]ComplianceUser{report.Compliant, report.NonCompliant} {
+		sort.Slice(cu, func(i, j int) bool {
+			return strings.ToLower(cu[i].Username) < strings.ToLower(cu[j].Username)
+		})
+	}
+
+	return report
+}
+
+// Append a string to a slice if it's not empty and not already there
+func appendUnique(s []string, v string) []string {
+	if v == "" || containsString(s, v) {
+		return s
+	}
+
+	return append(s, v)
+}
+
+// Check if a slice contains a string
+func containsString(s []string, v string) bool {
+	for _, x := range s {
+		if x == v {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Format a compliance report as plain text
+func (r *ComplianceReport) Text() string {
+	var s string
+
+	s = r.Subject() + "\n\n"
+	s = s + fmt.Sprintf("(%d) non-compliant users using a mobile client with no Approved MDM device:\n", len(r.NonCompliant))
+	for _, u := range r.NonCompliant {
+		s = s + fmt.Sprintf("   %s (@%s <%s>) via %s\n", u.Name, u.Username, u.Email, strings.Join(u.Clients, ", "))
+	}
+	s = s + fmt.Sprintf("\n(%d) compliant users using a mobile client with an Approved MDM device:\n", len(r.Compliant))
+	for _, u := range r.Compliant {
+		s = s + fmt.Sprintf("   %s (@%s <%s>)\n", u.Name, u.Username, u.Email)
+	}
+
+	return s
+}
+
+// Format a compliance report as HTML. If userurl is set, users seen in the Slack access logs
+// are linked to userurl+UserID
+func (r *ComplianceReport) HTML(userurl string) string {
+	var s string
+
+	user := func(u ComplianceUser) string {
+		if userurl == "" || !containsString(u.Sources, "slack") {
+			return fmt.Sprintf("&nbsp;&nbsp;&nbsp;%s (@%s &lt;%s&gt;)<br>", u.Name, u.Username, u.Email)
+		}
+		return fmt.Sprintf("&nbsp;&nbsp;&nbsp;%s (<a href=\"%s%s\">@%s</a> &lt;%s&gt;)<br>", u.Name, userurl, u.UserID, u.Username, u.Email)
+	}
+
+	s = fmt.Sprintf("<p><strong>(%d) non-compliant users using a mobile client with no Approved MDM device:</strong><br>", len(r.NonCompliant))
+	for _, u := range r.NonCompliant {
+		s = s + user(u)
+	}
+	s = s + "</p>"
+	s = s + fmt.Sprintf("<p><strong>(%d) compliant users using a mobile client with an Approved MDM device:</strong><br>", len(r.Compliant))
+	for _, u := range r.Compliant {
+		s = s + user(u)
+	}
+	s = s + "</p>"
+	s = s + fmt.Sprintf("<p><br>Report generated %s.</p>", r.Generated.Format(time.RFC1123))
+
+	return s
+}
+
+// Subject line of a compliance report
+func (r *ComplianceReport) Subject() string {
+	return fmt.Sprintf("%s for %s", r.Title, r.Generated.Format("January 2, 2006"))
+}
+
+// EOF
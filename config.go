@@ -0,0 +1,543 @@
+package gsuitemdm
+
+//
+// GSuiteMDM configuration loading, defaults & validation
+//
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"sigs.k8s.io/yaml"
+)
+
+// Default configuration values
+const (
+	DefaultActionScope    string = "https://www.googleapis.com/auth/admin.directory.device.mobile"
+	DefaultDirectoryScope string = "https://www.googleapis.com/auth/admin.directory.user.readonly"
+	DefaultDSNamekey      string = "MobileDevice"
+	DefaultDUNamekey      string = "DirectoryUser"
+	DefaultPLNamekey      string = "PhoneLine"
+	DefaultReportsScope   string = "https://www.googleapis.com/auth/admin.reports.audit.readonly"
+	DefaultSearchScope    string = "https://www.googleapis.com/auth/admin.directory.device.mobile.readonly"
+	DefaultSearchType     string = "all"
+	DefaultSheetScope     string = "https://www.googleapis.com/auth/spreadsheets"
+	DefaultTimeZone       string = "UTC"
+)
+
+// Formats of a configuration document
+const (
+	ConfigFormatJSON string = "JSON"
+	ConfigFormatYAML string = "YAML"
+)
+
+// Prefix of the ID of a secret holding a configuration, when loading one
+const ConfigSecretPrefix string = "secret:"
+
+// Start of a YAML configuration: a document marker, or a key followed by a space or the end of
+// the line. Secret IDs ("secret:ID") and file paths ("C:\mdm.yaml") have neither
+var configYAMLStart = regexp.MustCompile(`^(---|[A-Za-z0-9_]+:)(\s|$)`)
+
+// Admin SDK scopes that may be used for each kind of operation
+var ConfigScopes = map[string][]string{
+	"actionscope": {
+		"https://www.googleapis.com/auth/admin.directory.device.mobile",
+		"https://www.googleapis.com/auth/admin.directory.device.mobile.action",
+	},
+	"directoryscope": {
+		"https://www.googleapis.com/auth/admin.directory.user",
+		"https://www.googleapis.com/auth/admin.directory.user.readonly",
+	},
+	"reportsscope": {
+		"https://www.googleapis.com/auth/admin.reports.audit.readonly",
+	},
+	"searchscope": {
+		"https://www.googleapis.com/auth/admin.directory.device.mobile",
+		"https://www.googleapis.com/auth/admin.directory.device.mobile.action",
+		"https://www.googleapis.com/auth/admin.directory.device.mobile.readonly",
+	},
+	"sheetscope": {
+		"https://www.googleapis.com/auth/spreadsheets",
+	},
+}
+
+// A configuration as stored, as JSON or YAML
+type ConfigDocument struct {
+	Data   map[string]interface{} // The configuration
+	Format string                 // JSON or YAML
+}
+
+// Everything wrong with a configuration
+type ConfigError struct {
+	Problems []string
+}
+
+// Format a configuration error, one problem per line
+func (e *ConfigError) Error() string {
+	return "Invalid configuration:\n  - " + strings.Join(e.Problems, "\n  - ")
+}
+
+// Load a configuration, apply defaults and validate it. source is the JSON or YAML configuration
+// itself, the path of a file containing it, or the ID of a secret containing it ("secret:ID", or
+// a Secret Manager "projects/PROJECT/secrets/NAME" ID)
+func LoadConfig(ctx context.Context, source string) (GSuiteMDMConfig, error) {
+	var c GSuiteMDMConfig
+
+	b, err := readConfigSource(ctx, source)
+	if err != nil {
+		return c, err
+	}
+
+	c, err = ParseConfig(b)
+	if err != nil {
+		return c, err
+	}
+	c.SetDefaults()
+
+	return c, c.Validate()
+}
+
+// Get the contents of a configuration source
+func readConfigSource(ctx context.Context, source string) ([]byte, error) {
+	s := strings.TrimSpace(source)
+
+	switch {
+	// Nothing at all
+	case s == "":
+		return nil, errors.New("Empty configuration")
+
+	// The configuration itself, as JSON or YAML
+	case strings.HasPrefix(s, "{") || strings.Contains(s, "\n") || configYAMLStart.MatchString(s):
+		return []byte(s), nil
+
+	// A secret
+	case strings.HasPrefix(s, ConfigSecretPrefix), strings.HasPrefix(s, "projects/") && strings.Contains(s, "/secrets/"):
+		sv, err := GetSecret(ctx, strings.TrimPrefix(s, ConfigSecretPrefix))
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("Error retrieving configuration secret %s: %s", strings.TrimPrefix(s, ConfigSecretPrefix), err))
+		}
+		return []byte(sv), nil
+	}
+
+	// A file
+	b, err := ioutil.ReadFile(s)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, errors.New(fmt.Sprintf("Configuration file %s does not exist", s))
+		}
+		return nil, errors.New(fmt.Sprintf("Error reading configuration file %s: %s", s, err))
+	}
+
+	return b, nil
+}
+
+// Parse a JSON or YAML configuration. Unknown fields are errors, as they are usually misspelt
+func ParseConfig(b []byte) (GSuiteMDMConfig, error) {
+	var c GSuiteMDMConfig
+
+	// YAML is converted to JSON, so the same field names are used
+	js := bytes.TrimSpace(b)
+	format := ConfigFormatJSON
+	if !bytes.HasPrefix(js, []byte("{")) {
+		var err error
+		js, err = yaml.YAMLToJSON(b)
+		if err != nil {
+			return c, errors.New(fmt.Sprintf("Error parsing YAML configuration: %s", err))
+		}
+		format = ConfigFormatYAML
+	}
+
+	d := json.NewDecoder(bytes.NewReader(js))
+	d.DisallowUnknownFields()
+	err := d.Decode(&c)
+	if err != nil {
+		// Say where JSON syntax errors are
+		if se, ok := err.(*json.SyntaxError); ok && format == ConfigFormatJSON {
+			line := bytes.Count(js[:se.Offset], []byte("\n")) + 1
+			return c, errors.New(fmt.Sprintf("Error parsing JSON configuration at line %d: %s", line, err))
+		}
+		return c, errors.New(fmt.Sprintf("Error parsing %s configuration: %s", format, err))
+	}
+
+	return c, nil
+}
+
+// Fill in configuration values that have defaults
+func (c *GSuiteMDMConfig) SetDefaults() {
+	defaults := []struct {
+		v *string
+		d string
+	}{
+		{&c.ActionScope, DefaultActionScope},
+		{&c.DirectoryScope, DefaultDirectoryScope},
+		{&c.DSNamekey, DefaultDSNamekey},
+		{&c.DUNamekey, DefaultDUNamekey},
+		{&c.JBNamekey, DefaultJobNamekey},
+		{&c.PLNamekey, DefaultPLNamekey},
+		{&c.ReportsScope, DefaultReportsScope},
+		{&c.SearchScope, DefaultSearchScope},
+		{&c.SearchType, DefaultSearchType},
+		{&c.SheetScope, DefaultSheetScope},
+		{&c.TimeZone, DefaultTimeZone},
+	}
+	for _, d := range defaults {
+		if strings.TrimSpace(*d.v) == "" {
+			*d.v = d.d
+		}
+	}
+
+	// The Admin SDK wipe action of the remote wipe type. If it isn't set, devices are wiped
+	// according to their ownership
+	if a, ok := wipeTypeAction(c.RemoteWipeType); ok {
+		c.RemoteWipeType = a
+	}
+}
+
+// Check a configuration, returning a ConfigError listing all of its problems
+func (c *GSuiteMDMConfig) Validate() error {
+	var problems []string
+
+	problem := func(format string, a ...interface{}) {
+		problems = append(problems, fmt.Sprintf(format, a...))
+	}
+
+	// Required fields
+	if strings.TrimSpace(c.ProjectID) == "" {
+		problem("projectid is required")
+	}
+
+	// Scopes
+	scopes := map[string]string{
+		"actionscope":    c.ActionScope,
+		"directoryscope": c.DirectoryScope,
+		"reportsscope":   c.ReportsScope,
+		"searchscope":    c.SearchScope,
+		"sheetscope":     c.SheetScope,
+	}
+	var names []string
+	for name := range scopes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if !containsString(ConfigScopes[name], scopes[name]) {
+			problem("%s %q is not valid, must be one of: %s", name, scopes[name], strings.Join(ConfigScopes[name], ", "))
+		}
+	}
+
+	// Time zone
+	if _, err := time.LoadLocation(c.TimeZone); err != nil {
+		problem("timezone %q is not valid: %s", c.TimeZone, err)
+	}
+
+	// Wipe types
+	if c.RemoteWipeType != "" {
+		if _, ok := wipeTypeAction(c.RemoteWipeType); !ok {
+			problem("remotewipetype %q is not valid, must be %q or %q", c.RemoteWipeType, WipeActions[WipeTypeAccount], WipeActions[WipeTypeDevice])
+		}
+	}
+	for _, t := range c.AllowedWipeTypes {
+		if _, ok := wipeTypeAction(t); !ok {
+			problem("allowedwipetypes: %q is not valid, must be %q or %q", t, WipeTypeAccount, WipeTypeDevice)
+		}
+	}
+
+	// Domains
+	if len(c.Domains) < 1 {
+		problem("domains: at least one domain is required")
+	}
+	seen := make(map[string]int)
+	for i, d := range c.Domains {
+		name := strings.ToLower(strings.TrimSpace(d.DomainName))
+		where := fmt.Sprintf("domains[%d]", i)
+		if name == "" {
+			problem("%s: domainname is required", where)
+		} else {
+			where = fmt.Sprintf("domains[%d] (%s)", i, name)
+			if j, ok := seen[name]; ok {
+				problem("%s: duplicate of domains[%d]", where, j)
+			} else {
+				seen[name] = i
+			}
+			if !strings.Contains(name, ".") || strings.ContainsAny(name, " @/") {
+				problem("%s: domainname is not a valid domain name", where)
+			}
+		}
+		if strings.TrimSpace(d.CustomerID) == "" {
+			problem("%s: companyid is required", where)
+		}
+		if !strings.Contains(d.AdminUser, "@") {
+			problem("%s: adminuser must be an email address", where)
+		}
+		if strings.TrimSpace(d.SecretID) == "" {
+			problem("%s: secretid is required", where)
+		}
+	}
+
+	// Google Sheet. It's optional, but needs all of its settings if used
+	if c.SheetID != "" || c.SheetCredsID != "" || c.SheetWho != "" {
+		if c.SheetID == "" || c.SheetCredsID == "" || !strings.Contains(c.SheetWho, "@") {
+			problem("sheetid, sheetcredsid and sheetwho (an email address) are all required to use the Google Sheet")
+		}
+	}
+
+	// Secrets
+	switch c.Secrets.Provider {
+	case "", SecretProviderEnv, SecretProviderSecretManager:
+	case SecretProviderFile:
+		if c.Secrets.Dir == "" {
+			problem("secrets.dir is required with the %q secret provider", SecretProviderFile)
+		}
+	case SecretProviderVault:
+		if c.Secrets.VaultAddress == "" {
+			problem("secrets.vaultaddress is required with the %q secret provider", SecretProviderVault)
+		}
+	default:
+		problem("secrets.provider %q is not valid, must be one of: %s, %s, %s, %s", c.Secrets.Provider, SecretProviderSecretManager, SecretProviderFile, SecretProviderEnv, SecretProviderVault)
+	}
+
+	// Sync
+	if c.Sync.BatchSize > MaxDatastoreBatch {
+		problem("sync.batchsize may not be more than %d", MaxDatastoreBatch)
+	}
+
+	// Jobs
+	if c.Jobs.LeaseSeconds < 0 {
+		problem("jobs.leaseseconds may not be negative")
+	}
+
+	if len(problems) > 0 {
+		return &ConfigError{Problems: problems}
+	}
+
+	return nil
+}
+
+// Parse a configuration as stored, to be edited without filling in defaults or losing fields
+func ParseConfigDocument(b []byte) (*ConfigDocument, error) {
+	doc := &ConfigDocument{Format: ConfigFormatJSON}
+
+	js := bytes.TrimSpace(b)
+	if !bytes.HasPrefix(js, []byte("{")) {
+		var err error
+		js, err = yaml.YAMLToJSON(b)
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("Error parsing YAML configuration: %s", err))
+		}
+		doc.Format = ConfigFormatYAML
+	}
+
+	err := json.Unmarshal(js, &doc.Data)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("Error parsing %s configuration: %s", doc.Format, err))
+	}
+	if doc.Data == nil {
+		doc.Data = make(map[string]interface{})
+	}
+
+	return doc, nil
+}
+
+// Format a configuration document as it was parsed, JSON (indented with tabs) or YAML
+func (doc *ConfigDocument) Bytes() ([]byte, error) {
+	js, err := json.MarshalIndent(doc.Data, "", "\t")
+	if err != nil {
+		return nil, err
+	}
+	if doc.Format == ConfigFormatYAML {
+		return yaml.JSONToYAML(js)
+	}
+
+	return append(js, '\n'), nil
+}
+
+// Copy a configuration document, so it can be changed and compared with the original
+func (doc *ConfigDocument) Copy() *ConfigDocument {
+	var data map[string]interface{}
+
+	js, _ := json.Marshal(doc.Data)
+	json.Unmarshal(js, &data)
+
+	return &ConfigDocument{Data: data, Format: doc.Format}
+}
+
+// The configuration of a configuration document, with defaults, checked
+func (doc *ConfigDocument) Config() (GSuiteMDMConfig, error) {
+	js, err := json.Marshal(doc.Data)
+	if err != nil {
+		return GSuiteMDMConfig{}, err
+	}
+
+	c, err := ParseConfig(js)
+	if err != nil {
+		return c, err
+	}
+	c.SetDefaults()
+
+	return c, c.Validate()
+}
+
+// Set a configuration value using its dotted path (e.g. "sync.concurrency", "domains.0.adminuser").
+// The value is used as JSON if it is valid JSON (e.g. 8, true, ["account"]), otherwise as a string
+func (doc *ConfigDocument) Set(path, value string) error {
+	var v interface{}
+
+	if json.Unmarshal([]byte(value), &v) != nil {
+		v = value
+	}
+
+	keys := strings.Split(path, ".")
+	var parent interface{} = doc.Data
+	for i, k := range keys {
+		last := i == len(keys)-1
+
+		switch p := parent.(type) {
+		case map[string]interface{}:
+			if last {
+				p[k] = v
+				return nil
+			}
+			if _, ok := p[k]; !ok {
+				p[k] = make(map[string]interface{})
+			}
+			parent = p[k]
+
+		case []interface{}:
+			n, err := strconv.Atoi(k)
+			if err != nil || n < 0 || n >= len(p) {
+				return errors.New(fmt.Sprintf("%s: %q is not an index of the list %s", path, k, strings.Join(keys[:i], ".")))
+			}
+			if last {
+				p[n] = v
+				return nil
+			}
+			parent = p[n]
+
+		default:
+			return errors.New(fmt.Sprintf("%s: %s is not an object or a list", path, strings.Join(keys[:i], ".")))
+		}
+	}
+
+	return nil
+}
+
+// Add a domain to a configuration document
+func (doc *ConfigDocument) AddDomain(dc DomainConf) error {
+	var d map[string]interface{}
+
+	domains, _ := doc.Data["domains"].([]interface{})
+	for _, e := range domains {
+		if m, ok := e.(map[string]interface{}); ok && strings.EqualFold(fmt.Sprint(m["domainname"]), dc.DomainName) {
+			return errors.New(fmt.Sprintf("Domain %s is already configured", dc.DomainName))
+		}
+	}
+
+	js, err := json.Marshal(dc)
+	if err != nil {
+		return err
+	}
+	json.Unmarshal(js, &d)
+	doc.Data["domains"] = append(domains, d)
+
+	return nil
+}
+
+// Remove a domain from a configuration document
+func (doc *ConfigDocument) RemoveDomain(domain string) error {
+	var kept []interface{}
+
+	domains, _ := doc.Data["domains"].([]interface{})
+	for _, e := range domains {
+		if m, ok := e.(map[string]interface{}); ok && strings.EqualFold(fmt.Sprint(m["domainname"]), domain) {
+			continue
+		}
+		kept = append(kept, e)
+	}
+	if len(kept) == len(domains) {
+		return errors.New(fmt.Sprintf("Domain %s is not configured", domain))
+	}
+	doc.Data["domains"] = kept
+
+	return nil
+}
+
+// List the differences between two configuration documents, one line per changed value:
+// "- path: old value" and/or "+ path: new value"
+func (doc *ConfigDocument) Diff(old *ConfigDocument) []string {
+	var diff []string
+	var paths []string
+
+	ov := make(map[string]string)
+	nv := make(map[string]string)
+	flattenConfig("", old.Data, ov)
+	flattenConfig("", doc.Data, nv)
+
+	seen := make(map[string]bool)
+	for _, m := range []map[string]string{ov, nv} {
+		for p := range m {
+			if !seen[p] {
+				seen[p] = true
+				paths = append(paths, p)
+			}
+		}
+	}
+	sort.Strings(paths)
+
+	for _, p := range paths {
+		o, inold := ov[p]
+		n, innew := nv[p]
+		if inold && innew && o == n {
+			continue
+		}
+		if inold {
+			diff = append(diff, fmt.Sprintf("- %s: %s", p, o))
+		}
+		if innew {
+			diff = append(diff, fmt.Sprintf("+ %s: %s", p, n))
+		}
+	}
+
+	return diff
+}
+
+// Flatten a configuration value into dotted paths & their values as JSON
+func flattenConfig(prefix string, v interface{}, out map[string]string) {
+	join := func(k string) string {
+		if prefix == "" {
+			return k
+		}
+		return prefix + "." + k
+	}
+
+	switch t := v.(type) {
+	case map[string]interface{}:
+		for k, e := range t {
+			flattenConfig(join(k), e, out)
+		}
+		if len(t) == 0 && prefix != "" {
+			out[prefix] = "{}"
+		}
+	case []interface{}:
+		for i, e := range t {
+			flattenConfig(join(strconv.Itoa(i)), e, out)
+		}
+		if len(t) == 0 && prefix != "" {
+			out[prefix] = "[]"
+		}
+	default:
+		js, _ := json.Marshal(t)
+		out[prefix] = string(js)
+	}
+}
+
+// EOF
@@ -0,0 +1,233 @@
+package gsuitemdm
+
+//
+// GSuiteMDM configuration tests
+//
+
+import (
+	"context"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Get a valid configuration, with defaults filled in
+func validTestConfig() *GSuiteMDMConfig {
+	c := &GSuiteMDMConfig{
+		Domains: Domains{{
+			AdminUser:  "admin@example.com",
+			CustomerID: "C01abc123",
+			DomainName: "example.com",
+			SecretID:   "example-com-creds",
+		}},
+		ProjectID: "example-project",
+	}
+	c.SetDefaults()
+
+	return c
+}
+
+// Every problem with a configuration is reported
+func TestConfigValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		change func(c *GSuiteMDMConfig)
+		want   []string
+	}{
+		{name: "valid", change: func(c *GSuiteMDMConfig) {}},
+		{name: "no projectid", change: func(c *GSuiteMDMConfig) { c.ProjectID = " " }, want: []string{"projectid is required"}},
+		{name: "invalid scope", change: func(c *GSuiteMDMConfig) { c.ActionScope = "https://www.googleapis.com/auth/drive" }, want: []string{"actionscope"}},
+		{name: "invalid timezone", change: func(c *GSuiteMDMConfig) { c.TimeZone = "Mars/Olympus_Mons" }, want: []string{"timezone"}},
+		{name: "remotewipetype", change: func(c *GSuiteMDMConfig) { c.RemoteWipeType = WipeTypeDevice }},
+		{name: "invalid remotewipetype", change: func(c *GSuiteMDMConfig) { c.RemoteWipeType = "everything" }, want: []string{"remotewipetype"}},
+		{name: "invalid allowedwipetypes", change: func(c *GSuiteMDMConfig) { c.AllowedWipeTypes = []string{WipeTypeAccount, "everything"} }, want: []string{"allowedwipetypes: \"everything\""}},
+		{name: "no domains", change: func(c *GSuiteMDMConfig) { c.Domains = nil }, want: []string{"at least one domain"}},
+		{
+			name: "duplicate domain",
+			change: func(c *GSuiteMDMConfig) {
+				d := c.Domains[0]
+				d.DomainName = "Example.COM"
+				c.Domains = append(c.Domains, d)
+			},
+			want: []string{"domains[1] (example.com): duplicate of domains[0]"},
+		},
+		{
+			name: "incomplete domain",
+			change: func(c *GSuiteMDMConfig) {
+				c.Domains[0] = DomainConf{DomainName: "example"}
+			},
+			want: []string{"not a valid domain name", "companyid is required", "adminuser must be an email address", "secretid is required"},
+		},
+		{name: "no domainname", change: func(c *GSuiteMDMConfig) { c.Domains[0].DomainName = "" }, want: []string{"domains[0]: domainname is required"}},
+		{
+			name: "sheet",
+			change: func(c *GSuiteMDMConfig) {
+				c.SheetID, c.SheetCredsID, c.SheetWho = "sheet", "sheet-creds", "sheets@example.com"
+			},
+		},
+		{name: "incomplete sheet", change: func(c *GSuiteMDMConfig) { c.SheetID = "sheet" }, want: []string{"sheetid, sheetcredsid and sheetwho"}},
+		{name: "file secrets without dir", change: func(c *GSuiteMDMConfig) { c.Secrets.Provider = SecretProviderFile }, want: []string{"secrets.dir is required"}},
+		{name: "vault secrets without address", change: func(c *GSuiteMDMConfig) { c.Secrets.Provider = SecretProviderVault }, want: []string{"secrets.vaultaddress is required"}},
+		{name: "invalid secret provider", change: func(c *GSuiteMDMConfig) { c.Secrets.Provider = "keepass" }, want: []string{"secrets.provider \"keepass\""}},
+		{name: "sync batch too big", change: func(c *GSuiteMDMConfig) { c.Sync.BatchSize = MaxDatastoreBatch + 1 }, want: []string{"sync.batchsize"}},
+		{name: "negative job lease", change: func(c *GSuiteMDMConfig) { c.Jobs.LeaseSeconds = -1 }, want: []string{"jobs.leaseseconds"}},
+		{
+			name: "several problems",
+			change: func(c *GSuiteMDMConfig) {
+				c.ProjectID = ""
+				c.TimeZone = "Nowhere"
+				c.Domains = nil
+			},
+			want: []string{"projectid is required", "timezone", "at least one domain"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := validTestConfig()
+			tt.change(c)
+
+			err := c.Validate()
+			if len(tt.want) == 0 {
+				if err != nil {
+					t.Fatalf("Validate() error = %v", err)
+				}
+				return
+			}
+			ce, ok := err.(*ConfigError)
+			if !ok {
+				t.Fatalf("Validate() error = %v, want a *ConfigError", err)
+			}
+			if len(ce.Problems) != len(tt.want) {
+				t.Errorf("Validate() problems = %q, want %d", ce.Problems, len(tt.want))
+			}
+			for _, w := range tt.want {
+				found := false
+				for _, p := range ce.Problems {
+					if strings.Contains(p, w) {
+						found = true
+						break
+					}
+				}
+				if !found {
+					t.Errorf("Validate() problems = %q, want one containing %q", ce.Problems, w)
+				}
+			}
+		})
+	}
+}
+
+// Configurations are parsed from JSON or YAML, and unknown fields are rejected
+func TestParseConfig(t *testing.T) {
+	tests := []struct {
+		name        string
+		config      string
+		wantdomain  string
+		wanterr     string
+		wantproject string
+	}{
+		{name: "json", config: testConfigJSON("example-project"), wantdomain: "example.com", wantproject: "example-project"},
+		{name: "yaml", config: "projectid: example-project\ndomains:\n  - domainname: example.com\n", wantdomain: "example.com", wantproject: "example-project"},
+		{name: "yaml document", config: "---\nprojectid: example-project\n", wantproject: "example-project"},
+		{name: "json unknown field", config: `{"projectid": "example-project", "sheetcreds": "creds.json"}`, wanterr: `unknown field "sheetcreds"`},
+		{name: "yaml unknown field", config: "projectid: example-project\ncredentialsfile: creds.json\n", wanterr: `unknown field "credentialsfile"`},
+		{name: "json syntax error", config: "{\n  \"projectid\": \"example-project\",\n}", wanterr: "at line 3"},
+		{name: "yaml syntax error", config: "projectid: [example-project\n", wanterr: "Error parsing YAML configuration"},
+		{name: "wrong type", config: `{"projectid": 1}`, wanterr: "Error parsing JSON configuration"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := ParseConfig([]byte(tt.config))
+			if tt.wanterr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wanterr) {
+					t.Fatalf("ParseConfig() error = %v, want one containing %q", err, tt.wanterr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseConfig() error = %v", err)
+			}
+			if c.ProjectID != tt.wantproject {
+				t.Errorf("ParseConfig() projectid = %q, want %q", c.ProjectID, tt.wantproject)
+			}
+			if tt.wantdomain != "" && (len(c.Domains) != 1 || c.Domains[0].DomainName != tt.wantdomain) {
+				t.Errorf("ParseConfig() domains = %+v, want %q", c.Domains, tt.wantdomain)
+			}
+		})
+	}
+}
+
+// Configurations are loaded from the configuration itself or a file, with defaults filled in
+func TestLoadConfig(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, config string) string {
+		path := filepath.Join(dir, name)
+		err := ioutil.WriteFile(path, []byte(config), 0600)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	yml := "projectid: example-project\ndomains:\n  - domainname: example.com\n    companyid: C01abc123\n    adminuser: admin@example.com\n    secretid: example-com-creds\n"
+
+	tests := []struct {
+		name        string
+		source      string
+		wanterr     string
+		wantproject string
+		check       func(c GSuiteMDMConfig) bool
+	}{
+		{name: "inline json", source: testConfigJSON("example-project"), wantproject: "example-project"},
+		{name: "inline yaml", source: yml, wantproject: "example-project"},
+		{name: "single line yaml", source: "projectid: example-project", wanterr: "at least one domain"},
+		{name: "json file", source: write("gsuitemdm_conf.json", testConfigJSON("example-project")), wantproject: "example-project"},
+		{name: "yaml file", source: write("gsuitemdm_conf.yaml", yml), wantproject: "example-project"},
+		{name: "missing file", source: filepath.Join(dir, "missing.json"), wanterr: "does not exist"},
+		{name: "empty", source: " \n", wanterr: "Empty configuration"},
+		{name: "unknown field", source: `{"projectid": "example-project", "sheetcreds": "creds.json"}`, wanterr: `unknown field "sheetcreds"`},
+		{name: "invalid", source: `{"projectid": "example-project"}`, wanterr: "at least one domain"},
+		{
+			name:        "defaults",
+			source:      testConfigJSON("example-project"),
+			wantproject: "example-project",
+			check: func(c GSuiteMDMConfig) bool {
+				return c.ActionScope == DefaultActionScope && c.DSNamekey == DefaultDSNamekey && c.JBNamekey == DefaultJobNamekey && c.SearchType == DefaultSearchType && c.TimeZone == DefaultTimeZone && c.RemoteWipeType == ""
+			},
+		},
+		{
+			name:        "defaults don't replace values",
+			source:      `{"projectid": "example-project", "dsnamekey": "Device", "remotewipetype": "device", "timezone": "America/Los_Angeles", "domains": [{"adminuser": "admin@example.com", "companyid": "C01abc123", "domainname": "example.com", "secretid": "example-com-creds"}]}`,
+			wantproject: "example-project",
+			check: func(c GSuiteMDMConfig) bool {
+				return c.DSNamekey == "Device" && c.RemoteWipeType == ActionRemoteWipe && c.TimeZone == "America/Los_Angeles"
+			},
+		},
+		{name: "cloud functions example", source: filepath.Join("cloudfunctions", "gsuitemdm_conf_example.json"), wantproject: "yourproject"},
+		{name: "demo example", source: filepath.Join("demo", "gsuitemdmtest", "gsuitemdmtest_conf_example.json"), wantproject: "yourgcpprojectname"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := LoadConfig(context.Background(), tt.source)
+			if tt.wanterr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wanterr) {
+					t.Fatalf("LoadConfig() error = %v, want one containing %q", err, tt.wanterr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("LoadConfig() error = %v", err)
+			}
+			if c.ProjectID != tt.wantproject {
+				t.Errorf("LoadConfig() projectid = %q, want %q", c.ProjectID, tt.wantproject)
+			}
+			if tt.check != nil && !tt.check(c) {
+				t.Errorf("LoadConfig() = %+v, defaults not as expected", c)
+			}
+		})
+	}
+}
+
+// EOF
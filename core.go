@@ -8,21 +8,44 @@ import (
 	"context"
 )
 
-// Create a new G Suite MDM Service
+// Create a new G Suite MDM Service. config is the JSON or YAML configuration, the path of a file
+// containing it, or the ID of a secret containing it (see LoadConfig)
 func New(ctx context.Context, config string) (*GSuiteMDMService, error) {
 	var cf GSuiteMDMConfig
 	var err error
 
-	// Load in configuration and get a config struct back
-	cf, err = loadConfig(config)
+	// Load in configuration, with defaults, and check it
+	cf, err = LoadConfig(ctx, config)
 	if err != nil {
 		return nil, err
 	}
 
+	return newService(ctx, cf)
+}
+
+// Create a new G Suite MDM Service from a loaded configuration
+func newService(ctx context.Context, cf GSuiteMDMConfig) (*GSuiteMDMService, error) {
 	// Create a new G Suite MDM service and populate it
-	return &GSuiteMDMService{
+	mdms := &GSuiteMDMService{
 		C:   cf,
-		Ctx: ctx}, nil
+		Ctx: ctx}
+
+	// Secrets named in the configuration may be kept somewhere other than the configuration
+	// itself & the API keys, which always come from the bootstrap secret provider
+	if cf.Secrets.Provider != "" {
+		p, err := configSecretProvider(cf.Secrets)
+		if err != nil {
+			return nil, err
+		}
+		mdms.Secrets = p
+	}
+
+	// Submitted jobs are run by a background cloud function, if there is one
+	if cf.Jobs.Topic != "" {
+		mdms.Jobs = NewPubSubJobWorker(cf.ProjectID, cf.Jobs.Topic)
+	}
+
+	return mdms, nil
 }
 
 // EOF
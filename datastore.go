@@ -10,6 +10,7 @@ import (
 	"fmt"
 	admin "google.golang.org/api/admin/directory/v1"
 	"strings"
+	"time"
 )
 
 // Convert a Datastore mobile device object to an Admin SDK mobile device object
@@ -37,13 +38,32 @@ func (mdms *GSuiteMDMService) ConvertDatastoreDevicetoSDK(device *DatastoreMobil
 	return &d
 }
 
+// Most devices that can be written to Datastore at once
+const MaxDatastoreBatch int = 500
+
+// Get the shared Datastore client, creating it on first use
+func (mdms *GSuiteMDMService) DatastoreClient() (*datastore.Client, error) {
+	mdms.mu.Lock()
+	defer mdms.mu.Unlock()
+
+	if mdms.DS == nil {
+		dc, err := datastoreClient(mdms.C.ProjectID)
+		if err != nil {
+			return nil, err
+		}
+		mdms.DS = dc
+	}
+
+	return mdms.DS, nil
+}
+
 // Read all mobile device data from Google Cloud Datastore
 func (mdms *GSuiteMDMService) GetDatastoreData() error {
 	var dc *datastore.Client
 	var err error
 
-	// Create a Datastore client
-	dc, err = datastore.NewClient(mdms.Ctx, mdms.C.ProjectID)
+	// Get a Datastore client
+	dc, err = mdms.DatastoreClient()
 	if err != nil {
 		return errors.New(fmt.Sprintf("Error creating Datastore client: %s", err))
 	}
@@ -61,6 +81,59 @@ func (mdms *GSuiteMDMService) GetDatastoreData() error {
 	return nil
 }
 
+// Get a single mobile device from Google Cloud Datastore using its serial number
+func (mdms *GSuiteMDMService) GetDatastoreDevice(sn string) (*DatastoreMobileDevice, error) {
+	var d = new(DatastoreMobileDevice)
+
+	// Get a Datastore client
+	dc, err := mdms.DatastoreClient()
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("Error creating Datastore client: %s", err))
+	}
+
+	// Devices are keyed by their (normalised) serial number
+	err = dc.Get(mdms.Ctx, datastore.NameKey(mdms.C.DSNamekey, strings.Replace(sn, " ", "", -1), nil), d)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("Error retrieving device SN=%s from Datastore: %s", sn, err))
+	}
+
+	return d, nil
+}
+
+// Delete a single mobile device from Google Cloud Datastore
+func (mdms *GSuiteMDMService) DeleteDatastoreDevice(sn string) error {
+	// Get a Datastore client
+	dc, err := mdms.DatastoreClient()
+	if err != nil {
+		return errors.New(fmt.Sprintf("Error creating Datastore client: %s", err))
+	}
+
+	// Delete the device
+	err = dc.Delete(mdms.Ctx, datastore.NameKey(mdms.C.DSNamekey, strings.Replace(sn, " ", "", -1), nil))
+	if err != nil {
+		return errors.New(fmt.Sprintf("Error deleting device SN=%s from Datastore: %s", sn, err))
+	}
+
+	return nil
+}
+
+// Save a single mobile device in Google Cloud Datastore
+func (mdms *GSuiteMDMService) PutDatastoreDevice(device *DatastoreMobileDevice) error {
+	// Get a Datastore client
+	dc, err := mdms.DatastoreClient()
+	if err != nil {
+		return errors.New(fmt.Sprintf("Error creating Datastore client: %s", err))
+	}
+
+	// Save the device
+	_, err = dc.Put(mdms.Ctx, datastore.NameKey(mdms.C.DSNamekey, strings.Replace(device.SN, " ", "", -1), nil), device)
+	if err != nil {
+		return errors.New(fmt.Sprintf("Error saving device SN=%s to Datastore: %s", device.SN, err))
+	}
+
+	return nil
+}
+
 // Search for a matching device in Google Datastore using a specific Admin SDK mobile device object
 func (mdms *GSuiteMDMService) SearchDatastoreForDevice(device *admin.MobileDevice) (*DatastoreMobileDevice, error) {
 	var d = new(DatastoreMobileDevice)
@@ -92,26 +165,18 @@ func (mdms *GSuiteMDMService) SearchDatastoreForDevice(device *admin.MobileDevic
 // Update a device in Google Cloud Datastore
 func (mdms *GSuiteMDMService) UpdateDatastoreDevice(device *admin.MobileDevice) error {
 	var ed = new(DatastoreMobileDevice)
-	var nd = new(DatastoreMobileDevice)
 	var dc *datastore.Client
 	var err error
 	var key *datastore.Key
 
-	// Create a Datastore client
-	dc, err = datastore.NewClient(mdms.Ctx, mdms.C.ProjectID)
-	if err != nil {
-		return err
-	}
-
-	// We were passed an Admin SDK mobile device object. We need to convert it to a
-	// new Datastore mobile device object
-	nd, err = mdms.ConvertSDKDeviceToDatastore(device)
+	// Get a Datastore client
+	dc, err = mdms.DatastoreClient()
 	if err != nil {
 		return err
 	}
 
 	// Get the existing Datastore entry for this device
-	key = datastore.NameKey(mdms.C.DSNamekey, nd.SN, nil)
+	key = datastore.NameKey(mdms.C.DSNamekey, strings.Replace(device.SerialNumber, " ", "", -1), nil)
 	err = dc.Get(mdms.Ctx, key, ed)
 	if err != nil {
 		// Note: no need to return on err != nil here because we will get an error if
@@ -120,6 +185,107 @@ func (mdms *GSuiteMDMService) UpdateDatastoreDevice(device *admin.MobileDevice)
 		// return err
 	}
 
+	// Merge the Admin SDK's data with the existing data
+	nd, events, err := mdms.mergeDatastoreDevice(device, ed, time.Now())
+	if err != nil {
+		return err
+	}
+
+	// We're finished, save the device in Datastore
+	_, err = dc.Put(mdms.Ctx, key, nd)
+
+	if err != nil {
+		return err
+	}
+
+	// Tell anyone interested
+	for _, e := range events {
+		mdms.Publish(e)
+	}
+
+	return nil
+}
+
+// Update a batch of devices in Google Cloud Datastore, reading their existing data in one request
+// and writing them in another. Batches may not have more than MaxDatastoreBatch devices
+func (mdms *GSuiteMDMService) UpdateDatastoreDevices(devices []*admin.MobileDevice) error {
+	var keys []*datastore.Key
+	var sdevices []*admin.MobileDevice
+
+	if len(devices) > MaxDatastoreBatch {
+		return errors.New(fmt.Sprintf("Too many devices to update at once: %d (max %d)", len(devices), MaxDatastoreBatch))
+	}
+
+	// Get a Datastore client
+	dc, err := mdms.DatastoreClient()
+	if err != nil {
+		return errors.New(fmt.Sprintf("Error creating Datastore client: %s", err))
+	}
+
+	// A batch may not write the same device twice, so only its last entry is kept
+	index := make(map[string]int)
+	for _, device := range devices {
+		sn := strings.Replace(device.SerialNumber, " ", "", -1)
+		if i, ok := index[sn]; ok {
+			sdevices[i] = device
+			continue
+		}
+		index[sn] = len(keys)
+		keys = append(keys, datastore.NameKey(mdms.C.DSNamekey, sn, nil))
+		sdevices = append(sdevices, device)
+	}
+
+	// Get the existing Datastore entries for these devices. Devices that don't exist yet are
+	// created, so only other errors matter
+	existing := make([]DatastoreMobileDevice, len(keys))
+	err = dc.GetMulti(mdms.Ctx, keys, existing)
+	if me, ok := err.(datastore.MultiError); ok {
+		for i, e := range me {
+			if _, mismatch := e.(*datastore.ErrFieldMismatch); e != nil && e != datastore.ErrNoSuchEntity && !mismatch {
+				return errors.New(fmt.Sprintf("Error getting device SN=%s from Datastore: %s", keys[i].Name, e))
+			}
+		}
+	} else if err != nil {
+		return errors.New(fmt.Sprintf("Error getting devices from Datastore: %s", err))
+	}
+
+	// Merge the Admin SDK's data with the existing data
+	var events []Event
+	now := time.Now()
+	nds := make([]*DatastoreMobileDevice, len(keys))
+	for i, device := range sdevices {
+		nd, evs, err := mdms.mergeDatastoreDevice(device, &existing[i], now)
+		if err != nil {
+			return errors.New(fmt.Sprintf("Error converting device SN=%s: %s", keys[i].Name, err))
+		}
+		nds[i] = nd
+		events = append(events, evs...)
+	}
+
+	// Save the devices in Datastore
+	_, err = dc.PutMulti(mdms.Ctx, keys, nds)
+	if err != nil {
+		return errors.New(fmt.Sprintf("Error saving %d device(s) to Datastore: %s", len(nds), err))
+	}
+
+	// Tell anyone interested
+	for _, e := range events {
+		mdms.Publish(e)
+	}
+
+	return nil
+}
+
+// Merge fresh Admin SDK data for a device with its existing Datastore data (empty for new
+// devices) and Google Sheet data. Returns the device to save and the events it should publish
+func (mdms *GSuiteMDMService) mergeDatastoreDevice(device *admin.MobileDevice, ed *DatastoreMobileDevice, now time.Time) (*DatastoreMobileDevice, []Event, error) {
+	// We were passed an Admin SDK mobile device object. We need to convert it to a
+	// new Datastore mobile device object
+	nd, err := mdms.ConvertSDKDeviceToDatastore(device)
+	if err != nil {
+		return nil, nil, err
+	}
+
 	// If existing data exists for this device in Datastore, preserve it
 	if ed.PhoneNumber != "" {
 		nd.PhoneNumber = strings.Replace(ed.PhoneNumber, " ", "", -1)
@@ -133,6 +299,13 @@ func (mdms *GSuiteMDMService) UpdateDatastoreDevice(device *admin.MobileDevice)
 	if ed.Notes != "" {
 		nd.Notes = ed.Notes
 	}
+	preserveAssetData(nd, ed, now)
+
+	// Lost reports, owner notices & responses are never reported by the Admin SDK either
+	nd.Lost = ed.Lost
+	nd.Offboarded = ed.Offboarded
+	nd.OwnerNotice = ed.OwnerNotice
+	nd.OwnerResponses = ed.OwnerResponses
 
 	// Ensure domain for this device is accurate
 	nd.Domain = getEmailDomain(device.Email[0])
@@ -152,14 +325,8 @@ func (mdms *GSuiteMDMService) UpdateDatastoreDevice(device *admin.MobileDevice)
 		}
 	}
 
-	// We're finished, save the device in Datastore
-	_, err = dc.Put(mdms.Ctx, key, nd)
-
-	if err != nil {
-		return err
-	}
-
-	return nil
+	// Work out what has changed before the existing data is overwritten
+	return nd, mdms.deviceSyncEvents(nd, ed, now), nil
 }
 
 // EOF
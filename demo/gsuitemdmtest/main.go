@@ -20,22 +20,24 @@ import (
 //
 // Basic requirements:
 // 	1) a G Suite domain with MDM enabled and > 1 mobile device configured w/MDM
-//		2) a properly-setup main configuration file. be sure that 'sheetcreds', 'sheetid',
-//		   'sheetwho' and 'projectid' are configured correctly! an example configuration
-//			file is included (gsuitemdmtest_example_conf.json)
+//		2) a properly-setup main configuration file. be sure that 'secrets', 'sheetcredsid',
+//		   'sheetid', 'sheetwho' and 'projectid' are configured correctly! an example configuration
+//			file is included (gsuitemdmtest_conf_example.json)
 //		3) TODO
 //
 //	Instructions:
 //		1) edit the 'configfile', making sure to change the following vars to suit:
 //			'globaldebug': if you want debug messages (bool)
 //			'projectid': set to the name of the GCP project you want to run gsuitemdm inside
-//			'sheetcreds': set to the path of the JSON credentials file of the user with
+//			'secrets': set 'dir' to the directory containing your JSON credentials files
+//			'sheetcredsid': set to the name of the JSON credentials file of the user with
 //				appropriate permissions to write the test Google sheet
 //			'sheetid': set to the ID of the test Google sheet. Note this is the part
 //				after https://docs.google.com/spreadsheets/d/ but before /edit
 //			'sheetwho': set to the email address of the G Suite user who has permissions
 //				to write the test Google sheet
-//			'domains': setup this JSON array as per your G Suite domain setup
+//			'domains': setup this JSON array as per your G Suite domain setup. set each
+//				'secretid' to the name of the domain's JSON credentials file
 //		2) set the folowing environment variables to suit your specific needs:
 //			export TESTAPP="gsuitemdmtest"
 //			export TESTDOMAIN="yourdomain.com"
@@ -58,7 +60,7 @@ func main() {
 	// Get a G Suite MDM Service
 	gs, err := gsuitemdm.New(ctx, configfile)
 	if err != nil {
-		log.Fatal("Couldn't get a gsuitemdm service")
+		log.Fatalf("Couldn't get a gsuitemdm service: %s", err)
 	}
 
 	// Get Admin SDK data
@@ -0,0 +1,261 @@
+package gsuitemdm
+
+//
+// GSuiteMDM user directory funcs
+//
+
+import (
+	"cloud.google.com/go/datastore"
+	"encoding/json"
+	"errors"
+	"fmt"
+	admin "google.golang.org/api/admin/directory/v1"
+	"strings"
+	"time"
+)
+
+// Maximum number of entities Datastore allows in a single multi operation
+const datastoreBatchSize int = 500
+
+// Supported directory query types
+var DirectoryQueryTypes = []string{"any", "department", "email", "name", "title"}
+
+// Convert an Admin SDK user object to a directory user
+func ConvertSDKUserToDirectory(user *admin.User) *DirectoryUser {
+	var u DirectoryUser
+
+	u.Domain = getEmailDomain(user.PrimaryEmail)
+	u.Email = strings.ToLower(user.PrimaryEmail)
+	if user.Name != nil {
+		u.Name = user.Name.FullName
+	}
+	u.OrgUnit = user.OrgUnitPath
+
+	// Organizations, phones & relations are returned by the Admin SDK as untyped JSON, so
+	// round-trip them through their concrete types. Anything we can't decode is ignored.
+	var orgs []admin.UserOrganization
+	if decodeUserField(user.Organizations, &orgs) == nil {
+		for _, o := range orgs {
+			// Use the primary organization, or failing that the first one
+			if o.Primary || (u.Title == "" && u.Department == "") {
+				u.Department = o.Department
+				u.Title = o.Title
+			}
+		}
+	}
+
+	var phones []admin.UserPhone
+	if decodeUserField(user.Phones, &phones) == nil {
+		for _, p := range phones {
+			if p.Value != "" {
+				u.Phones = append(u.Phones, p.Value)
+			}
+		}
+	}
+
+	var relations []admin.UserRelation
+	if decodeUserField(user.Relations, &relations) == nil {
+		for _, r := range relations {
+			if r.Type == "manager" {
+				u.Manager = r.Value
+				break
+			}
+		}
+	}
+
+	return &u
+}
+
+// Decode an untyped Admin SDK user field into its concrete type
+func decodeUserField(v interface{}, dst interface{}) error {
+	if v == nil {
+		return errors.New("field not set")
+	}
+
+	js, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(js, dst)
+}
+
+// Get all directory users from Google Cloud Datastore
+func (mdms *GSuiteMDMService) GetDirectoryUsers() ([]DirectoryUser, error) {
+	var users []DirectoryUser
+
+	// Get a Datastore client
+	dc, err := mdms.DatastoreClient()
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("Error creating Datastore client: %s", err))
+	}
+
+	// Get all directory users
+	_, err = dc.GetAll(mdms.Ctx, datastore.NewQuery(mdms.C.DUNamekey).Order("Name"), &users)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("Error querying Datastore for directory users: %s", err))
+	}
+
+	return users, nil
+}
+
+// Refresh the directory users cached in Google Cloud Datastore using fresh Admin SDK user data
+// from all configured domains, merged with the phone numbers of each user's mobile devices.
+// Users that no longer exist (or are suspended) in a domain are removed from the cache. A domain
+// that fails doesn't stop the others being refreshed, and an error is returned for each.
+func (mdms *GSuiteMDMService) UpdateDirectory() []error {
+	var errs []error
+
+	// Get existing Datastore device data so we can merge device phone numbers. Devices already
+	// read are appended to, so start afresh
+	mdms.DatastoreData = nil
+	err := mdms.GetDatastoreData()
+	if err != nil {
+		return []error{err}
+	}
+	devicephones := make(map[string][]string)
+	for _, d := range mdms.DatastoreData {
+		if d.PhoneNumber == "" {
+			continue
+		}
+		e := strings.ToLower(d.Email)
+		devicephones[e] = append(devicephones[e], d.PhoneNumber)
+	}
+
+	// Get the currently cached users
+	cached, err := mdms.GetDirectoryUsers()
+	if err != nil {
+		return []error{err}
+	}
+
+	// Get a Datastore client
+	dc, err := mdms.DatastoreClient()
+	if err != nil {
+		return []error{errors.New(fmt.Sprintf("Error creating Datastore client: %s", err))}
+	}
+
+	now := time.Now().Format(time.RFC3339)
+
+	// Range through the slice of configured domains
+	for _, dm := range mdms.C.Domains {
+		err := mdms.updateDomainDirectory(dc, dm.DomainName, cached, devicephones, now)
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return errs
+}
+
+// Refresh the directory users of a single domain
+func (mdms *GSuiteMDMService) updateDomainDirectory(dc *datastore.Client, domain string, cached []DirectoryUser, devicephones map[string][]string, now string) error {
+	sdkusers, err := mdms.GetAdminSDKUsers(domain)
+	if err != nil {
+		return errors.New(fmt.Sprintf("Error getting Admin SDK users for %s: %s", domain, err))
+	}
+
+	// Convert the users & merge in their device phone numbers
+	var keys []*datastore.Key
+	var users []*DirectoryUser
+	seen := make(map[string]bool)
+	for _, su := range sdkusers {
+		u := ConvertSDKUserToDirectory(su)
+		u.DevicePhones = devicephones[u.Email]
+		u.Updated = now
+		keys = append(keys, datastore.NameKey(mdms.C.DUNamekey, u.Email, nil))
+		users = append(users, u)
+		seen[u.Email] = true
+	}
+
+	// Save this domain's users, in batches
+	for i := 0; i < len(keys); i += datastoreBatchSize {
+		j := i + datastoreBatchSize
+		if j > len(keys) {
+			j = len(keys)
+		}
+		_, err = dc.PutMulti(mdms.Ctx, keys[i:j], users[i:j])
+		if err != nil {
+			return errors.New(fmt.Sprintf("Error saving directory users for %s to Datastore: %s", domain, err))
+		}
+	}
+
+	// Remove this domain's users that have gone away
+	var stale []*datastore.Key
+	for _, u := range cached {
+		if u.Domain == domain && !seen[u.Email] {
+			stale = append(stale, datastore.NameKey(mdms.C.DUNamekey, u.Email, nil))
+		}
+	}
+	for i := 0; i < len(stale); i += datastoreBatchSize {
+		j := i + datastoreBatchSize
+		if j > len(stale) {
+			j = len(stale)
+		}
+		err = dc.DeleteMulti(mdms.Ctx, stale[i:j])
+		if err != nil {
+			return errors.New(fmt.Sprintf("Error removing stale directory users for %s from Datastore: %s", domain, err))
+		}
+	}
+
+	return nil
+}
+
+// Search directory users. Email searches must match exactly, all other searches match any
+// part of the field. The "any" query type searches name, email, department and title.
+func SearchDirectory(users []DirectoryUser, qtype, q string) ([]DirectoryUser, error) {
+	var results []DirectoryUser
+
+	uq := strings.ToUpper(strings.TrimSpace(q))
+	contains := func(s string) bool {
+		return strings.Contains(strings.ToUpper(s), uq)
+	}
+
+	for _, u := range users {
+		var match bool
+
+		switch qtype {
+		case "any":
+			match = contains(u.Name) || contains(u.Email) || contains(u.Department) || contains(u.Title)
+		case "department":
+			match = contains(u.Department)
+		case "email":
+			match = strings.ToUpper(u.Email) == uq
+		case "name":
+			match = contains(u.Name)
+		case "title":
+			match = contains(u.Title)
+		default:
+			return nil, errors.New(fmt.Sprintf("Invalid query type %q", qtype))
+		}
+
+		if match {
+			results = append(results, u)
+		}
+	}
+
+	return results, nil
+}
+
+// Convert a directory user to a directory entry. Device phone numbers take precedence over
+// phone numbers from the Admin SDK, and are formatted for the specified region.
+func (u *DirectoryUser) ToDirectoryData(region string) DirectoryData {
+	var p DirectoryData
+
+	p.Department = u.Department
+	p.Email = u.Email
+	p.Manager = u.Manager
+	p.Name = u.Name
+	p.OrgUnit = u.OrgUnit
+	p.Title = u.Title
+
+	for _, n := range append(append([]string{}, u.DevicePhones...), u.Phones...) {
+		p.Phones = append(p.Phones, FormatPhoneNumber(n, region))
+	}
+	if len(p.Phones) > 0 {
+		p.PhoneNumber = p.Phones[0]
+	}
+
+	return p
+}
+
+// EOF
@@ -0,0 +1,230 @@
+package gsuitemdm
+
+//
+// GSuiteMDM device event bus
+//
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Device event types
+const (
+	EventDeviceApproved    EventType = "DeviceApproved"
+	EventDeviceBlocked     EventType = "DeviceBlocked"
+	EventDeviceCompromised EventType = "DeviceCompromised"
+	EventDeviceFound       EventType = "DeviceFound"
+	EventDeviceLost        EventType = "DeviceLost"
+	EventDevicePending     EventType = "DevicePending"
+	EventDeviceStale       EventType = "DeviceStale"
+	EventDeviceWiped       EventType = "DeviceWiped"
+	EventOwnerResponded    EventType = "OwnerResponded"
+	EventSyncFailed        EventType = "SyncFailed"
+	EventUserOffboarded    EventType = "UserOffboarded"
+	EventWipeRequested     EventType = "WipeRequested"
+)
+
+// Subscribe to all events
+const EventAll EventType = "*"
+
+// Something that can be notified of events
+type Notifier interface {
+	// Name of the notifier
+	Name() string
+
+	// Deliver an event
+	Notify(e Event) error
+}
+
+// Delivers published events to subscribed notifiers
+type EventBus struct {
+	mu   sync.RWMutex
+	subs map[EventType][]Notifier
+}
+
+// Create a new, empty event bus
+func NewEventBus() *EventBus {
+	return &EventBus{subs: make(map[EventType][]Notifier)}
+}
+
+// Subscribe a notifier to an event type, or to EventAll
+func (b *EventBus) Subscribe(t EventType, n Notifier) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	// Don't subscribe the same notifier twice
+	for _, x := range b.subs[t] {
+		if x == n {
+			return
+		}
+	}
+	b.subs[t] = append(b.subs[t], n)
+}
+
+// Publish an event to all subscribed notifiers. Each notifier is sent an event at most once,
+// and a failing notifier does not stop delivery to the others.
+func (b *EventBus) Publish(e Event) []error {
+	var errs []error
+
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+
+	b.mu.RLock()
+	var ns []Notifier
+	seen := make(map[Notifier]bool)
+	for _, n := range append(append([]Notifier{}, b.subs[e.Type]...), b.subs[EventAll]...) {
+		if !seen[n] {
+			seen[n] = true
+			ns = append(ns, n)
+		}
+	}
+	b.mu.RUnlock()
+
+	for _, n := range ns {
+		err := n.Notify(e)
+		if err != nil {
+			errs = append(errs, errors.New(fmt.Sprintf("Error notifying %s of %s: %s", n.Name(), e.Type, err)))
+		}
+	}
+
+	return errs
+}
+
+// Publish an event using the service's event bus, creating it from the notification
+// configuration if necessary. Delivery errors are logged, not returned, as notifications
+// should never stop the operation that caused them.
+func (mdms *GSuiteMDMService) Publish(e Event) {
+	if mdms.Events == nil {
+		bus, err := mdms.NewConfiguredEventBus()
+		if err != nil {
+			log.Printf("Error setting up notifications: %s", err)
+			return
+		}
+		mdms.Events = bus
+	}
+
+	if e.Domain == "" && e.Device != nil {
+		e.Domain = e.Device.Domain
+	}
+
+	for _, err := range mdms.Events.Publish(e) {
+		log.Printf("%s", err)
+	}
+}
+
+// Create an event bus with the configured notifiers subscribed according to the routing rules
+func (mdms *GSuiteMDMService) NewConfiguredEventBus() (*EventBus, error) {
+	bus := NewEventBus()
+
+	// Create the notifiers
+	notifiers := make(map[string]Notifier)
+	for _, nc := range mdms.C.Notifications.Notifiers {
+		var secret string
+		if nc.SecretID != "" {
+			s, err := mdms.GetSecret(nc.SecretID)
+			if err != nil {
+				return nil, err
+			}
+			secret = strings.TrimSpace(s)
+		}
+
+		n, err := NewNotifier(nc, secret)
+		if err != nil {
+			return nil, err
+		}
+		notifiers[nc.Name] = n
+	}
+
+	// Subscribe them
+	for _, r := range mdms.C.Notifications.Routes {
+		for _, name := range r.Notifiers {
+			n, ok := notifiers[name]
+			if !ok {
+				return nil, errors.New(fmt.Sprintf("Notification route refers to unknown notifier %q", name))
+			}
+			for _, t := range r.Events {
+				bus.Subscribe(EventType(t), n)
+			}
+		}
+	}
+
+	return bus, nil
+}
+
+// Publish the event for a successful Admin SDK action on a device
+func (mdms *GSuiteMDMService) PublishAction(device *DatastoreMobileDevice, action string) {
+	var t EventType
+
+	switch action {
+	case ActionApprove, ActionCancelWipeThenActivate:
+		t = EventDeviceApproved
+	case ActionBlock, ActionCancelWipeThenBlock:
+		t = EventDeviceBlocked
+	case ActionAccountWipe, ActionRemoteWipe:
+		t = EventDeviceWiped
+	default:
+		return
+	}
+
+	mdms.Publish(Event{Device: device, Type: t,
+		Message: fmt.Sprintf("Device %s (%s) owned by %s: %s", device.SN, device.Model, device.Email, action)})
+}
+
+// Work out which events a sync of a device should publish, by comparing the new device data
+// from the Admin SDK with the existing device data. ed.SN is empty for brand new devices.
+func (mdms *GSuiteMDMService) deviceSyncEvents(nd, ed *DatastoreMobileDevice, now time.Time) []Event {
+	var events []Event
+
+	// New device waiting for approval, or an existing device that has gone back to pending
+	if nd.Status == "PENDING" && (ed.SN == "" || ed.Status != "PENDING") {
+		events = append(events, Event{Device: nd, Type: EventDevicePending,
+			Message: fmt.Sprintf("Device %s (%s) owned by %s is pending approval", nd.SN, nd.Model, nd.Email)})
+	}
+
+	// Device newly reporting as compromised
+	if isCompromised(nd.CompromisedStatus) && !isCompromised(ed.CompromisedStatus) {
+		events = append(events, Event{Device: nd, Type: EventDeviceCompromised,
+			Message: fmt.Sprintf("Device %s (%s) owned by %s reports as compromised", nd.SN, nd.Model, nd.Email)})
+	}
+
+	// Device that has stopped syncing. Only reported once, until it syncs again
+	nd.Stale = false
+	if days := mdms.C.Notifications.StaleDays; days > 0 && nd.SyncLast != "" {
+		ls, err := time.Parse(time.RFC3339, nd.SyncLast)
+		if err == nil && now.Sub(ls) > time.Duration(days)*24*time.Hour {
+			nd.Stale = true
+			if !ed.Stale {
+				events = append(events, Event{Device: nd, Type: EventDeviceStale,
+					Message: fmt.Sprintf("Device %s (%s) owned by %s has not synced since %s", nd.SN, nd.Model, nd.Email, nd.SyncLast)})
+			}
+		}
+	}
+
+	return events
+}
+
+// Does an Admin SDK compromised status ("Compromise detected", "No compromise detected") say the
+// device is compromised?
+func isCompromised(status string) bool {
+	return strings.HasPrefix(strings.ToLower(status), "compromise")
+}
+
+// Format an event for display
+func (e Event) Text() string {
+	if e.Message != "" {
+		return fmt.Sprintf("[%s] %s", e.Type, e.Message)
+	}
+	if e.Device != nil {
+		return fmt.Sprintf("[%s] Device %s owned by %s", e.Type, e.Device.SN, e.Device.Email)
+	}
+
+	return fmt.Sprintf("[%s] %s", e.Type, e.Domain)
+}
+
+// EOF
@@ -0,0 +1,414 @@
+package gsuitemdm
+
+//
+// GSuiteMDM device inventory export & import
+//
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/xuri/excelize/v2"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Supported export/import formats
+const (
+	FormatCSV  string = "csv"
+	FormatJSON string = "json"
+	FormatXLSX string = "xlsx"
+)
+
+// Name of the worksheet used for XLSX exports
+const xlsxSheetName string = "Devices"
+
+// A single exportable device field. Set is only non-nil for fields that are owned by
+// the Google Sheet (i.e. not reported by the Admin SDK), which are the only fields import
+// is allowed to change.
+type deviceField struct {
+	Name string
+	Get  func(d *DatastoreMobileDevice) string
+	Set  func(d *DatastoreMobileDevice, v string)
+}
+
+// All exportable device fields, in column order
+var deviceFields = []deviceField{
+	{"Domain", func(d *DatastoreMobileDevice) string { return d.Domain }, nil},
+	{"Name", func(d *DatastoreMobileDevice) string { return d.Name }, nil},
+	{"Email", func(d *DatastoreMobileDevice) string { return d.Email }, nil},
+	{"SN", func(d *DatastoreMobileDevice) string { return d.SN }, nil},
+	{"IMEI", func(d *DatastoreMobileDevice) string { return d.IMEI }, nil},
+	{"PhoneNumber",
+		func(d *DatastoreMobileDevice) string { return d.PhoneNumber },
+		func(d *DatastoreMobileDevice, v string) { d.PhoneNumber = strings.Replace(v, " ", "", -1) }},
+	{"Color",
+		func(d *DatastoreMobileDevice) string { return d.Color },
+		func(d *DatastoreMobileDevice, v string) { d.Color = v }},
+	{"RAM",
+		func(d *DatastoreMobileDevice) string { return d.RAM },
+		func(d *DatastoreMobileDevice, v string) { d.RAM = v }},
+	{"Model", func(d *DatastoreMobileDevice) string { return d.Model }, nil},
+	{"OS", func(d *DatastoreMobileDevice) string { return d.OS }, nil},
+	{"OSBuild", func(d *DatastoreMobileDevice) string { return d.OSBuild }, nil},
+	{"Status", func(d *DatastoreMobileDevice) string { return d.Status }, nil},
+	{"Type", func(d *DatastoreMobileDevice) string { return d.Type }, nil},
+	{"SyncFirst", func(d *DatastoreMobileDevice) string { return d.SyncFirst }, nil},
+	{"SyncLast", func(d *DatastoreMobileDevice) string { return d.SyncLast }, nil},
+	{"WifiMac", func(d *DatastoreMobileDevice) string { return d.WifiMac }, nil},
+	{"CompromisedStatus", func(d *DatastoreMobileDevice) string { return d.CompromisedStatus }, nil},
+	{"EncryptionStatus", func(d *DatastoreMobileDevice) string { return d.EncryptionStatus }, nil},
+	{"PasswordStatus", func(d *DatastoreMobileDevice) string { return d.PasswordStatus }, nil},
+	{"DeveloperMode", func(d *DatastoreMobileDevice) string { return strconv.FormatBool(d.DeveloperMode) }, nil},
+	{"UnknownSources", func(d *DatastoreMobileDevice) string { return strconv.FormatBool(d.UnknownSources) }, nil},
+	{"USBADB", func(d *DatastoreMobileDevice) string { return strconv.FormatBool(d.USBADB) }, nil},
+	{"ResourceId", func(d *DatastoreMobileDevice) string { return d.ResourceId }, nil},
+	{"Notes",
+		func(d *DatastoreMobileDevice) string { return d.Notes },
+		func(d *DatastoreMobileDevice, v string) { d.Notes = v }},
+	{"AssetTag", func(d *DatastoreMobileDevice) string { return d.AssetTag }, nil},
+	{"Vendor", func(d *DatastoreMobileDevice) string { return d.Vendor }, nil},
+	{"PurchaseDate", func(d *DatastoreMobileDevice) string { return d.PurchaseDate }, nil},
+	{"Cost", func(d *DatastoreMobileDevice) string { return formatCost(d.Cost) }, nil},
+	{"WarrantyExpiry", func(d *DatastoreMobileDevice) string { return d.WarrantyExpiry }, nil},
+	{"Carrier", func(d *DatastoreMobileDevice) string { return d.Carrier }, nil},
+	{"Plan", func(d *DatastoreMobileDevice) string { return d.Plan }, nil},
+	{"Ownership", func(d *DatastoreMobileDevice) string { return d.Ownership }, nil},
+	{"Owners", func(d *DatastoreMobileDevice) string { return formatOwnerHistory(d.OwnerHistory) }, nil},
+}
+
+// A single field change made (or to be made) by an import
+type DeviceChange struct {
+	SN    string `json:"sn"`
+	Field string `json:"field"`
+	Old   string `json:"old"`
+	New   string `json:"new"`
+}
+
+// A device read from import data, with the names of the sheet-owned fields the data actually
+// supplied for it. Only those fields are compared & changed, so a missing column or a short row
+// never clears a field
+type ImportedDevice struct {
+	DatastoreMobileDevice
+	Fields []string // Names of the sheet-owned fields supplied, e.g. "Color", "Notes"
+}
+
+// Does the imported data supply a field?
+func (d *ImportedDevice) HasField(name string) bool {
+	for _, f := range d.Fields {
+		if strings.EqualFold(f, name) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Export devices to a writer in the specified format
+func ExportDevices(w io.Writer, format string, devices []DatastoreMobileDevice) error {
+	switch strings.ToLower(format) {
+	// CSV
+	case FormatCSV:
+		cw := csv.NewWriter(w)
+		cw.Write(deviceFieldNames())
+		for k := range devices {
+			cw.Write(deviceFieldValues(&devices[k]))
+		}
+		cw.Flush()
+		return cw.Error()
+
+	// JSON
+	case FormatJSON:
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "   ")
+		return enc.Encode(devices)
+
+	// XLSX
+	case FormatXLSX:
+		f := excelize.NewFile()
+		defer f.Close()
+		f.SetSheetName(f.GetSheetName(0), xlsxSheetName)
+
+		// Header row, then one row per device
+		rows := [][]string{deviceFieldNames()}
+		for k := range devices {
+			rows = append(rows, deviceFieldValues(&devices[k]))
+		}
+		for kr, r := range rows {
+			cell, err := excelize.CoordinatesToCellName(1, kr+1)
+			if err != nil {
+				return err
+			}
+			vals := make([]interface{}, len(r))
+			for kc, v := range r {
+				vals[kc] = v
+			}
+			err = f.SetSheetRow(xlsxSheetName, cell, &vals)
+			if err != nil {
+				return err
+			}
+		}
+		return f.Write(w)
+	}
+
+	return errors.New(fmt.Sprintf("Unsupported export format %q", format))
+}
+
+// Import devices from a reader in the specified format. Only SN and the sheet-owned fields
+// (PhoneNumber, Color, RAM, Notes) are read, all other fields are ignored.
+func ImportDevices(r io.Reader, format string) ([]ImportedDevice, error) {
+	var rows [][]string
+	var err error
+
+	switch strings.ToLower(format) {
+	// CSV
+	case FormatCSV:
+		cr := csv.NewReader(r)
+		cr.FieldsPerRecord = -1
+		rows, err = cr.ReadAll()
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("Error reading CSV: %s", err))
+		}
+
+	// JSON
+	case FormatJSON:
+		var objs []map[string]json.RawMessage
+		err = json.NewDecoder(r).Decode(&objs)
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("Error decoding JSON: %s", err))
+		}
+		// Keep only SN and the sheet-owned fields each object supplies
+		var imported []ImportedDevice
+		for _, o := range objs {
+			var d ImportedDevice
+			for k, v := range o {
+				// Like encoding/json, field names are matched case-insensitively
+				var sv string
+				switch {
+				case strings.EqualFold(k, "SN"):
+					err = json.Unmarshal(v, &sv)
+					d.SN = strings.Replace(sv, " ", "", -1)
+				default:
+					df, ok := importableField(k)
+					if !ok {
+						continue
+					}
+					err = json.Unmarshal(v, &sv)
+					df.Set(&d.DatastoreMobileDevice, sv)
+					d.Fields = append(d.Fields, df.Name)
+				}
+				if err != nil {
+					return nil, errors.New(fmt.Sprintf("Error decoding JSON field %s: %s", k, err))
+				}
+			}
+			imported = append(imported, d)
+		}
+		return imported, nil
+
+	// XLSX
+	case FormatXLSX:
+		f, err := excelize.OpenReader(r)
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("Error reading XLSX: %s", err))
+		}
+		defer f.Close()
+		sheets := f.GetSheetList()
+		if len(sheets) < 1 {
+			return nil, errors.New("XLSX file contains no worksheets")
+		}
+		rows, err = f.GetRows(sheets[0])
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("Error reading XLSX: %s", err))
+		}
+
+	default:
+		return nil, errors.New(fmt.Sprintf("Unsupported import format %q", format))
+	}
+
+	return importDeviceRows(rows)
+}
+
+// Convert tabular rows (with a header row) into devices
+func importDeviceRows(rows [][]string) ([]ImportedDevice, error) {
+	var devices []ImportedDevice
+
+	if len(rows) < 1 {
+		return devices, nil
+	}
+
+	// Map the header row to column indexes
+	cols := make(map[string]int)
+	for k, h := range rows[0] {
+		cols[strings.ToLower(strings.TrimSpace(h))] = k
+	}
+	snc, ok := cols["sn"]
+	if !ok {
+		return nil, errors.New("Import data has no SN column")
+	}
+
+	// Range through the data rows
+	for kr, r := range rows[1:] {
+		if snc >= len(r) || strings.TrimSpace(r[snc]) == "" {
+			// Skip rows with no SN, but only if they're completely empty
+			if strings.TrimSpace(strings.Join(r, "")) == "" {
+				continue
+			}
+			return nil, errors.New(fmt.Sprintf("Import data row %d has no SN", kr+2))
+		}
+
+		var d ImportedDevice
+		d.SN = strings.Replace(r[snc], " ", "", -1)
+
+		// Only the sheet-owned fields are imported, and only from cells the row actually has
+		for _, df := range deviceFields {
+			if df.Set == nil {
+				continue
+			}
+			if c, ok := cols[strings.ToLower(df.Name)]; ok && c < len(r) {
+				df.Set(&d.DatastoreMobileDevice, r[c])
+				d.Fields = append(d.Fields, df.Name)
+			}
+		}
+
+		devices = append(devices, d)
+	}
+
+	return devices, nil
+}
+
+// Compare imported devices against existing devices and return the list of changes an
+// import would make. Only the fields each imported device supplies are compared. Devices that
+// don't exist are reported with an error.
+func DiffImportedDevices(existing []DatastoreMobileDevice, imported []ImportedDevice) ([]DeviceChange, error) {
+	var changes []DeviceChange
+	var missing []string
+
+	// Index existing devices by SN
+	idx := make(map[string]*DatastoreMobileDevice)
+	for k := range existing {
+		idx[strings.Replace(existing[k].SN, " ", "", -1)] = &existing[k]
+	}
+
+	for k := range imported {
+		ed, ok := idx[imported[k].SN]
+		if !ok {
+			missing = append(missing, imported[k].SN)
+			continue
+		}
+
+		for _, df := range deviceFields {
+			if df.Set == nil || !imported[k].HasField(df.Name) {
+				continue
+			}
+			if ov, nv := df.Get(ed), df.Get(&imported[k].DatastoreMobileDevice); ov != nv {
+				changes = append(changes, DeviceChange{
+					SN:    imported[k].SN,
+					Field: df.Name,
+					Old:   ov,
+					New:   nv,
+				})
+			}
+		}
+	}
+
+	if len(missing) > 0 {
+		return changes, errors.New(fmt.Sprintf("Unknown device(s) in import data: %s", strings.Join(missing, ", ")))
+	}
+
+	return changes, nil
+}
+
+// Import sheet-owned fields for a set of devices. The fields are written to the Google Sheet as
+// well as Datastore, since the sheet is where the next sync takes them from. If dryrun is true,
+// no changes are saved and the list of changes that would be made is returned.
+func (mdms *GSuiteMDMService) ImportDevices(imported []ImportedDevice, dryrun bool) ([]DeviceChange, error) {
+	// Get existing Datastore data
+	err := mdms.GetDatastoreData()
+	if err != nil {
+		return nil, err
+	}
+
+	// Work out what is changing
+	changes, err := DiffImportedDevices(mdms.DatastoreData, imported)
+	if err != nil || dryrun {
+		return changes, err
+	}
+
+	// Apply the supplied fields to each changed device
+	changed := make(map[string]bool)
+	for _, c := range changes {
+		changed[c.SN] = true
+	}
+	var devices []*DatastoreMobileDevice
+	var sheet []DatastoreMobileDevice
+	for k := range imported {
+		if !changed[imported[k].SN] {
+			continue
+		}
+
+		d, err := mdms.GetDatastoreDevice(imported[k].SN)
+		if err != nil {
+			return changes, err
+		}
+		for _, df := range deviceFields {
+			if df.Set != nil && imported[k].HasField(df.Name) {
+				df.Set(d, df.Get(&imported[k].DatastoreMobileDevice))
+			}
+		}
+		devices = append(devices, d)
+		sheet = append(sheet, *d)
+	}
+
+	// Write through to the sheet first: if a Datastore write then fails, the next sync still
+	// picks up the imported values from the sheet
+	err = mdms.UpdateSheetDevices(sheet)
+	if err != nil {
+		return changes, err
+	}
+
+	for _, d := range devices {
+		err = mdms.PutDatastoreDevice(d)
+		if err != nil {
+			return changes, err
+		}
+	}
+
+	return changes, nil
+}
+
+// Find an importable (sheet-owned) device field by name, ignoring case
+func importableField(name string) (deviceField, bool) {
+	for _, df := range deviceFields {
+		if df.Set != nil && strings.EqualFold(df.Name, name) {
+			return df, true
+		}
+	}
+
+	return deviceField{}, false
+}
+
+// Get the names of all exportable device fields
+func deviceFieldNames() []string {
+	var names []string
+
+	for _, df := range deviceFields {
+		names = append(names, df.Name)
+	}
+
+	return names
+}
+
+// Get the values of all exportable fields for a device
+func deviceFieldValues(d *DatastoreMobileDevice) []string {
+	var vals []string
+
+	for _, df := range deviceFields {
+		vals = append(vals, df.Get(d))
+	}
+
+	return vals
+}
+
+// EOF
@@ -0,0 +1,177 @@
+package gsuitemdm
+
+//
+// GSuiteMDM import/export tests
+//
+
+import (
+	"reflect"
+	"testing"
+)
+
+// Only the columns a row actually has are imported
+func TestImportDeviceRows(t *testing.T) {
+	tests := []struct {
+		name    string
+		rows    [][]string
+		want    []ImportedDevice
+		wanterr bool
+	}{
+		{
+			name: "all columns",
+			rows: [][]string{
+				{"SN", "PhoneNumber", "Color", "RAM", "Notes"},
+				{"AB 123", "+1 310 555 1234", "Black", "4GB", "Spare"},
+			},
+			want: []ImportedDevice{{
+				DatastoreMobileDevice: DatastoreMobileDevice{SN: "AB123", PhoneNumber: "+13105551234", Color: "Black", RAM: "4GB", Notes: "Spare"},
+				Fields:                []string{"PhoneNumber", "Color", "RAM", "Notes"},
+			}},
+		},
+		{
+			name: "missing columns",
+			rows: [][]string{
+				{"sn", "color"},
+				{"AB123", "White"},
+			},
+			want: []ImportedDevice{{
+				DatastoreMobileDevice: DatastoreMobileDevice{SN: "AB123", Color: "White"},
+				Fields:                []string{"Color"},
+			}},
+		},
+		{
+			name: "short row",
+			rows: [][]string{
+				{"SN", "Color", "Notes"},
+				{"AB123", "White"},
+			},
+			want: []ImportedDevice{{
+				DatastoreMobileDevice: DatastoreMobileDevice{SN: "AB123", Color: "White"},
+				Fields:                []string{"Color"},
+			}},
+		},
+		{
+			name: "empty cell is supplied",
+			rows: [][]string{
+				{"SN", "Notes"},
+				{"AB123", ""},
+			},
+			want: []ImportedDevice{{
+				DatastoreMobileDevice: DatastoreMobileDevice{SN: "AB123"},
+				Fields:                []string{"Notes"},
+			}},
+		},
+		{
+			name: "admin sdk columns ignored",
+			rows: [][]string{
+				{"SN", "Model", "Status"},
+				{"AB123", "Pixel", "WIPED"},
+			},
+			want: []ImportedDevice{{
+				DatastoreMobileDevice: DatastoreMobileDevice{SN: "AB123"},
+			}},
+		},
+		{
+			name: "empty rows skipped",
+			rows: [][]string{
+				{"SN", "Color"},
+				{"", ""},
+				{},
+			},
+		},
+		{
+			name:    "no sn column",
+			rows:    [][]string{{"Color"}, {"Black"}},
+			wanterr: true,
+		},
+		{
+			name: "row with no sn",
+			rows: [][]string{
+				{"SN", "Color"},
+				{"", "Black"},
+			},
+			wanterr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := importDeviceRows(tt.rows)
+			if (err != nil) != tt.wanterr {
+				t.Fatalf("importDeviceRows() error = %v, wanterr %v", err, tt.wanterr)
+			}
+			if tt.wanterr {
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("importDeviceRows() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+// Only the fields an imported device supplies are compared
+func TestDiffImportedDevices(t *testing.T) {
+	existing := []DatastoreMobileDevice{
+		{SN: "AB123", PhoneNumber: "+13105551234", Color: "Black", RAM: "4GB", Notes: "Spare"},
+	}
+
+	tests := []struct {
+		name     string
+		imported []ImportedDevice
+		want     []DeviceChange
+		wanterr  bool
+	}{
+		{
+			name: "missing columns leave fields alone",
+			imported: []ImportedDevice{{
+				DatastoreMobileDevice: DatastoreMobileDevice{SN: "AB123", Color: "White"},
+				Fields:                []string{"Color"},
+			}},
+			want: []DeviceChange{{SN: "AB123", Field: "Color", Old: "Black", New: "White"}},
+		},
+		{
+			name: "supplied empty field is cleared",
+			imported: []ImportedDevice{{
+				DatastoreMobileDevice: DatastoreMobileDevice{SN: "AB123"},
+				Fields:                []string{"Notes"},
+			}},
+			want: []DeviceChange{{SN: "AB123", Field: "Notes", Old: "Spare", New: ""}},
+		},
+		{
+			name: "no change",
+			imported: []ImportedDevice{{
+				DatastoreMobileDevice: DatastoreMobileDevice{SN: "AB123", RAM: "4GB"},
+				Fields:                []string{"RAM"},
+			}},
+		},
+		{
+			name: "no fields supplied",
+			imported: []ImportedDevice{{
+				DatastoreMobileDevice: DatastoreMobileDevice{SN: "AB123"},
+			}},
+		},
+		{
+			name: "unknown device",
+			imported: []ImportedDevice{{
+				DatastoreMobileDevice: DatastoreMobileDevice{SN: "ZZ999", Color: "White"},
+				Fields:                []string{"Color"},
+			}},
+			wanterr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := DiffImportedDevices(existing, tt.imported)
+			if (err != nil) != tt.wanterr {
+				t.Fatalf("DiffImportedDevices() error = %v, wanterr %v", err, tt.wanterr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("DiffImportedDevices() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+// EOF
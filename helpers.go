@@ -6,7 +6,6 @@ package gsuitemdm
 
 import (
 	"bufio"
-	"encoding/json"
 	"fmt"
 	"log"
 	"net/http"
@@ -77,16 +76,6 @@ func GetIP(r *http.Request) string {
 	return r.RemoteAddr
 }
 
-// Load configuration and return a config struct
-func loadConfig(config string) (GSuiteMDMConfig, error) {
-	var c GSuiteMDMConfig
-
-	jp := json.NewDecoder(strings.NewReader(config))
-	jp.Decode(&c)
-
-	return c, nil
-}
-
 // Helper func to track how long a func takes to execute (found on StackExchange I think!)
 func TimeTrack(start time.Time) {
 	elapsed := time.Since(start)
@@ -0,0 +1,725 @@
+package gsuitemdm
+
+//
+// GSuiteMDM long-running jobs (sync, sheet export, bulk actions)
+//
+
+import (
+	"bytes"
+	"cloud.google.com/go/datastore"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Job states
+const (
+	JobStateCancelled string = "cancelled"
+	JobStateFailed    string = "failed"
+	JobStateQueued    string = "queued"
+	JobStateRunning   string = "running"
+	JobStateSucceeded string = "succeeded"
+)
+
+// Job progress statuses
+const (
+	JobProgressDone    string = "done"
+	JobProgressFailed  string = "failed"
+	JobProgressRunning string = "running"
+)
+
+// Job types
+const (
+	JobTypeAction string = "action"
+	JobTypeSheet  string = "sheet"
+	JobTypeSync   string = "sync"
+)
+
+// Default Datastore namekey for jobs
+const DefaultJobNamekey string = "Job"
+
+// Default seconds a running job may go without a heartbeat before it is marked failed
+const DefaultJobLeaseSeconds int = 120
+
+// Returned by JobContext.Progress once cancellation of the job has been requested
+var ErrJobCancelled = errors.New("Job cancelled")
+
+// Returned by RunJob when a job isn't queued, e.g. because another worker has already claimed it
+var ErrJobNotQueued = errors.New("Job is not queued")
+
+// Runs a type of job, reporting progress through the job context
+type JobRunner func(mdms *GSuiteMDMService, jc *JobContext) error
+
+// A registered type of job
+type jobType struct {
+	role   string
+	run    JobRunner
+	verify func(mdms *GSuiteMDMService, p *JobParams, role string) error
+}
+
+// Registered job types, by name
+var jobTypes = map[string]*jobType{}
+
+// Runs jobs once they have been submitted
+type JobWorker interface {
+	// Start running a submitted job
+	Start(mdms *GSuiteMDMService, job *Job) error
+}
+
+// Runs jobs in goroutines of the current process
+type LocalJobWorker struct {
+	wg sync.WaitGroup
+}
+
+// Context of a running job, used to report progress & check for cancellation
+type JobContext struct {
+	Job  *Job
+	mdms *GSuiteMDMService
+}
+
+// Register the built in job types
+func init() {
+	RegisterJobType(JobTypeAction, RoleOperator, runActionJob, verifyActionJob)
+	RegisterJobType(JobTypeSheet, RoleAdmin, runSheetJob, nil)
+	RegisterJobType(JobTypeSync, RoleAdmin, runSyncJob, verifySyncJob)
+}
+
+// Register a type of job. The role is required of submitters, and verify (optional) checks the
+// job's parameters when it is submitted
+func RegisterJobType(name, role string, run JobRunner, verify func(mdms *GSuiteMDMService, p *JobParams, role string) error) {
+	jobTypes[name] = &jobType{role: role, run: run, verify: verify}
+}
+
+// Get a new local job worker
+func NewLocalJobWorker() *LocalJobWorker {
+	return &LocalJobWorker{}
+}
+
+// Start running a job in a goroutine
+func (w *LocalJobWorker) Start(mdms *GSuiteMDMService, job *Job) error {
+	w.wg.Add(1)
+	go func() {
+		defer w.wg.Done()
+		err := mdms.RunJob(job)
+		if err != nil {
+			log.Printf("Error running job %s: %s", job.ID, err)
+		}
+	}()
+
+	return nil
+}
+
+// Wait for all started jobs to finish
+func (w *LocalJobWorker) Wait() {
+	w.wg.Wait()
+}
+
+// Get the configured Datastore namekey for jobs
+func (mdms *GSuiteMDMService) jobNamekey() string {
+	if mdms.C.JBNamekey == "" {
+		return DefaultJobNamekey
+	}
+
+	return mdms.C.JBNamekey
+}
+
+// Get the configured lease of running jobs
+func (mdms *GSuiteMDMService) jobLease() time.Duration {
+	if mdms.C.Jobs.LeaseSeconds <= 0 {
+		return time.Duration(DefaultJobLeaseSeconds) * time.Second
+	}
+
+	return time.Duration(mdms.C.Jobs.LeaseSeconds) * time.Second
+}
+
+// Create a new queued job and store it
+func (mdms *GSuiteMDMService) NewJob(jtype string, params JobParams, by, role string) (*Job, error) {
+	// Do we know this type of job, and can the submitter run it?
+	jt, ok := jobTypes[jtype]
+	if !ok {
+		return nil, &ActionError{Code: 400, Message: fmt.Sprintf("Invalid request (unknown job type %q)", jtype)}
+	}
+	if !RoleAllows(role, jt.role) {
+		return nil, &ActionError{Code: 403, Message: fmt.Sprintf("Not authorized (%s jobs require role %s)", jtype, jt.role)}
+	}
+	if jt.verify != nil {
+		err := jt.verify(mdms, &params, role)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Get a unique-enough job ID
+	id := make([]byte, 4)
+	_, err := rand.Read(id)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now().Format(time.RFC3339)
+
+	job := &Job{
+		By:      by,
+		Created: now,
+		ID:      time.Now().UTC().Format("20060102T150405") + "-" + hex.EncodeToString(id),
+		Params:  params,
+		Role:    role,
+		State:   JobStateQueued,
+		Type:    jtype,
+		Updated: now,
+	}
+
+	err = mdms.PutJob(job)
+	if err != nil {
+		return nil, err
+	}
+
+	return job, nil
+}
+
+// Create a new job and hand it to the job worker, if there is one
+func (mdms *GSuiteMDMService) SubmitJob(jtype string, params JobParams, by, role string) (*Job, error) {
+	job, err := mdms.NewJob(jtype, params, by, role)
+	if err != nil {
+		return nil, err
+	}
+
+	if mdms.Jobs != nil {
+		err = mdms.Jobs.Start(mdms, job)
+		if err != nil {
+			return job, err
+		}
+	}
+
+	return job, nil
+}
+
+// Run a queued job to completion. The job is claimed in a transaction first, so it is only ever
+// run once however many workers try to run it. Returns ErrJobNotQueued if it can't be claimed
+func (mdms *GSuiteMDMService) RunJob(job *Job) error {
+	jt, ok := jobTypes[job.Type]
+	if !ok {
+		return errors.New(fmt.Sprintf("Unknown job type %q", job.Type))
+	}
+
+	// Running
+	claimed, err := mdms.claimJob(job.ID)
+	if err != nil {
+		return err
+	}
+	*job = *claimed
+
+	// Keep the job's lease while it runs
+	stop := make(chan struct{})
+	go mdms.heartbeatJob(job.ID, stop)
+
+	// Run it, unless it was cancelled while queued
+	if job.Cancel {
+		err = ErrJobCancelled
+	} else {
+		err = jt.run(mdms, &JobContext{Job: job, mdms: mdms})
+	}
+	close(stop)
+
+	// Finished
+	switch {
+	case err == ErrJobCancelled:
+		job.State = JobStateCancelled
+	case err != nil:
+		job.Error = err.Error()
+		job.State = JobStateFailed
+	default:
+		job.State = JobStateSucceeded
+	}
+	job.Finished = time.Now().Format(time.RFC3339)
+
+	return mdms.PutJob(job)
+}
+
+// Run a queued job by ID
+func (mdms *GSuiteMDMService) RunJobID(id string) error {
+	job, err := mdms.GetJob(id)
+	if err != nil {
+		return err
+	}
+
+	return mdms.RunJob(job)
+}
+
+// Run all queued jobs, oldest first. Jobs claimed by another worker meanwhile are skipped
+func (mdms *GSuiteMDMService) RunQueuedJobs() []error {
+	var errs []error
+
+	jobs, err := mdms.ListJobs(JobStateQueued, 0)
+	if err != nil {
+		return []error{err}
+	}
+	sort.Slice(jobs, func(i, j int) bool { return jobs[i].Created < jobs[j].Created })
+
+	for i := range jobs {
+		err = mdms.RunJob(&jobs[i])
+		if err != nil && err != ErrJobNotQueued {
+			errs = append(errs, errors.New(fmt.Sprintf("Error running job %s: %s", jobs[i].ID, err)))
+		}
+	}
+
+	return errs
+}
+
+// Claim a queued job for running, marking it running in the same transaction that checks it
+// is still queued
+func (mdms *GSuiteMDMService) claimJob(id string) (*Job, error) {
+	var job Job
+
+	// Get a Datastore client
+	dc, err := mdms.DatastoreClient()
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("Error creating Datastore client: %s", err))
+	}
+
+	key := datastore.NameKey(mdms.jobNamekey(), id, nil)
+	_, err = dc.RunInTransaction(mdms.Ctx, func(tx *datastore.Transaction) error {
+		err := tx.Get(key, &job)
+		if err != nil {
+			return err
+		}
+		if job.State != JobStateQueued {
+			return ErrJobNotQueued
+		}
+
+		now := time.Now().Format(time.RFC3339)
+		job.Started = now
+		job.State = JobStateRunning
+		job.Updated = now
+		_, err = tx.Put(key, &job)
+		return err
+	})
+	if err == ErrJobNotQueued {
+		return nil, err
+	}
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("Error claiming job %s: %s", id, err))
+	}
+
+	return &job, nil
+}
+
+// Send a running job's heartbeat every quarter of its lease, until stop is closed
+func (mdms *GSuiteMDMService) heartbeatJob(id string, stop chan struct{}) {
+	t := time.NewTicker(mdms.jobLease() / 4)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-t.C:
+			err := mdms.touchJob(id)
+			if err != nil {
+				log.Printf("Error sending heartbeat of job %s: %s", id, err)
+			}
+		}
+	}
+}
+
+// Record that a running job is still alive. Only the stored job's Updated time is changed, so the
+// heartbeat never overwrites the job's own updates
+func (mdms *GSuiteMDMService) touchJob(id string) error {
+	// Get a Datastore client
+	dc, err := mdms.DatastoreClient()
+	if err != nil {
+		return errors.New(fmt.Sprintf("Error creating Datastore client: %s", err))
+	}
+
+	key := datastore.NameKey(mdms.jobNamekey(), id, nil)
+	_, err = dc.RunInTransaction(mdms.Ctx, func(tx *datastore.Transaction) error {
+		var stored Job
+		err := tx.Get(key, &stored)
+		if err != nil || stored.State != JobStateRunning {
+			return err
+		}
+		stored.Updated = time.Now().Format(time.RFC3339)
+		_, err = tx.Put(key, &stored)
+		return err
+	})
+
+	return err
+}
+
+// Mark running jobs that have gone longer than their lease without a heartbeat (e.g. because
+// the cloud function running them timed out) as failed. Returns the jobs that were marked
+func (mdms *GSuiteMDMService) FailStaleJobs() ([]Job, error) {
+	var failed []Job
+
+	jobs, err := mdms.ListJobs(JobStateRunning, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	// Get a Datastore client
+	dc, err := mdms.DatastoreClient()
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("Error creating Datastore client: %s", err))
+	}
+
+	errNotStale := errors.New("Job is not stale")
+	lease := mdms.jobLease()
+	for i := range jobs {
+		if !jobStale(&jobs[i], lease, time.Now()) {
+			continue
+		}
+
+		// Check again in a transaction, the job may have sent a heartbeat since it was listed
+		var stored Job
+		key := datastore.NameKey(mdms.jobNamekey(), jobs[i].ID, nil)
+		_, err = dc.RunInTransaction(mdms.Ctx, func(tx *datastore.Transaction) error {
+			err := tx.Get(key, &stored)
+			if err != nil {
+				return err
+			}
+			if stored.State != JobStateRunning || !jobStale(&stored, lease, time.Now()) {
+				return errNotStale
+			}
+
+			now := time.Now().Format(time.RFC3339)
+			stored.Error = fmt.Sprintf("Job stopped without finishing (no heartbeat since %s)", stored.Updated)
+			stored.Finished = now
+			stored.State = JobStateFailed
+			stored.Updated = now
+			_, err = tx.Put(key, &stored)
+			return err
+		})
+		if err == errNotStale {
+			continue
+		}
+		if err != nil {
+			return failed, errors.New(fmt.Sprintf("Error failing stale job %s: %s", jobs[i].ID, err))
+		}
+		failed = append(failed, stored)
+	}
+
+	return failed, nil
+}
+
+// Has a running job gone longer than its lease without an update?
+func jobStale(job *Job, lease time.Duration, now time.Time) bool {
+	updated, err := time.Parse(time.RFC3339, job.Updated)
+	if err != nil {
+		return false
+	}
+
+	return now.Sub(updated) > lease
+}
+
+// Get a job from Google Cloud Datastore
+func (mdms *GSuiteMDMService) GetJob(id string) (*Job, error) {
+	var job Job
+
+	// Get a Datastore client
+	dc, err := mdms.DatastoreClient()
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("Error creating Datastore client: %s", err))
+	}
+
+	err = dc.Get(mdms.Ctx, datastore.NameKey(mdms.jobNamekey(), id, nil), &job)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("Job %s not found", id))
+	}
+
+	return &job, nil
+}
+
+// List the most recent jobs, optionally in a particular state. A limit < 1 lists all jobs
+func (mdms *GSuiteMDMService) ListJobs(state string, limit int) ([]Job, error) {
+	var jobs []Job
+
+	// Get a Datastore client
+	dc, err := mdms.DatastoreClient()
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("Error creating Datastore client: %s", err))
+	}
+
+	// Filtering by state & ordering by creation would need a composite index, so sort here
+	q := datastore.NewQuery(mdms.jobNamekey())
+	if state != "" {
+		q = q.Filter("State =", state)
+	}
+	_, err = dc.GetAll(mdms.Ctx, q, &jobs)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("Error querying Datastore for jobs: %s", err))
+	}
+	sort.Slice(jobs, func(i, j int) bool { return jobs[i].Created > jobs[j].Created })
+	if limit > 0 && len(jobs) > limit {
+		jobs = jobs[:limit]
+	}
+
+	return jobs, nil
+}
+
+// Store a job in Google Cloud Datastore. A cancellation requested since the job was read is kept
+func (mdms *GSuiteMDMService) PutJob(job *Job) error {
+	// Get a Datastore client
+	dc, err := mdms.DatastoreClient()
+	if err != nil {
+		return errors.New(fmt.Sprintf("Error creating Datastore client: %s", err))
+	}
+
+	job.Updated = time.Now().Format(time.RFC3339)
+	key := datastore.NameKey(mdms.jobNamekey(), job.ID, nil)
+	_, err = dc.RunInTransaction(mdms.Ctx, func(tx *datastore.Transaction) error {
+		var stored Job
+		if tx.Get(key, &stored) == nil && stored.Cancel {
+			job.Cancel = true
+		}
+		_, err := tx.Put(key, job)
+		return err
+	})
+	if err != nil {
+		return errors.New(fmt.Sprintf("Error storing job %s: %s", job.ID, err))
+	}
+
+	return nil
+}
+
+// Request cancellation of a job. Queued jobs are cancelled straight away, running jobs stop at
+// their next progress update
+func (mdms *GSuiteMDMService) CancelJob(id string) (*Job, error) {
+	job, err := mdms.GetJob(id)
+	if err != nil {
+		return nil, err
+	}
+
+	switch job.State {
+	case JobStateQueued:
+		job.Finished = time.Now().Format(time.RFC3339)
+		job.State = JobStateCancelled
+	case JobStateRunning:
+	default:
+		return job, &ActionError{Code: 400, Message: fmt.Sprintf("Job %s has already finished (%s)", job.ID, job.State)}
+	}
+	job.Cancel = true
+
+	err = mdms.PutJob(job)
+	if err != nil {
+		return nil, err
+	}
+
+	return job, nil
+}
+
+// Record the progress of a domain or device, and store the job. Returns ErrJobCancelled once
+// cancellation has been requested
+func (jc *JobContext) Progress(item, status, detail string, err error) error {
+	p := JobProgress{Detail: detail, Item: item, Status: status, Time: time.Now().Format(time.RFC3339)}
+	if err != nil {
+		p.Error = err.Error()
+	}
+
+	// Update the item's progress, or add it
+	found := false
+	for i := range jc.Job.Progress {
+		if jc.Job.Progress[i].Item == item {
+			jc.Job.Progress[i] = p
+			found = true
+			break
+		}
+	}
+	if !found {
+		jc.Job.Progress = append(jc.Job.Progress, p)
+	}
+
+	perr := jc.mdms.PutJob(jc.Job)
+	if perr != nil {
+		log.Printf("Error storing progress of job %s: %s", jc.Job.ID, perr)
+	}
+
+	if jc.Job.Cancel {
+		return ErrJobCancelled
+	}
+
+	return nil
+}
+
+// Check that an action job can be run by the submitter
+func verifyActionJob(mdms *GSuiteMDMService, p *JobParams, role string) error {
+	def, ok := GetAction(p.Action)
+	if !ok {
+		return &ActionError{Code: 400, Message: "Invalid request (invalid action specified)"}
+	}
+	if !RoleAllows(role, def.Role) {
+		return &ActionError{Code: 403, Message: fmt.Sprintf("Not authorized (action %s requires role %s)", def.Name, def.Role)}
+	}
+	if p.Domain == "" || mdms.IsDomainConfigured(p.Domain) == false {
+		return &ActionError{Code: 400, Message: "Invalid domain specified"}
+	}
+	if len(p.Devices) < 1 {
+		return &ActionError{Code: 400, Message: "Invalid request (no devices specified)"}
+	}
+
+	return nil
+}
+
+// Perform an action on each of a job's devices. Devices that fail don't stop the others
+func runActionJob(mdms *GSuiteMDMService, jc *JobContext) error {
+	var failed int
+	p := jc.Job.Params
+
+	for _, sn := range p.Devices {
+		err := jc.Progress(sn, JobProgressRunning, "", nil)
+		if err != nil {
+			return err
+		}
+
+		// Run the action as the submitter
+		r, err := mdms.RunAction(&ActionRequest{Action: p.Action, Confirm: true, Domain: p.Domain, SN: sn, Then: p.Then, WipeType: p.WipeType}, jc.Job.Role)
+		if err != nil {
+			failed++
+			err = jc.Progress(sn, JobProgressFailed, "", err)
+		} else {
+			detail := fmt.Sprintf("%s: status=%s", r.SDKAction, r.Status)
+			if !r.Confirmed {
+				detail += " (not yet confirmed by the Admin SDK)"
+			}
+			err = jc.Progress(sn, JobProgressDone, detail, nil)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	if failed > 0 {
+		return errors.New(fmt.Sprintf("%s failed on %d of %d device(s)", p.Action, failed, len(p.Devices)))
+	}
+
+	return nil
+}
+
+// Export the merged Datastore & Google Sheet data to the Google Sheet
+func runSheetJob(mdms *GSuiteMDMService, jc *JobContext) error {
+	err := jc.Progress("sheet", JobProgressRunning, "Reading Google Sheet & Datastore data", nil)
+	if err != nil {
+		return err
+	}
+
+	// Get Google Sheet & existing Datastore data
+	err = mdms.GetSheetData()
+	if err == nil {
+		err = mdms.GetDatastoreData()
+	}
+	if err != nil {
+		jc.Progress("sheet", JobProgressFailed, "", err)
+		return err
+	}
+
+	// Merge the data & update the Google Sheet
+	md := mdms.MergeDatastoreAndSheetData()
+	err = mdms.UpdateSheet(md)
+	if err != nil {
+		jc.Progress("sheet", JobProgressFailed, "", err)
+		return err
+	}
+
+	return jc.Progress("sheet", JobProgressDone, fmt.Sprintf("%d device(s) written", len(md)), nil)
+}
+
+// Check that a sync job's domain, if any, is configured
+func verifySyncJob(mdms *GSuiteMDMService, p *JobParams, role string) error {
+	if p.Domain != "" && mdms.IsDomainConfigured(p.Domain) == false {
+		return &ActionError{Code: 400, Message: "Invalid domain specified"}
+	}
+
+	return nil
+}
+
+// Sync Datastore with the Admin SDK, several domains at a time. Domains that fail don't stop
+// the others
+func runSyncJob(mdms *GSuiteMDMService, jc *JobContext) error {
+	var domains []string
+
+	// Get existing Datastore & Google Sheet data
+	err := mdms.GetDatastoreData()
+	if err != nil {
+		return errors.New(fmt.Sprintf("Error getting existing Datastore data: %s", err))
+	}
+	err = mdms.GetSheetData()
+	if err != nil {
+		return errors.New(fmt.Sprintf("Error getting Google Sheet data: %s", err))
+	}
+
+	// Which domains?
+	for _, dm := range mdms.C.Domains {
+		if jc.Job.Params.Domain == "" || jc.Job.Params.Domain == dm.DomainName {
+			domains = append(domains, dm.DomainName)
+		}
+	}
+
+	// Sync them, recording each domain's result as it finishes
+	sr, err := mdms.SyncDomains(domains, func(r DomainSyncResult) error {
+		status := JobProgressDone
+		var rerr error
+		if r.Error != "" {
+			status = JobProgressFailed
+			rerr = errors.New(r.Error)
+		}
+		return jc.Progress(r.Domain, status, fmt.Sprintf("seen=%d written=%d failed=%d attempts=%d in %s (%.1f devices/s, rate limited %s)", r.Seen, r.Written, r.Failed, r.Attempts, r.Duration, r.DevicesPerSecond, r.RateLimited), rerr)
+	})
+	jc.Job.Sync = *sr
+	if err != nil {
+		return err
+	}
+
+	// Refresh the directory users now that device phone numbers are up to date
+	errs := mdms.UpdateDirectory()
+	if len(errs) > 0 {
+		var msgs []string
+		for _, e := range errs {
+			msgs = append(msgs, e.Error())
+		}
+		err = errors.New(strings.Join(msgs, "; "))
+		jc.Progress("directory", JobProgressFailed, "", err)
+		return errors.New(fmt.Sprintf("Error updating directory: %s", err))
+	}
+
+	return sr.Err()
+}
+
+// Format a job for display
+func (job *Job) Text() string {
+	var b bytes.Buffer
+
+	fmt.Fprintf(&b, "Job %s (%s) by %s: %s", job.ID, job.Type, job.By, job.State)
+	if job.Cancel && job.State == JobStateRunning {
+		b.WriteString(", cancelling")
+	}
+	b.WriteString("\n")
+	fmt.Fprintf(&b, "  Submitted %s", job.Created)
+	if job.Started != "" {
+		fmt.Fprintf(&b, ", started %s", job.Started)
+	}
+	if job.Finished != "" {
+		fmt.Fprintf(&b, ", finished %s", job.Finished)
+	}
+	b.WriteString("\n")
+	for _, p := range job.Progress {
+		fmt.Fprintf(&b, "  %-24s %-8s %s", p.Item, p.Status, p.Detail)
+		if p.Error != "" {
+			fmt.Fprintf(&b, " (%s)", p.Error)
+		}
+		b.WriteString("\n")
+	}
+	if job.Sync.Finished != "" {
+		fmt.Fprintf(&b, "  Devices: seen=%d written=%d failed=%d in %s (%.1f devices/s), %d of %d domain(s) failed\n", job.Sync.Seen, job.Sync.Written, job.Sync.Failed, job.Sync.Duration, job.Sync.DevicesPerSecond, job.Sync.FailedDomains, len(job.Sync.Domains))
+	}
+	if job.Error != "" {
+		fmt.Fprintf(&b, "  Error: %s\n", job.Error)
+	}
+
+	return b.String()
+}
+
+// EOF
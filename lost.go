@@ -0,0 +1,363 @@
+package gsuitemdm
+
+//
+// GSuiteMDM lost/stolen device workflow
+//
+
+import (
+	"cloud.google.com/go/datastore"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+// Default lost device wipe countdowns
+const (
+	DefaultLostAccountWipeHours int = 24
+	DefaultLostDeviceWipeHours  int = 72
+)
+
+// Registered action used to wipe lost devices, with the Admin SDK wipe action as its wipe type
+const lostWipeAction string = "wipe"
+
+// Is the device currently reported lost?
+func (d *DatastoreMobileDevice) IsLost() bool {
+	return d.Lost.Reported != "" && d.Lost.Found == ""
+}
+
+// Record a step of a lost device report
+func (r *LostReport) addStep(step, by, detail string, now time.Time) {
+	r.Steps = append(r.Steps, LostStep{By: by, Detail: detail, Step: step, Time: now.Format(time.RFC3339)})
+}
+
+// Report a device lost on behalf of a caller with the given role: block it straight away, and
+// schedule an account wipe then a device wipe unless it is found first. The owner and IT are
+// notified
+func (mdms *GSuiteMDMService) ReportLost(sn, by, notes, role string, now time.Time) (*DatastoreMobileDevice, error) {
+	err := checkLostActionRole(ActionBlock, role)
+	if err != nil {
+		return nil, err
+	}
+
+	d, err := mdms.GetDatastoreDevice(sn)
+	if err != nil {
+		return nil, err
+	}
+	if d.IsLost() {
+		return nil, errors.New(fmt.Sprintf("Device SN=%s was already reported lost at %s", d.SN, d.Lost.Reported))
+	}
+
+	// Work out the countdowns
+	ah := mdms.C.Lost.AccountWipeHours
+	if ah < 1 {
+		ah = DefaultLostAccountWipeHours
+	}
+	dh := mdms.C.Lost.DeviceWipeHours
+	if dh < 1 {
+		dh = DefaultLostDeviceWipeHours
+	}
+	if dh < ah {
+		dh = ah
+	}
+
+	// Start a new report, keeping the steps of any earlier ones
+	d.Lost = LostReport{
+		PreviousStatus: d.Status,
+		Reported:       now.Format(time.RFC3339),
+		ReportedBy:     by,
+		Steps:          d.Lost.Steps,
+	}
+	detail := "Reported lost"
+	if notes != "" {
+		detail = detail + ": " + notes
+	}
+	d.Lost.addStep("lost", by, detail, now)
+
+	// Only schedule the wipes the allow-list permits
+	werr := mdms.checkWipeAllowed(ActionAccountWipe)
+	if werr != nil {
+		d.Lost.addStep(ActionAccountWipe, "gsuitemdm", "Account wipe not scheduled: "+werr.Error(), now)
+	} else {
+		d.Lost.AccountWipeAt = now.Add(time.Duration(ah) * time.Hour).Format(time.RFC3339)
+	}
+	werr = mdms.checkWipeAllowed(ActionRemoteWipe)
+	if werr != nil {
+		d.Lost.addStep(ActionRemoteWipe, "gsuitemdm", "Device wipe not scheduled: "+werr.Error(), now)
+	} else {
+		d.Lost.DeviceWipeAt = now.Add(time.Duration(dh) * time.Hour).Format(time.RFC3339)
+	}
+
+	// Block it now, unless it already is
+	if strings.ToUpper(d.Status) == "BLOCKED" {
+		d.Lost.addStep(ActionBlock, "gsuitemdm", "Device already blocked", now)
+	} else {
+		err = mdms.lostDeviceAction(d, ActionBlock, "", role, "Device blocked", now)
+	}
+
+	// Save the report even if the block failed, so the wipes still happen
+	perr := mdms.putLostReport(d)
+	if perr != nil {
+		return nil, perr
+	}
+
+	// Tell IT and the owner
+	mdms.Publish(Event{Device: d, Time: now, Type: EventDeviceLost,
+		Message: fmt.Sprintf("Device %s (%s) owned by %s reported lost by %s. %s", d.SN, d.Model, d.Email, by, lostWipeSchedule(&d.Lost))})
+	mdms.messageOwnerLost(d, fmt.Sprintf("Your mobile device %s has been reported lost", d.Model),
+		fmt.Sprintf("Hi %s,\n\nYour mobile device %s (serial number %s) was reported lost by %s and has been blocked.\n\n%s.\n\nIf you find it, contact IT straight away.\n",
+			d.Name, d.Model, d.SN, by, lostWipeSchedule(&d.Lost)))
+
+	if err != nil {
+		return d, errors.New(fmt.Sprintf("Device SN=%s reported lost but could not be blocked: %s", d.SN, err))
+	}
+
+	return d, nil
+}
+
+// Report a lost device found on behalf of a caller with the given role: cancel any wipes that
+// have not happened yet, and re-approve the device if it was approved before it was lost and has
+// not been wiped
+func (mdms *GSuiteMDMService) ReportFound(sn, by, notes, role string, now time.Time) (*DatastoreMobileDevice, error) {
+	err := checkLostActionRole(ActionApprove, role)
+	if err != nil {
+		return nil, err
+	}
+
+	d, err := mdms.GetDatastoreDevice(sn)
+	if err != nil {
+		return nil, err
+	}
+	if !d.IsLost() {
+		return nil, errors.New(fmt.Sprintf("Device SN=%s is not reported lost", d.SN))
+	}
+
+	d.Lost.Found = now.Format(time.RFC3339)
+	detail := "Reported found, pending wipes cancelled"
+	if notes != "" {
+		detail = detail + ": " + notes
+	}
+	d.Lost.addStep("found", by, detail, now)
+
+	// Restore the device, if we can
+	switch {
+	case d.Lost.AccountWiped || d.Lost.DeviceWiped:
+		d.Lost.addStep("found", "gsuitemdm", "Device was already wiped, not restored", now)
+	case d.Lost.PreviousStatus == "APPROVED":
+		err = mdms.lostDeviceAction(d, ActionApprove, "", role, "Device re-approved", now)
+	default:
+		d.Lost.addStep("found", "gsuitemdm", fmt.Sprintf("Device was %s before it was lost, left blocked", d.Lost.PreviousStatus), now)
+	}
+
+	perr := mdms.putLostReport(d)
+	if perr != nil {
+		return nil, perr
+	}
+
+	// Tell IT and the owner
+	mdms.Publish(Event{Device: d, Time: now, Type: EventDeviceFound,
+		Message: fmt.Sprintf("Device %s (%s) owned by %s reported found by %s", d.SN, d.Model, d.Email, by)})
+	mdms.messageOwnerLost(d, fmt.Sprintf("Your mobile device %s has been reported found", d.Model),
+		fmt.Sprintf("Hi %s,\n\nYour mobile device %s (serial number %s) was reported found by %s. Any pending wipes have been cancelled.\n", d.Name, d.Model, d.SN, by))
+
+	if err != nil {
+		return d, errors.New(fmt.Sprintf("Device SN=%s reported found but could not be re-approved: %s", d.SN, err))
+	}
+
+	return d, nil
+}
+
+// Issue the account & device wipes of lost devices whose countdowns have run out, on behalf of a
+// caller with the given role. Returns the devices that were acted on. Intended to be run on a
+// schedule
+func (mdms *GSuiteMDMService) ProcessLostDevices(role string, now time.Time) ([]DatastoreMobileDevice, []error) {
+	var done []DatastoreMobileDevice
+	var errs []error
+
+	err := checkLostActionRole(lostWipeAction, role)
+	if err != nil {
+		return nil, []error{err}
+	}
+
+	// Devices already read are appended to, so start afresh
+	mdms.DatastoreData = nil
+	err = mdms.GetDatastoreData()
+	if err != nil {
+		return nil, []error{err}
+	}
+
+	for i := range mdms.DatastoreData {
+		d := &mdms.DatastoreData[i]
+		if !d.IsLost() {
+			continue
+		}
+
+		var acted bool
+
+		// Account wipe due? The allow-list may have changed since it was scheduled, in which case
+		// the wipe is cancelled
+		if !d.Lost.AccountWiped && isDue(d.Lost.AccountWipeAt, now) {
+			err = mdms.checkWipeAllowed(ActionAccountWipe)
+			if err == nil {
+				err = mdms.lostDeviceAction(d, lostWipeAction, ActionAccountWipe, role, "Account wipe issued", now)
+				d.Lost.AccountWiped = err == nil
+			} else {
+				d.Lost.AccountWipeAt = ""
+				d.Lost.addStep("error", "gsuitemdm", err.Error(), now)
+			}
+			if err != nil {
+				errs = append(errs, err)
+			}
+			acted = true
+		}
+
+		// Device wipe due? Only after the account wipe has been issued, if one was scheduled
+		if (d.Lost.AccountWiped || d.Lost.AccountWipeAt == "") && !d.Lost.DeviceWiped && isDue(d.Lost.DeviceWipeAt, now) {
+			err = mdms.checkWipeAllowed(ActionRemoteWipe)
+			if err == nil {
+				err = mdms.lostDeviceAction(d, lostWipeAction, ActionRemoteWipe, role, "Device wipe issued", now)
+				d.Lost.DeviceWiped = err == nil
+			} else {
+				d.Lost.DeviceWipeAt = ""
+				d.Lost.addStep("error", "gsuitemdm", err.Error(), now)
+			}
+			if err != nil {
+				errs = append(errs, err)
+			}
+			acted = true
+		}
+
+		if !acted {
+			continue
+		}
+		err = mdms.putLostReport(d)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		done = append(done, *d)
+	}
+
+	return done, errs
+}
+
+// Get all devices currently reported lost
+func (mdms *GSuiteMDMService) GetLostDevices() ([]DatastoreMobileDevice, error) {
+	var lost []DatastoreMobileDevice
+
+	// Devices already read are appended to, so start afresh
+	mdms.DatastoreData = nil
+	err := mdms.GetDatastoreData()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, d := range mdms.DatastoreData {
+		if d.IsLost() {
+			lost = append(lost, d)
+		}
+	}
+
+	return lost, nil
+}
+
+// Check that a caller with this role may perform an action of the lost device workflow
+func checkLostActionRole(action, role string) error {
+	def, ok := GetAction(action)
+	if !ok {
+		return errors.New(fmt.Sprintf("Unknown action %q", action))
+	}
+	if !RoleAllows(role, def.Role) {
+		return &ActionError{Code: 403, Message: fmt.Sprintf("Not authorized (action %s requires role %s)", def.Name, def.Role)}
+	}
+
+	return nil
+}
+
+// Perform an action on a lost device through the action engine, recording the outcome in its
+// lost report
+func (mdms *GSuiteMDMService) lostDeviceAction(d *DatastoreMobileDevice, action, wipetype, role, detail string, now time.Time) error {
+	r, err := mdms.RunAction(&ActionRequest{Action: action, Confirm: true, Domain: d.Domain, SN: d.SN, WipeType: wipetype}, role)
+	if err != nil {
+		d.Lost.addStep("error", "gsuitemdm", err.Error(), now)
+		return err
+	}
+
+	if !r.Confirmed {
+		detail = detail + fmt.Sprintf(", status=%s (not yet confirmed by the Admin SDK)", r.Status)
+	}
+	d.Lost.addStep(r.SDKAction, "gsuitemdm", detail, now)
+
+	return nil
+}
+
+// Save a device's lost report. The action engine updates the stored device after each action, so
+// the report is saved over what is stored rather than over the device as it was read
+func (mdms *GSuiteMDMService) putLostReport(d *DatastoreMobileDevice) error {
+	var stored DatastoreMobileDevice
+
+	dc, err := mdms.DatastoreClient()
+	if err != nil {
+		return errors.New(fmt.Sprintf("Error creating Datastore client: %s", err))
+	}
+
+	key := datastore.NameKey(mdms.C.DSNamekey, strings.Replace(d.SN, " ", "", -1), nil)
+	_, err = dc.RunInTransaction(mdms.Ctx, func(tx *datastore.Transaction) error {
+		stored = DatastoreMobileDevice{}
+		err := tx.Get(key, &stored)
+		if err == datastore.ErrNoSuchEntity {
+			stored = *d
+		} else if err != nil {
+			return err
+		}
+		stored.Lost = d.Lost
+		_, err = tx.Put(key, &stored)
+		return err
+	})
+	if err != nil {
+		return errors.New(fmt.Sprintf("Error saving device SN=%s to Datastore: %s", d.SN, err))
+	}
+	*d = stored
+
+	return nil
+}
+
+// Has the time in an RFC3339 timestamp been reached?
+func isDue(at string, now time.Time) bool {
+	t, err := time.Parse(time.RFC3339, at)
+	if err != nil {
+		return false
+	}
+
+	return !now.Before(t)
+}
+
+// Describe the wipes scheduled for a lost device
+func lostWipeSchedule(r *LostReport) string {
+	switch {
+	case r.AccountWipeAt != "" && r.DeviceWipeAt != "":
+		return fmt.Sprintf("Account wipe at %s, device wipe at %s", r.AccountWipeAt, r.DeviceWipeAt)
+	case r.AccountWipeAt != "":
+		return fmt.Sprintf("Account wipe at %s, no device wipe", r.AccountWipeAt)
+	case r.DeviceWipeAt != "":
+		return fmt.Sprintf("No account wipe, device wipe at %s", r.DeviceWipeAt)
+	}
+
+	return "No wipes scheduled"
+}
+
+// Tell the owner of a lost device what is happening. Failures are logged, not returned, as the
+// owner may well not be able to read their messages
+func (mdms *GSuiteMDMService) messageOwnerLost(d *DatastoreMobileDevice, subject, body string) {
+	if d.Email == "" || (mdms.C.Owners.Host == "" && mdms.C.Owners.SlackTokenID == "") {
+		return
+	}
+
+	err := mdms.MessageOwner(d.Email, subject, body)
+	if err != nil {
+		log.Printf("Error messaging owner of lost device SN=%s: %s", d.SN, err)
+	}
+}
+
+// EOF
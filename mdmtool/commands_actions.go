@@ -1,7 +1,7 @@
 package main
 
 //
-// MDMTool action commands (approve, block, delete, wipe)
+// MDMTool action commands (approve, block, cancel-wipe, delete, unblock, wipe)
 //
 //
 
@@ -185,6 +185,98 @@ func (bc *BlockCommand) run(c *kingpin.ParseContext) error {
 	return nil
 }
 
+//
+// CANCEL-WIPE
+//
+
+// Add the "cancel-wipe" command
+func addCancelWipeCommand(mdmtool *kingpin.Application) {
+	c := &CancelWipeCommand{}
+	cw := mdmtool.Command("cancel-wipe", "Cancel a pending wipe of a mobile device").Action(c.run)
+	cw.Flag("domain", "The G Suite domain to which the mobile device belongs to (required)").Required().Short('d').StringVar(&c.Domain)
+	cw.Flag("imei", "Cancel the wipe of a device using IMEI").Short('i').StringVar(&c.IMEI)
+	cw.Flag("sn", "Cancel the wipe of a device using Serial number").Short('s').StringVar(&c.SN)
+	cw.Flag("then", "What to do with the device once the wipe is cancelled: activate or block").Default("activate").EnumVar(&c.Then, "activate", "block")
+}
+
+// Setup the "cancel-wipe" command
+func (cwc *CancelWipeCommand) run(c *kingpin.ParseContext) error {
+	// Check runtime options
+	if (cwc.IMEI == "" && cwc.SN == "") || (cwc.IMEI != "" && cwc.SN != "") {
+		return errors.New("with \"cancel-wipe\" command you must specify either --imei or --sn")
+	}
+
+	// Runtime options are good, lets setup the request body
+	var approval bool
+	var rb gsuitemdm.ActionRequest
+
+	// What happens to the device afterwards?
+	then := "ACTIVATE"
+	if cwc.Then == "block" {
+		then = "BLOCK"
+	}
+
+	// How are we identifying the device?
+	switch {
+
+	// IMEI
+	case cwc.IMEI != "":
+		rb.IMEI = cwc.IMEI
+		approval = checkUserConfirmation(fmt.Sprintf("WARNING: Are you sure you want to CANCEL THE WIPE of device IMEI=%s in domain %s and then %s it?", cwc.IMEI, cwc.Domain, then))
+		break
+
+	// Serial Number
+	case cwc.SN != "":
+		rb.SN = cwc.SN
+		approval = checkUserConfirmation(fmt.Sprintf("WARNING: Are you sure you want to CANCEL THE WIPE of device SN=%s in domain %s and then %s it?", cwc.SN, cwc.Domain, then))
+		break
+	}
+
+	// Check if approval was given
+	if approval == false {
+		return errors.New("Approval not granted, no change made to device.")
+	}
+
+	// Approval has been given, lets setup the rest of the CANCEL-WIPE request
+	rb.Action = "cancelwipe"
+	rb.Confirm = true
+	rb.Domain = cwc.Domain
+	rb.Key = m.Config.APIKey
+	rb.Then = cwc.Then
+
+	// Marshal the JSON
+	js, err := json.Marshal(rb)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	fmt.Printf("Cancelling device wipe... ")
+
+	// Build the http request
+	req, err := http.NewRequest("POST", m.Config.CancelWipeURL, bytes.NewBuffer(js))
+	if err != nil {
+		log.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	// Create an http client
+	client := &http.Client{}
+
+	// Send the request and get a nice response
+	resp, err := client.Do(req)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	fmt.Printf(" done.\n")
+
+	body, _ := ioutil.ReadAll(resp.Body)
+	fmt.Println(string(body))
+
+	return nil
+}
+
 //
 // DELETE
 //
@@ -319,6 +411,90 @@ func (shc *ShowDomainsCommand) run(c *kingpin.ParseContext) error {
 	return nil
 }
 
+//
+// UNBLOCK
+//
+
+// Add the "unblock" command
+func addUnblockCommand(mdmtool *kingpin.Application) {
+	c := &UnblockCommand{}
+	unblock := mdmtool.Command("unblock", "Unblock a blocked mobile device").Action(c.run)
+	unblock.Flag("domain", "The G Suite domain to which the mobile device belongs to (required)").Required().Short('d').StringVar(&c.Domain)
+	unblock.Flag("imei", "Unblock a device using IMEI").Short('i').StringVar(&c.IMEI)
+	unblock.Flag("sn", "Unblock a device using Serial number").Short('s').StringVar(&c.SN)
+}
+
+// Setup the "unblock" command
+func (uc *UnblockCommand) run(c *kingpin.ParseContext) error {
+	// Check runtime options
+	if (uc.IMEI == "" && uc.SN == "") || (uc.IMEI != "" && uc.SN != "") {
+		return errors.New("with \"unblock\" command you must specify either --imei or --sn")
+	}
+
+	// Runtime options are good, lets setup the request body
+	var approval bool
+	var rb gsuitemdm.ActionRequest
+
+	// How are we identifying the device to be unblocked?
+	switch {
+
+	// IMEI
+	case uc.IMEI != "":
+		rb.IMEI = uc.IMEI
+		approval = checkUserConfirmation(fmt.Sprintf("WARNING: Are you sure you want to UNBLOCK device IMEI=%s in domain %s?", uc.IMEI, uc.Domain))
+		break
+
+	// Serial Number
+	case uc.SN != "":
+		rb.SN = uc.SN
+		approval = checkUserConfirmation(fmt.Sprintf("WARNING: Are you sure you want to UNBLOCK device SN=%s in domain %s?", uc.SN, uc.Domain))
+		break
+	}
+
+	// Check if approval was given
+	if approval == false {
+		return errors.New("Approval not granted, no change made to device.")
+	}
+
+	// Approval has been given, lets setup the rest of the UNBLOCK request
+	rb.Action = "unblock"
+	rb.Confirm = true
+	rb.Domain = uc.Domain
+	rb.Key = m.Config.APIKey
+
+	// Marshal the JSON
+	js, err := json.Marshal(rb)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	fmt.Printf("Unblocking device... ")
+
+	// Build the http request
+	req, err := http.NewRequest("POST", m.Config.UnblockDeviceURL, bytes.NewBuffer(js))
+	if err != nil {
+		log.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	// Create an http client
+	client := &http.Client{}
+
+	// Send the request and get a nice response
+	resp, err := client.Do(req)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	fmt.Printf(" done.\n")
+
+	body, _ := ioutil.ReadAll(resp.Body)
+	fmt.Println(string(body))
+
+	return nil
+}
+
 //
 // WIPE
 //
@@ -330,6 +506,7 @@ func addWipeCommand(mdmtool *kingpin.Application) {
 	wipe.Flag("domain", "The G Suite domain to which the mobile device belongs to (required)").Required().Short('d').StringVar(&c.Domain)
 	wipe.Flag("imei", "Wipe using a mobile device IMEI number").Short('i').StringVar(&c.IMEI)
 	wipe.Flag("sn", "Wipe using a mobile device serial number").Short('s').StringVar(&c.SN)
+	wipe.Flag("type", "Type of wipe: account (G Suite account & data only) or device (factory reset). Defaults by device ownership").Short('t').EnumVar(&c.Type, gsuitemdm.WipeTypeAccount, gsuitemdm.WipeTypeDevice)
 }
 
 // Setup the "wipe" command
@@ -343,19 +520,28 @@ func (wc *WipeCommand) run(c *kingpin.ParseContext) error {
 	var approval bool
 	var rb gsuitemdm.ActionRequest
 
+	// What kind of wipe?
+	wt := "(wipe type based on device ownership)"
+	switch wc.Type {
+	case gsuitemdm.WipeTypeAccount:
+		wt = "(ACCOUNT wipe)"
+	case gsuitemdm.WipeTypeDevice:
+		wt = "(DEVICE wipe, factory reset)"
+	}
+
 	// How are we identifying the device to be wiped?
 	switch {
 
 	// IMEI
 	case wc.IMEI != "":
 		rb.IMEI = wc.IMEI
-		approval = checkUserConfirmation(fmt.Sprintf("WARNING: Are you sure you want to WIPE device IMEI=%s in domain %s?", wc.IMEI, wc.Domain))
+		approval = checkUserConfirmation(fmt.Sprintf("WARNING: Are you sure you want to WIPE device IMEI=%s in domain %s %s?", wc.IMEI, wc.Domain, wt))
 		break
 
 	// Serial Number
 	case wc.SN != "":
 		rb.SN = wc.SN
-		approval = checkUserConfirmation(fmt.Sprintf("WARNING: Are you sure you want to WIPE device SN=%s in domain %s?", wc.SN, wc.Domain))
+		approval = checkUserConfirmation(fmt.Sprintf("WARNING: Are you sure you want to WIPE device SN=%s in domain %s %s?", wc.SN, wc.Domain, wt))
 		break
 	}
 
@@ -369,6 +555,7 @@ func (wc *WipeCommand) run(c *kingpin.ParseContext) error {
 	rb.Confirm = true
 	rb.Domain = wc.Domain
 	rb.Key = m.Config.APIKey
+	rb.WipeType = wc.Type
 
 	// Marshal the JSON
 	js, err := json.Marshal(rb)
@@ -0,0 +1,180 @@
+package main
+
+//
+// MDMTool asset commands (asset, warranty)
+//
+//
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/rickt/gsuitemdm"
+	"gopkg.in/alecthomas/kingpin.v2"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+//
+// ASSET
+//
+
+// Add the "asset" command
+func addAssetCommand(mdmtool *kingpin.Application) {
+	c := &AssetCommand{}
+	asset := mdmtool.Command("asset", "Update the asset data of a mobile device").Action(c.run)
+	asset.Flag("sn", "Serial number of the mobile device (required)").Required().Short('s').StringVar(&c.SN)
+	asset.Flag("asset-tag", "Asset tag").StringVar(&c.AssetTag)
+	asset.Flag("carrier", "Mobile carrier").StringVar(&c.Carrier)
+	asset.Flag("cost", "Purchase cost").StringVar(&c.Cost)
+	asset.Flag("ownership", "Who owns the device: corporate or byod (\"\" for unknown)").StringVar(&c.Ownership)
+	asset.Flag("plan", "Carrier plan").StringVar(&c.Plan)
+	asset.Flag("purchase-date", "Purchase date (YYYY-MM-DD)").StringVar(&c.PurchaseDate)
+	asset.Flag("vendor", "Vendor the device was purchased from").StringVar(&c.Vendor)
+	asset.Flag("warranty-expiry", "Warranty expiry date (YYYY-MM-DD)").StringVar(&c.WarrantyExpiry)
+}
+
+// Setup the "asset" command
+func (ac *AssetCommand) run(c *kingpin.ParseContext) error {
+	var rb gsuitemdm.AssetRequest
+
+	// Only send the fields that were specified on the command line, so that an empty
+	// value (e.g. --carrier "") clears the field and a missing flag leaves it alone
+	var n int
+	for _, f := range []struct {
+		name string
+		src  *string
+		dst  **string
+	}{
+		{"asset-tag", &ac.AssetTag, &rb.AssetTag},
+		{"carrier", &ac.Carrier, &rb.Carrier},
+		{"cost", &ac.Cost, &rb.Cost},
+		{"ownership", &ac.Ownership, &rb.Ownership},
+		{"plan", &ac.Plan, &rb.Plan},
+		{"purchase-date", &ac.PurchaseDate, &rb.PurchaseDate},
+		{"vendor", &ac.Vendor, &rb.Vendor},
+		{"warranty-expiry", &ac.WarrantyExpiry, &rb.WarrantyExpiry},
+	} {
+		if flagSet(c, f.name) {
+			*f.dst = f.src
+			n++
+		}
+	}
+	if n < 1 {
+		return errors.New("with \"asset\" command you must specify at least one asset field to update")
+	}
+
+	// Setup the rest of the request
+	rb.Key = m.Config.APIKey
+	rb.SN = ac.SN
+
+	// Marshal the JSON
+	js, err := json.Marshal(rb)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	fmt.Printf("Updating asset data... ")
+
+	// Build the http request
+	req, err := http.NewRequest("POST", m.Config.UpdateAssetURL, bytes.NewBuffer(js))
+	if err != nil {
+		log.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	// Create an http client
+	client := &http.Client{}
+
+	// Send the request and get a nice response
+	resp, err := client.Do(req)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Was this a bad request?
+	if resp.StatusCode != http.StatusOK {
+		fmt.Printf(" failed.\n")
+		return errors.New(strings.TrimSpace(string(body)))
+	}
+
+	fmt.Printf(" done.\n")
+
+	// Show the updated device
+	var device gsuitemdm.DatastoreMobileDevice
+	err = json.Unmarshal(body, &device)
+	if err != nil {
+		return err
+	}
+	printDeviceData(device, true)
+
+	return nil
+}
+
+//
+// WARRANTY
+//
+
+// Add the "warranty" command
+func addWarrantyCommand(mdmtool *kingpin.Application) {
+	c := &WarrantyCommand{}
+	warranty := mdmtool.Command("warranty", "Show mobile devices with warranties expiring soon").Action(c.run)
+	warranty.Flag("days", "Show warranties expiring in the next N days").Short('n').Default("30").IntVar(&c.Days)
+	warranty.Flag("domain", "Restrict report to a specific G Suite domain (optional)").Short('d').StringVar(&c.Domain)
+}
+
+// Setup the "warranty" command
+func (wc *WarrantyCommand) run(c *kingpin.ParseContext) error {
+	if wc.Days < 0 {
+		return errors.New("with \"warranty\" command --days cannot be negative")
+	}
+
+	// Get all devices
+	var rb gsuitemdm.SearchRequest
+	rb.Domain = wc.Domain
+	rb.Key = m.Config.APIKey
+	rb.QType = "all"
+
+	devices, err := searchDevices(rb)
+	if err != nil {
+		return err
+	}
+
+	// Find the expiring warranties
+	expiring := gsuitemdm.ExpiringWarranties(devices, wc.Days, time.Now())
+
+	if len(expiring) < 1 {
+		fmt.Printf("No warranties expire in the next %d days.\n", wc.Days)
+		return nil
+	}
+
+	// Print the report
+	printWarrantyLine()
+	fmt.Printf("Warranty Expiry | Domain                | Model            | Serial #         | Asset Tag    | Vendor       | Owner\n")
+	printWarrantyLine()
+	for _, d := range expiring {
+		fmt.Printf("%-15.15s | %-21.21s | %-16.16s | %-16.16s | %-12.12s | %-12.12s | %s\n", d.WarrantyExpiry, d.Domain, d.Model, d.SN, d.AssetTag, d.Vendor, d.Name)
+	}
+	printWarrantyLine()
+
+	fmt.Printf("%d warranties expire in the next %d days.\n", len(expiring), wc.Days)
+
+	return nil
+}
+
+// Print a correctly formatted line for the warranty report
+func printWarrantyLine() {
+	fmt.Printf("----------------+-----------------------+------------------+------------------+--------------+--------------+---------------\n")
+}
+
+// EOF
@@ -0,0 +1,211 @@
+package main
+
+//
+// MDMTool configuration commands (config show, validate, add-domain, remove-domain, set)
+//
+//
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/rickt/gsuitemdm"
+	"gopkg.in/alecthomas/kingpin.v2"
+	"io/ioutil"
+	"sigs.k8s.io/yaml"
+	"strings"
+)
+
+//
+// CONFIG
+//
+
+// Add the "config" command and its subcommands
+func addConfigCommand(mdmtool *kingpin.Application) {
+	config := mdmtool.Command("config", "Show, check & change the shared gsuitemdm configuration")
+
+	// config show
+	sc := &ConfigCommand{Action: "show"}
+	show := config.Command("show", "Show the configuration").Action(sc.run)
+	show.Flag("defaults", "Show the configuration with defaults filled in").BoolVar(&sc.Defaults)
+	show.Flag("format", "Output format: json or yaml. Defaults to the format the configuration is stored in").EnumVar(&sc.Format, "json", "yaml")
+	show.Flag("secret", "ID of the configuration secret. Defaults to the profile's configsecretid").StringVar(&sc.SecretID)
+
+	// config validate
+	vc := &ConfigCommand{Action: "validate"}
+	validate := config.Command("validate", "Check the configuration, or a configuration file").Action(vc.run)
+	validate.Flag("file", "Check this JSON or YAML file instead").Short('f').StringVar(&vc.File)
+	validate.Flag("secret", "ID of the configuration secret. Defaults to the profile's configsecretid").StringVar(&vc.SecretID)
+
+	// config add-domain
+	ac := &ConfigCommand{Action: "add-domain"}
+	add := config.Command("add-domain", "Add a G Suite domain").Action(ac.run)
+	add.Flag("domain", "G Suite domain name (required)").Required().Short('d').StringVar(&ac.Domain.DomainName)
+	add.Flag("companyid", "Immutable customer ID of the domain (required)").Required().StringVar(&ac.Domain.CustomerID)
+	add.Flag("adminuser", "Admin user the domain's API calls run as (required)").Required().StringVar(&ac.Domain.AdminUser)
+	add.Flag("secretid", "ID of the secret holding the domain's service account credentials (required)").Required().StringVar(&ac.Domain.SecretID)
+	add.Flag("secret", "ID of the configuration secret. Defaults to the profile's configsecretid").StringVar(&ac.SecretID)
+	add.Flag("yes", "Don't ask for confirmation").Short('y').BoolVar(&ac.Yes)
+
+	// config remove-domain
+	rc := &ConfigCommand{Action: "remove-domain"}
+	remove := config.Command("remove-domain", "Remove a G Suite domain").Action(rc.run)
+	remove.Flag("domain", "G Suite domain name (required)").Required().Short('d').StringVar(&rc.Domain.DomainName)
+	remove.Flag("secret", "ID of the configuration secret. Defaults to the profile's configsecretid").StringVar(&rc.SecretID)
+	remove.Flag("yes", "Don't ask for confirmation").Short('y').BoolVar(&rc.Yes)
+
+	// config set
+	tc := &ConfigCommand{Action: "set"}
+	set := config.Command("set", "Set a configuration value, e.g. \"config set sync.concurrency 8\"").Action(tc.run)
+	set.Arg("path", "Dotted path of the value, e.g. sync.concurrency or domains.0.adminuser (required)").Required().StringVar(&tc.Path)
+	set.Arg("value", "New value. JSON values (numbers, true/false, lists) are used as they are, anything else as a string (required)").Required().StringVar(&tc.Value)
+	set.Flag("secret", "ID of the configuration secret. Defaults to the profile's configsecretid").StringVar(&tc.SecretID)
+	set.Flag("yes", "Don't ask for confirmation").Short('y').BoolVar(&tc.Yes)
+}
+
+// Setup the "config" commands
+func (cc *ConfigCommand) run(c *kingpin.ParseContext) error {
+	ctx := context.Background()
+
+	if cc.SecretID == "" {
+		cc.SecretID = m.Config.ConfigSecretID
+	}
+
+	// Checking a local file doesn't need the stored configuration
+	if cc.Action == "validate" && cc.File != "" {
+		b, err := ioutil.ReadFile(cc.File)
+		if err != nil {
+			return err
+		}
+		doc, err := gsuitemdm.ParseConfigDocument(b)
+		if err != nil {
+			return err
+		}
+		return validateConfigDocument(doc)
+	}
+
+	if cc.SecretID == "" {
+		return errors.New(fmt.Sprintf("No configuration secret: set configsecretid in the %s profile, set %s or use --secret", m.Config.Profile, envconfigsecretid))
+	}
+
+	// Get the stored configuration, as it is now
+	gsuitemdm.InvalidateSecret(cc.SecretID)
+	raw, err := gsuitemdm.GetSecret(ctx, cc.SecretID)
+	if err != nil {
+		return err
+	}
+	doc, err := gsuitemdm.ParseConfigDocument([]byte(raw))
+	if err != nil {
+		return err
+	}
+	nd := doc.Copy()
+
+	switch cc.Action {
+	// Show the configuration
+	case "show":
+		return showConfigDocument(doc, cc.Format, cc.Defaults)
+
+	// Check the configuration
+	case "validate":
+		return validateConfigDocument(doc)
+
+	// Add a domain
+	case "add-domain":
+		err = nd.AddDomain(cc.Domain)
+
+	// Remove a domain
+	case "remove-domain":
+		err = nd.RemoveDomain(cc.Domain.DomainName)
+
+	// Set a value
+	case "set":
+		err = nd.Set(cc.Path, cc.Value)
+	}
+	if err != nil {
+		return err
+	}
+
+	// Show what will change
+	diff := nd.Diff(doc)
+	if len(diff) == 0 {
+		fmt.Println("No change to the configuration.")
+		return nil
+	}
+	fmt.Println(strings.Join(diff, "\n"))
+
+	// Never store a configuration that won't load
+	_, err = nd.Config()
+	if err != nil {
+		return err
+	}
+
+	if cc.Yes == false && checkUserConfirmation(fmt.Sprintf("Store this change as a new version of %s?", cc.SecretID)) == false {
+		return errors.New("Approval not granted, no change made to the configuration.")
+	}
+
+	b, err := nd.Bytes()
+	if err != nil {
+		return err
+	}
+	version, err := gsuitemdm.PutSecret(ctx, cc.SecretID, string(b))
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Configuration stored as %s\n", version)
+
+	return nil
+}
+
+// Print a configuration document
+func showConfigDocument(doc *gsuitemdm.ConfigDocument, format string, defaults bool) error {
+	var b []byte
+	var err error
+
+	if format == "" {
+		format = strings.ToLower(doc.Format)
+	}
+
+	// With defaults, the loaded configuration is shown instead
+	if defaults == true {
+		cf, err := doc.Config()
+		if err != nil {
+			return err
+		}
+		b, err = json.MarshalIndent(cf, "", "\t")
+		if err != nil {
+			return err
+		}
+		if format == "yaml" {
+			b, err = yaml.JSONToYAML(b)
+			if err != nil {
+				return err
+			}
+		}
+		fmt.Println(strings.TrimSpace(string(b)))
+		return nil
+	}
+
+	sd := doc.Copy()
+	sd.Format = strings.ToUpper(format)
+	b, err = sd.Bytes()
+	if err != nil {
+		return err
+	}
+	fmt.Println(strings.TrimSpace(string(b)))
+
+	return nil
+}
+
+// Check a configuration document, listing any problems
+func validateConfigDocument(doc *gsuitemdm.ConfigDocument) error {
+	cf, err := doc.Config()
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Configuration is valid (%d domain(s)).\n", len(cf.Domains))
+
+	return nil
+}
+
+// EOF
@@ -0,0 +1,260 @@
+package main
+
+//
+// MDMTool inventory commands (export, import)
+//
+//
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/rickt/gsuitemdm"
+	"gopkg.in/alecthomas/kingpin.v2"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+//
+// EXPORT
+//
+
+// Add the "export" command
+func addExportCommand(mdmtool *kingpin.Application) {
+	c := &ExportCommand{}
+	export := mdmtool.Command("export", "Export the mobile device inventory").Action(c.run)
+	export.Flag("domain", "Restrict export to a specific G Suite domain (optional)").Short('d').StringVar(&c.Domain)
+	export.Flag("format", "Export format").Short('f').Default(gsuitemdm.FormatCSV).EnumVar(&c.Format, gsuitemdm.FormatCSV, gsuitemdm.FormatJSON, gsuitemdm.FormatXLSX)
+	export.Flag("output", "Output file (default: stdout)").Short('o').StringVar(&c.Output)
+	export.Flag("status", "Restrict export to devices with a specific MDM device status (optional)").Short('t').StringVar(&c.Status)
+}
+
+// Setup the "export" command
+func (ec *ExportCommand) run(c *kingpin.ParseContext) error {
+	// XLSX is binary, don't write it to a terminal
+	if ec.Format == gsuitemdm.FormatXLSX && ec.Output == "" {
+		return errors.New("with \"export --format xlsx\" you must specify --output")
+	}
+
+	// Setup the request body
+	var rb gsuitemdm.SearchRequest
+	rb.QType = "all"
+	if ec.Status != "" {
+		rb.QType = "status"
+		rb.Q = ec.Status
+	}
+	rb.Domain = ec.Domain
+	rb.Key = m.Config.APIKey
+
+	// Get the devices
+	devices, err := searchDevices(rb)
+	if err != nil {
+		return err
+	}
+
+	// Where are we writing to?
+	var w io.Writer = os.Stdout
+	if ec.Output != "" {
+		f, err := os.Create(ec.Output)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+
+	// Export the devices
+	err = gsuitemdm.ExportDevices(w, ec.Format, devices)
+	if err != nil {
+		return err
+	}
+
+	if ec.Output != "" {
+		fmt.Printf("Exported %d devices to %s.\n", len(devices), ec.Output)
+	}
+
+	return nil
+}
+
+//
+// IMPORT
+//
+
+// Add the "import" command
+func addImportCommand(mdmtool *kingpin.Application) {
+	c := &ImportCommand{}
+	imp := mdmtool.Command("import", "Import phone number, color, RAM & notes for mobile devices").Action(c.run)
+	imp.Flag("file", "File to import (required)").Required().Short('f').StringVar(&c.File)
+	imp.Flag("format", "Import format (default: guessed from the file extension)").EnumVar(&c.Format, gsuitemdm.FormatCSV, gsuitemdm.FormatJSON, gsuitemdm.FormatXLSX)
+}
+
+// Setup the "import" command
+func (ic *ImportCommand) run(c *kingpin.ParseContext) error {
+	// Guess the format if it wasn't specified
+	if ic.Format == "" {
+		ic.Format = strings.TrimPrefix(strings.ToLower(filepath.Ext(ic.File)), ".")
+	}
+
+	// Read the file
+	f, err := os.Open(ic.File)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	devices, err := gsuitemdm.ImportDevices(f, ic.Format)
+	if err != nil {
+		return err
+	}
+
+	// Dry run first, so we can show what will change
+	var rb gsuitemdm.ImportRequest
+	rb.Devices = devices
+	rb.Key = m.Config.APIKey
+
+	ir, err := importDevices(rb)
+	if err != nil {
+		return err
+	}
+
+	if len(ir.Changes) < 1 {
+		fmt.Printf("Import would make no changes.\n")
+		return nil
+	}
+
+	// Show the diff
+	printImportChanges(ir.Changes)
+	fmt.Printf("Import will make %d changes.\n", len(ir.Changes))
+
+	// Check if approval was given
+	if checkUserConfirmation("WARNING: Are you sure you want to IMPORT these changes?") == false {
+		return errors.New("Approval not granted, no changes made.")
+	}
+
+	// Approval has been given, do it for real
+	rb.Confirm = true
+
+	fmt.Printf("Importing devices... ")
+
+	ir, err = importDevices(rb)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf(" done.\n")
+	fmt.Printf("Import made %d changes.\n", len(ir.Changes))
+
+	return nil
+}
+
+// Send an import request and return the response
+func importDevices(rb gsuitemdm.ImportRequest) (*gsuitemdm.ImportResponse, error) {
+	var ir gsuitemdm.ImportResponse
+
+	// Marshal the JSON
+	js, err := json.Marshal(rb)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Build the http request
+	req, err := http.NewRequest("POST", m.Config.ImportDevicesURL, bytes.NewBuffer(js))
+	if err != nil {
+		log.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	// Create an http client
+	client := &http.Client{}
+
+	// Send the request and get a nice response
+	resp, err := client.Do(req)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Was this a bad request?
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.New(strings.TrimSpace(string(body)))
+	}
+
+	// Unmarshal the JSON
+	err = json.Unmarshal(body, &ir)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ir, nil
+}
+
+// Send a search request and return the matching devices
+func searchDevices(rb gsuitemdm.SearchRequest) ([]gsuitemdm.DatastoreMobileDevice, error) {
+	var devices []gsuitemdm.DatastoreMobileDevice
+
+	// Marshal the JSON
+	js, err := json.Marshal(rb)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Build the http request
+	req, err := http.NewRequest("POST", m.Config.SearchDatastoreURL, bytes.NewBuffer(js))
+	if err != nil {
+		log.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	// Create an http client
+	client := &http.Client{}
+
+	// Send the request and get a nice response
+	resp, err := client.Do(req)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	switch resp.StatusCode {
+	// Good response
+	case http.StatusOK:
+		err = json.Unmarshal(body, &devices)
+		if err != nil {
+			return nil, err
+		}
+		return devices, nil
+
+	// Good response but just with no data
+	case http.StatusNoContent:
+		return devices, nil
+	}
+
+	// Bad request
+	return nil, errors.New(strings.TrimSpace(string(body)))
+}
+
+// Print out a list of import changes
+func printImportChanges(changes []gsuitemdm.DeviceChange) {
+	fmt.Printf("%-16.16s | %-12.12s | %-20.20s | %s\n", "SN", "Field", "Old", "New")
+	for _, c := range changes {
+		fmt.Printf("%-16.16s | %-12.12s | %-20.20s | %s\n", c.SN, c.Field, c.Old, c.New)
+	}
+}
+
+// EOF
@@ -0,0 +1,176 @@
+package main
+
+//
+// MDMTool job commands (jobs submit, list, status, cancel)
+//
+//
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/rickt/gsuitemdm"
+	"gopkg.in/alecthomas/kingpin.v2"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// How often to check on a job when waiting for it
+const jobPollInterval = 3 * time.Second
+
+//
+// JOBS
+//
+
+// Add the "jobs" command and its subcommands
+func addJobsCommand(mdmtool *kingpin.Application) {
+	jobs := mdmtool.Command("jobs", "Submit, follow & cancel long-running jobs")
+
+	// jobs submit
+	sc := &JobsCommand{Action: "submit"}
+	submit := jobs.Command("submit", "Submit a job").Action(sc.run)
+	submit.Flag("type", "Type of job: sync, sheet or action (required)").Required().Short('t').EnumVar(&sc.Type, gsuitemdm.JobTypeAction, gsuitemdm.JobTypeSheet, gsuitemdm.JobTypeSync)
+	submit.Flag("action", "Action jobs: action to perform (approve, block, cancelwipe, delete, unblock, wipe)").Short('a').StringVar(&sc.JobAction)
+	submit.Flag("domain", "G Suite domain. Required for action jobs, sync jobs default to all domains").Short('d').StringVar(&sc.Domain)
+	submit.Flag("sn", "Action jobs: serial number of a device (repeatable)").Short('s').StringsVar(&sc.SNs)
+	submit.Flag("then", "Cancel wipe action jobs: activate or block").EnumVar(&sc.Then, "activate", "block")
+	submit.Flag("wipetype", "Wipe action jobs: account or device").EnumVar(&sc.WipeType, gsuitemdm.WipeTypeAccount, gsuitemdm.WipeTypeDevice)
+	submit.Flag("wait", "Wait for the job to finish, showing its progress").Default("true").BoolVar(&sc.Wait)
+
+	// jobs list
+	lc := &JobsCommand{Action: "list"}
+	list := jobs.Command("list", "List recent jobs").Action(lc.run)
+	list.Flag("state", "Only list jobs in this state (queued, running, succeeded, failed, cancelled)").StringVar(&lc.State)
+
+	// jobs status
+	tc := &JobsCommand{Action: "status"}
+	status := jobs.Command("status", "Show a job's progress & results").Action(tc.run)
+	status.Arg("id", "Job ID (required)").Required().StringVar(&tc.ID)
+	status.Flag("wait", "Wait for the job to finish, showing its progress").Short('w').BoolVar(&tc.Wait)
+
+	// jobs cancel
+	cc := &JobsCommand{Action: "cancel"}
+	cancel := jobs.Command("cancel", "Cancel a queued or running job").Action(cc.run)
+	cancel.Arg("id", "Job ID (required)").Required().StringVar(&cc.ID)
+}
+
+// Setup the "jobs" commands
+func (jc *JobsCommand) run(c *kingpin.ParseContext) error {
+	var job gsuitemdm.Job
+	var err error
+
+	switch jc.Action {
+	// Submit a job
+	case "submit":
+		var rb gsuitemdm.JobRequest
+
+		// Action jobs are checked first
+		if jc.Type == gsuitemdm.JobTypeAction {
+			if jc.JobAction == "" || jc.Domain == "" || len(jc.SNs) < 1 {
+				return errors.New("with \"action\" jobs you must specify --action, --domain and at least one --sn")
+			}
+			if checkUserConfirmation(fmt.Sprintf("WARNING: Are you sure you want to %s %d device(s) in domain %s?", strings.ToUpper(jc.JobAction), len(jc.SNs), jc.Domain)) == false {
+				return errors.New("Approval not granted, no change made to devices.")
+			}
+		}
+
+		// Setup the request body
+		rb.By = os.Getenv("USER")
+		rb.Key = m.Config.APIKey
+		rb.Params = gsuitemdm.JobParams{Action: jc.JobAction, Devices: jc.SNs, Domain: jc.Domain, Then: jc.Then, WipeType: jc.WipeType}
+		rb.Type = jc.Type
+
+		js, err := json.Marshal(rb)
+		if err != nil {
+			log.Fatal(err)
+		}
+		err = sendJobsRequest("POST", "", bytes.NewBuffer(js), &job)
+		if err != nil {
+			return err
+		}
+
+	// List jobs
+	case "list":
+		var jobs []gsuitemdm.Job
+
+		path := "?state=" + url.QueryEscape(jc.State)
+		err = sendJobsRequest("GET", path, nil, &jobs)
+		if err != nil {
+			return err
+		}
+		for _, j := range jobs {
+			fmt.Printf("%-26s %-7s %-10s %-25s %s\n", j.ID, j.Type, j.State, j.Created, j.By)
+		}
+		return nil
+
+	// Get a job
+	case "status":
+		err = sendJobsRequest("GET", "/"+url.PathEscape(jc.ID), nil, &job)
+		if err != nil {
+			return err
+		}
+
+	// Cancel a job
+	case "cancel":
+		err = sendJobsRequest("POST", "/"+url.PathEscape(jc.ID)+"/cancel", nil, &job)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Follow the job until it finishes?
+	if jc.Wait == true {
+		for job.State == gsuitemdm.JobStateQueued || job.State == gsuitemdm.JobStateRunning {
+			time.Sleep(jobPollInterval)
+			err = sendJobsRequest("GET", "/"+url.PathEscape(job.ID), nil, &job)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	fmt.Print(job.Text())
+
+	return nil
+}
+
+// Send a request to the jobs API and unmarshal the response
+func sendJobsRequest(method, path string, body io.Reader, v interface{}) error {
+	// Build the http request
+	req, err := http.NewRequest(method, strings.TrimRight(m.Config.JobsURL, "/")+"/v1/jobs"+path, body)
+	if err != nil {
+		log.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-GSuiteMDM-Key", m.Config.APIKey)
+
+	// Create an http client
+	client := &http.Client{}
+
+	// Send the request and get a nice response
+	resp, err := client.Do(req)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	rb, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Was this a bad request?
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusAccepted {
+		return errors.New(strings.TrimSpace(string(rb)))
+	}
+
+	return json.Unmarshal(rb, v)
+}
+
+// EOF
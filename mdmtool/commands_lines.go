@@ -0,0 +1,174 @@
+package main
+
+//
+// MDMTool phone line commands (lines add, delete, link, list, unlink, update)
+//
+//
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/rickt/gsuitemdm"
+	"gopkg.in/alecthomas/kingpin.v2"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strings"
+)
+
+//
+// LINES
+//
+
+// Add the "lines" command and its subcommands
+func addLinesCommand(mdmtool *kingpin.Application) {
+	lines := mdmtool.Command("lines", "Manage carrier phone lines")
+
+	// lines list
+	lc := &LinesCommand{Action: "list"}
+	list := lines.Command("list", "List phone lines").Action(lc.run)
+	list.Flag("unassigned", "Only show phone lines not assigned to any device or user").Short('u').BoolVar(&lc.Unassigned)
+
+	// lines add
+	ac := &LinesCommand{Action: "add"}
+	add := lines.Command("add", "Add a phone line").Action(ac.run)
+	add.Flag("number", "Phone number (required)").Required().Short('n').StringVar(&ac.Number)
+	add.Flag("carrier", "Mobile carrier").StringVar(&ac.Carrier)
+	add.Flag("cost", "Monthly cost").Float64Var(&ac.Cost)
+	add.Flag("notes", "Notes").StringVar(&ac.Notes)
+	add.Flag("plan", "Carrier plan").StringVar(&ac.Plan)
+
+	// lines update
+	uc := &LinesCommand{Action: "update"}
+	update := lines.Command("update", "Update the carrier details of a phone line").Action(uc.run)
+	update.Flag("number", "Phone number (required)").Required().Short('n').StringVar(&uc.Number)
+	update.Flag("carrier", "Mobile carrier").StringVar(&uc.Carrier)
+	update.Flag("cost", "Monthly cost").Float64Var(&uc.Cost)
+	update.Flag("notes", "Notes").StringVar(&uc.Notes)
+	update.Flag("plan", "Carrier plan").StringVar(&uc.Plan)
+
+	// lines delete
+	dc := &LinesCommand{Action: "delete"}
+	del := lines.Command("delete", "Delete an unassigned phone line").Action(dc.run)
+	del.Flag("number", "Phone number (required)").Required().Short('n').StringVar(&dc.Number)
+
+	// lines link
+	kc := &LinesCommand{Action: "link"}
+	link := lines.Command("link", "Assign a phone line to a mobile device").Action(kc.run)
+	link.Flag("number", "Phone number (required)").Required().Short('n').StringVar(&kc.Number)
+	link.Flag("sn", "Serial number of the mobile device (required)").Required().Short('s').StringVar(&kc.SN)
+
+	// lines unlink
+	nc := &LinesCommand{Action: "unlink"}
+	unlink := lines.Command("unlink", "Unassign a phone line from its mobile device").Action(nc.run)
+	unlink.Flag("number", "Phone number (required)").Required().Short('n').StringVar(&nc.Number)
+}
+
+// Setup the "lines" commands
+func (lc *LinesCommand) run(c *kingpin.ParseContext) error {
+	var rb gsuitemdm.PhoneLineRequest
+
+	// Deleting is not undoable, so check first
+	if lc.Action == "delete" {
+		if checkUserConfirmation(fmt.Sprintf("WARNING: Are you sure you want to DELETE phone line %s?", lc.Number)) == false {
+			return errors.New("Approval not granted, no change made to phone line.")
+		}
+	}
+
+	// Setup the request body
+	rb.Action = lc.Action
+	if lc.Action == "list" && lc.Unassigned == true {
+		rb.Action = "unassigned"
+	}
+	rb.Key = m.Config.APIKey
+	rb.Number = lc.Number
+	rb.SN = lc.SN
+
+	// Only send the carrier details that were specified on the command line, so that updating
+	// one field leaves the others alone
+	if flagSet(c, "carrier") {
+		rb.Carrier = &lc.Carrier
+	}
+	if flagSet(c, "cost") {
+		rb.MonthlyCost = &lc.Cost
+	}
+	if flagSet(c, "notes") {
+		rb.Notes = &lc.Notes
+	}
+	if flagSet(c, "plan") {
+		rb.Plan = &lc.Plan
+	}
+	if lc.Action == "update" && rb.Carrier == nil && rb.MonthlyCost == nil && rb.Notes == nil && rb.Plan == nil {
+		return errors.New("with \"lines update\" command you must specify at least one of --carrier, --cost, --notes or --plan")
+	}
+
+	// Marshal the JSON
+	js, err := json.Marshal(rb)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Build the http request
+	req, err := http.NewRequest("POST", m.Config.PhoneLinesURL, bytes.NewBuffer(js))
+	if err != nil {
+		log.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	// Create an http client
+	client := &http.Client{}
+
+	// Send the request and get a nice response
+	resp, err := client.Do(req)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Was this a bad request?
+	if resp.StatusCode != http.StatusOK {
+		return errors.New(strings.TrimSpace(string(body)))
+	}
+
+	// Unmarshal the JSON
+	var lines []gsuitemdm.PhoneLine
+	err = json.Unmarshal(body, &lines)
+	if err != nil {
+		return err
+	}
+
+	// Nothing to show for a delete
+	if lc.Action == "delete" {
+		fmt.Printf("Phone line %s deleted.\n", lc.Number)
+		return nil
+	}
+
+	// Print the phone line(s)
+	printLinesLine()
+	fmt.Printf("Phone Number         | Carrier      | Plan         | Monthly  | Device SN        | Assigned To\n")
+	printLinesLine()
+	for _, pl := range lines {
+		fmt.Printf("%-20.20s | %-12.12s | %-12.12s | %8.2f | %-16.16s | %s\n", gsuitemdm.FormatPhoneNumber(pl.Number, m.Config.DefaultRegion), pl.Carrier, pl.Plan, pl.MonthlyCost, pl.DeviceSN, pl.Email)
+	}
+	printLinesLine()
+
+	if lc.Action == "list" {
+		fmt.Printf("%d phone lines.\n", len(lines))
+	}
+
+	return nil
+}
+
+// Print a correctly formatted line for the phone lines list
+func printLinesLine() {
+	fmt.Printf("---------------------+--------------+--------------+----------+------------------+---------------\n")
+}
+
+// EOF
@@ -0,0 +1,58 @@
+package main
+
+//
+// MDMTool API key commands (login, logout)
+//
+//
+
+import (
+	"errors"
+	"fmt"
+	"gopkg.in/alecthomas/kingpin.v2"
+)
+
+//
+// LOGIN & LOGOUT
+//
+
+// Add the "login" and "logout" commands
+func addLoginCommands(mdmtool *kingpin.Application) {
+	lc := &LoginCommand{Action: "login"}
+	login := mdmtool.Command("login", "Store the API key for a profile in the OS keyring, so it isn't kept in the configuration file").Action(lc.run)
+	login.Flag("file", "Store the API key in a file only you can read, instead of the OS keyring").BoolVar(&lc.File)
+
+	oc := &LoginCommand{Action: "logout"}
+	mdmtool.Command("logout", "Remove the API key stored for a profile").Action(oc.run)
+}
+
+// Setup the "login" and "logout" commands
+func (lc *LoginCommand) run(c *kingpin.ParseContext) error {
+	profile := m.Config.Profile
+
+	if lc.Action == "logout" {
+		err := deleteStoredAPIKey(profile)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("API key for profile %s removed.\n", profile)
+		return nil
+	}
+
+	key, err := readAPIKey()
+	if err != nil {
+		return err
+	}
+	if key == "" {
+		return errors.New("No API key given, nothing stored.")
+	}
+
+	where, err := storeAPIKey(profile, key, lc.File)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("API key for profile %s stored in %s.\n", profile, where)
+
+	return nil
+}
+
+// EOF
@@ -0,0 +1,133 @@
+package main
+
+//
+// MDMTool lost/stolen device commands (found, lost)
+//
+//
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/rickt/gsuitemdm"
+	"gopkg.in/alecthomas/kingpin.v2"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+)
+
+//
+// LOST & FOUND
+//
+
+// Add the "lost" and "found" commands
+func addLostCommands(mdmtool *kingpin.Application) {
+	lc := &LostCommand{Action: "lost"}
+	lost := mdmtool.Command("lost", "Report a mobile device lost: block it now, then wipe it unless it is found").Action(lc.run)
+	lost.Flag("list", "List devices currently reported lost").Short('l').BoolVar(&lc.List)
+	lost.Flag("notes", "Notes about the loss").Short('n').StringVar(&lc.Notes)
+	lost.Flag("sn", "Serial number of the lost device").Short('s').StringVar(&lc.SN)
+
+	fc := &LostCommand{Action: "found"}
+	found := mdmtool.Command("found", "Report a lost mobile device found: cancel pending wipes and restore it").Action(fc.run)
+	found.Flag("notes", "Notes about the find").Short('n').StringVar(&fc.Notes)
+	found.Flag("sn", "Serial number of the found device (required)").Required().Short('s').StringVar(&fc.SN)
+}
+
+// Setup the "lost" and "found" commands
+func (lc *LostCommand) run(c *kingpin.ParseContext) error {
+	var rb gsuitemdm.LostRequest
+
+	// Check runtime options
+	rb.Action = lc.Action
+	switch {
+	case lc.List == true:
+		rb.Action = "list"
+	case lc.SN == "":
+		return errors.New("with \"lost\" command you must specify either --sn or --list")
+	case lc.Action == "lost":
+		if checkUserConfirmation(fmt.Sprintf("WARNING: Are you sure you want to report device SN=%s LOST? It will be blocked now and WIPED later unless found", lc.SN)) == false {
+			return errors.New("Approval not granted, no change made to device.")
+		}
+	}
+
+	// Setup the rest of the request body
+	rb.By = os.Getenv("USER")
+	rb.Key = m.Config.APIKey
+	rb.Notes = lc.Notes
+	rb.SN = lc.SN
+
+	// Marshal the JSON
+	js, err := json.Marshal(rb)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Build the http request
+	req, err := http.NewRequest("POST", m.Config.LostDeviceURL, bytes.NewBuffer(js))
+	if err != nil {
+		log.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	// Create an http client
+	client := &http.Client{}
+
+	// Send the request and get a nice response
+	resp, err := client.Do(req)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Was this a bad request?
+	if resp.StatusCode != http.StatusOK {
+		return errors.New(strings.TrimSpace(string(body)))
+	}
+
+	// Unmarshal the JSON
+	var devices []gsuitemdm.DatastoreMobileDevice
+	err = json.Unmarshal(body, &devices)
+	if err != nil {
+		return err
+	}
+
+	if len(devices) < 1 {
+		fmt.Printf("No devices are reported lost.\n")
+		return nil
+	}
+
+	// Print the device(s) and what has happened to them
+	for _, d := range devices {
+		printLostDevice(d)
+	}
+
+	return nil
+}
+
+// Print a lost device and the steps of its lost report
+func printLostDevice(d gsuitemdm.DatastoreMobileDevice) {
+	fmt.Printf("SN=%s %s owned by %s (%s), status %s\n", d.SN, d.Model, d.Name, d.Email, d.Status)
+	if d.IsLost() {
+		fmt.Printf("   Lost since %s, reported by %s\n", d.Lost.Reported, d.Lost.ReportedBy)
+		if d.Lost.AccountWiped == false && d.Lost.AccountWipeAt != "" {
+			fmt.Printf("   Account wipe due %s\n", d.Lost.AccountWipeAt)
+		}
+		if d.Lost.DeviceWiped == false && d.Lost.DeviceWipeAt != "" {
+			fmt.Printf("   Device wipe due %s\n", d.Lost.DeviceWipeAt)
+		}
+	}
+	for _, s := range d.Lost.Steps {
+		fmt.Printf("   %s  %-18.18s  %-12.12s  %s\n", s.Time, s.Step, s.By, s.Detail)
+	}
+}
+
+// EOF
@@ -0,0 +1,128 @@
+package main
+
+//
+// MDMTool offboarding command (offboard)
+//
+//
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/rickt/gsuitemdm"
+	"gopkg.in/alecthomas/kingpin.v2"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+)
+
+//
+// OFFBOARD
+//
+
+// Add the "offboard" command
+func addOffboardCommand(mdmtool *kingpin.Application) {
+	c := &OffboardCommand{}
+	offboard := mdmtool.Command("offboard", "Block/wipe every mobile device of a departing user, in all domains").Action(c.run)
+	offboard.Flag("email", "Email address of the departing user (required)").Required().Short('e').StringVar(&c.Email)
+	offboard.Flag("plan", "Only show what would be done").Short('p').BoolVar(&c.Plan)
+}
+
+// Setup the "offboard" command
+func (oc *OffboardCommand) run(c *kingpin.ParseContext) error {
+	// Always show the plan first
+	r, err := sendOffboardRequest("plan", oc.Email, false)
+	if err != nil {
+		return err
+	}
+	fmt.Print(r.Text())
+
+	if oc.Plan == true {
+		return nil
+	}
+
+	// Anything to do?
+	var todo int
+	for _, od := range r.Devices {
+		if od.Action != "" {
+			todo++
+		}
+	}
+	if todo < 1 {
+		fmt.Printf("Nothing to do.\n")
+		return nil
+	}
+
+	// Check first
+	if checkUserConfirmation(fmt.Sprintf("WARNING: Are you sure you want to OFFBOARD %s, applying the above to %d device(s)?", oc.Email, todo)) == false {
+		return errors.New("Approval not granted, no change made to devices.")
+	}
+
+	// Do it
+	r, err = sendOffboardRequest("offboard", oc.Email, true)
+	if err != nil {
+		return err
+	}
+	fmt.Print(r.Text())
+
+	return nil
+}
+
+// Send an offboard request and return the report
+func sendOffboardRequest(action, email string, confirm bool) (*gsuitemdm.OffboardReport, error) {
+	var rb gsuitemdm.OffboardRequest
+	var report gsuitemdm.OffboardReport
+
+	// Setup the request body
+	rb.Action = action
+	rb.By = os.Getenv("USER")
+	rb.Confirm = confirm
+	rb.Email = email
+	rb.Key = m.Config.APIKey
+
+	// Marshal the JSON
+	js, err := json.Marshal(rb)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Build the http request
+	req, err := http.NewRequest("POST", m.Config.OffboardURL, bytes.NewBuffer(js))
+	if err != nil {
+		log.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	// Create an http client
+	client := &http.Client{}
+
+	// Send the request and get a nice response
+	resp, err := client.Do(req)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Was this a bad request?
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.New(strings.TrimSpace(string(body)))
+	}
+
+	// Unmarshal the JSON
+	err = json.Unmarshal(body, &report)
+	if err != nil {
+		return nil, err
+	}
+
+	return &report, nil
+}
+
+// EOF
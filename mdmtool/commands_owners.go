@@ -0,0 +1,94 @@
+package main
+
+//
+// MDMTool owner notice commands (notify)
+//
+//
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/rickt/gsuitemdm"
+	"gopkg.in/alecthomas/kingpin.v2"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strings"
+)
+
+//
+// NOTIFY
+//
+
+// Add the "notify" command
+func addNotifyCommand(mdmtool *kingpin.Application) {
+	c := &NotifyCommand{}
+	n := mdmtool.Command("notify", "Send owners of pending & non-compliant mobile devices a notice with a one-time response link").Action(c.run)
+	n.Flag("domain", "Only notify owners of devices in this domain").Short('d').StringVar(&c.Domain)
+	n.Flag("sn", "(Re)send a notice to the owner of the device with this serial number").Short('s').StringVar(&c.SN)
+}
+
+// Setup the "notify" command
+func (nc *NotifyCommand) run(c *kingpin.ParseContext) error {
+	var rb gsuitemdm.OwnerNoticeRequest
+	var response gsuitemdm.OwnerNoticeResponse
+
+	// Setup the request body
+	rb.Domain = nc.Domain
+	rb.Key = m.Config.APIKey
+	rb.SN = nc.SN
+
+	// Marshal the JSON
+	js, err := json.Marshal(rb)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Build the http request
+	req, err := http.NewRequest("POST", m.Config.NotifyOwnersURL, bytes.NewBuffer(js))
+	if err != nil {
+		log.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	// Create an http client
+	client := &http.Client{}
+
+	// Send the request and get a nice response
+	resp, err := client.Do(req)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Was this a bad request?
+	if resp.StatusCode != http.StatusOK {
+		return errors.New(strings.TrimSpace(string(body)))
+	}
+
+	// Unmarshal the JSON
+	err = json.Unmarshal(body, &response)
+	if err != nil {
+		return err
+	}
+
+	// Show what happened
+	for _, sn := range response.Notified {
+		fmt.Printf("Notified owner of device SN=%s\n", sn)
+	}
+	for _, e := range response.Errors {
+		fmt.Printf("%s\n", e)
+	}
+	fmt.Printf("%d owner(s) notified, %d error(s).\n", len(response.Notified), len(response.Errors))
+
+	return nil
+}
+
+// EOF
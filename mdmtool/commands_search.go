@@ -28,13 +28,21 @@ func addDirectoryCommand(mdmtool *kingpin.Application) {
 	dir := mdmtool.Command("dir", "Search the mobile phone directory").Action(c.run)
 	dir.Flag("name", "Search for a phone number using name").Short('n').StringVar(&c.Name)
 	dir.Flag("email", "Search for a phone number using email").Short('e').StringVar(&c.Email)
+	dir.Flag("department", "Search for a phone number using department").Short('p').StringVar(&c.Department)
+	dir.Flag("title", "Search for a phone number using job title").Short('t').StringVar(&c.Title)
 }
 
 // Setup the "directory" command
 func (dr *DirectoryCommand) run(c *kingpin.ParseContext) error {
 	// Check runtime options
-	if (dr.Email == "" && dr.Name == "") || (dr.Email != "" && dr.Name != "") {
-		return errors.New("with \"dir\" command you must specify one of --email or --name")
+	var n int
+	for _, f := range []string{dr.Department, dr.Email, dr.Name, dr.Title} {
+		if f != "" {
+			n++
+		}
+	}
+	if n != 1 {
+		return errors.New("with \"dir\" command you must specify one of --department, --email, --name or --title")
 	}
 
 	// Runtime options are good, lets setup the request body
@@ -54,6 +62,18 @@ func (dr *DirectoryCommand) run(c *kingpin.ParseContext) error {
 		rb.QType = "name"
 		rb.Q = dr.Name
 		break
+
+	// Department
+	case dr.Department != "":
+		rb.QType = "department"
+		rb.Q = dr.Department
+		break
+
+	// Title
+	case dr.Title != "":
+		rb.QType = "title"
+		rb.Q = dr.Title
+		break
 	}
 
 	// Setup the rest of the SEARCH request
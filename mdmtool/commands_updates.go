@@ -61,7 +61,14 @@ func (ud *UpdateDatastoreCommand) run(c *kingpin.ParseContext) error {
 	fmt.Printf(" done.\n")
 
 	body, _ := ioutil.ReadAll(resp.Body)
-	fmt.Println(string(body))
+
+	// Show the result of each domain, if we got it
+	var job gsuitemdm.Job
+	if json.Unmarshal(body, &job) != nil || job.ID == "" {
+		fmt.Println(string(body))
+		return nil
+	}
+	fmt.Print(job.Text())
 
 	return nil
 }
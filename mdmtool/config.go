@@ -0,0 +1,308 @@
+package main
+
+//
+// MDMTool configuration file, profiles & stored API keys
+//
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"github.com/zalando/go-keyring"
+	"golang.org/x/term"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sigs.k8s.io/yaml"
+	"strings"
+)
+
+const (
+	defaultprofile    string = "default"
+	envapikey         string = "MDMTOOL_APIKEY"
+	envbaseurl        string = "MDMTOOL_BASEURL"
+	envconfig         string = "MDMTOOL_CONFIG"
+	envconfigsecretid string = "MDMTOOL_CONFIGSECRETID"
+	envprofile        string = "MDMTOOL_PROFILE"
+)
+
+// Cloud function names, as deployed, and the configuration fields holding their URLs
+func (c *MDMToolConfig) functionURLs() map[string]*string {
+	return map[string]*string{
+		"ApproveDevice":   &c.ApproveDeviceURL,
+		"BlockDevice":     &c.BlockDeviceURL,
+		"CancelWipe":      &c.CancelWipeURL,
+		"DeleteDevice":    &c.DeleteDeviceURL,
+		"Directory":       &c.DirectoryURL,
+		"ImportDevices":   &c.ImportDevicesURL,
+		"Jobs":            &c.JobsURL,
+		"LostDevice":      &c.LostDeviceURL,
+		"NotifyOwners":    &c.NotifyOwnersURL,
+		"Offboard":        &c.OffboardURL,
+		"PhoneLines":      &c.PhoneLinesURL,
+		"SearchDatastore": &c.SearchDatastoreURL,
+		"ShowDomains":     &c.ShowDomainsURL,
+		"UnblockDevice":   &c.UnblockDeviceURL,
+		"UpdateAsset":     &c.UpdateAssetURL,
+		"UpdateDatastore": &c.UpdateDatastoreURL,
+		"UpdateSheet":     &c.UpdateSheetURL,
+		"WipeDevice":      &c.WipeDeviceURL,
+	}
+}
+
+// Get the mdmtool configuration directory, $XDG_CONFIG_HOME/mdmtool or ~/.config/mdmtool
+func configDir() (string, error) {
+	if d := os.Getenv("XDG_CONFIG_HOME"); d != "" {
+		return filepath.Join(d, appname), nil
+	}
+
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(home, ".config", appname), nil
+}
+
+// Get the path of the mdmtool configuration file
+func configFilePath() (string, error) {
+	if f := os.Getenv(envconfig); f != "" {
+		return f, nil
+	}
+
+	d, err := configDir()
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(d, "config.yaml"), nil
+}
+
+// Read the mdmtool configuration file. A missing file is the same as an empty one
+func readConfigFile() (MDMToolConfigFile, string, error) {
+	var cf MDMToolConfigFile
+
+	path, err := configFilePath()
+	if err != nil {
+		return cf, "", err
+	}
+
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return cf, path, nil
+	}
+	if err != nil {
+		return cf, path, err
+	}
+
+	err = yaml.UnmarshalStrict(b, &cf)
+	if err != nil {
+		return cf, path, errors.New(fmt.Sprintf("Error reading %s: %s", path, err))
+	}
+
+	return cf, path, nil
+}
+
+// Work out which profile to use: --profile or $MDMTOOL_PROFILE, then the configuration file's
+// default profile
+func profileName(cf MDMToolConfigFile, profile string) string {
+	if profile != "" {
+		return profile
+	}
+	if cf.Profile != "" {
+		return cf.Profile
+	}
+
+	return defaultprofile
+}
+
+// Load MDMTool configuration for a profile. Values come from, highest first: environment
+// variables, the profile in the configuration file, then API keys stored by "mdmtool login"
+func loadMDMToolConfig(profile string) (MDMToolConfig, error) {
+	cf, path, err := readConfigFile()
+	if err != nil {
+		return MDMToolConfig{}, err
+	}
+
+	name := profileName(cf, profile)
+	p, ok := cf.Profiles[name]
+	if !ok && (len(cf.Profiles) > 0 || name != defaultprofile) {
+		return MDMToolConfig{}, errors.New(fmt.Sprintf("Profile %s not found in %s", name, path))
+	}
+
+	// Environment variables override the profile
+	if v := os.Getenv(envbaseurl); v != "" {
+		p.BaseURL = v
+	}
+	if v := os.Getenv(envapikey); v != "" {
+		p.APIKey = v
+	}
+	if v := os.Getenv(envconfigsecretid); v != "" {
+		p.ConfigSecretID = v
+	}
+
+	c := p.MDMToolConfig
+	c.Profile = name
+
+	// Any cloud function URL not set in the profile lives under the base URL
+	base := strings.TrimRight(p.BaseURL, "/")
+	if base != "" {
+		for fn, url := range c.functionURLs() {
+			if *url == "" {
+				*url = base + "/" + fn
+			}
+		}
+	}
+
+	// Fall back to the API key stored by "mdmtool login"
+	if c.APIKey == "" {
+		c.APIKey, err = getStoredAPIKey(name)
+		if err != nil {
+			return c, err
+		}
+	}
+
+	return c, nil
+}
+
+// Check a configuration has everything needed to call the cloud functions
+func checkMDMToolConfig(c MDMToolConfig) error {
+	var missing []string
+
+	if c.APIKey == "" {
+		missing = append(missing, fmt.Sprintf("an API key (run \"%s login --profile %s\" or set %s)", appname, c.Profile, envapikey))
+	}
+	for _, url := range c.functionURLs() {
+		if *url == "" {
+			missing = append(missing, fmt.Sprintf("a base URL (set baseurl in the %s profile or set %s)", c.Profile, envbaseurl))
+			break
+		}
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+
+	path, _ := configFilePath()
+
+	return errors.New(fmt.Sprintf("Profile %s needs %s. See %s", c.Profile, strings.Join(missing, " and "), path))
+}
+
+// Get the path of the file an API key is stored in when the OS keyring isn't available
+func apiKeyFilePath(profile string) (string, error) {
+	d, err := configDir()
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(d, "apikey."+profile), nil
+}
+
+// Get the API key stored for a profile, from the OS keyring or the profile's key file
+func getStoredAPIKey(profile string) (string, error) {
+	key, err := keyring.Get(appname, profile)
+	if err == nil {
+		return key, nil
+	}
+
+	path, err := apiKeyFilePath(profile)
+	if err != nil {
+		return "", err
+	}
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimSpace(string(b)), nil
+}
+
+// Store the API key for a profile in the OS keyring or, if there isn't one (or usefile is set),
+// in a file only the user can read. Any key stored in the other place is removed, so the new key
+// is the one used. Returns where the key was stored
+func storeAPIKey(profile, key string, usefile bool) (string, error) {
+	path, err := apiKeyFilePath(profile)
+	if err != nil {
+		return "", err
+	}
+
+	if usefile == false {
+		err = keyring.Set(appname, profile, key)
+		if err == nil {
+			err = os.Remove(path)
+			if err != nil && os.IsNotExist(err) == false {
+				return "", err
+			}
+			return "the OS keyring", nil
+		}
+	}
+
+	// The keyring is checked first, so don't leave an old key there. Without a keyring there is
+	// nothing to remove, so only fail if a key can still be read from it
+	err = keyring.Delete(appname, profile)
+	if _, gerr := keyring.Get(appname, profile); gerr == nil {
+		return "", errors.New(fmt.Sprintf("Error removing the API key stored in the OS keyring: %v", err))
+	}
+
+	err = os.MkdirAll(filepath.Dir(path), 0700)
+	if err != nil {
+		return "", err
+	}
+
+	// Write then rename, so a key file is never left half written or readable by others
+	tmp := path + ".tmp"
+	err = ioutil.WriteFile(tmp, []byte(key+"\n"), 0600)
+	if err != nil {
+		return "", err
+	}
+	err = os.Rename(tmp, path)
+	if err != nil {
+		os.Remove(tmp)
+		return "", err
+	}
+
+	return path, nil
+}
+
+// Remove any API key stored for a profile
+func deleteStoredAPIKey(profile string) error {
+	// Without a keyring the key can only be in a file, so keyring errors don't matter
+	keyring.Delete(appname, profile)
+
+	path, err := apiKeyFilePath(profile)
+	if err != nil {
+		return err
+	}
+	err = os.Remove(path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	return nil
+}
+
+// Read an API key from the terminal without echoing it, or from stdin when it isn't a terminal
+func readAPIKey() (string, error) {
+	fd := int(os.Stdin.Fd())
+
+	if term.IsTerminal(fd) {
+		fmt.Print("API key: ")
+		b, err := term.ReadPassword(fd)
+		fmt.Println()
+		if err != nil {
+			return "", err
+		}
+		return strings.TrimSpace(string(b)), nil
+	}
+
+	key, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && key == "" {
+		return "", err
+	}
+
+	return strings.TrimSpace(key), nil
+}
+
+// EOF
@@ -9,7 +9,7 @@ import (
 	"fmt"
 	"github.com/dustin/go-humanize"
 	"github.com/rickt/gsuitemdm"
-	"github.com/ttacon/libphonenumber"
+	"gopkg.in/alecthomas/kingpin.v2"
 	"log"
 	"os"
 	"strings"
@@ -38,22 +38,15 @@ func checkUserConfirmation(s string) bool {
 	}
 }
 
-// Load MDMTool configuration
-func loadMDMToolConfig() (MDMToolConfig, error) {
-	c := MDMToolConfig{
-		APIKey:             apikey,
-		ApproveDeviceURL:   approvedeviceurl,
-		BlockDeviceURL:     blockdeviceurl,
-		DeleteDeviceURL:    deletedeviceurl,
-		DirectoryURL:       directoryurl,
-		SearchDatastoreURL: searchdatastoreurl,
-		ShowDomainsURL:     showdomainsurl,
-		UpdateDatastoreURL: updatedatastoreurl,
-		UpdateSheetURL:     updatesheeturl,
-		WipeDeviceURL:      wipedeviceurl,
+// Helper function to check if a flag was specified on the command line
+func flagSet(c *kingpin.ParseContext, name string) bool {
+	for _, e := range c.Elements {
+		if f, ok := e.Clause.(*kingpin.FlagClause); ok && f.Model().Name == name {
+			return true
+		}
 	}
 
-	return c, nil
+	return false
 }
 
 // Print out mobile device data (Datastore edition)
@@ -66,12 +59,7 @@ func printDeviceData(device gsuitemdm.DatastoreMobileDevice, verbose bool) {
 	}
 
 	// Make telephone numbers pretty again
-	var num *libphonenumber.PhoneNumber
-	var fnum string
-	if device.PhoneNumber != "" {
-		num, err = libphonenumber.Parse(device.PhoneNumber, "US")
-		fnum = libphonenumber.Format(num, libphonenumber.NATIONAL)
-	}
+	fnum := gsuitemdm.FormatPhoneNumber(device.PhoneNumber, m.Config.DefaultRegion)
 
 	// Print detail only if --verbose was specified
 	switch verbose {
@@ -103,6 +91,19 @@ func printDeviceData(device gsuitemdm.DatastoreMobileDevice, verbose bool) {
 		fmt.Printf("   Compromised Status: %s\n", device.CompromisedStatus)
 		fmt.Printf("    Encryption Status: %s\n", device.EncryptionStatus)
 		fmt.Printf("           OS Options: Developer mode (%v), Allow Unknown Sources (%v), USB Debugging (%v)\n", device.DeveloperMode, device.UnknownSources, device.USBADB)
+		fmt.Printf("            Asset Tag: %s\n", device.AssetTag)
+		if device.Ownership != "" {
+			fmt.Printf("            Ownership: %s\n", device.Ownership)
+		} else {
+			fmt.Printf("            Ownership: %s (inferred)\n", gsuitemdm.DeviceOwnership(&device))
+		}
+		fmt.Printf("        Vendor / Cost: %s / %.2f\n", device.Vendor, device.Cost)
+		fmt.Printf("        Purchase Date: %s\n", device.PurchaseDate)
+		fmt.Printf("      Warranty Expiry: %s\n", device.WarrantyExpiry)
+		fmt.Printf("       Carrier / Plan: %s / %s\n", device.Carrier, device.Plan)
+		for _, o := range device.OwnerHistory {
+			fmt.Printf("        Owner History: %s (%.10s - %.10s)\n", o.Email, o.From, o.To)
+		}
 		fmt.Printf("            --- Notes: ---\n%s\n            --- Notes: ---\n", device.Notes)
 
 	}
@@ -111,7 +112,7 @@ func printDeviceData(device gsuitemdm.DatastoreMobileDevice, verbose bool) {
 
 // Print out directory data
 func printDirectoryData(person gsuitemdm.DirectoryData) {
-	fmt.Printf("%-21.21s | %s | %s\n", person.Name, person.PhoneNumber, person.Email)
+	fmt.Printf("%-21.21s | %-14.14s | %-32.32s | %s\n", person.Name, person.PhoneNumber, person.Email, strings.Trim(person.Title+", "+person.Department, ", "))
 
 	// fmt.Printf("%21.21s | %-16.16s | %-14.14s | %-16.16s | %-15.15s | %-13.13s | %-18.18s | %-20.20s\n", device.Domain, device.Model, fnum, device.SN, device.IMEI, device.Status, humanize.Time(lts), device.Name)
 }
@@ -121,7 +122,7 @@ func printDirectoryHeaderLine() {
 	// print the first line of dashes
 	printDirectoryLine()
 	// print header line
-	fmt.Printf("Name                  | Phone Number   | Email \n")
+	fmt.Printf("Name                  | Phone Number   | Email                            | Title, Department\n")
 	// print a line of dashes under the header line
 	printDirectoryLine()
 }
@@ -129,7 +130,7 @@ func printDirectoryHeaderLine() {
 // Print out a correctly formatted line for the phone directory
 func printDirectoryLine() {
 	// print a line
-	fmt.Printf("----------------------+----------------+------------------------------------------\n")
+	fmt.Printf("----------------------+----------------+----------------------------------+------------------------------\n")
 }
 
 // Print out the header
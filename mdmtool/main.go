@@ -6,22 +6,12 @@ package main
 
 import (
 	"gopkg.in/alecthomas/kingpin.v2"
-	"log"
 	"os"
+	"strings"
 )
 
 const (
-	apikey             string = "YOURKEYGOESHERE"
-	appname            string = "mdmtool"
-	approvedeviceurl   string = "https://us-central1-PROJECTID.cloudfunctions.net/ApproveDevice"
-	blockdeviceurl     string = "https://us-central1-PROJECTID.cloudfunctions.net/BlockDevice"
-	deletedeviceurl    string = "https://us-central1-PROJECTID.cloudfunctions.net/DeleteDevice"
-	directoryurl       string = "https://us-central1-PROJECTID.cloudfunctions.net/Directory"
-	searchdatastoreurl string = "https://us-central1-PROJECTID.cloudfunctions.net/SearchDatastore"
-	showdomainsurl     string = "https://us-central1-PROJECTID.cloudfunctions.net/ShowDomains"
-	updatedatastoreurl string = "https://us-central1-PROJECTID.cloudfunctions.net/UpdateDatastore"
-	updatesheeturl     string = "https://us-central1-PROJECTID.cloudfunctions.net/UpdateSheet"
-	wipedeviceurl      string = "https://us-central1-PROJECTID.cloudfunctions.net/WipeDevice"
+	appname string = "mdmtool"
 )
 
 var (
@@ -29,26 +19,35 @@ var (
 )
 
 func main() {
-	var err error
-
-	// Load MDMTool config
-	m.Config, err = loadMDMToolConfig()
-	if err != nil {
-		log.Fatal("Error loading MDMTool configuration")
-	}
-
 	// Create an MDMTool app
 	mdmtool := kingpin.New(appname, "A G Suite MDM Tool")
+	mdmtool.Flag("profile", "Configuration profile to use, e.g. prod or staging").Envar(envprofile).StringVar(&m.Profile)
+
+	// Load MDMTool config for the profile once the command line has been parsed
+	mdmtool.PreAction(loadConfigAction)
 
 	// Add the commands
 	addApproveCommand(mdmtool)         // approve
+	addAssetCommand(mdmtool)           // asset
 	addBlockCommand(mdmtool)           // block
+	addCancelWipeCommand(mdmtool)      // cancel-wipe
+	addConfigCommand(mdmtool)          // config show, validate, add-domain, remove-domain, set
 	addDeleteCommand(mdmtool)          // delete
 	addDirectoryCommand(mdmtool)       // directory
+	addExportCommand(mdmtool)          // export
+	addImportCommand(mdmtool)          // import
+	addJobsCommand(mdmtool)            // jobs submit, list, status, cancel
+	addLinesCommand(mdmtool)           // lines
+	addLoginCommands(mdmtool)          // login, logout
+	addLostCommands(mdmtool)           // lost, found
+	addNotifyCommand(mdmtool)          // notify
+	addOffboardCommand(mdmtool)        // offboard
 	addSearchCommand(mdmtool)          // search
 	addShowDomainsCommand(mdmtool)     // showdomains
+	addUnblockCommand(mdmtool)         // unblock
 	addUpdateDatastoreCommand(mdmtool) // updatedb
 	addUpdateSheetCommand(mdmtool)     // updatesheet
+	addWarrantyCommand(mdmtool)        // warranty
 	addWipeCommand(mdmtool)            // wipe
 
 	// Parse runtime options
@@ -56,4 +55,25 @@ func main() {
 
 }
 
+// Load MDMTool config for the selected profile, checking it's usable by commands that call the
+// cloud functions
+func loadConfigAction(c *kingpin.ParseContext) error {
+	var err error
+
+	m.Config, err = loadMDMToolConfig(m.Profile)
+	if err != nil {
+		return err
+	}
+
+	// login & config don't call the cloud functions
+	if c.SelectedCommand != nil {
+		cmd := strings.Fields(c.SelectedCommand.FullCommand())
+		if len(cmd) > 0 && (cmd[0] == "config" || cmd[0] == "login" || cmd[0] == "logout") {
+			return nil
+		}
+	}
+
+	return checkMDMToolConfig(m.Config)
+}
+
 // EOF
@@ -7,7 +7,8 @@ package main
 import ()
 
 type MDMTool struct {
-	Config MDMToolConfig // MDMTool configuration
+	Config  MDMToolConfig // MDMTool configuration
+	Profile string        // Configuration profile, from --profile or $MDMTOOL_PROFILE
 
 }
 
@@ -15,13 +16,38 @@ type MDMToolConfig struct {
 	APIKey             string `json:"apikey"`             // G Suite MDM API Key
 	ApproveDeviceURL   string `json:"approvedeviceurl"`   // URL of Approve Device cloud function
 	BlockDeviceURL     string `json:"blockdeviceurl"`     // URL of Block Device cloud function
+	CancelWipeURL      string `json:"cancelwipeurl"`      // URL of Cancel Wipe cloud function
+	ConfigSecretID     string `json:"configsecretid"`     // ID of the shared configuration secret
+	DefaultRegion      string `json:"defaultregion"`      // Region phone numbers are shown for, e.g. "GB". Defaults to US
 	DeleteDeviceURL    string `json:"deletedeviceurl"`    // URL of Delete Device cloud function
 	DirectoryURL       string `json:"directoryurl"`       // URL of Directory cloud function
+	ImportDevicesURL   string `json:"importdevicesurl"`   // URL of Import Devices cloud function
+	JobsURL            string `json:"jobsurl"`            // URL of Jobs cloud function
+	LostDeviceURL      string `json:"lostdeviceurl"`      // URL of Lost Device cloud function
+	NotifyOwnersURL    string `json:"notifyownersurl"`    // URL of Notify Owners cloud function
+	OffboardURL        string `json:"offboardurl"`        // URL of Offboard cloud function
+	PhoneLinesURL      string `json:"phonelinesurl"`      // URL of Phone Lines cloud function
+	Profile            string `json:"-"`                  // Name of the profile this configuration was loaded from
 	SearchDatastoreURL string `json:"searchdatastoreurl"` // URL of Search Device cloud function
 	ShowDomainsURL     string `json:"showdomainsurl"`     // URL of Show Domains cloud function
+	UnblockDeviceURL   string `json:"unblockdeviceurl"`   // URL of Unblock Device cloud function
+	UpdateAssetURL     string `json:"updateasseturl"`     // URL of Update Asset cloud function
 	UpdateDatastoreURL string `json:"updatedatastoreurl"` // URL of Update Datastore cloud function
 	UpdateSheetURL     string `json:"updatesheeturl"`     // URL of Update Sheet cloud function
 	WipeDeviceURL      string `json:"wipedeviceurl"`      // URL of Wipe Device cloud function
 }
 
+// The mdmtool configuration file, ~/.config/mdmtool/config.yaml
+type MDMToolConfigFile struct {
+	Profile  string                    `json:"profile"`  // Profile used when --profile isn't given
+	Profiles map[string]MDMToolProfile `json:"profiles"` // Profiles, by name, e.g. prod & staging
+}
+
+// A named set of mdmtool configuration, e.g. for a prod or staging deployment. Cloud function
+// URLs not set are the function's name under BaseURL
+type MDMToolProfile struct {
+	BaseURL string `json:"baseurl"` // Base URL of the deployed cloud functions
+	MDMToolConfig
+}
+
 // EOF
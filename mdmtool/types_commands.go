@@ -4,6 +4,10 @@ package main
 // Types for mdmtool commands
 //
 
+import (
+	"github.com/rickt/gsuitemdm"
+)
+
 // ApproveCommand ...
 type ApproveCommand struct {
 	Domain string
@@ -11,6 +15,19 @@ type ApproveCommand struct {
 	SN     string
 }
 
+// AssetCommand ...
+type AssetCommand struct {
+	AssetTag       string
+	Carrier        string
+	Cost           string
+	Ownership      string
+	Plan           string
+	PurchaseDate   string
+	SN             string
+	Vendor         string
+	WarrantyExpiry string
+}
+
 // BlockCommand ...
 type BlockCommand struct {
 	Domain string
@@ -18,6 +35,27 @@ type BlockCommand struct {
 	SN     string
 }
 
+// CancelWipeCommand ...
+type CancelWipeCommand struct {
+	Domain string
+	IMEI   string
+	SN     string
+	Then   string
+}
+
+// ConfigCommand ...
+type ConfigCommand struct {
+	Action   string
+	Defaults bool
+	Domain   gsuitemdm.DomainConf
+	File     string
+	Format   string
+	Path     string
+	SecretID string
+	Value    string
+	Yes      bool
+}
+
 // DeleteCommand ...
 type DeleteCommand struct {
 	Domain string
@@ -27,8 +65,76 @@ type DeleteCommand struct {
 
 // DirectoryCommand ...
 type DirectoryCommand struct {
+	Department string
+	Email      string
+	Name       string
+	Title      string
+}
+
+// ExportCommand ...
+type ExportCommand struct {
+	Domain string
+	Format string
+	Output string
+	Status string
+}
+
+// ImportCommand ...
+type ImportCommand struct {
+	File   string
+	Format string
+}
+
+// JobsCommand ...
+type JobsCommand struct {
+	Action    string
+	Domain    string
+	ID        string
+	JobAction string
+	SNs       []string
+	State     string
+	Then      string
+	Type      string
+	Wait      bool
+	WipeType  string
+}
+
+// LinesCommand ...
+type LinesCommand struct {
+	Action     string
+	Carrier    string
+	Cost       float64
+	Notes      string
+	Number     string
+	Plan       string
+	SN         string
+	Unassigned bool
+}
+
+// LoginCommand ...
+type LoginCommand struct {
+	Action string
+	File   bool
+}
+
+// LostCommand ...
+type LostCommand struct {
+	Action string
+	List   bool
+	Notes  string
+	SN     string
+}
+
+// NotifyCommand ...
+type NotifyCommand struct {
+	Domain string
+	SN     string
+}
+
+// OffboardCommand ...
+type OffboardCommand struct {
 	Email string
-	Name  string
+	Plan  bool
 }
 
 // SearchCommand ...
@@ -50,6 +156,13 @@ type ShowDomainsCommand struct {
 	Verbose bool
 }
 
+// UnblockCommand ...
+type UnblockCommand struct {
+	Domain string
+	IMEI   string
+	SN     string
+}
+
 // UpdateSheetCommand ...
 type UpdateSheetCommand struct {
 	Verbose bool
@@ -60,11 +173,18 @@ type UpdateDatastoreCommand struct {
 	Verbose bool
 }
 
+// WarrantyCommand ...
+type WarrantyCommand struct {
+	Days   int
+	Domain string
+}
+
 // WipeCommand ...
 type WipeCommand struct {
 	Domain string
 	IMEI   string
 	SN     string
+	Type   string
 }
 
 // EOF
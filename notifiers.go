@@ -0,0 +1,152 @@
+package gsuitemdm
+
+//
+// GSuiteMDM device event notifiers
+//
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Headers set on signed webhook notifications
+const (
+	WebhookSignatureHeader = "X-GSuiteMDM-Signature"
+	WebhookTimestampHeader = "X-GSuiteMDM-Timestamp"
+)
+
+// Create a notifier from its configuration. secret is the retrieved value of nc.SecretID, if any
+func NewNotifier(nc NotifierConf, secret string) (Notifier, error) {
+	if nc.Name == "" {
+		return nil, errors.New("Notifier requires a name")
+	}
+
+	switch nc.Type {
+	case "slack":
+		if nc.URL == "" {
+			return nil, errors.New(fmt.Sprintf("slack notifier %s requires url", nc.Name))
+		}
+		return &SlackNotifier{Conf: nc}, nil
+
+	case "email":
+		if nc.Host == "" || nc.From == "" || len(nc.To) < 1 {
+			return nil, errors.New(fmt.Sprintf("email notifier %s requires host, from and to", nc.Name))
+		}
+		return &EmailNotifier{Conf: nc, Password: secret}, nil
+
+	case "webhook":
+		if nc.URL == "" || secret == "" {
+			return nil, errors.New(fmt.Sprintf("webhook notifier %s requires url and secretid", nc.Name))
+		}
+		return &WebhookNotifier{Conf: nc, Secret: secret}, nil
+	}
+
+	return nil, errors.New(fmt.Sprintf("Unknown notifier type %q", nc.Type))
+}
+
+// Post events to a Slack incoming webhook
+type SlackNotifier struct {
+	Conf NotifierConf
+}
+
+// Name of the notifier
+func (n *SlackNotifier) Name() string {
+	return n.Conf.Name
+}
+
+// Deliver an event
+func (n *SlackNotifier) Notify(e Event) error {
+	req, err := newJSONRequest(n.Conf.URL, SlackMessage{Text: e.Text()})
+	if err != nil {
+		return err
+	}
+
+	return doSendRequest(req, "notification to Slack")
+}
+
+// Email events via SMTP
+type EmailNotifier struct {
+	Conf     NotifierConf
+	Password string
+}
+
+// Name of the notifier
+func (n *EmailNotifier) Name() string {
+	return n.Conf.Name
+}
+
+// Deliver an event
+func (n *EmailNotifier) Notify(e Event) error {
+	// Build the message
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s <%s>\r\n", n.Conf.FromName, n.Conf.From)
+	fmt.Fprintf(&msg, "To: %s\r\n", strings.Join(n.Conf.To, ", "))
+	fmt.Fprintf(&msg, "Subject: %s\r\n", e.Text())
+	fmt.Fprintf(&msg, "MIME-Version: 1.0\r\n")
+	fmt.Fprintf(&msg, "Content-Type: text/plain; charset=\"UTF-8\"\r\n\r\n")
+	fmt.Fprintf(&msg, "%s\r\n\r\nTime: %s\r\nDomain: %s\r\n", e.Text(), e.Time.Format(time.RFC3339), e.Domain)
+	if e.Device != nil {
+		fmt.Fprintf(&msg, "Serial Number: %s\r\nModel: %s\r\nOwner: %s (%s)\r\nStatus: %s\r\n",
+			e.Device.SN, e.Device.Model, e.Device.Name, e.Device.Email, e.Device.Status)
+	}
+
+	err := sendSMTPMail(n.Conf.Host, n.Conf.Port, n.Conf.Username, n.Password, n.Conf.From, n.Conf.To, msg.Bytes())
+	if err != nil {
+		return errors.New(fmt.Sprintf("Error sending notification via SMTP: %s", err))
+	}
+
+	return nil
+}
+
+// POST events as JSON to an HTTP endpoint, signed with HMAC-SHA256
+type WebhookNotifier struct {
+	Conf   NotifierConf
+	Secret string
+}
+
+// Name of the notifier
+func (n *WebhookNotifier) Name() string {
+	return n.Conf.Name
+}
+
+// Deliver an event
+func (n *WebhookNotifier) Notify(e Event) error {
+	js, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest("POST", n.Conf.URL, bytes.NewBuffer(js))
+	if err != nil {
+		return err
+	}
+
+	ts := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(WebhookTimestampHeader, ts)
+	req.Header.Set(WebhookSignatureHeader, SignWebhook(n.Secret, ts, js))
+
+	return doSendRequest(req, "notification to webhook")
+}
+
+// Compute the signature of a webhook notification. Receivers verify a notification by computing
+// this over the X-GSuiteMDM-Timestamp header and the raw request body, and comparing it to the
+// X-GSuiteMDM-Signature header
+func SignWebhook(secret, ts string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte("v0:" + ts + ":"))
+	mac.Write(body)
+
+	return "v0=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// EOF
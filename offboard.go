@@ -0,0 +1,194 @@
+package gsuitemdm
+
+//
+// GSuiteMDM offboarding of departing users' devices
+//
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Default offboarding actions
+const (
+	DefaultOffboardBYODAction      string = ActionAccountWipe
+	DefaultOffboardCorporateAction string = ActionRemoteWipe
+)
+
+// Actions an offboarding plan can take
+var OffboardActions = []string{ActionBlock, ActionAccountWipe, ActionRemoteWipe}
+
+// Get the offboarding action for a device from the configured plan
+func (mdms *GSuiteMDMService) offboardAction(ownership string) (string, error) {
+	action := mdms.C.Offboarding.BYODAction
+	if action == "" {
+		action = DefaultOffboardBYODAction
+	}
+	if ownership == OwnershipCorporate {
+		action = mdms.C.Offboarding.CorporateAction
+		if action == "" {
+			action = DefaultOffboardCorporateAction
+		}
+	}
+
+	if !containsString(OffboardActions, action) {
+		return "", errors.New(fmt.Sprintf("Invalid %s offboarding action %q", ownership, action))
+	}
+	if action != "block" {
+		err := mdms.checkWipeAllowed(action)
+		if err != nil {
+			return "", errors.New(fmt.Sprintf("Invalid %s offboarding action: %s", ownership, err))
+		}
+	}
+
+	return action, nil
+}
+
+// Work out what offboarding a user will do to each of their devices, across all configured
+// domains, without doing anything
+func (mdms *GSuiteMDMService) PlanOffboarding(email, by string, now time.Time) (*OffboardReport, error) {
+	email = strings.ToLower(strings.TrimSpace(email))
+	if email == "" {
+		return nil, errors.New("No email address specified")
+	}
+
+	r := &OffboardReport{By: by, Devices: []OffboardDevice{}, Email: email, Time: now}
+
+	// Find the user's devices in every domain
+	found, errs := mdms.FindAdminSDKDevicesByEmail(email)
+	for _, err := range errs {
+		r.Errors = append(r.Errors, err.Error())
+	}
+
+	var deleteAt string
+	if days := mdms.C.Offboarding.DeleteAfterDays; days > 0 {
+		deleteAt = now.Add(time.Duration(days) * 24 * time.Hour).Format(time.RFC3339)
+	}
+
+	for _, d := range found {
+		// Prefer what we know about the device in Datastore, it has the asset data
+		if ed, err := mdms.GetDatastoreDevice(d.SN); err == nil {
+			ed.Status = d.Status
+			d = ed
+		}
+
+		od := OffboardDevice{Device: d, Ownership: DeviceOwnership(d)}
+		switch {
+		case strings.Contains(d.Status, "WIP"):
+			od.Result = "Already wiped, nothing to do"
+		default:
+			action, err := mdms.offboardAction(od.Ownership)
+			if err != nil {
+				return nil, err
+			}
+			od.Action = action
+			od.DeleteAt = deleteAt
+			od.Result = "Planned"
+		}
+		r.Devices = append(r.Devices, od)
+	}
+
+	return r, nil
+}
+
+// Offboard a user: apply the offboarding plan to each of their devices, record it against the
+// devices, and publish a single consolidated report
+func (mdms *GSuiteMDMService) Offboard(email, by string, now time.Time) (*OffboardReport, error) {
+	r, err := mdms.PlanOffboarding(email, by, now)
+	if err != nil {
+		return nil, err
+	}
+	r.Applied = true
+
+	for i := range r.Devices {
+		od := &r.Devices[i]
+		if od.Action == "" {
+			continue
+		}
+
+		err = mdms.ActionDevice(od.Device, od.Action)
+		if err != nil {
+			od.Error = err.Error()
+			od.Result = "Failed"
+			continue
+		}
+		od.Result = "Done"
+
+		// Record it, so the record can be deleted later
+		od.Device.Offboarded = OffboardRecord{Action: od.Action, By: by, DeleteAt: od.DeleteAt, Email: r.Email, Time: now.Format(time.RFC3339)}
+		err = mdms.PutDatastoreDevice(od.Device)
+		if err != nil {
+			od.Error = err.Error()
+		}
+	}
+
+	// Tell anyone interested
+	mdms.Publish(Event{Domain: getEmailDomain(r.Email), Message: r.Text(), Time: now, Type: EventUserOffboarded})
+
+	return r, nil
+}
+
+// Delete the records of offboarded devices that are due to be deleted from G Suite MDM & Datastore.
+// Returns the serial numbers of the devices deleted. Intended to be run on a schedule
+func (mdms *GSuiteMDMService) ProcessOffboardedDevices(now time.Time) ([]string, []error) {
+	var deleted []string
+	var errs []error
+
+	err := mdms.GetDatastoreData()
+	if err != nil {
+		return nil, []error{err}
+	}
+
+	for i := range mdms.DatastoreData {
+		d := &mdms.DatastoreData[i]
+		if d.Offboarded.DeleteAt == "" || !isDue(d.Offboarded.DeleteAt, now) {
+			continue
+		}
+
+		// The device may already be gone from G Suite, which is fine
+		err = mdms.DeleteAdminSDKDevice(d)
+		if err != nil && !isNotFound(err) {
+			errs = append(errs, errors.New(fmt.Sprintf("Error deleting device SN=%s: %s", d.SN, err)))
+			continue
+		}
+		err = mdms.DeleteDatastoreDevice(d.SN)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		deleted = append(deleted, d.SN)
+	}
+
+	return deleted, errs
+}
+
+// Format an offboarding report for display
+func (r *OffboardReport) Text() string {
+	var b bytes.Buffer
+
+	what := "Offboarding plan"
+	if r.Applied {
+		what = "Offboarding"
+	}
+	fmt.Fprintf(&b, "%s for %s (%d device(s)) by %s at %s\n", what, r.Email, len(r.Devices), r.By, r.Time.Format(time.RFC3339))
+	for _, od := range r.Devices {
+		fmt.Fprintf(&b, "  SN=%s %s %s [%s, %s] action=%s: %s", od.Device.SN, od.Device.Domain, od.Device.Model, od.Ownership, od.Device.Status, od.Action, od.Result)
+		if od.DeleteAt != "" {
+			fmt.Fprintf(&b, ", record deleted %s", od.DeleteAt)
+		}
+		if od.Error != "" {
+			fmt.Fprintf(&b, " (%s)", od.Error)
+		}
+		b.WriteString("\n")
+	}
+	for _, e := range r.Errors {
+		fmt.Fprintf(&b, "  Error: %s\n", e)
+	}
+
+	return b.String()
+}
+
+// EOF
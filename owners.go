@@ -0,0 +1,509 @@
+package gsuitemdm
+
+//
+// GSuiteMDM device owner self-service notices & responses
+//
+
+import (
+	"bytes"
+	"cloud.google.com/go/datastore"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// Why a device owner is contacted
+const (
+	OwnerReasonNoncompliant string = "noncompliant"
+	OwnerReasonPending      string = "pending"
+)
+
+// What a device owner can do with a notice
+const (
+	OwnerActionAcknowledge string = "acknowledge"
+	OwnerActionNotes       string = "notes"
+	OwnerActionWipe        string = "wipe"
+)
+
+// Default owner link validity
+const DefaultOwnerExpiryHours int = 72
+
+const (
+	slackLookupByEmailURL string = "https://slack.com/api/users.lookupByEmail"
+	slackPostMessageURL   string = "https://slack.com/api/chat.postMessage"
+)
+
+// Default owner notice templates
+var DefaultOwnerTemplates = map[string]OwnerTemplate{
+	OwnerReasonPending: {
+		Subject: "Your mobile device {{.Device.Model}} is pending approval",
+		Body: `Hi {{.Device.Name}},
+
+Your mobile device {{.Device.Model}} (serial number {{.Device.SN}}) has been registered with company MDM and is waiting for approval by IT.
+
+Please confirm this is your device, or let us know if something is wrong, here:
+
+{{.Link}}
+
+This link can be used once, and expires {{.Expires}}.
+`,
+	},
+	OwnerReasonNoncompliant: {
+		Subject: "Your mobile device {{.Device.Model}} does not meet company policy",
+		Body: `Hi {{.Device.Name}},
+
+Your mobile device {{.Device.Model}} (serial number {{.Device.SN}}) does not meet company mobile device policy:
+{{range .Problems}}
+  - {{.}}{{end}}
+
+Please fix the above, then acknowledge this message, add a note, or (if the device is lost) request a wipe, here:
+
+{{.Link}}
+
+This link can be used once, and expires {{.Expires}}.
+`,
+	},
+}
+
+// Why a device does not meet policy. An empty slice means the device is compliant
+func DeviceProblems(d *DatastoreMobileDevice) []string {
+	var problems []string
+
+	if isCompromised(d.CompromisedStatus) {
+		problems = append(problems, "Device is compromised (rooted or jailbroken)")
+	}
+	if strings.HasPrefix(strings.ToLower(d.EncryptionStatus), "not") {
+		problems = append(problems, "Device is not encrypted")
+	}
+	if strings.ToLower(d.PasswordStatus) == "off" {
+		problems = append(problems, "Device has no screen lock password")
+	}
+
+	return problems
+}
+
+// Why the owner of a device should be contacted, or an empty string if they shouldn't be
+func OwnerNoticeReason(d *DatastoreMobileDevice) string {
+	switch {
+	case d.Status == "PENDING":
+		return OwnerReasonPending
+	case d.Status == "APPROVED" && len(DeviceProblems(d)) > 0:
+		return OwnerReasonNoncompliant
+	}
+
+	return ""
+}
+
+// Sign an owner link token
+func SignOwnerToken(secret string, t OwnerToken) (string, error) {
+	js, err := json.Marshal(t)
+	if err != nil {
+		return "", err
+	}
+
+	payload := base64.RawURLEncoding.EncodeToString(js)
+
+	return payload + "." + signOwnerPayload(secret, payload), nil
+}
+
+// Check the signature & expiry of an owner link token and return its payload
+func ParseOwnerToken(secret, s string, now time.Time) (*OwnerToken, error) {
+	var t OwnerToken
+
+	parts := strings.Split(s, ".")
+	if len(parts) != 2 {
+		return nil, errors.New("Invalid link")
+	}
+
+	// Check the signature before believing anything in the payload
+	if !hmac.Equal([]byte(parts[1]), []byte(signOwnerPayload(secret, parts[0]))) {
+		return nil, errors.New("Invalid link")
+	}
+
+	js, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, errors.New("Invalid link")
+	}
+	err = json.Unmarshal(js, &t)
+	if err != nil {
+		return nil, errors.New("Invalid link")
+	}
+
+	if now.Unix() > t.Expires {
+		return nil, errors.New("This link has expired")
+	}
+
+	return &t, nil
+}
+
+// Compute the signature of an owner link payload
+func signOwnerPayload(secret, payload string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(payload))
+
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// Get the owner link signing secret
+func (mdms *GSuiteMDMService) ownerSecret() (string, error) {
+	if mdms.C.Owners.SecretID == "" {
+		return "", errors.New("Owner link signing secret (owners.secretid) not configured")
+	}
+
+	secret, err := mdms.GetSecret(mdms.C.Owners.SecretID)
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimSpace(secret), nil
+}
+
+// Send a notice to the owner of a device by email and/or Slack DM, with a signed one-time link
+// to the owner response page. Any previously sent link for the device stops working
+func (mdms *GSuiteMDMService) NotifyOwner(d *DatastoreMobileDevice, reason string, now time.Time) error {
+	if d.Email == "" {
+		return errors.New(fmt.Sprintf("Device SN=%s has no owner", d.SN))
+	}
+	if mdms.C.Owners.ResponseURL == "" {
+		return errors.New("Owner response URL (owners.responseurl) not configured")
+	}
+
+	secret, err := mdms.ownerSecret()
+	if err != nil {
+		return err
+	}
+
+	// Create a new one-time link
+	nonce := make([]byte, 16)
+	_, err = rand.Read(nonce)
+	if err != nil {
+		return err
+	}
+	hours := mdms.C.Owners.ExpiryHours
+	if hours < 1 {
+		hours = DefaultOwnerExpiryHours
+	}
+	expires := now.Add(time.Duration(hours) * time.Hour)
+	t := OwnerToken{Email: d.Email, Expires: expires.Unix(), Nonce: hex.EncodeToString(nonce), SN: d.SN}
+	token, err := SignOwnerToken(secret, t)
+	if err != nil {
+		return err
+	}
+
+	// Render the message
+	tmpl, ok := mdms.C.Owners.Templates[reason]
+	if !ok {
+		tmpl, ok = DefaultOwnerTemplates[reason]
+		if !ok {
+			return errors.New(fmt.Sprintf("Unknown owner notice reason %q", reason))
+		}
+	}
+	data := OwnerTemplateData{
+		Device:   d,
+		Expires:  expires.Format("Mon Jan 2 15:04 MST"),
+		Link:     mdms.C.Owners.ResponseURL + "?t=" + url.QueryEscape(token),
+		Problems: DeviceProblems(d),
+		Reason:   reason,
+	}
+	subject, err := renderOwnerTemplate(tmpl.Subject, data)
+	if err != nil {
+		return err
+	}
+	body, err := renderOwnerTemplate(tmpl.Body, data)
+	if err != nil {
+		return err
+	}
+
+	// Record the notice before sending it, so the link works as soon as it arrives
+	d.OwnerNotice = OwnerNotice{Expires: expires.Format(time.RFC3339), Nonce: t.Nonce, Reason: reason, Sent: now.Format(time.RFC3339)}
+	err = mdms.PutDatastoreDevice(d)
+	if err != nil {
+		return err
+	}
+
+	// Send it
+	return mdms.MessageOwner(d.Email, subject, body)
+}
+
+// Send a message to a device owner by email and/or Slack DM, whichever are configured
+func (mdms *GSuiteMDMService) MessageOwner(email, subject, body string) error {
+	var sent bool
+
+	if mdms.C.Owners.Host != "" {
+		err := mdms.emailOwner(email, subject, body)
+		if err != nil {
+			return err
+		}
+		sent = true
+	}
+	if mdms.C.Owners.SlackTokenID != "" {
+		err := mdms.slackOwner(email, subject+"\n\n"+body)
+		if err != nil {
+			return err
+		}
+		sent = true
+	}
+	if !sent {
+		return errors.New("Neither owner email (owners.host) nor Slack DMs (owners.slacktokenid) are configured")
+	}
+
+	return nil
+}
+
+// Contact the owners of all devices that need it and don't already have an outstanding notice
+// for the same reason. Returns the serial numbers of the devices whose owners were contacted
+func (mdms *GSuiteMDMService) NotifyOwners(devices []DatastoreMobileDevice, now time.Time) ([]string, []error) {
+	var errs []error
+	var notified []string
+
+	for i := range devices {
+		d := &devices[i]
+
+		reason := OwnerNoticeReason(d)
+		if reason == "" {
+			continue
+		}
+
+		// Already told them?
+		if d.OwnerNotice.Reason == reason && d.OwnerNotice.Nonce != "" {
+			exp, err := time.Parse(time.RFC3339, d.OwnerNotice.Expires)
+			if err == nil && now.Before(exp) {
+				continue
+			}
+		}
+
+		err := mdms.NotifyOwner(d, reason, now)
+		if err != nil {
+			errs = append(errs, errors.New(fmt.Sprintf("Error notifying owner of device SN=%s: %s", d.SN, err)))
+			continue
+		}
+		notified = append(notified, d.SN)
+	}
+
+	return notified, errs
+}
+
+// Check an owner link and return the device it is for. The link is not used up
+func (mdms *GSuiteMDMService) GetOwnerDevice(token string, now time.Time) (*DatastoreMobileDevice, error) {
+	secret, err := mdms.ownerSecret()
+	if err != nil {
+		return nil, err
+	}
+
+	t, err := ParseOwnerToken(secret, token, now)
+	if err != nil {
+		return nil, err
+	}
+
+	d, err := mdms.GetDatastoreDevice(t.SN)
+	if err != nil {
+		return nil, err
+	}
+
+	err = checkOwnerLink(d, t)
+	if err != nil {
+		return nil, err
+	}
+
+	return d, nil
+}
+
+// Check an owner link is the device's outstanding one. It isn't if the link has been used,
+// replaced by a newer one, or the device has changed hands
+func checkOwnerLink(d *DatastoreMobileDevice, t *OwnerToken) error {
+	if d.OwnerNotice.Nonce == "" || !hmac.Equal([]byte(d.OwnerNotice.Nonce), []byte(t.Nonce)) ||
+		strings.ToLower(d.Email) != strings.ToLower(t.Email) {
+		return errors.New("This link has already been used")
+	}
+
+	return nil
+}
+
+// Record an owner's response to a notice, using up the link. Notes are kept with the response,
+// and wipe requests are published for IT to action
+func (mdms *GSuiteMDMService) RecordOwnerResponse(token, action, notes, ip string, now time.Time) (*DatastoreMobileDevice, error) {
+	var d DatastoreMobileDevice
+
+	switch action {
+	case OwnerActionAcknowledge, OwnerActionWipe:
+	case OwnerActionNotes:
+		if strings.TrimSpace(notes) == "" {
+			return nil, errors.New("No notes entered")
+		}
+	default:
+		return nil, errors.New(fmt.Sprintf("Invalid action %q", action))
+	}
+
+	secret, err := mdms.ownerSecret()
+	if err != nil {
+		return nil, err
+	}
+
+	t, err := ParseOwnerToken(secret, token, now)
+	if err != nil {
+		return nil, err
+	}
+
+	// Get a Datastore client
+	dc, err := mdms.DatastoreClient()
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("Error creating Datastore client: %s", err))
+	}
+
+	// Check & use up the link in one transaction, so it can only be used once
+	notes = strings.TrimSpace(notes)
+	key := datastore.NameKey(mdms.C.DSNamekey, strings.Replace(t.SN, " ", "", -1), nil)
+	_, err = dc.RunInTransaction(mdms.Ctx, func(tx *datastore.Transaction) error {
+		d = DatastoreMobileDevice{}
+		err := tx.Get(key, &d)
+		if err != nil {
+			return errors.New(fmt.Sprintf("Error retrieving device SN=%s from Datastore: %s", t.SN, err))
+		}
+
+		err = checkOwnerLink(&d, t)
+		if err != nil {
+			return err
+		}
+
+		d.OwnerResponses = append(d.OwnerResponses, OwnerResponse{Action: action, Email: d.Email, Notes: notes, RemoteIP: ip, Time: now.Format(time.RFC3339)})
+		d.OwnerNotice.Nonce = ""
+		_, err = tx.Put(key, &d)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Tell anyone interested
+	msg := fmt.Sprintf("Owner %s responded to %s notice for device %s (%s): %s", d.Email, d.OwnerNotice.Reason, d.SN, d.Model, action)
+	if notes != "" {
+		msg = msg + ": " + notes
+	}
+	mdms.Publish(Event{Device: &d, Message: msg, Time: now, Type: EventOwnerResponded})
+	if action == OwnerActionWipe {
+		mdms.Publish(Event{Device: &d, Time: now, Type: EventWipeRequested,
+			Message: fmt.Sprintf("Owner %s requested a wipe of device %s (%s)", d.Email, d.SN, d.Model)})
+	}
+
+	return &d, nil
+}
+
+// Render an owner notice template
+func renderOwnerTemplate(text string, data OwnerTemplateData) (string, error) {
+	t, err := template.New("owner").Parse(text)
+	if err != nil {
+		return "", errors.New(fmt.Sprintf("Error parsing owner notice template: %s", err))
+	}
+
+	var b bytes.Buffer
+	err = t.Execute(&b, data)
+	if err != nil {
+		return "", errors.New(fmt.Sprintf("Error rendering owner notice template: %s", err))
+	}
+
+	return b.String(), nil
+}
+
+// Email a device owner
+func (mdms *GSuiteMDMService) emailOwner(to, subject, body string) error {
+	var password string
+	if mdms.C.Owners.SMTPSecretID != "" {
+		s, err := mdms.GetSecret(mdms.C.Owners.SMTPSecretID)
+		if err != nil {
+			return err
+		}
+		password = strings.TrimSpace(s)
+	}
+
+	// Build the message
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s <%s>\r\n", mdms.C.Owners.FromName, mdms.C.Owners.From)
+	fmt.Fprintf(&msg, "To: %s\r\n", to)
+	fmt.Fprintf(&msg, "Subject: %s\r\n", subject)
+	fmt.Fprintf(&msg, "MIME-Version: 1.0\r\n")
+	fmt.Fprintf(&msg, "Content-Type: text/plain; charset=\"UTF-8\"\r\n\r\n")
+	msg.WriteString(strings.Replace(body, "\n", "\r\n", -1))
+
+	c := mdms.C.Owners
+	err := sendSMTPMail(c.Host, c.Port, c.Username, password, c.From, []string{to}, msg.Bytes())
+	if err != nil {
+		return errors.New(fmt.Sprintf("Error emailing device owner %s: %s", to, err))
+	}
+
+	return nil
+}
+
+// Send a Slack DM to a device owner, looking them up by email address
+// Refer to https://api.slack.com/methods/users.lookupByEmail & https://api.slack.com/methods/chat.postMessage
+func (mdms *GSuiteMDMService) slackOwner(email, text string) error {
+	var ui SlackUserInfo
+
+	token, err := mdms.GetSecret(mdms.C.Owners.SlackTokenID)
+	if err != nil {
+		return err
+	}
+	token = strings.TrimSpace(token)
+
+	// Find the owner's Slack user
+	req, err := http.NewRequest("GET", slackLookupByEmailURL+"?email="+url.QueryEscape(email), nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	err = doSlackAPIRequest(req, &ui)
+	if err != nil {
+		return errors.New(fmt.Sprintf("Error looking up Slack user %s: %s", email, err))
+	}
+	if !ui.Ok {
+		return errors.New(fmt.Sprintf("Error looking up Slack user %s: %s", email, ui.Error))
+	}
+
+	// DM them
+	req, err = newJSONRequest(slackPostMessageURL, SlackMessage{Channel: ui.User.ID, Text: text})
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	var pm struct {
+		Error string `json:"error"`
+		Ok    bool   `json:"ok"`
+	}
+	err = doSlackAPIRequest(req, &pm)
+	if err != nil {
+		return errors.New(fmt.Sprintf("Error sending Slack DM to %s: %s", email, err))
+	}
+	if !pm.Ok {
+		return errors.New(fmt.Sprintf("Error sending Slack DM to %s: %s", email, pm.Error))
+	}
+
+	return nil
+}
+
+// Send a Slack Web API request and decode the response
+func doSlackAPIRequest(req *http.Request, v interface{}) error {
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(body, v)
+}
+
+// EOF
@@ -0,0 +1,99 @@
+package gsuitemdm
+
+//
+// GSuiteMDM device owner self-service tests
+//
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Owner links only parse with the right secret, untampered and before they expire
+func TestParseOwnerToken(t *testing.T) {
+	secret := "owner-link-secret"
+	now := time.Unix(1700000000, 0)
+	tok := OwnerToken{Email: "jane@example.com", Expires: now.Add(time.Hour).Unix(), Nonce: "abc123", SN: "AB123"}
+
+	signed, err := SignOwnerToken(secret, tok)
+	if err != nil {
+		t.Fatalf("SignOwnerToken() error = %v", err)
+	}
+	parts := strings.Split(signed, ".")
+
+	// A payload for another device, signed with the original signature
+	other := tok
+	other.SN = "ZZ999"
+	js, _ := json.Marshal(other)
+	swapped := base64.RawURLEncoding.EncodeToString(js) + "." + parts[1]
+
+	tests := []struct {
+		name    string
+		secret  string
+		token   string
+		now     time.Time
+		wanterr string
+	}{
+		{name: "valid", secret: secret, token: signed, now: now},
+		{name: "valid at expiry", secret: secret, token: signed, now: time.Unix(tok.Expires, 0)},
+		{name: "expired", secret: secret, token: signed, now: time.Unix(tok.Expires+1, 0), wanterr: "This link has expired"},
+		{name: "wrong secret", secret: "another-secret", token: signed, now: now, wanterr: "Invalid link"},
+		{name: "payload swapped", secret: secret, token: swapped, now: now, wanterr: "Invalid link"},
+		{name: "signature tampered", secret: secret, token: parts[0] + "." + strings.ToUpper(parts[1]), now: now, wanterr: "Invalid link"},
+		{name: "no signature", secret: secret, token: parts[0], now: now, wanterr: "Invalid link"},
+		{name: "extra part", secret: secret, token: signed + ".x", now: now, wanterr: "Invalid link"},
+		{name: "empty", secret: secret, token: "", now: now, wanterr: "Invalid link"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseOwnerToken(tt.secret, tt.token, tt.now)
+			if tt.wanterr != "" {
+				if err == nil || err.Error() != tt.wanterr {
+					t.Fatalf("ParseOwnerToken() error = %v, want %q", err, tt.wanterr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseOwnerToken() error = %v", err)
+			}
+			if !reflect.DeepEqual(*got, tok) {
+				t.Errorf("ParseOwnerToken() = %+v, want %+v", *got, tok)
+			}
+		})
+	}
+}
+
+// Owner links can only be used while they are the device's outstanding notice
+func TestCheckOwnerLink(t *testing.T) {
+	tok := &OwnerToken{Email: "Jane@Example.com", Nonce: "abc123", SN: "AB123"}
+
+	tests := []struct {
+		name    string
+		email   string
+		nonce   string
+		wanterr bool
+	}{
+		{name: "outstanding", email: "jane@example.com", nonce: "abc123"},
+		{name: "already used", email: "jane@example.com", nonce: "", wanterr: true},
+		{name: "replaced by newer notice", email: "jane@example.com", nonce: "def456", wanterr: true},
+		{name: "device changed hands", email: "john@example.com", nonce: "abc123", wanterr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := &DatastoreMobileDevice{Email: tt.email, SN: "AB123"}
+			d.OwnerNotice.Nonce = tt.nonce
+			err := checkOwnerLink(d, tok)
+			if (err != nil) != tt.wanterr {
+				t.Errorf("checkOwnerLink() error = %v, wanterr %v", err, tt.wanterr)
+			}
+		})
+	}
+}
+
+// EOF
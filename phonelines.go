@@ -0,0 +1,308 @@
+package gsuitemdm
+
+//
+// GSuiteMDM carrier phone line funcs
+//
+
+import (
+	"cloud.google.com/go/datastore"
+	"errors"
+	"fmt"
+	"github.com/ttacon/libphonenumber"
+	"strings"
+)
+
+// Region used to parse phone numbers when none has been configured
+const DefaultPhoneRegion string = "US"
+
+// Get the configured default phone number region
+func (mdms *GSuiteMDMService) phoneRegion() string {
+	if mdms.C.DefaultRegion == "" {
+		return DefaultPhoneRegion
+	}
+
+	return strings.ToUpper(mdms.C.DefaultRegion)
+}
+
+// Normalise a phone number to E.164 format (e.g. +12135551212). Numbers that are not in
+// international format are parsed as being in the specified region (or DefaultPhoneRegion).
+func NormalizePhoneNumber(number, region string) (string, error) {
+	if region == "" {
+		region = DefaultPhoneRegion
+	}
+
+	num, err := libphonenumber.Parse(number, strings.ToUpper(region))
+	if err != nil {
+		return "", errors.New(fmt.Sprintf("Invalid phone number %q: %s", number, err))
+	}
+	if !libphonenumber.IsValidNumber(num) {
+		return "", errors.New(fmt.Sprintf("Invalid phone number %q", number))
+	}
+
+	return libphonenumber.Format(num, libphonenumber.E164), nil
+}
+
+// Format a phone number for display. Numbers in the specified region are formatted in national
+// format (e.g. "(213) 555-1212"), all others in international format (e.g. "+44 20 7946 0000").
+// Numbers that cannot be parsed are returned unchanged.
+func FormatPhoneNumber(number, region string) string {
+	if number == "" {
+		return ""
+	}
+	region = strings.ToUpper(region)
+	if region == "" {
+		region = DefaultPhoneRegion
+	}
+
+	num, err := libphonenumber.Parse(number, region)
+	if err != nil {
+		return number
+	}
+
+	if libphonenumber.GetRegionCodeForNumber(num) == region {
+		return libphonenumber.Format(num, libphonenumber.NATIONAL)
+	}
+
+	return libphonenumber.Format(num, libphonenumber.INTERNATIONAL)
+}
+
+// Do two phone numbers refer to the same line? Numbers are compared in E.164 format, so
+// "(213) 555-1212" matches "+12135551212". Numbers that cannot be parsed are compared digit by digit
+func (mdms *GSuiteMDMService) PhoneNumbersMatch(a, b string) bool {
+	if a == "" || b == "" {
+		return a == b
+	}
+
+	na, erra := NormalizePhoneNumber(a, mdms.phoneRegion())
+	nb, errb := NormalizePhoneNumber(b, mdms.phoneRegion())
+	if erra == nil && errb == nil {
+		return na == nb
+	}
+
+	return phoneDigits(a) == phoneDigits(b)
+}
+
+// Get just the digits of a phone number
+func phoneDigits(number string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= '0' && r <= '9' {
+			return r
+		}
+		return -1
+	}, number)
+}
+
+// Get all phone lines from Google Cloud Datastore
+func (mdms *GSuiteMDMService) GetPhoneLines() ([]PhoneLine, error) {
+	var lines []PhoneLine
+
+	// Get a Datastore client
+	dc, err := mdms.DatastoreClient()
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("Error creating Datastore client: %s", err))
+	}
+
+	// Get all phone lines
+	_, err = dc.GetAll(mdms.Ctx, datastore.NewQuery(mdms.C.PLNamekey).Order("Number"), &lines)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("Error querying Datastore for phone lines: %s", err))
+	}
+
+	return lines, nil
+}
+
+// Get a single phone line from Google Cloud Datastore
+func (mdms *GSuiteMDMService) GetPhoneLine(number string) (*PhoneLine, error) {
+	var pl = new(PhoneLine)
+
+	// Phone lines are keyed by their E.164 number
+	e164, err := NormalizePhoneNumber(number, mdms.phoneRegion())
+	if err != nil {
+		return nil, err
+	}
+
+	// Get a Datastore client
+	dc, err := mdms.DatastoreClient()
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("Error creating Datastore client: %s", err))
+	}
+
+	err = dc.Get(mdms.Ctx, datastore.NameKey(mdms.C.PLNamekey, e164, nil), pl)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("Error retrieving phone line %s from Datastore: %s", e164, err))
+	}
+
+	return pl, nil
+}
+
+// Save a single phone line in Google Cloud Datastore. The number is normalised to E.164 first
+func (mdms *GSuiteMDMService) PutPhoneLine(pl *PhoneLine) error {
+	var err error
+
+	// Phone lines are keyed by their E.164 number
+	pl.Number, err = NormalizePhoneNumber(pl.Number, mdms.phoneRegion())
+	if err != nil {
+		return err
+	}
+
+	// Get a Datastore client
+	dc, err := mdms.DatastoreClient()
+	if err != nil {
+		return errors.New(fmt.Sprintf("Error creating Datastore client: %s", err))
+	}
+
+	_, err = dc.Put(mdms.Ctx, datastore.NameKey(mdms.C.PLNamekey, pl.Number, nil), pl)
+	if err != nil {
+		return errors.New(fmt.Sprintf("Error saving phone line %s to Datastore: %s", pl.Number, err))
+	}
+
+	return nil
+}
+
+// Update the carrier details of a phone line. Only fields that are set in the request are changed
+func (mdms *GSuiteMDMService) UpdatePhoneLine(req PhoneLineRequest) (*PhoneLine, error) {
+	// Get the phone line
+	pl, err := mdms.GetPhoneLine(req.Number)
+	if err != nil {
+		return nil, err
+	}
+
+	// Update whatever was specified
+	if req.Carrier != nil {
+		pl.Carrier = strings.TrimSpace(*req.Carrier)
+	}
+	if req.MonthlyCost != nil {
+		pl.MonthlyCost = *req.MonthlyCost
+	}
+	if req.Notes != nil {
+		pl.Notes = *req.Notes
+	}
+	if req.Plan != nil {
+		pl.Plan = strings.TrimSpace(*req.Plan)
+	}
+
+	// Save the phone line
+	err = mdms.PutPhoneLine(pl)
+	if err != nil {
+		return nil, err
+	}
+
+	return pl, nil
+}
+
+// Delete a single phone line from Google Cloud Datastore
+func (mdms *GSuiteMDMService) DeletePhoneLine(number string) error {
+	// Make sure the line exists (and is not assigned) before deleting it
+	pl, err := mdms.GetPhoneLine(number)
+	if err != nil {
+		return err
+	}
+	if pl.DeviceSN != "" {
+		return errors.New(fmt.Sprintf("Phone line %s is assigned to device SN=%s, unlink it first", pl.Number, pl.DeviceSN))
+	}
+
+	// Get a Datastore client
+	dc, err := mdms.DatastoreClient()
+	if err != nil {
+		return errors.New(fmt.Sprintf("Error creating Datastore client: %s", err))
+	}
+
+	return dc.Delete(mdms.Ctx, datastore.NameKey(mdms.C.PLNamekey, pl.Number, nil))
+}
+
+// Link a phone line to a device. The device's owner becomes the line's assigned user and the
+// device's phone number is updated to match the line.
+func (mdms *GSuiteMDMService) LinkPhoneLine(number, sn string) (*PhoneLine, error) {
+	// Get the phone line
+	pl, err := mdms.GetPhoneLine(number)
+	if err != nil {
+		return nil, err
+	}
+
+	// Get the device
+	d, err := mdms.GetDatastoreDevice(sn)
+	if err != nil {
+		return nil, err
+	}
+
+	// Is the line already linked to a different device?
+	if pl.DeviceSN != "" && pl.DeviceSN != d.SN {
+		return nil, errors.New(fmt.Sprintf("Phone line %s is already assigned to device SN=%s, unlink it first", pl.Number, pl.DeviceSN))
+	}
+
+	// Link the line to the device
+	pl.DeviceSN = d.SN
+	pl.Email = d.Email
+	pl.Name = d.Name
+	err = mdms.PutPhoneLine(pl)
+	if err != nil {
+		return nil, err
+	}
+
+	// And the device to the line. The sheet owns device phone numbers, so write it there too or
+	// the next sync would put the old number back
+	d.PhoneNumber = pl.Number
+	err = mdms.UpdateSheetDevices([]DatastoreMobileDevice{*d})
+	if err != nil {
+		return nil, err
+	}
+	err = mdms.PutDatastoreDevice(d)
+	if err != nil {
+		return nil, err
+	}
+
+	return pl, nil
+}
+
+// Unlink a phone line from its device & user
+func (mdms *GSuiteMDMService) UnlinkPhoneLine(number string) (*PhoneLine, error) {
+	// Get the phone line
+	pl, err := mdms.GetPhoneLine(number)
+	if err != nil {
+		return nil, err
+	}
+
+	// Clear the device's phone number, but only if it still matches this line
+	if pl.DeviceSN != "" {
+		d, err := mdms.GetDatastoreDevice(pl.DeviceSN)
+		if err == nil {
+			if mdms.PhoneNumbersMatch(d.PhoneNumber, pl.Number) {
+				d.PhoneNumber = ""
+				err = mdms.UpdateSheetDevices([]DatastoreMobileDevice{*d})
+				if err != nil {
+					return nil, err
+				}
+				err = mdms.PutDatastoreDevice(d)
+				if err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+
+	// Unlink the line
+	pl.DeviceSN = ""
+	pl.Email = ""
+	pl.Name = ""
+	err = mdms.PutPhoneLine(pl)
+	if err != nil {
+		return nil, err
+	}
+
+	return pl, nil
+}
+
+// Get phone lines that are not assigned to a device or a user
+func UnassignedPhoneLines(lines []PhoneLine) []PhoneLine {
+	var unassigned []PhoneLine
+
+	for _, pl := range lines {
+		if pl.DeviceSN == "" && pl.Email == "" {
+			unassigned = append(unassigned, pl)
+		}
+	}
+
+	return unassigned
+}
+
+// EOF
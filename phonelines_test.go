@@ -0,0 +1,76 @@
+package gsuitemdm
+
+//
+// GSuiteMDM carrier phone line tests
+//
+
+import (
+	"testing"
+)
+
+// Phone numbers in any format are normalised to E.164
+func TestNormalizePhoneNumber(t *testing.T) {
+	tests := []struct {
+		name    string
+		number  string
+		region  string
+		want    string
+		wanterr bool
+	}{
+		{name: "national", number: "(213) 555-1212", want: "+12135551212"},
+		{name: "dashes", number: "213-555-1212", want: "+12135551212"},
+		{name: "spaces", number: "213 555 1212", want: "+12135551212"},
+		{name: "digits only", number: "2135551212", want: "+12135551212"},
+		{name: "with country code", number: "1 213 555 1212", want: "+12135551212"},
+		{name: "already e164", number: "+12135551212", want: "+12135551212"},
+		{name: "international", number: "+44 20 7946 0000", want: "+442079460000"},
+		{name: "national in region", number: "020 7946 0000", region: "GB", want: "+442079460000"},
+		{name: "lower case region", number: "020 7946 0000", region: "gb", want: "+442079460000"},
+		{name: "too short", number: "555-1212", wanterr: true},
+		{name: "not a number", number: "not a number", wanterr: true},
+		{name: "empty", number: "", wanterr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := NormalizePhoneNumber(tt.number, tt.region)
+			if (err != nil) != tt.wanterr {
+				t.Fatalf("NormalizePhoneNumber(%q, %q) error = %v, wanterr %v", tt.number, tt.region, err, tt.wanterr)
+			}
+			if got != tt.want {
+				t.Errorf("NormalizePhoneNumber(%q, %q) = %q, want %q", tt.number, tt.region, got, tt.want)
+			}
+		})
+	}
+}
+
+// Phone numbers in different formats match if they are the same line
+func TestPhoneNumbersMatch(t *testing.T) {
+	mdms := &GSuiteMDMService{}
+
+	tests := []struct {
+		name string
+		a    string
+		b    string
+		want bool
+	}{
+		{name: "same format", a: "+12135551212", b: "+12135551212", want: true},
+		{name: "national vs e164", a: "(213) 555-1212", b: "+12135551212", want: true},
+		{name: "dashes vs digits", a: "213-555-1212", b: "2135551212", want: true},
+		{name: "different numbers", a: "+12135551212", b: "+12135551213", want: false},
+		{name: "unparseable digits match", a: "555-12", b: "55512", want: true},
+		{name: "unparseable digits differ", a: "555-12", b: "55513", want: false},
+		{name: "one empty", a: "", b: "+12135551212", want: false},
+		{name: "both empty", a: "", b: "", want: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := mdms.PhoneNumbersMatch(tt.a, tt.b); got != tt.want {
+				t.Errorf("PhoneNumbersMatch(%q, %q) = %v, want %v", tt.a, tt.b, got, tt.want)
+			}
+		})
+	}
+}
+
+// EOF
@@ -0,0 +1,55 @@
+package gsuitemdm
+
+//
+// GSuiteMDM Pub/Sub funcs, used to hand work to background cloud functions
+//
+
+import (
+	"cloud.google.com/go/pubsub"
+	"context"
+	"errors"
+	"fmt"
+)
+
+// A message delivered to a background cloud function by a Pub/Sub trigger
+type PubSubMessage struct {
+	Data []byte `json:"data"` // Message payload
+}
+
+// Runs jobs by publishing their IDs to a Pub/Sub topic. A background cloud function subscribed
+// to the topic runs them, so the function that submitted a job can respond straight away
+type PubSubJobWorker struct {
+	ProjectID string // GCP project of the topic
+	Topic     string // Topic ID
+}
+
+// Publish a message to a Pub/Sub topic, waiting until Pub/Sub has accepted it
+func PublishMessage(ctx context.Context, projectid, topic string, data []byte) error {
+	client, err := pubsub.NewClient(ctx, projectid)
+	if err != nil {
+		return errors.New(fmt.Sprintf("Error creating Pub/Sub client: %s", err))
+	}
+	defer client.Close()
+
+	t := client.Topic(topic)
+	defer t.Stop()
+
+	_, err = t.Publish(ctx, &pubsub.Message{Data: data}).Get(ctx)
+	if err != nil {
+		return errors.New(fmt.Sprintf("Error publishing to Pub/Sub topic %s: %s", topic, err))
+	}
+
+	return nil
+}
+
+// Get a job worker that publishes job IDs to a Pub/Sub topic
+func NewPubSubJobWorker(projectid, topic string) *PubSubJobWorker {
+	return &PubSubJobWorker{ProjectID: projectid, Topic: topic}
+}
+
+// Hand a queued job to the background cloud function that runs jobs
+func (w *PubSubJobWorker) Start(mdms *GSuiteMDMService, job *Job) error {
+	return PublishMessage(mdms.Ctx, w.ProjectID, w.Topic, []byte(job.ID))
+}
+
+// EOF
@@ -0,0 +1,140 @@
+package gsuitemdm
+
+//
+// GSuiteMDM configuration hot reload for long-running servers
+//
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Default seconds between checks for a new configuration
+const DefaultConfigReloadSeconds int = 60
+
+// Reloads a configuration when it changes, so long-running servers pick up new configuration
+// versions without a restart. Only the configuration is shared: each request gets a service of its
+// own from Service(), so requests already running keep the configuration they started with and
+// never share device data
+type ConfigWatcher struct {
+	Interval time.Duration                       // How often Run checks the configuration
+	Jobs     JobWorker                           // Job worker given to each service (optional). If nil, the configuration's is used
+	OnReload func(cf GSuiteMDMConfig, err error) // Called by Run after each reload or failed reload (optional)
+
+	cf     GSuiteMDMConfig // Current configuration, loaded & validated
+	ctx    context.Context
+	mu     sync.Mutex // Guards cf & raw
+	raw    []byte     // Current configuration, as read
+	source string
+}
+
+// Load a configuration, ready to watch for changes. source is the same as for LoadConfig,
+// usually a file path or secret ID
+func NewConfigWatcher(ctx context.Context, source string) (*ConfigWatcher, error) {
+	w := &ConfigWatcher{
+		Interval: time.Duration(DefaultConfigReloadSeconds) * time.Second,
+		ctx:      ctx,
+		source:   source,
+	}
+
+	_, err := w.Reload()
+	if err != nil {
+		return nil, err
+	}
+
+	return w, nil
+}
+
+// Get the current configuration
+func (w *ConfigWatcher) Config() GSuiteMDMConfig {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return w.cf
+}
+
+// Get a new service using the current configuration
+func (w *ConfigWatcher) Service(ctx context.Context) (*GSuiteMDMService, error) {
+	mdms, err := newService(ctx, w.Config())
+	if err != nil {
+		return nil, err
+	}
+	if w.Jobs != nil {
+		mdms.Jobs = w.Jobs
+	}
+
+	return mdms, nil
+}
+
+// Check the configuration, returning whether it has changed. A configuration that fails to load or
+// validate is not used, and the current configuration is kept
+func (w *ConfigWatcher) Reload() (bool, error) {
+	raw, err := readConfigSource(w.ctx, w.source)
+	if err != nil {
+		return false, err
+	}
+
+	// Has it changed?
+	w.mu.Lock()
+	same := w.raw != nil && bytes.Equal(raw, w.raw)
+	w.mu.Unlock()
+	if same {
+		return false, nil
+	}
+
+	cf, err := ParseConfig(raw)
+	if err != nil {
+		return false, err
+	}
+	cf.SetDefaults()
+	err = cf.Validate()
+	if err != nil {
+		return false, err
+	}
+
+	// Make sure services can be created from it, e.g. that its secret provider can be used
+	_, err = newService(w.ctx, cf)
+	if err != nil {
+		return false, err
+	}
+
+	w.mu.Lock()
+	w.cf = cf
+	w.raw = raw
+	w.mu.Unlock()
+
+	return true, nil
+}
+
+// Check for a new configuration every Interval, until ctx is done
+func (w *ConfigWatcher) Run(ctx context.Context) {
+	t := time.NewTicker(w.Interval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			// Secrets are cached, so make sure we get the latest version
+			s := strings.TrimSpace(w.source)
+			if strings.HasPrefix(s, ConfigSecretPrefix) || strings.HasPrefix(s, "projects/") {
+				InvalidateSecret(strings.TrimPrefix(s, ConfigSecretPrefix))
+			}
+
+			changed, err := w.Reload()
+			if err != nil {
+				log.Printf("Error reloading configuration, keeping the current configuration: %s", err)
+			}
+			if (changed || err != nil) && w.OnReload != nil {
+				w.OnReload(w.Config(), err)
+			}
+		}
+	}
+}
+
+// EOF
@@ -0,0 +1,106 @@
+package gsuitemdm
+
+//
+// GSuiteMDM configuration hot reload tests
+//
+
+import (
+	"context"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+// A valid configuration for a project
+func testConfigJSON(projectid string) string {
+	return `{"projectid": "` + projectid + `", "domains": [{"adminuser": "admin@example.com", "companyid": "C01abc123", "domainname": "example.com", "secretid": "example-com-creds"}]}`
+}
+
+// Only valid, changed configurations are used
+func TestConfigWatcherReload(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "gsuitemdm_conf.json")
+
+	err := ioutil.WriteFile(path, []byte(testConfigJSON("first-project")), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w, err := NewConfigWatcher(ctx, path)
+	if err != nil {
+		t.Fatalf("NewConfigWatcher() error = %v", err)
+	}
+
+	// Each step runs in order against the same watcher
+	tests := []struct {
+		name        string
+		config      string
+		wantchanged bool
+		wanterr     bool
+		wantproject string
+	}{
+		{name: "unchanged", config: testConfigJSON("first-project"), wantproject: "first-project"},
+		{name: "invalid json", config: `{"projectid": "second-project",`, wanterr: true, wantproject: "first-project"},
+		{name: "unknown field", config: `{"projectid": "second-project", "sheetcreds": "x"}`, wanterr: true, wantproject: "first-project"},
+		{name: "fails validation", config: `{"projectid": "second-project"}`, wanterr: true, wantproject: "first-project"},
+		{name: "invalid secret provider", config: `{"projectid": "second-project", "secrets": {"provider": "file"}, "domains": [{"adminuser": "admin@example.com", "companyid": "C01abc123", "domainname": "example.com", "secretid": "example-com-creds"}]}`, wanterr: true, wantproject: "first-project"},
+		{name: "changed", config: testConfigJSON("second-project"), wantchanged: true, wantproject: "second-project"},
+		{name: "unchanged after change", config: testConfigJSON("second-project"), wantproject: "second-project"},
+	}
+
+	for _, tt := range tests {
+		err := ioutil.WriteFile(path, []byte(tt.config), 0600)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		changed, err := w.Reload()
+		if (err != nil) != tt.wanterr {
+			t.Errorf("%s: Reload() error = %v, wanterr %v", tt.name, err, tt.wanterr)
+		}
+		if changed != tt.wantchanged {
+			t.Errorf("%s: Reload() changed = %v, want %v", tt.name, changed, tt.wantchanged)
+		}
+
+		mdms, err := w.Service(ctx)
+		if err != nil {
+			t.Fatalf("%s: Service() error = %v", tt.name, err)
+		}
+		if mdms.C.ProjectID != tt.wantproject {
+			t.Errorf("%s: Service() project = %q, want %q", tt.name, mdms.C.ProjectID, tt.wantproject)
+		}
+	}
+}
+
+// Each request gets a service of its own, so device data isn't shared
+func TestConfigWatcherService(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "gsuitemdm_conf.json")
+
+	err := ioutil.WriteFile(path, []byte(testConfigJSON("first-project")), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w, err := NewConfigWatcher(ctx, path)
+	if err != nil {
+		t.Fatalf("NewConfigWatcher() error = %v", err)
+	}
+
+	a, err := w.Service(ctx)
+	if err != nil {
+		t.Fatalf("Service() error = %v", err)
+	}
+	b, err := w.Service(ctx)
+	if err != nil {
+		t.Fatalf("Service() error = %v", err)
+	}
+	if a == b {
+		t.Fatalf("Service() returned the same service twice")
+	}
+
+	a.DatastoreData = append(a.DatastoreData, DatastoreMobileDevice{SN: "AB123"})
+	if len(b.DatastoreData) != 0 {
+		t.Errorf("Service() device data shared between services")
+	}
+}
+
+// EOF
@@ -0,0 +1,280 @@
+package gsuitemdm
+
+//
+// GSuiteMDM compliance report sinks
+//
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/smtp"
+	"strconv"
+	"strings"
+)
+
+// Somewhere a compliance report can be delivered
+type ReportSink interface {
+	// Name of the sink, e.g. "smtp"
+	Name() string
+
+	// Deliver a compliance report
+	Send(r *ComplianceReport) error
+}
+
+// Create the configured compliance report sinks. Secrets (SMTP passwords, SendGrid API keys)
+// are retrieved from Secret Manager.
+func (mdms *GSuiteMDMService) NewReportSinks(userurl string) ([]ReportSink, error) {
+	var sinks []ReportSink
+
+	for _, sc := range mdms.C.Compliance.Sinks {
+		// Get the sink's secret, if it has one
+		var secret string
+		if sc.SecretID != "" {
+			s, err := mdms.GetSecret(sc.SecretID)
+			if err != nil {
+				return nil, err
+			}
+			secret = strings.TrimSpace(s)
+		}
+
+		sink, err := NewReportSink(sc, secret, userurl)
+		if err != nil {
+			return nil, err
+		}
+		sinks = append(sinks, sink)
+	}
+
+	return sinks, nil
+}
+
+// Create a single compliance report sink
+func NewReportSink(sc ReportSinkConf, secret, userurl string) (ReportSink, error) {
+	switch sc.Type {
+	case "smtp":
+		if sc.Host == "" || sc.From == "" || len(sc.To) < 1 {
+			return nil, errors.New("smtp report sink requires host, from and to")
+		}
+		return &SMTPSink{Conf: sc, Password: secret, UserURL: userurl}, nil
+
+	case "sendgrid":
+		if secret == "" || sc.From == "" || len(sc.To) < 1 {
+			return nil, errors.New("sendgrid report sink requires secretid, from and to")
+		}
+		return &SendGridSink{APIKey: secret, Conf: sc, UserURL: userurl}, nil
+
+	case "slack":
+		if sc.URL == "" {
+			return nil, errors.New("slack report sink requires url")
+		}
+		return &SlackSink{URL: sc.URL}, nil
+
+	case "webhook":
+		if sc.URL == "" {
+			return nil, errors.New("webhook report sink requires url")
+		}
+		return &WebhookSink{URL: sc.URL}, nil
+
+	case "jsonfile":
+		if sc.Path == "" {
+			return nil, errors.New("jsonfile report sink requires path")
+		}
+		return &JSONFileSink{Path: sc.Path}, nil
+	}
+
+	return nil, errors.New(fmt.Sprintf("Unknown report sink type %q", sc.Type))
+}
+
+// Deliver a compliance report to an email address via SMTP
+type SMTPSink struct {
+	Conf     ReportSinkConf
+	Password string
+	UserURL  string
+}
+
+// Name of the sink
+func (s *SMTPSink) Name() string {
+	return "smtp"
+}
+
+// Deliver a compliance report
+func (s *SMTPSink) Send(r *ComplianceReport) error {
+	// Build the message
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s <%s>\r\n", s.Conf.FromName, s.Conf.From)
+	fmt.Fprintf(&msg, "To: %s\r\n", strings.Join(s.Conf.To, ", "))
+	if s.Conf.ReplyTo != "" {
+		fmt.Fprintf(&msg, "Reply-To: %s\r\n", s.Conf.ReplyTo)
+	}
+	fmt.Fprintf(&msg, "Subject: %s\r\n", r.Subject())
+	fmt.Fprintf(&msg, "MIME-Version: 1.0\r\n")
+	fmt.Fprintf(&msg, "Content-Type: text/html; charset=\"UTF-8\"\r\n\r\n")
+	msg.WriteString(r.HTML(s.UserURL))
+
+	err := sendSMTPMail(s.Conf.Host, s.Conf.Port, s.Conf.Username, s.Password, s.Conf.From, s.Conf.To, msg.Bytes())
+	if err != nil {
+		return errors.New(fmt.Sprintf("Error sending compliance report via SMTP: %s", err))
+	}
+
+	return nil
+}
+
+// Deliver a compliance report to an email address via the SendGrid v3 API
+type SendGridSink struct {
+	APIKey  string
+	Conf    ReportSinkConf
+	UserURL string
+}
+
+// Name of the sink
+func (s *SendGridSink) Name() string {
+	return "sendgrid"
+}
+
+// Deliver a compliance report
+func (s *SendGridSink) Send(r *ComplianceReport) error {
+	type address struct {
+		Email string `json:"email"`
+		Name  string `json:"name,omitempty"`
+	}
+
+	// Build the message
+	// Refer to https://sendgrid.com/docs/api-reference/
+	var to []address
+	for _, t := range s.Conf.To {
+		to = append(to, address{Email: t})
+	}
+	m := map[string]interface{}{
+		"personalizations": []map[string]interface{}{{"to": to}},
+		"from":             address{Email: s.Conf.From, Name: s.Conf.FromName},
+		"subject":          r.Subject(),
+		"content":          []map[string]string{{"type": "text/html", "value": r.HTML(s.UserURL)}},
+	}
+	if s.Conf.ReplyTo != "" {
+		m["reply_to"] = address{Email: s.Conf.ReplyTo}
+	}
+
+	req, err := newJSONRequest("https://api.sendgrid.com/v3/mail/send", m)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+s.APIKey)
+
+	return doReportRequest(req, "SendGrid")
+}
+
+// Deliver a compliance report to a Slack channel via an incoming webhook
+type SlackSink struct {
+	URL string
+}
+
+// Name of the sink
+func (s *SlackSink) Name() string {
+	return "slack"
+}
+
+// Deliver a compliance report
+func (s *SlackSink) Send(r *ComplianceReport) error {
+	req, err := newJSONRequest(s.URL, SlackMessage{Text: "```" + r.Text() + "```"})
+	if err != nil {
+		return err
+	}
+
+	return doReportRequest(req, "Slack")
+}
+
+// Deliver a compliance report to an HTTP endpoint as JSON
+type WebhookSink struct {
+	URL string
+}
+
+// Name of the sink
+func (s *WebhookSink) Name() string {
+	return "webhook"
+}
+
+// Deliver a compliance report
+func (s *WebhookSink) Send(r *ComplianceReport) error {
+	req, err := newJSONRequest(s.URL, r)
+	if err != nil {
+		return err
+	}
+
+	return doReportRequest(req, "webhook")
+}
+
+// Write a compliance report to a file as JSON
+type JSONFileSink struct {
+	Path string
+}
+
+// Name of the sink
+func (s *JSONFileSink) Name() string {
+	return "jsonfile"
+}
+
+// Deliver a compliance report
+func (s *JSONFileSink) Send(r *ComplianceReport) error {
+	js, err := json.MarshalIndent(r, "", "   ")
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(s.Path, js, 0644)
+}
+
+// Build a JSON POST request
+func newJSONRequest(u string, v interface{}) (*http.Request, error) {
+	js, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequest("POST", u, bytes.NewBuffer(js))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	return req, nil
+}
+
+// Send a report request and check the response
+func doReportRequest(req *http.Request, what string) error {
+	return doSendRequest(req, "compliance report to "+what)
+}
+
+// Send a request and check the response
+func doSendRequest(req *http.Request, what string) error {
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return errors.New(fmt.Sprintf("Error sending %s: %s", what, err))
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		body, _ := ioutil.ReadAll(resp.Body)
+		return errors.New(fmt.Sprintf("Error sending %s: %s %s", what, resp.Status, strings.TrimSpace(string(body))))
+	}
+
+	return nil
+}
+
+// Send an email via SMTP, authenticating only if we have a user. The port defaults to 587
+func sendSMTPMail(host string, port int, username, password, from string, to []string, msg []byte) error {
+	if port == 0 {
+		port = 587
+	}
+
+	var auth smtp.Auth
+	if username != "" {
+		auth = smtp.PlainAuth("", username, password, host)
+	}
+
+	return smtp.SendMail(host+":"+strconv.Itoa(port), auth, from, to, msg)
+}
+
+// EOF
@@ -1,28 +1,320 @@
 package gsuitemdm
 
 //
-// GSuiteMDM GCP Secret Manager related code
+// GSuiteMDM secret providers (GCP Secret Manager, local files, environment variables, Vault)
 //
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
 	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 
-	secretmanager "cloud.google.com/go/secretmanager/apiv1beta1"
-	secretmanagerpb "google.golang.org/genproto/googleapis/cloud/secretmanager/v1beta1"
+	secretmanager "cloud.google.com/go/secretmanager/apiv1"
+	secretmanagerpb "google.golang.org/genproto/googleapis/cloud/secretmanager/v1"
 )
 
-// Get a secret from Secret Manager
+// Secret providers
+const (
+	SecretProviderEnv           string = "env"
+	SecretProviderFile          string = "file"
+	SecretProviderSecretManager string = "secretmanager"
+	SecretProviderVault         string = "vault"
+)
+
+// Environment variables that choose the secret provider used before the configuration has been
+// read, i.e. for the configuration & API key themselves
+const (
+	SecretsDirEnv       string = "SECRETS_DIR"
+	SecretsEnvPrefixEnv string = "SECRETS_ENVPREFIX"
+	SecretsProviderEnv  string = "SECRETS_PROVIDER"
+	VaultAddrEnv        string = "VAULT_ADDR"
+	VaultFieldEnv       string = "VAULT_FIELD"
+	VaultMountEnv       string = "VAULT_MOUNT"
+	VaultTokenEnv       string = "VAULT_TOKEN"
+)
+
+// Defaults of the secret providers
+const (
+	DefaultSecretsEnvPrefix string = "SECRET_"
+	DefaultVaultField       string = "value"
+	DefaultVaultMount       string = "secret"
+)
+
+// Somewhere secrets are kept. Secret IDs are Secret Manager style
+// ("projects/PROJECT/secrets/NAME", optionally ending in "/versions/N"), or just a NAME
+type SecretProvider interface {
+	// Get a secret using its ID
+	GetSecret(ctx context.Context, id string) (string, error)
+
+	// Name of the provider
+	Name() string
+}
+
+// A secret provider that can also store new versions of secrets
+type SecretWriter interface {
+	// Store a new version of a secret, returning the ID of the new version
+	PutSecret(ctx context.Context, id, value string) (string, error)
+}
+
+// The bootstrap secret provider, used by GetSecret for the configuration, API keys and anything
+// else read before (or without) a configuration
+var secretProvider = struct {
+	sync.Mutex
+	p SecretProvider
+}{}
+
+// Get a secret from the bootstrap secret provider. The latest version of a secret is cached for a
+// few minutes (see SetSecretCacheTTL), a pinned version (sid ending in /versions/N) for the life
+// of the process. Secrets named in a configuration are read with GSuiteMDMService.GetSecret
 func GetSecret(ctx context.Context, sid string) (string, error) {
-	// Create a Secret Manager client
-	client, err := secretmanager.NewClient(ctx)
+	p, err := GetSecretProvider()
+	if err != nil {
+		return "", err
+	}
+
+	return getSecret(ctx, p, sid, secretCacheTTL())
+}
+
+// Get a secret named in the configuration, from the configuration's secret provider (the
+// bootstrap provider if it doesn't set one). The latest version of a secret is cached for the
+// configuration's cache.secretttlseconds
+func (mdms *GSuiteMDMService) GetSecret(sid string) (string, error) {
+	p := mdms.Secrets
+	if p == nil {
+		var err error
+		p, err = GetSecretProvider()
+		if err != nil {
+			return "", err
+		}
+	}
+
+	return getSecret(mdms.Ctx, p, sid, mdms.secretTTL())
+}
+
+// Get a secret from a provider, using the cache
+func getSecret(ctx context.Context, p SecretProvider, sid string, ttl time.Duration) (string, error) {
+	// Already have it?
+	key := secretKey{p: p, sid: sid}
+	if s, ok := getCachedSecret(key); ok {
+		return s, nil
+	}
+
+	s, err := p.GetSecret(ctx, sid)
+	if err != nil {
+		return "", err
+	}
+	putCachedSecret(key, secretPinned(sid), s, ttl)
+
+	// Return the specified secret
+	return s, nil
+}
+
+// How long the latest version of a secret named in the configuration is cached for
+func (mdms *GSuiteMDMService) secretTTL() time.Duration {
+	if mdms.C.Cache.SecretTTLSeconds == 0 {
+		return time.Duration(DefaultSecretCacheSeconds) * time.Second
+	}
+
+	return time.Duration(mdms.C.Cache.SecretTTLSeconds) * time.Second
+}
+
+// Store a new version of a secret using the bootstrap secret provider, if it can store secrets.
+// Returns the ID of the new version
+func PutSecret(ctx context.Context, sid, value string) (string, error) {
+	p, err := GetSecretProvider()
 	if err != nil {
-		return "", errors.New("Error creating Secret Manager client: " + err.Error())
+		return "", err
+	}
+
+	w, ok := p.(SecretWriter)
+	if !ok {
+		return "", errors.New(fmt.Sprintf("The %s secret provider can't store secrets", p.Name()))
+	}
+	version, err := w.PutSecret(ctx, sid, value)
+	if err != nil {
+		return "", err
+	}
+
+	// The cached secret is out of date
+	InvalidateSecret(sid)
+
+	return version, nil
+}
+
+// Get the bootstrap secret provider used by GetSecret. Until one is set, it is created from the
+// SECRETS_PROVIDER etc. environment variables, and is Secret Manager if they aren't set
+func GetSecretProvider() (SecretProvider, error) {
+	secretProvider.Lock()
+	defer secretProvider.Unlock()
+
+	if secretProvider.p == nil {
+		p, err := NewSecretProvider(SecretsConfFromEnv())
+		if err != nil {
+			return nil, err
+		}
+		secretProvider.p = p
+	}
+
+	return secretProvider.p, nil
+}
+
+// Set the bootstrap secret provider used by GetSecret
+func SetSecretProvider(p SecretProvider) {
+	secretProvider.Lock()
+	defer secretProvider.Unlock()
+
+	secretProvider.p = p
+}
+
+// Get the secret provider described by a configuration. Providers are shared by every service
+// with the same secrets configuration, so their clients & cached secrets are reused
+func configSecretProvider(sc SecretsConf) (SecretProvider, error) {
+	cache.Lock()
+	defer cache.Unlock()
+
+	if p, ok := cache.providers[sc]; ok {
+		return p, nil
+	}
+
+	p, err := NewSecretProvider(sc)
+	if err != nil {
+		return nil, err
+	}
+	cache.providers[sc] = p
+
+	return p, nil
+}
+
+// Create a secret provider from its configuration
+func NewSecretProvider(sc SecretsConf) (SecretProvider, error) {
+	switch sc.Provider {
+	case "", SecretProviderSecretManager:
+		return &SecretManagerProvider{}, nil
+
+	case SecretProviderFile:
+		if sc.Dir == "" {
+			return nil, errors.New("file secret provider requires dir")
+		}
+		return &FileSecretProvider{Dir: sc.Dir}, nil
+
+	case SecretProviderEnv:
+		prefix := sc.EnvPrefix
+		if prefix == "" {
+			prefix = DefaultSecretsEnvPrefix
+		}
+		return &EnvSecretProvider{Prefix: prefix}, nil
+
+	case SecretProviderVault:
+		if sc.VaultAddress == "" {
+			return nil, errors.New("vault secret provider requires vaultaddress")
+		}
+		token := os.Getenv(VaultTokenEnv)
+		if token == "" {
+			return nil, errors.New(fmt.Sprintf("vault secret provider requires the %s environment variable", VaultTokenEnv))
+		}
+		v := &VaultSecretProvider{Address: strings.TrimRight(sc.VaultAddress, "/"), Field: sc.VaultField, Mount: sc.VaultMount, Token: token}
+		if v.Field == "" {
+			v.Field = DefaultVaultField
+		}
+		if v.Mount == "" {
+			v.Mount = DefaultVaultMount
+		}
+		return v, nil
+	}
+
+	return nil, errors.New(fmt.Sprintf("Unknown secret provider %q", sc.Provider))
+}
+
+// Secret provider configuration from the SECRETS_PROVIDER etc. environment variables
+func SecretsConfFromEnv() SecretsConf {
+	return SecretsConf{
+		Dir:          os.Getenv(SecretsDirEnv),
+		EnvPrefix:    os.Getenv(SecretsEnvPrefixEnv),
+		Provider:     os.Getenv(SecretsProviderEnv),
+		VaultAddress: os.Getenv(VaultAddrEnv),
+		VaultField:   os.Getenv(VaultFieldEnv),
+		VaultMount:   os.Getenv(VaultMountEnv),
+	}
+}
+
+// Split a secret ID into the secret's name and version. The version is "" for the latest version
+func splitSecretID(id string) (string, string) {
+	name := id
+	version := ""
+
+	// Secret Manager style ID?
+	if i := strings.Index(name, "/secrets/"); i >= 0 {
+		name = name[i+len("/secrets/"):]
+	}
+	if i := strings.Index(name, "/versions/"); i >= 0 {
+		version = name[i+len("/versions/"):]
+		name = name[:i]
+	}
+	if version == "latest" {
+		version = ""
+	}
+
+	return name, version
+}
+
+// Is a secret ID pinned to a version, which never changes?
+func secretPinned(id string) bool {
+	_, version := splitSecretID(id)
+	return version != ""
+}
+
+//
+// GCP SECRET MANAGER
+//
+
+// Get secrets from GCP Secret Manager. IDs without a version get the latest version
+type SecretManagerProvider struct {
+	client *secretmanager.Client
+	mu     sync.Mutex
+}
+
+// Name of the provider
+func (p *SecretManagerProvider) Name() string {
+	return SecretProviderSecretManager
+}
+
+// Get the Secret Manager client, shared by all requests
+func (p *SecretManagerProvider) getClient() (*secretmanager.Client, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.client == nil {
+		client, err := secretmanager.NewClient(context.Background())
+		if err != nil {
+			return nil, errors.New("Error creating Secret Manager client: " + err.Error())
+		}
+		p.client = client
+	}
+
+	return p.client, nil
+}
+
+// Get a secret from Secret Manager
+func (p *SecretManagerProvider) GetSecret(ctx context.Context, id string) (string, error) {
+	client, err := p.getClient()
+	if err != nil {
+		return "", err
 	}
 
 	// Build the Secret Manager request
 	smreq := &secretmanagerpb.AccessSecretVersionRequest{
-		Name: sid + "/versions/latest",
+		Name: secretVersionName(id),
 	}
 
 	// Call the Secret Manager API and get the requested secret using its ID
@@ -31,8 +323,314 @@ func GetSecret(ctx context.Context, sid string) (string, error) {
 		return "", errors.New("Error retrieving secret: " + err.Error())
 	}
 
-	// Return the specified secret
 	return string(smres.Payload.Data), nil
 }
 
+// Add a new version of a secret to Secret Manager
+func (p *SecretManagerProvider) PutSecret(ctx context.Context, id, value string) (string, error) {
+	client, err := p.getClient()
+	if err != nil {
+		return "", err
+	}
+
+	// New versions are added to the secret itself
+	if i := strings.Index(id, "/versions/"); i >= 0 {
+		id = id[:i]
+	}
+	smreq := &secretmanagerpb.AddSecretVersionRequest{
+		Parent:  id,
+		Payload: &secretmanagerpb.SecretPayload{Data: []byte(value)},
+	}
+
+	smres, err := client.AddSecretVersion(ctx, smreq)
+	if err != nil {
+		return "", errors.New("Error adding secret version: " + err.Error())
+	}
+
+	return smres.Name, nil
+}
+
+// The Secret Manager name of the version of a secret to get. Secret IDs without a version get the
+// latest version, IDs ending in /versions/N are pinned to that version
+func secretVersionName(sid string) string {
+	if strings.Contains(sid, "/versions/") {
+		return sid
+	}
+
+	return sid + "/versions/latest"
+}
+
+//
+// LOCAL FILES
+//
+
+// Get secrets from files in a directory, named after the secrets. A secret may instead be a
+// directory of versions, with files named after each version and "latest"
+type FileSecretProvider struct {
+	Dir string
+}
+
+// Name of the provider
+func (p *FileSecretProvider) Name() string {
+	return SecretProviderFile
+}
+
+// Read a secret from its file
+func (p *FileSecretProvider) GetSecret(ctx context.Context, id string) (string, error) {
+	path, version, err := p.path(id)
+	if err != nil {
+		return "", err
+	}
+
+	// A directory of versions?
+	fi, err := os.Stat(path)
+	if err == nil && fi.IsDir() {
+		if version == "" {
+			version = "latest"
+		}
+		path = filepath.Join(path, version)
+	}
+
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", errors.New("Error retrieving secret: " + err.Error())
+	}
+
+	return strings.TrimRight(string(b), "\r\n"), nil
+}
+
+// Write a secret's file. If the secret is a directory of versions, the next version is added
+// and also written to "latest"
+func (p *FileSecretProvider) PutSecret(ctx context.Context, id, value string) (string, error) {
+	path, _, err := p.path(id)
+	if err != nil {
+		return "", err
+	}
+
+	// A directory of versions?
+	fi, err := os.Stat(path)
+	if err != nil || !fi.IsDir() {
+		return id, writeFileAtomic(path, []byte(value))
+	}
+	files, err := ioutil.ReadDir(path)
+	if err != nil {
+		return "", errors.New("Error storing secret: " + err.Error())
+	}
+	next := 1
+	for _, f := range files {
+		if n, err := strconv.Atoi(f.Name()); err == nil && n >= next {
+			next = n + 1
+		}
+	}
+	version := strconv.Itoa(next)
+	err = writeFileAtomic(filepath.Join(path, version), []byte(value))
+	if err != nil {
+		return "", err
+	}
+	err = writeFileAtomic(filepath.Join(path, "latest"), []byte(value))
+	if err != nil {
+		return "", err
+	}
+	name, _ := splitSecretID(id)
+
+	return name + "/versions/" + version, nil
+}
+
+// Path of a secret's file (or directory of versions), and the version asked for
+func (p *FileSecretProvider) path(id string) (string, string, error) {
+	name, version := splitSecretID(id)
+	if name == "" || strings.Contains(name, "..") {
+		return "", "", errors.New(fmt.Sprintf("Invalid secret ID %q", id))
+	}
+
+	return filepath.Join(p.Dir, filepath.FromSlash(name)), version, nil
+}
+
+// Write a file readable only by its owner, replacing it in one step so readers never see part
+// of it
+func writeFileAtomic(path string, b []byte) error {
+	tmp := path + ".tmp"
+	err := ioutil.WriteFile(tmp, b, 0600)
+	if err != nil {
+		return errors.New("Error storing secret: " + err.Error())
+	}
+	err = os.Rename(tmp, path)
+	if err != nil {
+		os.Remove(tmp)
+		return errors.New("Error storing secret: " + err.Error())
+	}
+
+	return nil
+}
+
+//
+// ENVIRONMENT VARIABLES
+//
+
+// Get secrets from environment variables named after the secrets: the prefix followed by the
+// secret's name in upper case, with characters other than letters & digits replaced by "_".
+// Versions are ignored
+type EnvSecretProvider struct {
+	Prefix string
+}
+
+// Name of the provider
+func (p *EnvSecretProvider) Name() string {
+	return SecretProviderEnv
+}
+
+// Get a secret from its environment variable
+func (p *EnvSecretProvider) GetSecret(ctx context.Context, id string) (string, error) {
+	name, _ := splitSecretID(id)
+	v := p.Prefix + strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z':
+			return r - 'a' + 'A'
+		case r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+			return r
+		}
+		return '_'
+	}, name)
+
+	s, ok := os.LookupEnv(v)
+	if !ok {
+		return "", errors.New(fmt.Sprintf("Error retrieving secret: environment variable %s is not set", v))
+	}
+
+	return s, nil
+}
+
+//
+// HASHICORP VAULT
+//
+
+// Get secrets from a HashiCorp Vault KV version 2 secrets engine. Each secret is kept at its name,
+// with its value in Field
+type VaultSecretProvider struct {
+	Address string
+	Field   string
+	Mount   string
+	Token   string
+}
+
+// Name of the provider
+func (p *VaultSecretProvider) Name() string {
+	return SecretProviderVault
+}
+
+// Get a secret from Vault
+func (p *VaultSecretProvider) GetSecret(ctx context.Context, id string) (string, error) {
+	name, version := splitSecretID(id)
+	data, _, err := p.getSecretData(ctx, name, version)
+	if err != nil {
+		return "", err
+	}
+	if data == nil {
+		return "", errors.New(fmt.Sprintf("Error retrieving secret %s from Vault: %d %s", name, http.StatusNotFound, http.StatusText(http.StatusNotFound)))
+	}
+
+	s, ok := data[p.Field].(string)
+	if !ok {
+		return "", errors.New(fmt.Sprintf("Vault secret %s has no %q field", name, p.Field))
+	}
+
+	return s, nil
+}
+
+// Get all the fields of a version of a Vault secret (the latest if version is empty) and the
+// version number. A secret that doesn't exist has no fields and version 0
+func (p *VaultSecretProvider) getSecretData(ctx context.Context, name, version string) (map[string]interface{}, int, error) {
+	var vr struct {
+		Data struct {
+			Data     map[string]interface{} `json:"data"`
+			Metadata struct {
+				Version int `json:"version"`
+			} `json:"metadata"`
+		} `json:"data"`
+	}
+
+	// Build the Vault request
+	u := fmt.Sprintf("%s/v1/%s/data/%s", p.Address, p.Mount, name)
+	if version != "" {
+		u += "?version=" + url.QueryEscape(version)
+	}
+	req, err := http.NewRequest("GET", u, nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("X-Vault-Token", p.Token)
+
+	// Send it
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, 0, errors.New("Error retrieving secret: " + err.Error())
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, 0, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, 0, errors.New(fmt.Sprintf("Error retrieving secret %s from Vault: %s", name, resp.Status))
+	}
+
+	err = json.NewDecoder(resp.Body).Decode(&vr)
+	if err != nil {
+		return nil, 0, errors.New(fmt.Sprintf("Error decoding Vault response: %s", err))
+	}
+
+	return vr.Data.Data, vr.Data.Metadata.Version, nil
+}
+
+// Write a new version of a secret to Vault. The secret's other fields are kept, and the write
+// is check-and-set against the version read, so a concurrent write isn't lost
+func (p *VaultSecretProvider) PutSecret(ctx context.Context, id, value string) (string, error) {
+	var vr struct {
+		Data struct {
+			Version int `json:"version"`
+		} `json:"data"`
+	}
+
+	// Merge the new value into the latest version
+	name, _ := splitSecretID(id)
+	data, cas, err := p.getSecretData(ctx, name, "")
+	if err != nil {
+		return "", err
+	}
+	if data == nil {
+		data = map[string]interface{}{}
+	}
+	data[p.Field] = value
+
+	// Build the Vault request
+	js, err := json.Marshal(map[string]interface{}{"data": data, "options": map[string]int{"cas": cas}})
+	if err != nil {
+		return "", err
+	}
+	req, err := http.NewRequest("POST", fmt.Sprintf("%s/v1/%s/data/%s", p.Address, p.Mount, name), bytes.NewBuffer(js))
+	if err != nil {
+		return "", err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Vault-Token", p.Token)
+
+	// Send it
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", errors.New("Error storing secret: " + err.Error())
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", errors.New(fmt.Sprintf("Error storing secret %s in Vault: %s", name, resp.Status))
+	}
+
+	err = json.NewDecoder(resp.Body).Decode(&vr)
+	if err != nil {
+		return "", errors.New(fmt.Sprintf("Error decoding Vault response: %s", err))
+	}
+
+	return fmt.Sprintf("%s/versions/%d", name, vr.Data.Version), nil
+}
+
 // EOF
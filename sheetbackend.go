@@ -0,0 +1,425 @@
+package gsuitemdm
+
+//
+// GSuiteMDM Google Sheet backends
+//
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"google.golang.org/api/option"
+	sheets "google.golang.org/api/sheets/v4"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// A backend capable of reading & writing the tracking Google Sheet. All ranges are specified
+// in A1 notation (e.g. "A3:S") and refer to the first worksheet of the spreadsheet.
+type SheetBackend interface {
+	// Read all rows in a range. Trailing empty cells may be omitted by the backend
+	ReadRows(rng string) ([][]string, error)
+
+	// Write multiple ranges in as few calls as the backend allows
+	WriteRanges(data []SheetRange) error
+
+	// Clear multiple ranges
+	ClearRanges(rngs ...string) error
+
+	// Apply cell formatting
+	Format(fmts []SheetFormat) error
+}
+
+// A range of cell values to be written to the sheet
+type SheetRange struct {
+	Range  string     // A1 notation range, e.g. "A3:S"
+	Values [][]string // Rows of cell values
+}
+
+// Formatting applied to a block of cells. Rows & columns are zero-indexed
+type SheetFormat struct {
+	Row        int        // First row
+	Column     int        // First column
+	NumRows    int        // Number of rows
+	NumColumns int        // Number of columns
+	Bold       bool       // Bold text?
+	Background [3]float64 // Background color (red, green, blue), each 0-1. All zeroes leaves the background alone
+}
+
+//
+// Sheets API v4 backend
+//
+
+// SheetBackend using the native Google Sheets API v4
+type SheetsAPIBackend struct {
+	sheetid string          // ID of the Google Sheet
+	srv     *sheets.Service // Sheets API service
+	title   string          // Title of the first worksheet
+	wsid    int64           // ID of the first worksheet
+}
+
+// Create a new Sheets API backend for a Google Sheet, using an authenticated http client
+func NewSheetsAPIBackend(ctx context.Context, client *http.Client, sheetid string) (*SheetsAPIBackend, error) {
+	// Get a Google Sheets service
+	srv, err := sheets.NewService(ctx, option.WithHTTPClient(client))
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("Error creating Sheets API service: %s", err))
+	}
+
+	// Fetch the spreadsheet metadata so we know the title & ID of the first worksheet
+	ss, err := srv.Spreadsheets.Get(sheetid).Fields("sheets.properties").Do()
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("Error fetching Google Sheet %s: %s", sheetid, err))
+	}
+	if len(ss.Sheets) < 1 || ss.Sheets[0].Properties == nil {
+		return nil, errors.New(fmt.Sprintf("Google Sheet %s has no worksheets", sheetid))
+	}
+
+	return &SheetsAPIBackend{
+		sheetid: sheetid,
+		srv:     srv,
+		title:   ss.Sheets[0].Properties.Title,
+		wsid:    ss.Sheets[0].Properties.SheetId}, nil
+}
+
+// Prefix a range with the (quoted) title of the first worksheet
+func (b *SheetsAPIBackend) qualify(rng string) string {
+	return "'" + strings.Replace(b.title, "'", "''", -1) + "'!" + rng
+}
+
+// Read all rows in a range
+func (b *SheetsAPIBackend) ReadRows(rng string) ([][]string, error) {
+	vr, err := b.srv.Spreadsheets.Values.Get(b.sheetid, b.qualify(rng)).
+		ValueRenderOption("FORMATTED_VALUE").Do()
+	if err != nil {
+		return nil, err
+	}
+
+	// Convert the returned cells to strings
+	var rows [][]string
+	for _, r := range vr.Values {
+		row := make([]string, len(r))
+		for k, c := range r {
+			row[k] = fmt.Sprintf("%v", c)
+		}
+		rows = append(rows, row)
+	}
+
+	return rows, nil
+}
+
+// Write multiple ranges using a single values:batchUpdate call
+func (b *SheetsAPIBackend) WriteRanges(data []SheetRange) error {
+	var req = &sheets.BatchUpdateValuesRequest{
+		ValueInputOption: "RAW",
+	}
+
+	for _, d := range data {
+		vr := &sheets.ValueRange{
+			Range: b.qualify(d.Range),
+		}
+		for _, r := range d.Values {
+			row := make([]interface{}, len(r))
+			for k, c := range r {
+				row[k] = c
+			}
+			vr.Values = append(vr.Values, row)
+		}
+		req.Data = append(req.Data, vr)
+	}
+
+	_, err := b.srv.Spreadsheets.Values.BatchUpdate(b.sheetid, req).Do()
+	return err
+}
+
+// Clear multiple ranges using a single values:batchClear call
+func (b *SheetsAPIBackend) ClearRanges(rngs ...string) error {
+	var req = &sheets.BatchClearValuesRequest{}
+
+	for _, r := range rngs {
+		req.Ranges = append(req.Ranges, b.qualify(r))
+	}
+
+	_, err := b.srv.Spreadsheets.Values.BatchClear(b.sheetid, req).Do()
+	return err
+}
+
+// Apply cell formatting using a single spreadsheets:batchUpdate call
+func (b *SheetsAPIBackend) Format(fmts []SheetFormat) error {
+	var req = &sheets.BatchUpdateSpreadsheetRequest{}
+
+	for _, f := range fmts {
+		var cf = &sheets.CellFormat{
+			TextFormat: &sheets.TextFormat{
+				Bold:            f.Bold,
+				ForceSendFields: []string{"Bold"},
+			},
+		}
+		fields := "userEnteredFormat.textFormat.bold"
+
+		// Only touch the background if a color was specified
+		if f.Background != [3]float64{} {
+			cf.BackgroundColor = &sheets.Color{
+				Red:   f.Background[0],
+				Green: f.Background[1],
+				Blue:  f.Background[2],
+			}
+			fields = fields + ",userEnteredFormat.backgroundColor"
+		}
+
+		req.Requests = append(req.Requests, &sheets.Request{
+			RepeatCell: &sheets.RepeatCellRequest{
+				Range: &sheets.GridRange{
+					SheetId:          b.wsid,
+					StartRowIndex:    int64(f.Row),
+					EndRowIndex:      int64(f.Row + f.NumRows),
+					StartColumnIndex: int64(f.Column),
+					EndColumnIndex:   int64(f.Column + f.NumColumns),
+					ForceSendFields:  []string{"SheetId", "StartRowIndex", "StartColumnIndex"},
+				},
+				Cell:   &sheets.CellData{UserEnteredFormat: cf},
+				Fields: fields,
+			},
+		})
+	}
+
+	if len(req.Requests) < 1 {
+		return nil
+	}
+
+	_, err := b.srv.Spreadsheets.BatchUpdate(b.sheetid, req).Do()
+	return err
+}
+
+//
+// In-memory backend
+//
+
+// SheetBackend that keeps the worksheet in memory. Can be loaded from & saved to CSV, which
+// makes it useful for offline testing and local development.
+type MemorySheetBackend struct {
+	Formats []SheetFormat // Formatting applied so far
+	Rows    [][]string    // Worksheet cells
+	mu      sync.Mutex
+}
+
+// Create a new, empty in-memory sheet backend
+func NewMemorySheetBackend() *MemorySheetBackend {
+	return &MemorySheetBackend{}
+}
+
+// Create a new in-memory sheet backend populated from CSV data
+func NewMemorySheetBackendFromCSV(r io.Reader) (*MemorySheetBackend, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+
+	rows, err := cr.ReadAll()
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("Error reading CSV sheet data: %s", err))
+	}
+
+	return &MemorySheetBackend{Rows: rows}, nil
+}
+
+// Write the in-memory worksheet out as CSV
+func (b *MemorySheetBackend) WriteCSV(w io.Writer) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	cw := csv.NewWriter(w)
+	err := cw.WriteAll(b.Rows)
+	if err != nil {
+		return err
+	}
+
+	return cw.Error()
+}
+
+// Read all rows in a range
+func (b *MemorySheetBackend) ReadRows(rng string) ([][]string, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	ar, err := parseA1Range(rng)
+	if err != nil {
+		return nil, err
+	}
+
+	var rows [][]string
+	for r := ar.row; r < len(b.Rows) && (ar.endrow < 0 || r <= ar.endrow); r++ {
+		var row []string
+		for c := ar.col; c < len(b.Rows[r]) && (ar.endcol < 0 || c <= ar.endcol); c++ {
+			row = append(row, b.Rows[r][c])
+		}
+		// Trim trailing empty cells, like the Sheets API does
+		for len(row) > 0 && row[len(row)-1] == "" {
+			row = row[:len(row)-1]
+		}
+		rows = append(rows, row)
+	}
+
+	// Trim trailing empty rows, like the Sheets API does
+	for len(rows) > 0 && len(rows[len(rows)-1]) == 0 {
+		rows = rows[:len(rows)-1]
+	}
+
+	return rows, nil
+}
+
+// Write multiple ranges
+func (b *MemorySheetBackend) WriteRanges(data []SheetRange) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for _, d := range data {
+		ar, err := parseA1Range(d.Range)
+		if err != nil {
+			return err
+		}
+		for kr, r := range d.Values {
+			for kc, c := range r {
+				b.set(ar.row+kr, ar.col+kc, c)
+			}
+		}
+	}
+
+	return nil
+}
+
+// Clear multiple ranges
+func (b *MemorySheetBackend) ClearRanges(rngs ...string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for _, rng := range rngs {
+		ar, err := parseA1Range(rng)
+		if err != nil {
+			return err
+		}
+		for r := ar.row; r < len(b.Rows) && (ar.endrow < 0 || r <= ar.endrow); r++ {
+			for c := ar.col; c < len(b.Rows[r]) && (ar.endcol < 0 || c <= ar.endcol); c++ {
+				b.Rows[r][c] = ""
+			}
+		}
+	}
+
+	return nil
+}
+
+// Record cell formatting
+func (b *MemorySheetBackend) Format(fmts []SheetFormat) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.Formats = append(b.Formats, fmts...)
+
+	return nil
+}
+
+// Set a single cell, growing the worksheet as needed
+func (b *MemorySheetBackend) set(row, col int, v string) {
+	for len(b.Rows) <= row {
+		b.Rows = append(b.Rows, nil)
+	}
+	for len(b.Rows[row]) <= col {
+		b.Rows[row] = append(b.Rows[row], "")
+	}
+	b.Rows[row][col] = v
+}
+
+//
+// A1 notation helpers
+//
+
+// A parsed A1 notation range. Rows & columns are zero-indexed, -1 means unbounded
+type a1Range struct {
+	row, col       int
+	endrow, endcol int
+}
+
+// Parse an A1 notation range such as "B1", "A3:S" or "A3:S100"
+func parseA1Range(rng string) (a1Range, error) {
+	var ar = a1Range{endrow: -1, endcol: -1}
+	var err error
+
+	parts := strings.SplitN(rng, ":", 2)
+
+	// Start cell
+	ar.row, ar.col, err = parseA1Cell(parts[0])
+	if err != nil {
+		return ar, err
+	}
+	if ar.row < 0 {
+		ar.row = 0
+	}
+	if ar.col < 0 {
+		ar.col = 0
+	}
+
+	// Single cell?
+	if len(parts) == 1 {
+		ar.endrow, ar.endcol = ar.row, ar.col
+		return ar, nil
+	}
+
+	// End cell
+	ar.endrow, ar.endcol, err = parseA1Cell(parts[1])
+	if err != nil {
+		return ar, err
+	}
+
+	return ar, nil
+}
+
+// Parse an A1 notation cell such as "A3", "S" or "3". Missing parts are returned as -1
+func parseA1Cell(cell string) (int, int, error) {
+	var col = -1
+	var row = -1
+
+	cell = strings.ToUpper(strings.TrimSpace(cell))
+
+	// Column letters first
+	var k int
+	for k < len(cell) && cell[k] >= 'A' && cell[k] <= 'Z' {
+		if col < 0 {
+			col = 0
+		}
+		col = col*26 + int(cell[k]-'A'+1)
+		k++
+	}
+	if col > 0 {
+		col--
+	}
+
+	// Then the row number
+	if k < len(cell) {
+		n, err := strconv.Atoi(cell[k:])
+		if err != nil || n < 1 {
+			return -1, -1, errors.New(fmt.Sprintf("Invalid A1 notation cell %q", cell))
+		}
+		row = n - 1
+	}
+
+	if row < 0 && col < 0 {
+		return -1, -1, errors.New(fmt.Sprintf("Invalid A1 notation cell %q", cell))
+	}
+
+	return row, col, nil
+}
+
+// Convert a zero-indexed column number to A1 notation column letters
+func a1Column(col int) string {
+	var s string
+
+	for col++; col > 0; col = (col - 1) / 26 {
+		s = string(rune('A'+(col-1)%26)) + s
+	}
+
+	return s
+}
+
+// EOF
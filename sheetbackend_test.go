@@ -0,0 +1,152 @@
+package gsuitemdm
+
+//
+// GSuiteMDM Google Sheet backend tests
+//
+
+import (
+	"reflect"
+	"testing"
+)
+
+// A1 notation ranges are parsed to zero-indexed rows & columns, -1 meaning unbounded
+func TestParseA1Range(t *testing.T) {
+	tests := []struct {
+		name    string
+		rng     string
+		want    a1Range
+		wanterr bool
+	}{
+		{name: "single cell", rng: "B1", want: a1Range{row: 0, col: 1, endrow: 0, endcol: 1}},
+		{name: "bounded", rng: "A3:S100", want: a1Range{row: 2, col: 0, endrow: 99, endcol: 18}},
+		{name: "open rows", rng: "A3:S", want: a1Range{row: 2, col: 0, endrow: -1, endcol: 18}},
+		{name: "whole rows", rng: "3:5", want: a1Range{row: 2, col: 0, endrow: 4, endcol: -1}},
+		{name: "whole columns", rng: "B:D", want: a1Range{row: 0, col: 1, endrow: -1, endcol: 3}},
+		{name: "two letter columns", rng: "AA1:AZ2", want: a1Range{row: 0, col: 26, endrow: 1, endcol: 51}},
+		{name: "lower case & spaces", rng: " b2 : c3 ", want: a1Range{row: 1, col: 1, endrow: 2, endcol: 2}},
+		{name: "empty", rng: "", wanterr: true},
+		{name: "row zero", rng: "A0", wanterr: true},
+		{name: "negative row", rng: "A-1", wanterr: true},
+		{name: "row before column", rng: "3A", wanterr: true},
+		{name: "bad end", rng: "A1:B2C", wanterr: true},
+		{name: "empty end", rng: "A1:", wanterr: true},
+		{name: "not a range", rng: "Sheet1!A1", wanterr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseA1Range(tt.rng)
+			if (err != nil) != tt.wanterr {
+				t.Fatalf("parseA1Range(%q) error = %v, wanterr %v", tt.rng, err, tt.wanterr)
+			}
+			if !tt.wanterr && got != tt.want {
+				t.Errorf("parseA1Range(%q) = %+v, want %+v", tt.rng, got, tt.want)
+			}
+		})
+	}
+}
+
+// The in-memory backend reads, writes & clears ranges like the Sheets API
+func TestMemorySheetBackend(t *testing.T) {
+	sheet := func() [][]string {
+		return [][]string{
+			{"Last Updated", "today"},
+			{"Domain", "SN", "Notes"},
+			{"example.com", "AB123", "spare"},
+			{"example.com", "CD456"},
+		}
+	}
+
+	tests := []struct {
+		name    string
+		do      func(b *MemorySheetBackend) error
+		read    string
+		want    [][]string
+		wanterr bool
+	}{
+		{name: "read all", read: "A1:C", want: sheet()},
+		{name: "read rows", read: "A3:C", want: [][]string{{"example.com", "AB123", "spare"}, {"example.com", "CD456"}}},
+		{name: "read column", read: "B3:B", want: [][]string{{"AB123"}, {"CD456"}}},
+		{name: "read cell", read: "B1", want: [][]string{{"today"}}},
+		{name: "read past the end", read: "A10:C", want: nil},
+		{name: "read bad range", read: "A0", wanterr: true},
+		{
+			name: "write cells",
+			do: func(b *MemorySheetBackend) error {
+				return b.WriteRanges([]SheetRange{
+					{Range: "B1", Values: [][]string{{"tomorrow"}}},
+					{Range: "C4", Values: [][]string{{"loaner"}}},
+				})
+			},
+			read: "A1:C",
+			want: [][]string{{"Last Updated", "tomorrow"}, {"Domain", "SN", "Notes"}, {"example.com", "AB123", "spare"}, {"example.com", "CD456", "loaner"}},
+		},
+		{
+			name: "write grows the sheet",
+			do: func(b *MemorySheetBackend) error {
+				return b.WriteRanges([]SheetRange{{Range: "A5:C", Values: [][]string{{"example.com", "EF789", ""}, {"", "", "new"}}}})
+			},
+			read: "A5:D",
+			want: [][]string{{"example.com", "EF789"}, {"", "", "new"}},
+		},
+		{
+			name: "write bad range",
+			do: func(b *MemorySheetBackend) error {
+				return b.WriteRanges([]SheetRange{{Range: "1A", Values: [][]string{{"x"}}}})
+			},
+			wanterr: true,
+		},
+		{
+			name: "clear rows",
+			do: func(b *MemorySheetBackend) error {
+				return b.ClearRanges("A3:C")
+			},
+			read: "A1:C",
+			want: [][]string{{"Last Updated", "today"}, {"Domain", "SN", "Notes"}},
+		},
+		{
+			name: "clear cells",
+			do: func(b *MemorySheetBackend) error {
+				return b.ClearRanges("B1", "C3:C")
+			},
+			read: "A1:C",
+			want: [][]string{{"Last Updated"}, {"Domain", "SN", "Notes"}, {"example.com", "AB123"}, {"example.com", "CD456"}},
+		},
+		{
+			name: "clear bad range",
+			do: func(b *MemorySheetBackend) error {
+				return b.ClearRanges("A1", "")
+			},
+			wanterr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := &MemorySheetBackend{Rows: sheet()}
+
+			var err error
+			if tt.do != nil {
+				err = tt.do(b)
+			} else {
+				_, err = b.ReadRows(tt.read)
+			}
+			if (err != nil) != tt.wanterr {
+				t.Fatalf("error = %v, wanterr %v", err, tt.wanterr)
+			}
+			if tt.wanterr {
+				return
+			}
+
+			got, err := b.ReadRows(tt.read)
+			if err != nil {
+				t.Fatalf("ReadRows(%q) error = %v", tt.read, err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ReadRows(%q) = %q, want %q", tt.read, got, tt.want)
+			}
+		})
+	}
+}
+
+// EOF
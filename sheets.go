@@ -5,13 +5,9 @@ package gsuitemdm
 //
 
 import (
-	"context"
 	"errors"
 	"fmt"
-	"github.com/Iwark/spreadsheet"
 	"github.com/dustin/go-humanize"
-	"golang.org/x/oauth2"
-	"golang.org/x/oauth2/google"
 	admin "google.golang.org/api/admin/directory/v1"
 	"net/http"
 	"strconv"
@@ -19,82 +15,145 @@ import (
 	"time"
 )
 
-// Read all mobile device data from the Google Sheet
-func (mdms *GSuiteMDMService) GetSheetData() error {
-	var err error
+// Layout of the Google Sheet. Row 0 is the "Last updated" line, row 1 is the header row
+// and device rows start at row 2
+const (
+	sheetHeaderRow    int = 1
+	sheetFirstDataRow int = 2
+)
 
-	// We need to get the credentials to read the Google Sheet from Secret Manager
-	ctx := context.Background()
+// Google Sheet columns, in order
+var sheetColumns = []string{
+	"Domain",
+	"Phone Number",
+	"Color",
+	"RAM",
+	"Name",
+	"Status",
+	"Email",
+	"Model",
+	"IMEI",
+	"Serial Number",
+	"Last Sync",
+	"OS",
+	"Type",
+	"Wifi MAC",
+	"Compromised",
+	"Developer Mode",
+	"Unknown Sources",
+	"USB ADB",
+	"Notes",
+	"Asset Tag",
+	"Vendor",
+	"Purchase Date",
+	"Cost",
+	"Warranty Expiry",
+	"Carrier",
+	"Plan",
+}
 
-	// Retrieve the credentials necessary to read/write to/from the Google Sheet from Secret Manager
-	creds, err := GetSecret(ctx, mdms.C.SheetCredsID)
-	if err != nil {
-		return err
+// Google Sheet columns read & written by index
+var (
+	sheetColumnColor = sheetColumn("Color")
+	sheetColumnIMEI  = sheetColumn("IMEI")
+	sheetColumnNotes = sheetColumn("Notes")
+	sheetColumnPhone = sheetColumn("Phone Number")
+	sheetColumnRAM   = sheetColumn("RAM")
+	sheetColumnSN    = sheetColumn("Serial Number")
+)
+
+// Get the index of a Google Sheet column
+func sheetColumn(name string) int {
+	for i, c := range sheetColumns {
+		if c == name {
+			return i
+		}
 	}
 
-	// Get an authenticated http client
-	client, err := mdms.HttpClient(creds)
-	if err != nil {
-		return err
+	panic("Unknown Google Sheet column " + name)
+}
+
+// Get a cell of a Google Sheet row. The Sheets API omits trailing empty cells
+func sheetCell(r []string, col int) string {
+	if col < len(r) {
+		return r[col]
 	}
 
-	// Get a google sheets service
-	gss := spreadsheet.NewServiceWithClient(client)
+	return ""
+}
 
-	// Fetch the Google sheet
-	sheet, err := gss.FetchSpreadsheet(mdms.C.SheetID)
+// Read all mobile device data from the Google Sheet
+func (mdms *GSuiteMDMService) GetSheetData() error {
+	// Get a sheet backend
+	sb, err := mdms.getSheetBackend()
 	if err != nil {
 		return err
 	}
 
-	// Select the first worksheet
-	ws, err := sheet.SheetByIndex(0)
-
-	// Row count is number of rows in the worksheet minus 1 (because of header row)
-	numrows := len(ws.Rows) - 1
-
-	// Range through the Sheet's rows, skipping the first row because it's a header row
-	var row = 0
-	for kr := range ws.Rows {
-		if row == numrows {
-			break
-		}
+	// Read all device rows
+	rows, err := sb.ReadRows(fmt.Sprintf("A%d:%s", sheetFirstDataRow+1, a1Column(len(sheetColumns)-1)))
+	if err != nil {
+		return err
+	}
 
+	// Range through the Sheet's rows
+	for _, r := range rows {
 		// Build a temporary device
 		var d DatastoreMobileDevice
 
 		// Get data from the sheet and populate
-		d.PhoneNumber = strings.Replace((ws.Rows[kr+1][1].Value), " ", "", -1)
-		d.Color = (ws.Rows[kr+1][2].Value)
-		d.RAM = (ws.Rows[kr+1][3].Value)
-		d.IMEI = strings.Replace((ws.Rows[kr+1][8].Value), " ", "", -1)
-		d.SN = strings.Replace((ws.Rows[kr+1][9].Value), " ", "", -1)
-		d.Notes = (ws.Rows[kr+1][18].Value)
+		d.PhoneNumber = strings.Replace(sheetCell(r, sheetColumnPhone), " ", "", -1)
+		d.Color = sheetCell(r, sheetColumnColor)
+		d.RAM = sheetCell(r, sheetColumnRAM)
+		d.IMEI = strings.Replace(sheetCell(r, sheetColumnIMEI), " ", "", -1)
+		d.SN = strings.Replace(sheetCell(r, sheetColumnSN), " ", "", -1)
+		d.Notes = sheetCell(r, sheetColumnNotes)
+
+		// Skip empty rows
+		if d.SN == "" {
+			continue
+		}
 
 		// Append this device to devices
 		mdms.SheetData = append(mdms.SheetData, d)
-
-		// Increment the row count
-		row++
 	}
 
 	return nil
 }
 
-// Create an authenticated http(s) client, used to read/write the Google Sheet
-func (mdms *GSuiteMDMService) HttpClient(creds string) (*http.Client, error) {
-	// Create JWT config using the credentials
-	conf, err := google.JWTConfigFromJSON([]byte(creds), mdms.C.SheetScope)
+// Get the configured sheet backend, or create a Sheets API backend if none has been set
+func (mdms *GSuiteMDMService) getSheetBackend() (SheetBackend, error) {
+	if mdms.Sheet != nil {
+		return mdms.Sheet, nil
+	}
+
+	// Retrieve the credentials necessary to read/write to/from the Google Sheet from Secret Manager
+	creds, err := mdms.GetSecret(mdms.C.SheetCredsID)
 	if err != nil {
 		return nil, err
 	}
 
+	// Get an authenticated http client
+	client, err := mdms.HttpClient(creds)
+	if err != nil {
+		return nil, err
+	}
+
+	// Get a Sheets API backend
+	mdms.Sheet, err = NewSheetsAPIBackend(mdms.Ctx, client, mdms.C.SheetID)
+	if err != nil {
+		return nil, err
+	}
+
+	return mdms.Sheet, nil
+}
+
+// Get an authenticated http(s) client, used to read/write the Google Sheet. Clients are cached
+// and reused by later requests
+func (mdms *GSuiteMDMService) HttpClient(creds string) (*http.Client, error) {
 	// Since we are using a service account's JSON credentials to write, we need to specify
 	// an actual G Suite user (required by Google)
-	conf.Subject = mdms.C.SheetWho
-
-	// Return the authenticated http client
-	return conf.Client(oauth2.NoContext), nil
+	return jwtClient(creds, mdms.C.SheetScope, mdms.C.SheetWho)
 }
 
 // Merge Datastore and Sheet data
@@ -131,6 +190,14 @@ func (mdms *GSuiteMDMService) MergeDatastoreAndSheetData() []DatastoreMobileDevi
 		d.UnknownSources = dsv.UnknownSources
 		d.USBADB = dsv.USBADB
 		d.WifiMac = dsv.WifiMac
+		d.AssetTag = dsv.AssetTag
+		d.Carrier = dsv.Carrier
+		d.Cost = dsv.Cost
+		d.OwnerHistory = dsv.OwnerHistory
+		d.Plan = dsv.Plan
+		d.PurchaseDate = dsv.PurchaseDate
+		d.Vendor = dsv.Vendor
+		d.WarrantyExpiry = dsv.WarrantyExpiry
 
 		// Add the local-to-sheet data for this specific mobile device (if it exists)
 		for _, shv := range mdms.SheetData {
@@ -172,47 +239,85 @@ func (mdms *GSuiteMDMService) SearchSheetForDevice(device *admin.MobileDevice) (
 	return d, errors.New(fmt.Sprintf("Could not find device"))
 }
 
-// Update the Google Sheet
-func (mdms *GSuiteMDMService) UpdateSheet(mergeddata []DatastoreMobileDevice) error {
-	// We need to get the credentials to read the Google Sheet from Secret Manager
-	ctx := context.Background()
+// Write the sheet-owned fields (Phone Number, Color, RAM & Notes) of devices to their existing rows
+// in the Google Sheet, so the next sync doesn't overwrite changes made outside the sheet. Devices
+// without a row are skipped, the next sheet update adds them with their Datastore data
+func (mdms *GSuiteMDMService) UpdateSheetDevices(devices []DatastoreMobileDevice) error {
+	if len(devices) < 1 {
+		return nil
+	}
 
-	// Retrieve the credentials necessary to read/write to/from the Google Sheet from Secret Manager
-	creds, err := GetSecret(ctx, mdms.C.SheetCredsID)
+	// Get a sheet backend
+	sb, err := mdms.getSheetBackend()
 	if err != nil {
 		return err
 	}
 
-	// Get an authenticated http client
-	client, err := mdms.HttpClient(creds)
+	// Find each device's row by serial number
+	rows, err := sb.ReadRows(fmt.Sprintf("A%d:%s", sheetFirstDataRow+1, a1Column(len(sheetColumns)-1)))
 	if err != nil {
 		return err
 	}
+	idx := make(map[string]int)
+	for k, r := range rows {
+		sn := strings.Replace(sheetCell(r, sheetColumnSN), " ", "", -1)
+		if sn != "" {
+			idx[sn] = sheetFirstDataRow + 1 + k
+		}
+	}
 
-	// Get a Google Sheets service
-	gss := spreadsheet.NewServiceWithClient(client)
+	var data []SheetRange
+	for _, d := range devices {
+		row, ok := idx[strings.Replace(d.SN, " ", "", -1)]
+		if !ok {
+			continue
+		}
+		cells := []struct {
+			col int
+			v   string
+		}{
+			{sheetColumnPhone, strings.Replace(d.PhoneNumber, " ", "", -1)},
+			{sheetColumnColor, d.Color},
+			{sheetColumnRAM, d.RAM},
+			{sheetColumnNotes, d.Notes},
+		}
+		for _, c := range cells {
+			data = append(data, SheetRange{Range: fmt.Sprintf("%s%d", a1Column(c.col), row), Values: [][]string{{c.v}}})
+		}
 
-	// Fetch the Google sheet
-	sheet, err := gss.FetchSpreadsheet(mdms.C.SheetID)
-	if err != nil {
-		return err
+		// Keep any sheet data already read in step
+		for k := range mdms.SheetData {
+			if strings.Replace(mdms.SheetData[k].SN, " ", "", -1) == strings.Replace(d.SN, " ", "", -1) {
+				mdms.SheetData[k].PhoneNumber = strings.Replace(d.PhoneNumber, " ", "", -1)
+				mdms.SheetData[k].Color = d.Color
+				mdms.SheetData[k].RAM = d.RAM
+				mdms.SheetData[k].Notes = d.Notes
+			}
+		}
+	}
+	if len(data) < 1 {
+		return nil
 	}
 
-	// Select the first worksheet
-	ws, err := sheet.SheetByIndex(0)
+	return sb.WriteRanges(data)
+}
 
-	// Note that we start at row 2 because row0 == "Last updated" line and row1 == Header
-	var row = 2
+// Update the Google Sheet
+func (mdms *GSuiteMDMService) UpdateSheet(mergeddata []DatastoreMobileDevice) error {
+	// Get a sheet backend
+	sb, err := mdms.getSheetBackend()
+	if err != nil {
+		return err
+	}
 
 	// Set time zone to be as configured
 	loc, err := time.LoadLocation(mdms.C.TimeZone)
 	if err != nil {
 		return err
 	}
-	// Update the Last Updated timestamp in the sheet
-	ws.Update(0, 1, time.Now().In(loc).Format(time.RFC1123))
 
-	// Range through the canonical device data
+	// Build the rows. Note that row0 == "Last updated" line and row1 == Header
+	var rows [][]string
 	for _, upd := range mergeddata {
 		// convert last sync strings to time.Time so we can humanize them
 		lts, err := time.Parse(time.RFC3339, upd.SyncLast)
@@ -220,33 +325,63 @@ func (mdms *GSuiteMDMService) UpdateSheet(mergeddata []DatastoreMobileDevice) er
 			return err
 		}
 
-		// Update each column, per row
-		ws.Update(row, 0, upd.Domain)
-		ws.Update(row, 1, strings.Replace(upd.PhoneNumber, " ", "", -1))
-		ws.Update(row, 2, upd.Color)
-		ws.Update(row, 3, upd.RAM)
-		ws.Update(row, 4, upd.Name)
-		ws.Update(row, 5, upd.Status)
-		ws.Update(row, 6, upd.Email)
-		ws.Update(row, 7, upd.Model)
-		ws.Update(row, 8, strings.Replace(upd.IMEI, " ", "", -1))
-		ws.Update(row, 9, strings.Replace(upd.SN, " ", "", -1))
-		ws.Update(row, 10, humanize.Time(lts))
-		ws.Update(row, 11, upd.OS)
-		ws.Update(row, 12, upd.Type)
-		ws.Update(row, 13, upd.WifiMac)
-		ws.Update(row, 14, upd.CompromisedStatus)
-		ws.Update(row, 15, strconv.FormatBool(upd.DeveloperMode))
-		ws.Update(row, 16, strconv.FormatBool(upd.UnknownSources))
-		ws.Update(row, 17, strconv.FormatBool(upd.USBADB))
-		ws.Update(row, 18, upd.Notes)
-
-		// Incremement the row count
-		row++
+		rows = append(rows, []string{
+			upd.Domain,
+			strings.Replace(upd.PhoneNumber, " ", "", -1),
+			upd.Color,
+			upd.RAM,
+			upd.Name,
+			upd.Status,
+			upd.Email,
+			upd.Model,
+			strings.Replace(upd.IMEI, " ", "", -1),
+			strings.Replace(upd.SN, " ", "", -1),
+			humanize.Time(lts),
+			upd.OS,
+			upd.Type,
+			upd.WifiMac,
+			upd.CompromisedStatus,
+			strconv.FormatBool(upd.DeveloperMode),
+			strconv.FormatBool(upd.UnknownSources),
+			strconv.FormatBool(upd.USBADB),
+			upd.Notes,
+			upd.AssetTag,
+			upd.Vendor,
+			upd.PurchaseDate,
+			formatCost(upd.Cost),
+			upd.WarrantyExpiry,
+			upd.Carrier,
+			upd.Plan,
+		})
+	}
+
+	lastcol := a1Column(len(sheetColumns) - 1)
+
+	// Blank out old device rows beyond the new ones, so that removed devices don't linger. This
+	// is done in the same write rather than by clearing them first, so a failed write never
+	// leaves the sheet empty
+	old, err := sb.ReadRows(fmt.Sprintf("A%d:%s", sheetFirstDataRow+1, lastcol))
+	if err != nil {
+		return err
+	}
+	for len(rows) < len(old) {
+		rows = append(rows, make([]string, len(sheetColumns)))
+	}
+
+	// Write the Last Updated timestamp, the header and all device rows in one go
+	err = sb.WriteRanges([]SheetRange{
+		{Range: "B1", Values: [][]string{{time.Now().In(loc).Format(time.RFC1123)}}},
+		{Range: fmt.Sprintf("A%d:%s%d", sheetHeaderRow+1, lastcol, sheetHeaderRow+1), Values: [][]string{sheetColumns}},
+		{Range: fmt.Sprintf("A%d:%s", sheetFirstDataRow+1, lastcol), Values: rows},
+	})
+	if err != nil {
+		return err
 	}
 
-	// Save all changes to the Sheet
-	err = ws.Synchronize()
+	// Make the header row stand out
+	err = sb.Format([]SheetFormat{
+		{Row: sheetHeaderRow, Column: 0, NumRows: 1, NumColumns: len(sheetColumns), Bold: true},
+	})
 	if err != nil {
 		return err
 	}
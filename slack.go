@@ -0,0 +1,250 @@
+package gsuitemdm
+
+//
+// GSuiteMDM Slack app funcs
+//
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Slack app permissions
+const (
+	SlackPermAll    string = "*"
+	SlackPermBlock  string = "block"
+	SlackPermDevice string = "device"
+	SlackPermSearch string = "search"
+)
+
+// Maximum age of a signed Slack request
+const SlackMaxRequestAge time.Duration = 5 * time.Minute
+
+// Context key used to mark a request as verified by VerifySlackRequests
+type slackVerifiedKey struct{}
+
+// Signatures seen within the replay window, and when their requests' timestamps leave it. Slack
+// retries are re-signed, so a signature should never be seen twice.
+var (
+	slackSeen   = make(map[string]time.Time)
+	slackSeenMu sync.Mutex
+)
+
+// Wrap an http.HandlerFunc so that it is only called for requests with a valid Slack
+// signature. The signing secret is retrieved from Secret Manager using sid. The request body
+// is restored so the wrapped handler can parse it as usual.
+func VerifySlackRequests(sid string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// Null message body?
+		if r.Body == nil {
+			http.Error(w, "Error: Null message body", 400)
+			return
+		}
+
+		// Read the raw body, we need it to verify the request signature
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			log.Printf("Error reading message body: %s", err)
+			http.Error(w, "Error reading message body", 400)
+			return
+		}
+		r.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+		// Get the Slack signing secret from Secret Manager
+		secret, err := GetSecret(r.Context(), sid)
+		if err != nil {
+			log.Printf("Error retrieving Slack signing secret from Secret Manager: %s", err)
+			http.Error(w, "Error retrieving Slack signing secret from Secret Manager", 500)
+			return
+		}
+
+		// Verify the request came from Slack, and hasn't been seen before
+		now := time.Now()
+		err = VerifySlackSignature(r.Header, body, strings.TrimSpace(secret), now)
+		if err == nil {
+			// The timestamp has been checked by VerifySlackSignature
+			secs, _ := strconv.ParseInt(r.Header.Get("X-Slack-Request-Timestamp"), 10, 64)
+			err = checkSlackReplay(r.Header.Get("X-Slack-Signature"), time.Unix(secs, 0), now)
+		}
+		if err != nil {
+			log.Printf("Error: %s RemoteIP=%s", err, GetIP(r))
+			http.Error(w, "Not authorized", 401)
+			return
+		}
+
+		next(w, r.WithContext(context.WithValue(r.Context(), slackVerifiedKey{}, true)))
+	}
+}
+
+// Check that a request signature has not been seen while its timestamp is within the replay
+// window. Requests may be dated up to SlackMaxRequestAge ahead, so each signature is kept until
+// its own timestamp leaves the window
+func checkSlackReplay(sig string, ts, now time.Time) error {
+	slackSeenMu.Lock()
+	defer slackSeenMu.Unlock()
+
+	// Forget signatures whose requests would now be rejected as too old anyway
+	for s, expires := range slackSeen {
+		if now.After(expires) {
+			delete(slackSeen, s)
+		}
+	}
+
+	if _, ok := slackSeen[sig]; ok {
+		return errors.New("Replayed Slack request")
+	}
+	slackSeen[sig] = ts.Add(SlackMaxRequestAge)
+
+	return nil
+}
+
+// Parse a Slack slash command request. The request must have been verified by VerifySlackRequests.
+func ParseSlackRequest(r *http.Request) (*SlackRequest, error) {
+	if v, _ := r.Context().Value(slackVerifiedKey{}).(bool); !v {
+		return nil, errors.New("Slack request has not been verified")
+	}
+
+	// Decode the x-www-form-urlencoded message body
+	err := r.ParseForm()
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("Error decoding Slack x-www-form-urlencoded message body: %s", err))
+	}
+
+	return &SlackRequest{
+		ChannelID:      r.Form.Get("channel_id"),
+		ChannelName:    r.Form.Get("channel_name"),
+		Command:        r.Form.Get("command"),
+		EnterpriseID:   r.Form.Get("enterprise_id"),
+		EnterpriseName: r.Form.Get("enterprise_name"),
+		ResponseURL:    r.Form.Get("response_url"),
+		TeamDomain:     r.Form.Get("team_domain"),
+		TeamID:         r.Form.Get("team_id"),
+		Text:           strings.TrimSpace(r.Form.Get("text")),
+		Token:          r.Form.Get("token"),
+		TriggerID:      r.Form.Get("trigger_id"),
+		UserID:         r.Form.Get("user_id"),
+		UserName:       r.Form.Get("user_name"),
+	}, nil
+}
+
+// Parse a Slack interactive message payload. The request must have been verified by VerifySlackRequests.
+func ParseSlackInteraction(r *http.Request) (*SlackInteraction, error) {
+	var ia SlackInteraction
+
+	if v, _ := r.Context().Value(slackVerifiedKey{}).(bool); !v {
+		return nil, errors.New("Slack request has not been verified")
+	}
+
+	// Decode the x-www-form-urlencoded message body
+	err := r.ParseForm()
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("Error decoding Slack x-www-form-urlencoded message body: %s", err))
+	}
+
+	err = json.Unmarshal([]byte(r.Form.Get("payload")), &ia)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("Error decoding Slack interaction payload: %s", err))
+	}
+
+	return &ia, nil
+}
+
+// Verify the signature of a request from Slack using the app's signing secret
+// Refer to https://api.slack.com/authentication/verifying-requests-from-slack
+func VerifySlackSignature(h http.Header, body []byte, secret string, now time.Time) error {
+	sig := h.Get("X-Slack-Signature")
+	ts := h.Get("X-Slack-Request-Timestamp")
+	if sig == "" || ts == "" {
+		return errors.New("Missing Slack signature")
+	}
+
+	// Reject stale (or replayed) requests
+	secs, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil {
+		return errors.New("Invalid Slack request timestamp")
+	}
+	age := now.Sub(time.Unix(secs, 0))
+	if age > SlackMaxRequestAge || age < -SlackMaxRequestAge {
+		return errors.New("Slack request timestamp outside of allowed window")
+	}
+
+	// Compute the expected signature
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte("v0:" + ts + ":"))
+	mac.Write(body)
+	expected := "v0=" + hex.EncodeToString(mac.Sum(nil))
+
+	if !hmac.Equal([]byte(sig), []byte(expected)) {
+		return errors.New("Incorrect Slack signature")
+	}
+
+	return nil
+}
+
+// Check if a Slack user has been granted a permission
+func (mdms *GSuiteMDMService) SlackUserPermitted(userid, perm string) bool {
+	for _, u := range mdms.C.SlackUsers {
+		if u.UserID != userid {
+			continue
+		}
+		for _, p := range u.Permissions {
+			if p == perm || p == SlackPermAll {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// Post a delayed response to a Slack response_url
+// Refer to https://api.slack.com/interactivity/handling#message_responses
+func PostSlackResponse(url string, msg SlackMessage) error {
+	js, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+
+	resp, err := http.Post(url, "application/json", bytes.NewBuffer(js))
+	if err != nil {
+		return errors.New(fmt.Sprintf("Error posting Slack response: %s", err))
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return errors.New(fmt.Sprintf("Error posting Slack response: %s", resp.Status))
+	}
+
+	return nil
+}
+
+// Build a Slack Block Kit section block containing mrkdwn text
+func SlackSection(text string) SlackBlock {
+	return SlackBlock{Type: "section", Text: &SlackText{Type: "mrkdwn", Text: text}}
+}
+
+// Build a Slack Block Kit button
+func SlackButton(text, actionid, value, style string) SlackElement {
+	return SlackElement{
+		ActionID: actionid,
+		Style:    style,
+		Text:     SlackText{Type: "plain_text", Text: text},
+		Type:     "button",
+		Value:    value,
+	}
+}
+
+// EOF
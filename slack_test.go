@@ -0,0 +1,106 @@
+package gsuitemdm
+
+//
+// GSuiteMDM Slack app tests
+//
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// Sign a Slack request the way Slack does
+func signSlackRequest(secret, ts string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte("v0:" + ts + ":"))
+	mac.Write(body)
+
+	return "v0=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Requests must be signed with the signing secret, within the allowed clock skew
+func TestVerifySlackSignature(t *testing.T) {
+	secret := "8f742231b10e8888abcd99yyyzzz85a5"
+	body := []byte("token=xyzz0WbapA4vBCDEFasx0q6G&team_id=T1DC2JH3J&command=%2Fmdm&text=search+pixel")
+	now := time.Unix(1700000000, 0)
+	ts := func(d time.Duration) string { return strconv.FormatInt(now.Add(d).Unix(), 10) }
+
+	tests := []struct {
+		name    string
+		ts      string
+		sig     string
+		body    []byte
+		wanterr bool
+	}{
+		{name: "valid", ts: ts(0), sig: signSlackRequest(secret, ts(0), body), body: body},
+		{name: "old within window", ts: ts(-SlackMaxRequestAge + time.Second), sig: signSlackRequest(secret, ts(-SlackMaxRequestAge+time.Second), body), body: body},
+		{name: "ahead within window", ts: ts(SlackMaxRequestAge - time.Second), sig: signSlackRequest(secret, ts(SlackMaxRequestAge-time.Second), body), body: body},
+		{name: "too old", ts: ts(-SlackMaxRequestAge - time.Second), sig: signSlackRequest(secret, ts(-SlackMaxRequestAge-time.Second), body), body: body, wanterr: true},
+		{name: "too far ahead", ts: ts(SlackMaxRequestAge + time.Second), sig: signSlackRequest(secret, ts(SlackMaxRequestAge+time.Second), body), body: body, wanterr: true},
+		{name: "timestamp not signed", ts: ts(-time.Second), sig: signSlackRequest(secret, ts(0), body), body: body, wanterr: true},
+		{name: "tampered body", ts: ts(0), sig: signSlackRequest(secret, ts(0), body), body: []byte("command=%2Fmdm&text=wipe"), wanterr: true},
+		{name: "wrong secret", ts: ts(0), sig: signSlackRequest("not the secret", ts(0), body), body: body, wanterr: true},
+		{name: "invalid timestamp", ts: "yesterday", sig: signSlackRequest(secret, "yesterday", body), body: body, wanterr: true},
+		{name: "missing signature", ts: ts(0), body: body, wanterr: true},
+		{name: "missing timestamp", sig: signSlackRequest(secret, "", body), body: body, wanterr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := http.Header{}
+			if tt.sig != "" {
+				h.Set("X-Slack-Signature", tt.sig)
+			}
+			if tt.ts != "" {
+				h.Set("X-Slack-Request-Timestamp", tt.ts)
+			}
+			err := VerifySlackSignature(h, tt.body, secret, now)
+			if (err != nil) != tt.wanterr {
+				t.Errorf("VerifySlackSignature() error = %v, wanterr %v", err, tt.wanterr)
+			}
+		})
+	}
+}
+
+// A signature can only be used once while its timestamp is within the replay window
+func TestCheckSlackReplay(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+
+	// Each step runs in order against the same replay window. ts is the request's timestamp, at
+	// when it is checked
+	tests := []struct {
+		name    string
+		sig     string
+		ts      time.Duration
+		at      time.Duration
+		wanterr bool
+	}{
+		{name: "first use", sig: "v0=aaaa", ts: 0, at: 0},
+		{name: "different signature", sig: "v0=bbbb", ts: time.Second, at: time.Second},
+		{name: "replayed", sig: "v0=aaaa", ts: 0, at: 2 * time.Second, wanterr: true},
+		{name: "replayed at end of window", sig: "v0=aaaa", ts: 0, at: SlackMaxRequestAge, wanterr: true},
+		{name: "aged out of window", sig: "v0=aaaa", ts: 0, at: SlackMaxRequestAge + time.Second},
+		{name: "future dated", sig: "v0=cccc", ts: 2 * SlackMaxRequestAge, at: SlackMaxRequestAge + 2*time.Second},
+		{name: "future dated replayed after a window", sig: "v0=cccc", ts: 2 * SlackMaxRequestAge, at: 2*SlackMaxRequestAge + 3*time.Second, wanterr: true},
+		{name: "future dated replayed at end of window", sig: "v0=cccc", ts: 2 * SlackMaxRequestAge, at: 3 * SlackMaxRequestAge, wanterr: true},
+		{name: "future dated aged out of window", sig: "v0=cccc", ts: 2 * SlackMaxRequestAge, at: 3*SlackMaxRequestAge + time.Second},
+	}
+
+	slackSeenMu.Lock()
+	slackSeen = make(map[string]time.Time)
+	slackSeenMu.Unlock()
+
+	for _, tt := range tests {
+		err := checkSlackReplay(tt.sig, now.Add(tt.ts), now.Add(tt.at))
+		if (err != nil) != tt.wanterr {
+			t.Errorf("%s: checkSlackReplay() error = %v, wanterr %v", tt.name, err, tt.wanterr)
+		}
+	}
+}
+
+// EOF
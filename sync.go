@@ -0,0 +1,289 @@
+package gsuitemdm
+
+//
+// GSuiteMDM resilient multi-domain sync of Datastore with the Admin SDK
+//
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+	admin "google.golang.org/api/admin/directory/v1"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Default sync settings
+const (
+	DefaultSyncBurst               int     = 5
+	DefaultSyncConcurrency         int     = 4
+	DefaultSyncRequestsPerSecond   float64 = 5
+	DefaultSyncRetries             int     = 3
+	DefaultSyncRetryBackoffSeconds int     = 2
+)
+
+// Pages of devices that may be waiting to be written while the next pages are listed
+const syncPageBuffer int = 4
+
+// Sync one domain: list its devices from the Admin SDK a page at a time, writing them to Datastore
+// in batches while the next pages are listed. Transient errors are retried with backoff
+func (mdms *GSuiteMDMService) SyncDomain(domain string) DomainSyncResult {
+	var wg sync.WaitGroup
+
+	start := time.Now()
+	r := DomainSyncResult{Domain: domain}
+
+	// How many devices to write at a time?
+	size := mdms.C.Sync.BatchSize
+	if size < 1 || size > MaxDatastoreBatch {
+		size = MaxDatastoreBatch
+	}
+
+	// Write devices as they are listed. Batches that fail don't stop the others
+	pages := make(chan []*admin.MobileDevice, syncPageBuffer)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+
+		var batch []*admin.MobileDevice
+		write := func() {
+			b := batch
+			batch = nil
+			r.Batches++
+			_, err := mdms.withRetry(func() error {
+				return mdms.UpdateDatastoreDevices(b)
+			})
+			if err != nil {
+				log.Printf("Error updating %d device(s) in domain %s: %s", len(b), domain, err)
+				r.Failed += len(b)
+				return
+			}
+			r.Written += len(b)
+		}
+
+		for page := range pages {
+			for _, device := range page {
+				batch = append(batch, device)
+				if len(batch) == size {
+					write()
+				}
+			}
+		}
+		if len(batch) > 0 {
+			write()
+		}
+	}()
+
+	// Get the domain's devices
+	attempts, waited, err := mdms.ListAdminSDKDevicePages(domain, func(page []*admin.MobileDevice) error {
+		r.Pages++
+		r.Seen += len(page)
+		pages <- page
+		return nil
+	})
+	close(pages)
+	wg.Wait()
+
+	r.Attempts = attempts
+	r.RateLimited = waited.String()
+	r.Duration = time.Since(start).String()
+	r.DevicesPerSecond = perSecond(r.Written, time.Since(start))
+
+	switch {
+	case err != nil:
+		r.Error = fmt.Sprintf("Error getting Admin SDK data: %s", err)
+		mdms.Publish(Event{Domain: domain, Type: EventSyncFailed, Message: fmt.Sprintf("Sync of domain %s failed: %s", domain, err)})
+	case r.Failed > 0:
+		r.Error = fmt.Sprintf("%d of %d device(s) could not be written", r.Failed, r.Seen)
+	}
+
+	return r
+}
+
+// Sync several domains, up to the configured number at a time. progress (optional) is called
+// with each domain's result as it finishes; if it returns an error, no more domains are started
+func (mdms *GSuiteMDMService) SyncDomains(domains []string, progress func(DomainSyncResult) error) (*SyncResult, error) {
+	var mu sync.Mutex
+	var perr error
+	var wg sync.WaitGroup
+
+	start := time.Now()
+	sr := &SyncResult{Domains: make([]DomainSyncResult, len(domains)), Started: start.Format(time.RFC3339)}
+
+	// Set up notifications first, as domains publish events concurrently
+	if mdms.Events == nil {
+		bus, err := mdms.NewConfiguredEventBus()
+		if err != nil {
+			log.Printf("Error setting up notifications: %s", err)
+			bus = NewEventBus()
+		}
+		mdms.Events = bus
+	}
+
+	// How many domains at a time?
+	n := mdms.C.Sync.Concurrency
+	if n < 1 {
+		n = DefaultSyncConcurrency
+	}
+	sem := make(chan struct{}, n)
+
+	for i, domain := range domains {
+		sem <- struct{}{}
+
+		// Stop starting domains once progress says so
+		mu.Lock()
+		stop := perr != nil
+		mu.Unlock()
+		if stop {
+			<-sem
+			break
+		}
+
+		wg.Add(1)
+		go func(i int, domain string) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			r := mdms.SyncDomain(domain)
+
+			mu.Lock()
+			defer mu.Unlock()
+			sr.Domains[i] = r
+			if progress != nil && perr == nil {
+				perr = progress(r)
+			}
+		}(i, domain)
+	}
+	wg.Wait()
+
+	// Totals
+	for i, r := range sr.Domains {
+		if r.Domain == "" {
+			sr.Domains[i] = DomainSyncResult{Domain: domains[i], Error: "Not started"}
+		}
+		if sr.Domains[i].Error != "" {
+			sr.FailedDomains++
+		}
+		sr.Failed += r.Failed
+		sr.Seen += r.Seen
+		sr.Written += r.Written
+	}
+	sr.Finished = time.Now().Format(time.RFC3339)
+	sr.Duration = time.Since(start).String()
+	sr.DevicesPerSecond = perSecond(sr.Written, time.Since(start))
+
+	return sr, perr
+}
+
+// Get a domain's Admin SDK rate limiter, shared by everything that lists the domain's devices
+func (mdms *GSuiteMDMService) DomainLimiter(domain string) *rate.Limiter {
+	mdms.mu.Lock()
+	defer mdms.mu.Unlock()
+
+	if l, ok := mdms.limiters[domain]; ok {
+		return l
+	}
+
+	// Token bucket of Burst requests, refilled at RequestsPerSecond
+	limit := rate.Limit(mdms.C.Sync.RequestsPerSecond)
+	switch {
+	case mdms.C.Sync.RequestsPerSecond < 0:
+		limit = rate.Inf
+	case mdms.C.Sync.RequestsPerSecond == 0:
+		limit = rate.Limit(DefaultSyncRequestsPerSecond)
+	}
+	burst := mdms.C.Sync.Burst
+	if burst < 1 {
+		burst = DefaultSyncBurst
+	}
+
+	if mdms.limiters == nil {
+		mdms.limiters = make(map[string]*rate.Limiter)
+	}
+	l := rate.NewLimiter(limit, burst)
+	mdms.limiters[domain] = l
+
+	return l
+}
+
+// Run f, retrying transient errors with exponential backoff. Returns the number of attempts made
+func (mdms *GSuiteMDMService) withRetry(f func() error) (int, error) {
+	retries := mdms.C.Sync.Retries
+	switch {
+	case retries < 0:
+		retries = 0
+	case retries == 0:
+		retries = DefaultSyncRetries
+	}
+	backoff := time.Duration(mdms.C.Sync.RetryBackoffSeconds) * time.Second
+	if backoff <= 0 {
+		backoff = time.Duration(DefaultSyncRetryBackoffSeconds) * time.Second
+	}
+
+	attempts := 0
+	for {
+		attempts++
+		err := f()
+		if err == nil || attempts > retries || !isTransient(err) {
+			return attempts, err
+		}
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+// Is an error worth retrying? Rate limits, server errors & timeouts are
+func isTransient(err error) bool {
+	switch e := err.(type) {
+	case *googleapi.Error:
+		switch e.Code {
+		case http.StatusTooManyRequests, http.StatusInternalServerError, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+			return true
+		}
+		// The Admin SDK reports some rate limits as 403s
+		for _, ei := range e.Errors {
+			if ei.Reason == "rateLimitExceeded" || ei.Reason == "userRateLimitExceeded" {
+				return true
+			}
+		}
+		return false
+	case net.Error:
+		return e.Timeout() || e.Temporary()
+	}
+
+	// Datastore errors
+	switch status.Code(err) {
+	case codes.Aborted, codes.DeadlineExceeded, codes.ResourceExhausted, codes.Unavailable:
+		return true
+	}
+
+	return false
+}
+
+// Throughput, to one decimal place
+func perSecond(n int, d time.Duration) float64 {
+	if d <= 0 {
+		return 0
+	}
+
+	return math.Round(float64(n)/d.Seconds()*10) / 10
+}
+
+// Did any domain fail to sync?
+func (sr *SyncResult) Err() error {
+	if sr.FailedDomains > 0 {
+		return errors.New(fmt.Sprintf("Sync failed for %d of %d domain(s)", sr.FailedDomains, len(sr.Domains)))
+	}
+
+	return nil
+}
+
+// EOF
@@ -5,17 +5,28 @@ package gsuitemdm
 //
 
 import (
+	"cloud.google.com/go/datastore"
 	"context"
+	"golang.org/x/time/rate"
 	admin "google.golang.org/api/admin/directory/v1"
+	"sync"
 )
 
 // G Suite MDM Service main struct type
 type GSuiteMDMService struct {
 	C             GSuiteMDMConfig         // Main configuration
 	Ctx           context.Context         // Context
+	DS            *datastore.Client       // Shared Datastore client. If nil, created on first use
 	DatastoreData []DatastoreMobileDevice // Datastore mobile device data
+	Events        *EventBus               // Device event bus. If nil, created from config on first use
+	Jobs          JobWorker               // Job worker. If nil, submitted jobs stay queued until run
 	SDKData       *admin.MobileDevices    // Admin SDK mobile device data
+	Secrets       SecretProvider          // Provider of secrets named in the configuration. If nil, the bootstrap provider is used
+	Sheet         SheetBackend            // Google Sheet backend. If nil, the Sheets API is used
 	SheetData     []DatastoreMobileDevice // Google Sheet mobile device data
+
+	limiters map[string]*rate.Limiter // Admin SDK rate limiter of each domain
+	mu       sync.Mutex               // Guards DS & limiters
 }
 
 // G Suite MDM Service config struct type
@@ -24,26 +35,85 @@ type GSuiteMDMConfig struct {
 	// See SearchScope for more details
 	ActionScope string `json:"actionscope"`
 
+	// How long to wait for the Admin SDK to confirm the result of an action
+	ActionVerify ActionVerifyConf `json:"actionverify"`
+
+	// Wipe types that may be used. Possible values are "account", "device". Defaults to both
+	AllowedWipeTypes []string `json:"allowedwipetypes"`
+
 	// Default sort order of devices returned by the Admin API query parameter: orderBy.
 	// Refer to https://developers.google.com/admin-sdk/directory/v1/reference/mobiledevices/list
 	APIQueryOrderBy string `json:"apiqueryorderby"`
 
+	// Caching of secrets across requests
+	Cache CacheConf `json:"cache"`
+
+	// Mobile access compliance reporting
+	Compliance ComplianceConf `json:"compliance"`
+
 	// Default sort order of devices returned by Cloud Datastore
 	DatastoreQueryOrderBy string `json:"datastorequeryorderby"`
 
+	// Default region used to parse phone numbers that are not in international format, as an
+	// ISO 3166-1 two-letter country code. Defaults to "US"
+	DefaultRegion string `json:"defaultregion"`
+
+	// Required G Suite Admin SDK scope to read users for the directory. Default value for this
+	// should be: "https://www.googleapis.com/auth/admin.directory.user.readonly"
+	DirectoryScope string `json:"directoryscope"`
+
 	// Global debug mode?
 	Debug bool `json:"globaldebug"`
 
 	// Datastore namekey
 	DSNamekey string `json:"dsnamekey"`
 
+	// Datastore namekey for cached directory users
+	DUNamekey string `json:"dunamekey"`
+
+	// Datastore namekey for long-running jobs. Defaults to "Job"
+	JBNamekey string `json:"jbnamekey"`
+
+	// How long-running jobs are run
+	Jobs JobsConf `json:"jobs"`
+
+	// Lost/stolen device workflow
+	Lost LostConf `json:"lost"`
+
+	// Device event notifications
+	Notifications NotificationConf `json:"notifications"`
+
+	// What happens to a departing user's devices
+	Offboarding OffboardConf `json:"offboarding"`
+
+	// GCP Secret Manager ID of an optional second API key that may only perform operator actions
+	// (approve, block, cancel wipe, unblock). The main API key may perform any action
+	OperatorKeyID string `json:"operatorkeyid"`
+
+	// Device owner self-service notices & responses
+	Owners OwnerConf `json:"owners"`
+
+	// Datastore namekey for carrier phone lines
+	PLNamekey string `json:"plnamekey"`
+
 	// Project ID of the GCP project
 	ProjectID string `json:"projectid"`
 
-	// What type of Remote Wipe will we use for the "wipe" command? Possible values are:
+	// Required G Suite Admin SDK scope to read audit activities for the compliance report's
+	// G Suite access log source. Default value for this should be:
+	// "https://www.googleapis.com/auth/admin.reports.audit.readonly"
+	ReportsScope string `json:"reportsscope"`
+
+	// What type of Remote Wipe will we use for the "wipe" command when no wipe type is requested
+	// and the device's ownership is unknown? Possible values are: "admin_account_wipe",
+	// "admin_remote_wipe". If not set, the wipe type is based on the device's inferred ownership.
 	// Refer to https://developers.google.com/admin-sdk/directory/v1/reference/mobiledevices/action
 	RemoteWipeType string `json:"remotewipetype"`
 
+	// Where the secrets named in the configuration are kept. Defaults to the bootstrap secret
+	// provider
+	Secrets SecretsConf `json:"secrets"`
+
 	// Required G Suite Admin API scope to perform SEARCH operations. Since we are using the
 	// Mobiledevices: list method of the G Suite Admin API, refer to
 	// https://developers.google.com/admin-sdk/directory/v1/reference/mobiledevices/list
@@ -78,6 +148,12 @@ type GSuiteMDMConfig struct {
 	// Who to write the spreadsheet as
 	SheetWho string `json:"sheetwho"`
 
+	// Slack users allowed to use the Slack app, and what they are allowed to do
+	SlackUsers SlackUsers `json:"slackusers"`
+
+	// How Datastore is synced with the Admin SDK
+	Sync SyncConf `json:"sync"`
+
 	// Time Zone
 	TimeZone string `json:"timezone"`
 
@@ -108,4 +184,122 @@ type DomainConf struct {
 	SecretID string `json:"secretid"`
 }
 
+// Post-action verification configuration
+type ActionVerifyConf struct {
+	// Seconds between checks of a device's status. Defaults to 2
+	IntervalSeconds int `json:"intervalseconds"`
+
+	// Seconds to wait for a device to reach its expected status. Defaults to 30, a negative
+	// value checks the device once without waiting
+	TimeoutSeconds int `json:"timeoutseconds"`
+}
+
+// Secret caching configuration
+type CacheConf struct {
+	// Seconds the latest version of a secret named in the configuration is cached for. Defaults
+	// to 300, a negative value disables caching of latest versions. Pinned versions (secret IDs
+	// ending in /versions/N) never change, so are always cached. The configuration itself & the
+	// API keys are cached for 300 seconds (see SetSecretCacheTTL)
+	SecretTTLSeconds int `json:"secretttlseconds"`
+}
+
+// Secret provider configuration of the secrets named in the configuration. The bootstrap secret
+// provider, used to read the configuration itself & the API keys, is set by the SECRETS_PROVIDER
+// etc. environment variables
+type SecretsConf struct {
+	// file: directory containing a file (or directory of versions) for each secret
+	Dir string `json:"dir"`
+
+	// env: prefix of the environment variable of each secret. Defaults to "SECRET_"
+	EnvPrefix string `json:"envprefix"`
+
+	// Where secrets are kept. Possible values are "secretmanager" (the default), "file", "env",
+	// "vault"
+	Provider string `json:"provider"`
+
+	// vault: address of the Vault server, e.g. "https://vault.example.com:8200". The Vault
+	// token is read from the VAULT_TOKEN environment variable
+	VaultAddress string `json:"vaultaddress"`
+
+	// vault: field of each secret holding its value. Defaults to "value"
+	VaultField string `json:"vaultfield"`
+
+	// vault: mount path of the KV version 2 secrets engine. Defaults to "secret"
+	VaultMount string `json:"vaultmount"`
+}
+
+// Datastore sync configuration
+type SyncConf struct {
+	// How many devices are written to Datastore at a time. Defaults to (and may not be more than) 500
+	BatchSize int `json:"batchsize"`
+
+	// How many Admin SDK requests may be made at once for a domain, before being limited to
+	// RequestsPerSecond. Defaults to 5
+	Burst int `json:"burst"`
+
+	// How many domains are synced at a time. Defaults to 4
+	Concurrency int `json:"concurrency"`
+
+	// Admin SDK requests per second allowed for each domain, to stay within the Admin SDK's
+	// quotas. Defaults to 5, a negative value disables rate limiting
+	RequestsPerSecond float64 `json:"requestspersecond"`
+
+	// How many times transient Admin SDK & Datastore errors are retried. Defaults to 3, a
+	// negative value disables retries
+	Retries int `json:"retries"`
+
+	// Seconds to wait before the first retry, doubling for each retry after. Defaults to 2
+	RetryBackoffSeconds int `json:"retrybackoffseconds"`
+}
+
+// Long-running job configuration
+type JobsConf struct {
+	// Seconds a running job may go without a heartbeat before it is marked failed. Running jobs
+	// send a heartbeat every quarter of this. Defaults to 120
+	LeaseSeconds int `json:"leaseseconds"`
+
+	// Pub/Sub topic that submitted jobs are published to, for a background cloud function to
+	// run. If not set, submitted jobs stay queued until /v1/jobs/run is called
+	Topic string `json:"topic"`
+}
+
+// Lost/stolen device workflow configuration
+type LostConf struct {
+	// Hours after a device is reported lost that its account is wiped. Defaults to 24
+	AccountWipeHours int `json:"accountwipehours"`
+
+	// Hours after a device is reported lost that the device is wiped. Defaults to 72
+	DeviceWipeHours int `json:"devicewipehours"`
+}
+
+// Offboarding plan configuration
+type OffboardConf struct {
+	// Action for personally owned (BYOD) devices. Possible values are "block",
+	// "admin_account_wipe", "admin_remote_wipe". Defaults to "admin_account_wipe"
+	BYODAction string `json:"byodaction"`
+
+	// Action for corporate owned devices. Defaults to "admin_remote_wipe"
+	CorporateAction string `json:"corporateaction"`
+
+	// Days after offboarding that device records are deleted from G Suite MDM & Datastore.
+	// 0 never deletes them
+	DeleteAfterDays int `json:"deleteafterdays"`
+}
+
+// Struct used for Slack app users. Just a slice of the user-specific permission struct
+type SlackUsers []SlackUserConf
+
+// Specific Slack user permissions
+type SlackUserConf struct {
+	// Slack user name (informational only)
+	Name string `json:"name"`
+
+	// Permissions granted to this user. Possible values are "search", "device", "block",
+	// or "*" for all permissions
+	Permissions []string `json:"permissions"`
+
+	// Slack user ID, e.g. U012AB3CD
+	UserID string `json:"userid"`
+}
+
 // EOF
@@ -0,0 +1,164 @@
+package gsuitemdm
+
+//
+// GSuiteMDM types for mobile access compliance reporting
+//
+
+import (
+	"time"
+)
+
+// A single login seen by an access log source
+type AccessLogEntry struct {
+	DateLast  time.Time `json:"datelast"`  // Time of the most recent login
+	Email     string    `json:"email"`     // Email address of the user
+	IP        string    `json:"ip"`        // IP address of the client
+	Name      string    `json:"name"`      // Full Name of the user
+	Source    string    `json:"source"`    // Name of the access log source, e.g. "slack"
+	UserAgent string    `json:"useragent"` // User agent of the client
+	UserID    string    `json:"userid"`    // Source-specific ID of the user
+	Username  string    `json:"username"`  // Source-specific user name
+}
+
+// A single user in a compliance report
+type ComplianceUser struct {
+	Clients  []string  `json:"clients"`  // Mobile clients the user was seen using
+	Devices  int       `json:"devices"`  // Number of Approved devices in the device store
+	Email    string    `json:"email"`    // Email address of the user
+	LastSeen time.Time `json:"lastseen"` // Time of the user's most recent mobile login
+	Name     string    `json:"name"`     // Full Name of the user
+	Sources  []string  `json:"sources"`  // Names of the access log sources the user was seen in
+	UserID   string    `json:"userid"`   // Source-specific ID of the user
+	Username string    `json:"username"` // Source-specific user name
+}
+
+// A single user agent classification rule
+type UserAgentRule struct {
+	Client  string `json:"client"`  // Name of the client, e.g. "Slack mobile"
+	Mobile  bool   `json:"mobile"`  // Is this a mobile client?
+	Pattern string `json:"pattern"` // Case-insensitive regular expression matched against the user agent
+}
+
+// Specific access log source configuration
+type AccessLogSourceConf struct {
+	// Type of source. Possible values are "slack", "gsuite", "csv"
+	Type string `json:"type"`
+
+	// gsuite: Reports API application to read activities for. Defaults to "token", the only
+	// application whose events have client details to classify
+	Application string `json:"application"`
+
+	// gsuite: number of days of activities to read. Defaults to 7
+	Days int `json:"days"`
+
+	// csv: path of the CSV file
+	Path string `json:"path"`
+}
+
+// A mobile access compliance report
+type ComplianceReport struct {
+	Compliant    []ComplianceUser `json:"compliant"`    // Users whose mobile logins are covered by MDM
+	Generated    time.Time        `json:"generated"`    // Time the report was generated
+	NonCompliant []ComplianceUser `json:"noncompliant"` // Users with mobile logins not covered by MDM
+	Title        string           `json:"title"`        // Report title
+}
+
+// Compliance reporting configuration
+type ComplianceConf struct {
+	// Report title. Defaults to "Mobile Access Compliance Report"
+	Title string `json:"title"`
+
+	// Slack access log source: Secret Manager ID of a Slack API token with the admin scope
+	// (required for team.accessLogs)
+	SlackTokenID string `json:"slacktokenid"`
+
+	// Slack access log source: maximum number of pages of access logs to read. Defaults to 5
+	SlackPages int `json:"slackpages"`
+
+	// Slack access log source: prefix used to link to Slack users in reports, e.g.
+	// "https://yourslack.slack.com/team/"
+	SlackUserURL string `json:"slackuserurl"`
+
+	// Where compliance reports are delivered
+	Sinks []ReportSinkConf `json:"sinks"`
+
+	// Where logins are read from. Defaults to the Slack access logs
+	Sources []AccessLogSourceConf `json:"sources"`
+
+	// Rules used to decide which logins are from mobile clients. Defaults to DefaultUserAgentRules
+	UserAgentRules []UserAgentRule `json:"useragentrules"`
+}
+
+// Specific compliance report sink configuration
+type ReportSinkConf struct {
+	// Type of sink. Possible values are "smtp", "sendgrid", "slack", "webhook", "jsonfile"
+	Type string `json:"type"`
+
+	// smtp, sendgrid: sender & recipients
+	From     string   `json:"from"`
+	FromName string   `json:"fromname"`
+	ReplyTo  string   `json:"replyto"`
+	To       []string `json:"to"`
+
+	// smtp: mail server & user
+	Host     string `json:"host"`
+	Port     int    `json:"port"`
+	Username string `json:"username"`
+
+	// smtp: Secret Manager ID of the password. sendgrid: Secret Manager ID of the API key
+	SecretID string `json:"secretid"`
+
+	// slack: incoming webhook URL. webhook: URL the JSON report is POSTed to
+	URL string `json:"url"`
+
+	// jsonfile: path of the file the JSON report is written to
+	Path string `json:"path"`
+}
+
+// Slack access log (team.accessLogs) response
+// Refer to https://api.slack.com/methods/team.accessLogs
+type SlackAccessLog struct {
+	Error      string                `json:"error"`
+	Logins     []SlackAccessLogEntry `json:"logins"`
+	PagingData SlackAccessLogPaging  `json:"paging"`
+	Status     bool                  `json:"ok"`
+}
+
+type SlackAccessLogEntry struct {
+	Count     int    `json:"count"`
+	Country   string `json:"country"`
+	DateFirst int64  `json:"date_first"`
+	DateLast  int64  `json:"date_last"`
+	IP        string `json:"ip"`
+	ISP       string `json:"isp"`
+	Region    string `json:"region"`
+	UserAgent string `json:"user_agent"`
+	UserID    string `json:"user_id"`
+	Username  string `json:"username"`
+}
+
+type SlackAccessLogPaging struct {
+	Count int `json:"count"`
+	Page  int `json:"page"`
+	Pages int `json:"pages"`
+	Total int `json:"total"`
+}
+
+// Slack user (users.info) response, only the fields we care about
+// Refer to https://api.slack.com/methods/users.info
+type SlackUserInfo struct {
+	Error string `json:"error"`
+	Ok    bool   `json:"ok"`
+	User  struct {
+		Deleted  bool   `json:"deleted"`
+		ID       string `json:"id"`
+		Name     string `json:"name"`
+		RealName string `json:"real_name"`
+		Profile  struct {
+			Email    string `json:"email"`
+			RealName string `json:"real_name"`
+		} `json:"profile"`
+	} `json:"user"`
+}
+
+// EOF
@@ -7,30 +7,126 @@ package gsuitemdm
 // A single mobile device type, stored in Datastore.
 // Based on https://developers.google.com/admin-sdk/directory/v1/reference/mobiledevices#resource
 type DatastoreMobileDevice struct {
-	Color             string // Color of device
-	CompromisedStatus string // Is the device compromised?
-	Domain            string // G Suite domain
-	DeveloperMode     bool   // Is the device in developer mode?
-	Email             string // Email address of device owner
-	EncryptionStatus  string // Is the device encrypted?
-	IMEI              string // IMEI
-	Model             string // Model
-	Name              string // Full Name of device owner
-	Notes             string // Notes
-	OS                string // Operating System
-	OSBuild           string // OS Build
-	PasswordStatus    string // Password status
-	PhoneNumber       string // Telephone number of the device
-	RAM               string // RAM in GB
-	ResourceId        string // MDM ID for device
-	SN                string // Serial number
-	Status            string // Device status
-	SyncFirst         string // First sync device time
-	SyncLast          string // Most recent device sync time
-	Type              string // Type of G Suite sync
-	UnknownSources    bool   // Are unknown sources of apps allowed on the device?
-	USBADB            bool   // Is ADB/USB debugging enabled?
-	WifiMac           string // Wifi MAC address
+	AssetTag          string            // Asset tag
+	Carrier           string            // Mobile carrier
+	Color             string            // Color of device
+	CompromisedStatus string            // Is the device compromised?
+	Cost              float64           // Purchase cost
+	Domain            string            // G Suite domain
+	DeveloperMode     bool              // Is the device in developer mode?
+	Email             string            // Email address of device owner
+	EncryptionStatus  string            // Is the device encrypted?
+	IMEI              string            // IMEI
+	Lost              LostReport        // Lost/stolen report, if the device has ever been reported lost
+	Model             string            // Model
+	Name              string            // Full Name of device owner
+	Notes             string            // Notes
+	Offboarded        OffboardRecord    // Offboarding of the device's owner, if they have left
+	OS                string            // Operating System
+	OSBuild           string            // OS Build
+	OwnerHistory      []OwnerAssignment // History of owner assignments, oldest first
+	Ownership         string            // Who owns the device ("corporate", "byod"), empty if unknown
+	OwnerNotice       OwnerNotice       // Most recent notice sent to the owner
+	OwnerResponses    []OwnerResponse   // Responses from the owner, oldest first
+	PasswordStatus    string            // Password status
+	PhoneNumber       string            // Telephone number of the device
+	Plan              string            // Carrier plan
+	PurchaseDate      string            // Purchase date (YYYY-MM-DD)
+	RAM               string            // RAM in GB
+	ResourceId        string            // MDM ID for device
+	SN                string            // Serial number
+	Stale             bool              // Has the device not synced for Notifications.StaleDays?
+	Status            string            // Device status
+	SyncFirst         string            // First sync device time
+	SyncLast          string            // Most recent device sync time
+	Type              string            // Type of G Suite sync
+	UnknownSources    bool              // Are unknown sources of apps allowed on the device?
+	USBADB            bool              // Is ADB/USB debugging enabled?
+	Vendor            string            // Vendor the device was purchased from
+	WarrantyExpiry    string            // Warranty expiry date (YYYY-MM-DD)
+	WifiMac           string            // Wifi MAC address
+}
+
+// A single assignment of a mobile device to an owner
+type OwnerAssignment struct {
+	Email string // Email address of owner
+	Name  string // Full Name of owner
+	From  string // Time the device was assigned to this owner (RFC3339)
+	To    string // Time the device stopped being assigned to this owner (RFC3339), empty if current
+}
+
+// A lost/stolen device report
+type LostReport struct {
+	AccountWipeAt  string     // Time the account wipe is due (RFC3339)
+	AccountWiped   bool       // Has the account wipe been issued?
+	DeviceWipeAt   string     // Time the device wipe is due (RFC3339)
+	DeviceWiped    bool       // Has the device wipe been issued?
+	Found          string     // Time the device was reported found (RFC3339), empty if still lost
+	PreviousStatus string     // Device status before it was reported lost
+	Reported       string     // Time the device was reported lost (RFC3339)
+	ReportedBy     string     // Who reported the device lost
+	Steps          []LostStep // Everything done about the report, oldest first
+}
+
+// A single step of a lost/stolen device report
+type LostStep struct {
+	By     string // Who (or what) did it
+	Detail string // What happened
+	Step   string // "lost", "block", "admin_account_wipe", "admin_remote_wipe", "found", "approve", "error"
+	Time   string // Time of the step (RFC3339)
+}
+
+// Offboarding of a mobile device's owner
+type OffboardRecord struct {
+	Action   string // Action taken on the device
+	By       string // Who offboarded the owner
+	DeleteAt string // Time the device record is due to be deleted (RFC3339), empty if never
+	Email    string // Email address of the offboarded owner
+	Time     string // Time of the offboarding (RFC3339)
+}
+
+// A notice sent to the owner of a mobile device
+type OwnerNotice struct {
+	Expires string // Time the notice's link expires (RFC3339)
+	Nonce   string // Nonce of the notice's link, empty once the link has been used
+	Reason  string // Why the owner was contacted
+	Sent    string // Time the notice was sent (RFC3339)
+}
+
+// A single response from the owner of a mobile device
+type OwnerResponse struct {
+	Action   string // What the owner did ("acknowledge", "notes", "wipe")
+	Email    string // Email address of the owner
+	Notes    string // Notes from the owner
+	RemoteIP string // IP address the response came from
+	Time     string // Time of the response (RFC3339)
+}
+
+// A single directory user, cached in Datastore.
+// Based on https://developers.google.com/admin-sdk/directory/v1/reference/users#resource
+type DirectoryUser struct {
+	Department   string   // Department
+	DevicePhones []string // Phone numbers of the user's mobile devices
+	Domain       string   // G Suite domain
+	Email        string   // Primary email address
+	Manager      string   // Email address of the user's manager
+	Name         string   // Full Name
+	OrgUnit      string   // Org unit path
+	Phones       []string // Phone numbers from the G Suite user profile
+	Title        string   // Job title
+	Updated      string   // Time this entry was last refreshed (RFC3339)
+}
+
+// A single carrier phone line, stored in Datastore
+type PhoneLine struct {
+	Carrier     string  // Mobile carrier
+	DeviceSN    string  // Serial number of the device this line is assigned to
+	Email       string  // Email address of the user this line is assigned to
+	MonthlyCost float64 // Monthly cost of the line
+	Name        string  // Full Name of the user this line is assigned to
+	Notes       string  // Notes
+	Number      string  // Phone number, in E.164 format
+	Plan        string  // Carrier plan
 }
 
 // Multiple mobile devices
@@ -38,4 +134,32 @@ type DatastoreMobileDevices struct {
 	Mobiledevices []DatastoreMobileDevice
 }
 
+// Result of syncing a domain's devices from the Admin SDK to Datastore
+type DomainSyncResult struct {
+	Attempts         int     `json:"attempts"`         // Admin SDK requests made to list the domain's devices, including retries
+	Batches          int     `json:"batches"`          // Batches of devices written to Datastore
+	DevicesPerSecond float64 `json:"devicespersecond"` // Devices written per second
+	Domain           string  `json:"domain"`           // G Suite domain
+	Duration         string  `json:"duration"`         // How long the sync took
+	Error            string  `json:"error"`            // Why the sync failed, if it did
+	Failed           int     `json:"failed"`           // Devices that could not be written
+	Pages            int     `json:"pages"`            // Pages of devices listed by the Admin SDK
+	RateLimited      string  `json:"ratelimited"`      // How long Admin SDK requests waited for the domain's rate limiter
+	Seen             int     `json:"seen"`             // Devices reported by the Admin SDK
+	Written          int     `json:"written"`          // Devices written to Datastore
+}
+
+// Result of syncing several domains
+type SyncResult struct {
+	DevicesPerSecond float64            `json:"devicespersecond"` // Devices written per second, across all domains
+	Domains          []DomainSyncResult `json:"domains"`          // Result of each domain
+	Duration         string             `json:"duration"`         // How long the sync took
+	Failed           int                `json:"failed"`           // Total devices that could not be written
+	FailedDomains    int                `json:"faileddomains"`    // Domains that failed
+	Finished         string             `json:"finished"`         // When the sync finished
+	Seen             int                `json:"seen"`             // Total devices reported by the Admin SDK
+	Started          string             `json:"started"`          // When the sync started
+	Written          int                `json:"written"`          // Total devices written to Datastore
+}
+
 // EOF
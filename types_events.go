@@ -0,0 +1,68 @@
+package gsuitemdm
+
+//
+// GSuiteMDM types for device events & notifications
+//
+
+import (
+	"time"
+)
+
+// Type of device event
+type EventType string
+
+// A single device event
+type Event struct {
+	Device  *DatastoreMobileDevice `json:"device,omitempty"` // Device the event is about, if any
+	Domain  string                 `json:"domain"`           // G Suite domain
+	Message string                 `json:"message"`          // Human readable description of the event
+	Time    time.Time              `json:"time"`             // Time of the event
+	Type    EventType              `json:"type"`             // Type of event
+}
+
+// Notification configuration
+type NotificationConf struct {
+	// Configured notifiers
+	Notifiers []NotifierConf `json:"notifiers"`
+
+	// Which events are sent to which notifiers
+	Routes []NotificationRoute `json:"routes"`
+
+	// Devices that have not synced for this many days are reported as stale. 0 disables
+	StaleDays int `json:"staledays"`
+}
+
+// Specific notifier configuration
+type NotifierConf struct {
+	// Unique name of the notifier, used in routes
+	Name string `json:"name"`
+
+	// Type of notifier. Possible values are "slack", "email", "webhook"
+	Type string `json:"type"`
+
+	// email: sender, recipients & mail server
+	From     string   `json:"from"`
+	FromName string   `json:"fromname"`
+	Host     string   `json:"host"`
+	Port     int      `json:"port"`
+	To       []string `json:"to"`
+	Username string   `json:"username"`
+
+	// email: Secret Manager ID of the SMTP password. webhook: Secret Manager ID of the HMAC
+	// signing secret
+	SecretID string `json:"secretid"`
+
+	// slack: incoming webhook URL. webhook: URL events are POSTed to
+	URL string `json:"url"`
+}
+
+// Specific notification routing rule
+type NotificationRoute struct {
+	// Event types this route applies to, or "*" for all events
+	Events []string `json:"events"`
+
+	// Names of the notifiers events are sent to
+	Notifiers []string `json:"notifiers"`
+}
+
+// EOF
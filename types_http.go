@@ -4,22 +4,48 @@ package gsuitemdm
 // GSuiteMDM types for HTTP requests
 //
 
+import (
+	"time"
+)
+
 // Action (Approve, Block, Delete, Wipe)
 type ActionRequest struct {
-	Action  string `json:"action"`
-	Confirm bool   `json:"confirm"`
-	Debug   bool   `json:"debug"`
-	Domain  string `json:"domain"`
-	IMEI    string `json:"imei"`
-	Key     string `json:"key"`
-	SN      string `json:"sn"`
+	Action   string `json:"action"`
+	Confirm  bool   `json:"confirm"`
+	Debug    bool   `json:"debug"`
+	Domain   string `json:"domain"`
+	IMEI     string `json:"imei"`
+	Key      string `json:"key"`
+	SN       string `json:"sn"`
+	Then     string `json:"then"`     // Cancel wipe only: "activate" (default) or "block"
+	WipeType string `json:"wipetype"` // Wipe only: "account" or "device". Defaults by device ownership
+}
+
+// Asset update. Only fields that are present are changed, an empty string clears a field
+type AssetRequest struct {
+	AssetTag       *string `json:"assettag,omitempty"`
+	Carrier        *string `json:"carrier,omitempty"`
+	Cost           *string `json:"cost,omitempty"`
+	Debug          bool    `json:"debug"`
+	Key            string  `json:"key"`
+	Ownership      *string `json:"ownership,omitempty"`
+	Plan           *string `json:"plan,omitempty"`
+	PurchaseDate   *string `json:"purchasedate,omitempty"`
+	SN             string  `json:"sn"`
+	Vendor         *string `json:"vendor,omitempty"`
+	WarrantyExpiry *string `json:"warrantyexpiry,omitempty"`
 }
 
 // Individual directory entry
 type DirectoryData struct {
-	Name        string `json:"name"`
-	Email       string `json:"email"`
-	PhoneNumber string `json:"phonenumber"`
+	Name        string   `json:"name"`
+	Email       string   `json:"email"`
+	PhoneNumber string   `json:"phonenumber"`
+	Department  string   `json:"department,omitempty"`
+	Manager     string   `json:"manager,omitempty"`
+	OrgUnit     string   `json:"orgunit,omitempty"`
+	Phones      []string `json:"phones,omitempty"`
+	Title       string   `json:"title,omitempty"`
 }
 
 // Multiple directory entries
@@ -27,6 +53,88 @@ type AllDirectoryData struct {
 	Data []DirectoryData
 }
 
+// Import (sheet-owned device fields)
+type ImportRequest struct {
+	Confirm bool             `json:"confirm"`
+	Debug   bool             `json:"debug"`
+	Devices []ImportedDevice `json:"devices"`
+	Key     string           `json:"key"`
+}
+
+// Import response
+type ImportResponse struct {
+	Applied bool           `json:"applied"`
+	Changes []DeviceChange `json:"changes"`
+}
+
+// Lost/stolen device (found, list, lost, process)
+type LostRequest struct {
+	Action string `json:"action"`
+	By     string `json:"by"`
+	Debug  bool   `json:"debug"`
+	Key    string `json:"key"`
+	Notes  string `json:"notes"`
+	SN     string `json:"sn"`
+}
+
+// Offboarding (offboard, plan, process)
+type OffboardRequest struct {
+	Action  string `json:"action"`
+	By      string `json:"by"`
+	Confirm bool   `json:"confirm"`
+	Debug   bool   `json:"debug"`
+	Email   string `json:"email"`
+	Key     string `json:"key"`
+}
+
+// Consolidated offboarding report
+type OffboardReport struct {
+	Applied bool             `json:"applied"` // Were the actions applied, or is this just the plan?
+	By      string           `json:"by"`
+	Devices []OffboardDevice `json:"devices"`
+	Email   string           `json:"email"`
+	Errors  []string         `json:"errors"`
+	Time    time.Time        `json:"time"`
+}
+
+// A single device in an offboarding report
+type OffboardDevice struct {
+	Action    string                 `json:"action"`   // Planned (or taken) action, empty if none
+	DeleteAt  string                 `json:"deleteat"` // When the device record will be deleted
+	Device    *DatastoreMobileDevice `json:"device"`
+	Error     string                 `json:"error,omitempty"`
+	Ownership string                 `json:"ownership"` // "corporate" or "byod"
+	Result    string                 `json:"result"`    // What happened
+}
+
+// Owner notices. If SN is not set, the owners of all pending & non-compliant devices (in Domain,
+// if set) without an outstanding notice are contacted
+type OwnerNoticeRequest struct {
+	Debug  bool   `json:"debug"`
+	Domain string `json:"domain"`
+	Key    string `json:"key"`
+	SN     string `json:"sn"`
+}
+
+// Owner notice response
+type OwnerNoticeResponse struct {
+	Errors   []string `json:"errors"`
+	Notified []string `json:"notified"`
+}
+
+// Phone line (add, delete, link, list, unassigned, unlink, update)
+type PhoneLineRequest struct {
+	Action      string   `json:"action"`
+	Carrier     *string  `json:"carrier,omitempty"`
+	Debug       bool     `json:"debug"`
+	Key         string   `json:"key"`
+	MonthlyCost *float64 `json:"monthlycost,omitempty"`
+	Notes       *string  `json:"notes,omitempty"`
+	Number      string   `json:"number"`
+	Plan        *string  `json:"plan,omitempty"`
+	SN          string   `json:"sn"`
+}
+
 // Search
 type SearchRequest struct {
 	Debug        bool   `json:"debug"`
@@ -38,7 +146,67 @@ type SearchRequest struct {
 	SlackToken   string `json:"slacktoken"`
 }
 
-// Slack Search Request (nicked from https://github.com/nlopes/slack)
+// Slack Block Kit message, used for slash command responses and response_url posts
+// Refer to https://api.slack.com/reference/block-kit
+type SlackMessage struct {
+	Blocks          []SlackBlock `json:"blocks,omitempty"`
+	Channel         string       `json:"channel,omitempty"`
+	DeleteOriginal  bool         `json:"delete_original,omitempty"`
+	ReplaceOriginal bool         `json:"replace_original,omitempty"`
+	ResponseType    string       `json:"response_type,omitempty"`
+	Text            string       `json:"text"`
+}
+
+// Slack Block Kit layout block (section, actions, context, divider)
+type SlackBlock struct {
+	BlockID  string         `json:"block_id,omitempty"`
+	Elements []SlackElement `json:"elements,omitempty"`
+	Fields   []SlackText    `json:"fields,omitempty"`
+	Text     *SlackText     `json:"text,omitempty"`
+	Type     string         `json:"type"`
+}
+
+// Slack Block Kit element (button, or text in a context block)
+type SlackElement struct {
+	ActionID string        `json:"action_id,omitempty"`
+	Confirm  *SlackConfirm `json:"confirm,omitempty"`
+	Style    string        `json:"style,omitempty"`
+	Text     interface{}   `json:"text,omitempty"`
+	Type     string        `json:"type"`
+	Value    string        `json:"value,omitempty"`
+}
+
+// Slack Block Kit text object
+type SlackText struct {
+	Text string `json:"text"`
+	Type string `json:"type"`
+}
+
+// Slack Block Kit confirmation dialog
+type SlackConfirm struct {
+	Confirm SlackText `json:"confirm"`
+	Deny    SlackText `json:"deny"`
+	Text    SlackText `json:"text"`
+	Title   SlackText `json:"title"`
+}
+
+// Slack interactive message payload (block_actions)
+// Refer to https://api.slack.com/reference/interaction-payloads/block-actions
+type SlackInteraction struct {
+	Actions []struct {
+		ActionID string `json:"action_id"`
+		Value    string `json:"value"`
+	} `json:"actions"`
+	ResponseURL string `json:"response_url"`
+	Type        string `json:"type"`
+	User        struct {
+		ID       string `json:"id"`
+		Username string `json:"username"`
+	} `json:"user"`
+}
+
+// Slack Search Request (nicked from https://github.com/nlopes/slack). Token is Slack's deprecated
+// verification token and is not checked, requests are verified using VerifySlackRequests
 type SlackRequest struct {
 	Token          string `json:"token"`
 	TeamID         string `json:"team_id"`
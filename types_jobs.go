@@ -0,0 +1,52 @@
+package gsuitemdm
+
+//
+// GSuiteMDM types for long-running jobs
+//
+
+// A long-running job, such as a sync, sheet export or bulk action
+type Job struct {
+	By       string        `json:"by"`                         // Who submitted the job
+	Cancel   bool          `json:"cancel"`                     // Has cancellation been requested?
+	Created  string        `json:"created"`                    // When the job was submitted
+	Error    string        `json:"error" datastore:",noindex"` // Why the job failed, if it did
+	Finished string        `json:"finished"`                   // When the job finished
+	ID       string        `json:"id"`                         // Job ID
+	Params   JobParams     `json:"params"`                     // What the job should do
+	Progress []JobProgress `json:"progress"`                   // Progress of each domain or device the job works on
+	Role     string        `json:"role"`                       // Role of the submitter
+	Started  string        `json:"started"`                    // When the job started running
+	State    string        `json:"state"`                      // queued, running, succeeded, failed or cancelled
+	Sync     SyncResult    `json:"sync"`                       // Sync jobs: result of each domain
+	Type     string        `json:"type"`                       // action, sheet or sync
+	Updated  string        `json:"updated"`                    // When the job was last updated
+}
+
+// Job parameters
+type JobParams struct {
+	Action   string   `json:"action"`   // Action jobs: registered action to perform, e.g. "block"
+	Devices  []string `json:"devices"`  // Action jobs: serial numbers of the devices
+	Domain   string   `json:"domain"`   // G Suite domain. Sync jobs default to all domains
+	Then     string   `json:"then"`     // Cancel wipe action jobs: "activate" or "block"
+	WipeType string   `json:"wipetype"` // Wipe action jobs: "account" or "device"
+}
+
+// Progress of a single domain or device within a job
+type JobProgress struct {
+	Detail string `json:"detail"`                     // What happened
+	Error  string `json:"error" datastore:",noindex"` // Error, if any
+	Item   string `json:"item"`                       // Domain name or device serial number
+	Status string `json:"status"`                     // running, done or failed
+	Time   string `json:"time"`                       // When the status last changed
+}
+
+// Job submission request
+type JobRequest struct {
+	By     string    `json:"by"`
+	Debug  bool      `json:"debug"`
+	Key    string    `json:"key"`
+	Params JobParams `json:"params"`
+	Type   string    `json:"type"`
+}
+
+// EOF
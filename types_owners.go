@@ -0,0 +1,60 @@
+package gsuitemdm
+
+//
+// GSuiteMDM types for device owner self-service
+//
+
+// Owner self-service configuration
+type OwnerConf struct {
+	// URL of the OwnerResponse cloud function that owner links point to
+	ResponseURL string `json:"responseurl"`
+
+	// Secret Manager ID of the secret used to sign owner links
+	SecretID string `json:"secretid"`
+
+	// How long owner links are valid for, in hours. Defaults to 72
+	ExpiryHours int `json:"expiryhours"`
+
+	// Email: sender & mail server. Email is not sent if host is not set
+	From     string `json:"from"`
+	FromName string `json:"fromname"`
+	Host     string `json:"host"`
+	Port     int    `json:"port"`
+	Username string `json:"username"`
+
+	// Email: Secret Manager ID of the SMTP password
+	SMTPSecretID string `json:"smtpsecretid"`
+
+	// Slack DMs: Secret Manager ID of a Slack bot token with the users:read.email and chat:write
+	// scopes. Slack DMs are not sent if not set
+	SlackTokenID string `json:"slacktokenid"`
+
+	// Message templates by notice reason ("pending", "noncompliant"). Defaults to
+	// DefaultOwnerTemplates
+	Templates map[string]OwnerTemplate `json:"templates"`
+}
+
+// Owner notice message template. Templates use text/template, and are passed OwnerTemplateData
+type OwnerTemplate struct {
+	Body    string `json:"body"`
+	Subject string `json:"subject"`
+}
+
+// Data passed to owner notice templates
+type OwnerTemplateData struct {
+	Device   *DatastoreMobileDevice // The owner's device
+	Expires  string                 // When the link expires
+	Link     string                 // Signed one-time link to the owner response page
+	Problems []string               // Why the device is non-compliant, if it is
+	Reason   string                 // Why the owner is being contacted
+}
+
+// Signed owner link payload
+type OwnerToken struct {
+	Email   string `json:"e"` // Email address of the device owner the link was sent to
+	Expires int64  `json:"x"` // Unix time the link expires
+	Nonce   string `json:"n"` // Must match the device's outstanding notice
+	SN      string `json:"s"` // Serial number of the device
+}
+
+// EOF
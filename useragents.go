@@ -0,0 +1,61 @@
+package gsuitemdm
+
+//
+// GSuiteMDM user agent classification
+//
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+)
+
+// Default user agent rules, used if none are configured. Rules are checked in order and the
+// first match wins; user agents that match no rule are not mobile clients.
+var DefaultUserAgentRules = []UserAgentRule{
+	{Client: "Slack mobile", Mobile: true, Pattern: `com\.tinyspeck\.chatlyio`},
+	{Client: "Google iOS app", Mobile: true, Pattern: `client_type=NATIVE_IOS`},
+	{Client: "Google Android app", Mobile: true, Pattern: `client_type=NATIVE_ANDROID`},
+	{Client: "Desktop", Mobile: false, Pattern: `Windows NT|Macintosh|X11|CrOS`},
+	{Client: "iOS", Mobile: true, Pattern: `iPhone|iPad|iPod|\biOS\b`},
+	{Client: "Android", Mobile: true, Pattern: `Android`},
+	{Client: "Mobile browser", Mobile: true, Pattern: `Mobile`},
+}
+
+// Classifies user agents as mobile clients (or not) using a table of rules
+type UserAgentClassifier struct {
+	rules []UserAgentRule
+	res   []*regexp.Regexp
+}
+
+// Create a user agent classifier. If rules is empty, DefaultUserAgentRules are used
+func NewUserAgentClassifier(rules []UserAgentRule) (*UserAgentClassifier, error) {
+	if len(rules) < 1 {
+		rules = DefaultUserAgentRules
+	}
+
+	uac := &UserAgentClassifier{rules: rules}
+	for k, r := range rules {
+		// Patterns are case-insensitive
+		re, err := regexp.Compile("(?i)" + r.Pattern)
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("Invalid user agent rule %d (%s): %s", k+1, r.Client, err))
+		}
+		uac.res = append(uac.res, re)
+	}
+
+	return uac, nil
+}
+
+// Classify a user agent, returning the name of the client and whether it is a mobile client
+func (uac *UserAgentClassifier) Classify(ua string) (string, bool) {
+	for k, re := range uac.res {
+		if re.MatchString(ua) {
+			return uac.rules[k].Client, uac.rules[k].Mobile
+		}
+	}
+
+	return "", false
+}
+
+// EOF
@@ -0,0 +1,72 @@
+package slackusermdmchecker
+
+//
+// GSuiteMDM slackusermdmchecker recorded test data, used by ?test=yes
+//
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/rickt/gsuitemdm"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// An http.RoundTripper that serves recorded API responses from a directory instead of
+// calling the real API. The response for a request is read from a file named after the last
+// element of the URL path and the sorted query parameters (excluding tokens), e.g.
+// "team.accessLogs_count=1000_page=1.json"
+type fixtureTransport struct {
+	dir string
+}
+
+// Serve a recorded response
+func (ft *fixtureTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	q := req.URL.Query()
+	q.Del("token")
+
+	var keys []string
+	for k := range q {
+		keys = append(keys, k+"="+q.Get(k))
+	}
+	sort.Strings(keys)
+
+	name := strings.Join(append([]string{filepath.Base(req.URL.Path)}, keys...), "_") + ".json"
+
+	f, err := os.Open(filepath.Join(ft.dir, name))
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("No recorded response for %s: %s", req.URL, err))
+	}
+
+	return &http.Response{
+		Body:       f,
+		Header:     http.Header{"Content-Type": {"application/json"}},
+		Request:    req,
+		Status:     "200 OK",
+		StatusCode: http.StatusOK,
+	}, nil
+}
+
+// Load recorded mobile devices from a JSON file, in the same format as a JSON export
+func loadFixtureDevices(path string) ([]gsuitemdm.DatastoreMobileDevice, error) {
+	var devices []gsuitemdm.DatastoreMobileDevice
+
+	js, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	err = json.Unmarshal(js, &devices)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("Error decoding fixture devices %s: %s", path, err))
+	}
+
+	return devices, nil
+}
+
+// EOF
@@ -1,264 +1,152 @@
 package slackusermdmchecker
 
+//
+// GSuiteMDM slackusermdmchecker scheduled compliance reporter
+//
+
 import (
-	"bytes"
-	"encoding/json"
+	"cloud.google.com/go/logging"
+	"context"
 	"fmt"
-	"github.com/sendgrid/sendgrid-go"
-	"github.com/sendgrid/sendgrid-go/helpers/mail"
+	"github.com/rickt/gsuitemdm"
 	"log"
 	"net/http"
 	"os"
-	"sort"
-	"strings"
+	"path/filepath"
 	"time"
 )
 
-// Constants
-const (
-	datelayout                  = "January 2, 2006"
-	slackaccesslogsurl   string = "https://slack.com/api/team.accessLogs"
-	slackuserinfourl     string = "https://slack.com/api/users.info"
-	slackmobileuseragent string = "com.tinyspeck.chatlyio"
-)
-
-// Globals
 var (
-	mdmstatusurl string = os.Getenv("GSUITEMDMURL") + "/SearchDatastore"
-	page         int    = 1
-	pages        int    = 5
+	appname      string = os.Getenv("APPNAME")
+	sm_config_id string = os.Getenv("SM_CONFIG_ID")
+	testdata     string = "testdata"
 )
 
-// Sort funcs for users
-func (s Users) Len() int {
-	return len(s)
-}
-func (s Users) Less(i, j int) bool {
-	return s[i].SlackName < s[j].SlackName
-}
-func (s Users) Swap(i, j int) {
-	s[i], s[j] = s[j], s[i]
-}
-
-// Build HTML email
-func buildhtmlemail(cu, ncu Users) []byte {
-	// Basic setup
-	from := mail.NewEmail(os.Getenv("FROM_NAME"), os.Getenv("FROM_ADDR"))
-	to := mail.NewEmail(os.Getenv("RECIPIENTS_NAME"), os.Getenv("RECIPIENTS_ADDR"))
-	t := time.Now()
-	subject := "Company Slack + MDM Compliance Report for " + t.Format(datelayout)
-	replyto := mail.NewEmail(os.Getenv("REPLYTO_NAME"), os.Getenv("REPLYTO_ADDR"))
-
-	// Create the plaintext email body
-	var body string
-
-	// Start with non-compliant users
-	body = fmt.Sprintf("<p><strong>(%d) non-compliant accounts using a personal phone or company phone with no MDM/non-matching MDM to login to Slack:</strong><br>", len(ncu))
-	for _, x := range ncu {
-		body = body + fmt.Sprintf("&nbsp;&nbsp;&nbsp;%s (<a href=\"%s=%s\">@%s</a> &lt;%s&gt;)<br>", x.SlackUserName, os.Getenv("SLACKURL"), x.SlackUserId, x.SlackName, x.SlackEmail)
-	}
-	body = body + "</p>"
-	// Now the users with MDM
-	body = body + fmt.Sprintf("<p><strong>(%d) compliant accounts using a company phone with matching MDM to login to Slack:\n</strong><br>", len(cu))
-	for _, x := range cu {
-		body = body + fmt.Sprintf("&nbsp;&nbsp;&nbsp;%s (<a href=\"%s=%s\">@%s</a> &lt;%s&gt;)<br>", x.SlackUserName, os.Getenv("SLACKURL"), x.SlackUserId, x.SlackName, x.SlackEmail)
-	}
-	body = body + "</p>"
-	body = body + fmt.Sprintf("<p><br>This email generated & sent by %s.<br>", os.Getenv("APPNAME"))
-	body = body + fmt.Sprintf("Source code available <a href=\"%s\">here</a>.<br>", os.Getenv("SRCURL"))
-	body = body + "</p>"
-
-	// Build the message
-	content := mail.NewContent("text/html", body)
-	m := mail.NewV3MailInit(from, subject, to, content)
-	m.SetReplyTo(replyto)
-
-	return mail.GetRequestBody(m)
-}
-
-// Helper func to do a case-insensitive search
-func caseinsensitivecontains(a, b string) bool {
-	return strings.Contains(strings.ToUpper(a), strings.ToUpper(b))
-}
-
-// Get MDM status
-func getmdmstatus(email string) bool {
-	var mr MDMRequest
+// Generate a compliance report of users logging in with mobile clients, with and without
+// Approved MDM-managed devices,
+// and deliver it to the configured report sinks if ?send=yes is specified. With ?test=yes,
+// recorded Slack API responses & devices from testdata are used and nothing is delivered.
+func SlackUserMDMChecker(w http.ResponseWriter, r *http.Request) {
+	var devices []gsuitemdm.DatastoreMobileDevice
+	var err error
+	var l *logging.Client
 
-	// Build the request to send to the gsuitemdm search API
-	mr.Key = os.Getenv("GSUITEMDMTOKEN")
-	mr.QType = "email"
-	mr.Q = email
+	// Get a context
+	ctx := context.Background()
 
-	// Marshal the request into JSON
-	req, err := json.Marshal(mr)
+	// Get our app configuration from Secret Manager
+	config, err := gsuitemdm.GetSecret(ctx, sm_config_id)
 	if err != nil {
-		log.Fatal("error: %s", err)
+		log.Printf("Error retrieving app configuration from Secret Manager: %s", err)
+		http.Error(w, "Error retrieving app configuration from Secret Manager", 500)
+		return
 	}
 
-	// Send the request
-	resp, err := http.Post(mdmstatusurl, "application/json", bytes.NewBuffer(req))
+	// Get a G Suite MDM Service
+	gs, err := gsuitemdm.New(ctx, config)
 	if err != nil {
-		log.Fatal("error: %s", err)
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode == 200 {
-		// User has MDM
-		return true
-	} else {
-		// User does not have MDM
-		return false
+		// Log to stderr, will be captured as a basic Stackdriver log
+		log.Printf("Error: gsuitemdm cloudfunction %s could not start: %s", appname, err)
+		return
 	}
-}
-
-// Get Slack access logs
-func getslackaccesslogs(page int) *SlackAccessLog {
-	var sal *SlackAccessLog
 
-	// Build the url
-	logsurl := fmt.Sprintf("%s?token=%s&count=1000&page=%d", slackaccesslogsurl, os.Getenv("SLACKTOKEN"), page)
-	// Create the request
-	req, err := http.NewRequest("GET", logsurl, nil)
-	if err != nil {
-		log.Fatal("error: %s", err)
-	}
-	// Create the http client
-	client := &http.Client{}
-	// Get the response
-	response, err := client.Do(req)
-	if err != nil {
-		log.Fatal("error: %s", err)
-	}
-	defer response.Body.Close()
-	// Decode the JSON response into our SlackAccessLog var
-	if err := json.NewDecoder(response.Body).Decode(&sal); err != nil {
-		log.Println(err)
-	}
+	// Initialise Stackdriver logging for this GCP project
+	l, err = logging.NewClient(ctx, gs.C.ProjectID)
 
-	return sal
-}
+	// Register a Stackdriver logger instance for this app
+	sl := l.Logger(appname)
 
-// Get user info from the Slack API
-func getslackuserinfo(userid string) *SlackUser {
-	var su *SlackUser
+	// Test mode?
+	test := r.URL.Query().Get("test") == "yes"
 
-	// Build the url
-	uu := fmt.Sprintf("%s?token=%s&user=%s", slackuserinfourl, os.Getenv("SLACKTOKEN"), userid)
-	// Create the request
-	req, err := http.NewRequest("GET", uu, nil)
-	if err != nil {
-		log.Fatal("error: %s", err)
-	}
-	// Create the http client
-	client := &http.Client{}
-	// Get the response
-	response, err := client.Do(req)
-	if err != nil {
-		log.Fatal("error: %s", err)
-	}
-	defer response.Body.Close()
-	// Decode the JSON response into our user var
-	if err := json.NewDecoder(response.Body).Decode(&su); err != nil {
-		log.Println(err)
+	// Setup the access log sources. In test mode, recorded Slack API responses and a CSV
+	// access log are used instead
+	var sources []gsuitemdm.AccessLogSource
+	if test {
+		slack := gsuitemdm.NewSlackAccessLogSource("", gs.C.Compliance.SlackPages)
+		slack.Client = &http.Client{Transport: &fixtureTransport{dir: testdata}}
+		sources = []gsuitemdm.AccessLogSource{slack, &gsuitemdm.CSVAccessLogSource{Path: filepath.Join(testdata, "accesslogs.csv")}}
+	} else {
+		sources, err = gs.NewAccessLogSources()
+		if err != nil {
+			log.Printf("Error setting up access log sources: %s", err)
+			http.Error(w, fmt.Sprintf("Error setting up access log sources: %s", err), 500)
+			sl.Log(logging.Entry{Severity: logging.Error, Payload: "Error setting up access log sources: " + err.Error()})
+			return
+		}
 	}
 
-	return su
-}
-
-// Send email
-func sendemail(cu, ncu Users) {
-	// Create the Sendgrid API request
-	req := sendgrid.GetRequest(os.Getenv("SENDGRID_API_KEY"), "/v3/mail/send", "https://api.sendgrid.com")
-	req.Method = "POST"
-
-	// Create the email body
-	var Body []byte = buildhtmlemail(cu, ncu)
-	req.Body = Body
-
-	// Make the request to the Sendgrid API
-	resp, err := sendgrid.API(req)
+	// Setup the user agent classifier
+	uac, err := gsuitemdm.NewUserAgentClassifier(gs.C.Compliance.UserAgentRules)
 	if err != nil {
-		log.Fatal("error: %s", err)
-	}
-	if resp.StatusCode == 202 {
-		fmt.Printf("\nEmail sent.\n\n")
-	}
-}
-
-// Generate a list of naughty and nice users
-func SlackUserMDMChecker(w http.ResponseWriter, r *http.Request) {
-	// User map
-	var um map[string]*UserInfo
-	um = make(map[string]*UserInfo)
-
-	// Get the Slack access logs one page at a time as per Slack API spec
-	for page := 1; page < pages; page++ {
-		// Get this page
-		var sal *SlackAccessLog
-		sal = getslackaccesslogs(page)
-		// Range through the log entries in this page of the response
-		for _, le := range sal.Logins {
-			// We only care about mobile users
-			if caseinsensitivecontains(le.UserAgent, slackmobileuseragent) {
-				// Does this user already exist in the user map?
-				if _, ok := um[le.UserID]; !ok {
-					// It doesn't exist, get more info about the user from the Slack API
-					var su *SlackUser
-					su = getslackuserinfo(le.UserID)
-					// Add this user to the user map
-					um[le.UserID] = &UserInfo{
-						GSuiteMDM:     getmdmstatus(su.User.Profile.Email),
-						SlackEmail:    su.User.Profile.Email,
-						SlackName:     le.Username,
-						SlackUserId:   le.UserID,
-						SlackUserName: su.User.RealName,
-					}
-				}
-			}
+		log.Printf("%s", err)
+		http.Error(w, err.Error(), 500)
+		sl.Log(logging.Entry{Severity: logging.Error, Payload: err.Error()})
+		return
+	}
+
+	// Get the access logs from every source
+	var entries []gsuitemdm.AccessLogEntry
+	for _, src := range sources {
+		e, err := src.Entries()
+		if err != nil {
+			log.Printf("Error getting %s access logs: %s", src.Name(), err)
+			http.Error(w, fmt.Sprintf("Error getting %s access logs: %s", src.Name(), err), 500)
+			sl.Log(logging.Entry{Severity: logging.Error, Payload: fmt.Sprintf("Error getting %s access logs: %s", src.Name(), err)})
+			return
 		}
+		entries = append(entries, e...)
 	}
 
-	// Range through our map and find MDM-compliant/non-compliant users
-	var cu, ncu Users
-	for _, v := range um {
-		switch {
-		case v.GSuiteMDM == true:
-			// User has MDM
-			cu = append(cu, v)
-			break
-
-		case v.GSuiteMDM == false:
-			// User does not have MDM
-			ncu = append(ncu, v)
-			break
-		}
+	// Get the devices
+	if test {
+		devices, err = loadFixtureDevices(filepath.Join(testdata, "devices.json"))
+	} else {
+		err = gs.GetDatastoreData()
+		devices = gs.DatastoreData
 	}
+	if err != nil {
+		log.Printf("Error getting devices: %s", err)
+		http.Error(w, fmt.Sprintf("Error getting devices: %s", err), 500)
+		sl.Log(logging.Entry{Severity: logging.Error, Payload: "Error getting devices: " + err.Error()})
+		return
+	}
+
+	// Build the report
+	report := gsuitemdm.BuildComplianceReport(gs.C.Compliance.Title, entries, devices, uac, time.Now())
+
+	// Print out the report
+	fmt.Fprintf(w, "%s\n", report.Text())
+
+	// Deliver the report? Check the ?send parameter. If ?send=yes was not sent, or we're in
+	// test mode, do not deliver the report.
+	if r.URL.Query().Get("send") == "yes" && !test {
+		sinks, err := gs.NewReportSinks(gs.C.Compliance.SlackUserURL)
+		if err != nil {
+			log.Printf("Error setting up report sinks: %s", err)
+			http.Error(w, fmt.Sprintf("Error setting up report sinks: %s", err), 500)
+			sl.Log(logging.Entry{Severity: logging.Error, Payload: "Error setting up report sinks: " + err.Error()})
+			return
+		}
 
-	// Sort the data
-	sort.Sort(cu)
-	sort.Sort(ncu)
-
-	// Print out a report
-	fmt.Fprintf(w, "(%d) Active Slack staff using a personal phone or company phone with no MDM to login to Slack.\n", len(ncu))
-	fmt.Fprintf(w, "\n")
-	fmt.Fprintf(w, "(%d) Active Slack staff using an MDM-protected company phone to login to Slack\n", len(cu))
-
-	// Send an email? Check the ?sendemail parameter. If ?sendemail=yes was
-	// not sent, do not send the email.
-	sendit := r.URL.Query().Get("sendemail")
-	if sendit == "yes" {
-		// Send email
-		sendemail(cu, ncu)
-		fmt.Fprintf(w, "\n")
-		fmt.Fprintf(w, "Email sent.\n")
+		// Deliver to every sink, even if one fails
+		for _, s := range sinks {
+			err = s.Send(report)
+			if err != nil {
+				log.Printf("%s", err)
+				fmt.Fprintf(w, "Report NOT sent to %s: %s\n", s.Name(), err)
+				sl.Log(logging.Entry{Severity: logging.Error, Payload: err.Error()})
+				continue
+			}
+			fmt.Fprintf(w, "Report sent to %s.\n", s.Name())
+		}
 	}
 
-	fmt.Fprintf(w, "\n")
-	fmt.Fprintf(w, "%s Success\n", os.Getenv("APPNAME"))
+	// Finished
+	sl.Log(logging.Entry{Severity: logging.Notice, Payload: fmt.Sprintf("%s Success: %d compliant, %d non-compliant users RemoteIP=%s", appname, len(report.Compliant), len(report.NonCompliant), gsuitemdm.GetIP(r))})
+	fmt.Fprintf(w, "\n%s Success\n", appname)
 
 	return
-
 }
 
 // EOF
@@ -0,0 +1,113 @@
+package gsuitemdm
+
+//
+// GSuiteMDM device ownership & wipe policy
+//
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Who owns a mobile device
+const (
+	OwnershipBYOD      string = "byod"
+	OwnershipCorporate string = "corporate"
+)
+
+// Types of wipe that can be requested
+const (
+	WipeTypeAccount string = "account" // admin_account_wipe: remove the G Suite account & data only
+	WipeTypeDevice  string = "device"  // admin_remote_wipe: factory reset the whole device
+)
+
+// Admin SDK action for each wipe type
+var WipeActions = map[string]string{
+	WipeTypeAccount: ActionAccountWipe,
+	WipeTypeDevice:  ActionRemoteWipe,
+}
+
+// Check a device ownership value. An empty value means unknown
+func ValidOwnership(o string) bool {
+	return o == "" || o == OwnershipBYOD || o == OwnershipCorporate
+}
+
+// Who owns a device. Uses the device's ownership if it has been set, otherwise devices with asset
+// data (an asset tag, purchase date or cost) were bought by the company, and everything else is
+// treated as personally owned
+func DeviceOwnership(d *DatastoreMobileDevice) string {
+	if d.Ownership != "" {
+		return d.Ownership
+	}
+	if d.AssetTag != "" || d.PurchaseDate != "" || d.Cost > 0 {
+		return OwnershipCorporate
+	}
+
+	return OwnershipBYOD
+}
+
+// Convert a wipe type ("account", "device", or an Admin SDK wipe action) to its Admin SDK action
+func wipeTypeAction(t string) (string, bool) {
+	t = strings.ToLower(strings.TrimSpace(t))
+	if a, ok := WipeActions[t]; ok {
+		return a, true
+	}
+	for _, a := range WipeActions {
+		if a == t {
+			return a, true
+		}
+	}
+
+	return "", false
+}
+
+// Work out the Admin SDK wipe action for a device. If a wipe type was requested it is used,
+// otherwise devices of known ownership get an account wipe (BYOD) or device wipe (corporate),
+// and devices of unknown ownership get the configured remotewipetype, if any. The wipe type must
+// be in the configured allow-list
+func (mdms *GSuiteMDMService) WipeAction(d *DatastoreMobileDevice, requested string) (string, error) {
+	var action string
+	var ok bool
+
+	switch {
+	case requested != "":
+		action, ok = wipeTypeAction(requested)
+		if !ok {
+			return "", errors.New(fmt.Sprintf("Invalid wipe type %q (must be %q or %q)", requested, WipeTypeAccount, WipeTypeDevice))
+		}
+	case d.Ownership == "" && mdms.C.RemoteWipeType != "":
+		action, ok = wipeTypeAction(mdms.C.RemoteWipeType)
+		if !ok {
+			return "", errors.New(fmt.Sprintf("Invalid remotewipetype %q", mdms.C.RemoteWipeType))
+		}
+	case DeviceOwnership(d) == OwnershipCorporate:
+		action = WipeActions[WipeTypeDevice]
+	default:
+		action = WipeActions[WipeTypeAccount]
+	}
+
+	err := mdms.checkWipeAllowed(action)
+	if err != nil {
+		return "", err
+	}
+
+	return action, nil
+}
+
+// Check an Admin SDK wipe action is in the configured allow-list. Every wipe, however it comes
+// about, must pass this check
+func (mdms *GSuiteMDMService) checkWipeAllowed(action string) error {
+	if len(mdms.C.AllowedWipeTypes) == 0 {
+		return nil
+	}
+	for _, t := range mdms.C.AllowedWipeTypes {
+		if a, ok := wipeTypeAction(t); ok && a == action {
+			return nil
+		}
+	}
+
+	return errors.New(fmt.Sprintf("Wipe action %s is not allowed (allowedwipetypes)", action))
+}
+
+// EOF
@@ -0,0 +1,95 @@
+package gsuitemdm
+
+//
+// GSuiteMDM device ownership & wipe policy tests
+//
+
+import (
+	"testing"
+)
+
+// Wipe actions are worked out from the request, ownership & configuration, and must be allowed
+func TestWipeAction(t *testing.T) {
+	tests := []struct {
+		name       string
+		allowed    []string
+		remotewipe string
+		device     DatastoreMobileDevice
+		requested  string
+		want       string
+		wanterr    bool
+	}{
+		{name: "byod", device: DatastoreMobileDevice{Ownership: OwnershipBYOD}, want: ActionAccountWipe},
+		{name: "corporate", device: DatastoreMobileDevice{Ownership: OwnershipCorporate}, want: ActionRemoteWipe},
+		{name: "unknown with asset data", device: DatastoreMobileDevice{AssetTag: "IT-0001"}, want: ActionRemoteWipe},
+		{name: "unknown without asset data", want: ActionAccountWipe},
+		{name: "unknown uses remotewipetype", remotewipe: WipeTypeDevice, want: ActionRemoteWipe},
+		{name: "known ignores remotewipetype", remotewipe: WipeTypeDevice, device: DatastoreMobileDevice{Ownership: OwnershipBYOD}, want: ActionAccountWipe},
+		{name: "invalid remotewipetype", remotewipe: "everything", wanterr: true},
+		{name: "requested type", device: DatastoreMobileDevice{Ownership: OwnershipBYOD}, requested: WipeTypeDevice, want: ActionRemoteWipe},
+		{name: "requested admin sdk action", requested: " Admin_Remote_Wipe ", want: ActionRemoteWipe},
+		{name: "invalid requested type", requested: "everything", wanterr: true},
+		{name: "allowed", allowed: []string{WipeTypeAccount}, device: DatastoreMobileDevice{Ownership: OwnershipBYOD}, want: ActionAccountWipe},
+		{name: "allowed as admin sdk action", allowed: []string{ActionRemoteWipe}, requested: WipeTypeDevice, want: ActionRemoteWipe},
+		{name: "requested not allowed", allowed: []string{WipeTypeAccount}, requested: WipeTypeDevice, wanterr: true},
+		{name: "ownership default not allowed", allowed: []string{WipeTypeAccount}, device: DatastoreMobileDevice{Ownership: OwnershipCorporate}, wanterr: true},
+		{name: "remotewipetype not allowed", allowed: []string{WipeTypeAccount}, remotewipe: WipeTypeDevice, wanterr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mdms := &GSuiteMDMService{}
+			mdms.C.AllowedWipeTypes = tt.allowed
+			mdms.C.RemoteWipeType = tt.remotewipe
+
+			got, err := mdms.WipeAction(&tt.device, tt.requested)
+			if (err != nil) != tt.wanterr {
+				t.Fatalf("WipeAction() error = %v, wanterr %v", err, tt.wanterr)
+			}
+			if got != tt.want {
+				t.Errorf("WipeAction() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// Offboarding wipes must be allowed too
+func TestOffboardAction(t *testing.T) {
+	tests := []struct {
+		name      string
+		allowed   []string
+		byod      string
+		corporate string
+		ownership string
+		want      string
+		wanterr   bool
+	}{
+		{name: "byod default", ownership: OwnershipBYOD, want: ActionAccountWipe},
+		{name: "corporate default", ownership: OwnershipCorporate, want: ActionRemoteWipe},
+		{name: "configured", byod: ActionBlock, ownership: OwnershipBYOD, want: ActionBlock},
+		{name: "invalid action", corporate: "shred", ownership: OwnershipCorporate, wanterr: true},
+		{name: "allowed", allowed: []string{WipeTypeDevice}, ownership: OwnershipCorporate, want: ActionRemoteWipe},
+		{name: "block needs no allow-list", allowed: []string{WipeTypeAccount}, byod: ActionBlock, ownership: OwnershipBYOD, want: ActionBlock},
+		{name: "default not allowed", allowed: []string{WipeTypeAccount}, ownership: OwnershipCorporate, wanterr: true},
+		{name: "configured not allowed", allowed: []string{WipeTypeAccount}, byod: ActionRemoteWipe, ownership: OwnershipBYOD, wanterr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mdms := &GSuiteMDMService{}
+			mdms.C.AllowedWipeTypes = tt.allowed
+			mdms.C.Offboarding.BYODAction = tt.byod
+			mdms.C.Offboarding.CorporateAction = tt.corporate
+
+			got, err := mdms.offboardAction(tt.ownership)
+			if (err != nil) != tt.wanterr {
+				t.Fatalf("offboardAction(%q) error = %v, wanterr %v", tt.ownership, err, tt.wanterr)
+			}
+			if got != tt.want {
+				t.Errorf("offboardAction(%q) = %q, want %q", tt.ownership, got, tt.want)
+			}
+		})
+	}
+}
+
+// EOF